@@ -1,20 +1,63 @@
 package rest
 
 import (
+	"errors"
 	"github.com/gorilla/mux"
 	"github.com/shpaktakur1/TestAvito/db"
 	"io"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
+var ErrReservedKey = errors.New("Key name is reserved for service routes")
+
+// reservedKeys - первые сегменты служебных маршрутов. Маршруты ключа с таким
+// именем перекрывались бы служебными, поэтому REST API такие ключи не
+// записывает. Прочитать и удалить их можно
+var reservedKeys = map[string]bool{"db": true, "pubsub": true, "keyspace": true, "script": true}
+
+// checkKeys возвращает ErrReservedKey, если среди ключей есть служебное имя
+func checkKeys(keys ...string) error {
+	for _, key := range keys {
+		if reservedKeys[key] {
+			return ErrReservedKey
+		}
+	}
+	return nil
+}
+
+// reservedKeyGuard отклоняет запись в ключ {key} или в ключ назначения ?to=
+// со служебным именем
+func reservedKeyGuard(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			if err := checkKeys(mux.Vars(r)["key"], r.URL.Query().Get("to")); err != nil {
+				respondWithAppError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		fn(w, r)
+	}
+}
+
 type App struct {
 	initialized bool
 
 	Authorization Authorizer
 	Router        *mux.Router
 	Cache         db.Cache
+	// Databases - логические базы, доступные по префиксу /db/{db}/.
+	// Если не заданы до Initialize, создаются DefaultDatabases баз в памяти,
+	// а Cache становится базой 0
+	Databases *db.Databases
+	// PubSub - каналы публикации сообщений. Если не задан до Initialize,
+	// создается новый
+	PubSub *db.PubSub
+
+	dbMu   sync.Mutex
+	dbApps map[string]*App
 }
 
 // Начало проверки по представленному адресу
@@ -43,18 +86,30 @@ func (a *App) Run(addr string, readTimeout int, writeTimeout int) {
 
 // Инициализация кэша и маршрутизации
 func (a *App) Initialize(defaultTTL time.Duration, out io.Writer, rw io.ReadWriter, saveFreq time.Duration, nShards int, shardFunction func(string) uint32) (err error) {
-	a.Cache, err = db.NewCache(
-		defaultTTL,
-		out,
-		rw,
-		saveFreq,
-		nShards,
-		shardFunction,
-	)
+	if a.Databases != nil {
+		a.Cache, err = a.Databases.DB(db.DefaultDB)
+	} else {
+		a.Cache, err = db.NewCache(
+			defaultTTL,
+			out,
+			rw,
+			saveFreq,
+			nShards,
+			shardFunction,
+		)
+		if err == nil {
+			a.Databases = db.NewDatabases(db.NumberedDatabases(DefaultDatabases, defaultTTL, out, nil, saveFreq, nShards, shardFunction))
+			err = a.Databases.Add(db.DefaultDB, a.Cache)
+		}
+	}
 	if err != nil {
 		return err
 	}
 
+	if a.PubSub == nil {
+		a.PubSub = db.NewPubSub()
+	}
+
 	a.Router = mux.NewRouter()
 	a.initializeRoutes()
 	a.initialized = true
@@ -64,10 +119,27 @@ func (a *App) Initialize(defaultTTL time.Duration, out io.Writer, rw io.ReadWrit
 
 
 func (a *App) initializeRoutes() {
-	wrappers := []wrapper{}
+	wrappers := []wrapper{reservedKeyGuard}
 	if a.Authorization != nil {
 		wrappers = append(wrappers, auth(a.Authorization))
 	}
+	a.initializeDatabaseRoutes(wrappers)
+	a.initializePubSubRoutes(wrappers)
+	a.initializeKeyspaceRoutes(wrappers)
+	a.initializeScriptRoutes(wrappers)
+	a.initializeHashRoutes(wrappers)
+	a.initializeListRoutes(wrappers)
+	a.initializeSetRoutes(wrappers)
+	a.initializeSortedSetRoutes(wrappers)
+	a.initializeCounterRoutes(wrappers)
+	a.initializeBitmapRoutes(wrappers)
+	a.initializeHyperLogLogRoutes(wrappers)
+	a.initializeStreamRoutes(wrappers)
+	a.initializeGeoRoutes(wrappers)
+	a.initializePathRoutes(wrappers)
+	a.initializeTxRoutes(wrappers)
+	a.initializeBatchRoutes(wrappers)
+	a.initializeKeyRoutes(wrappers)
 	a.Router.HandleFunc("/{key}/{index}", Wrap(a.actionGetByIndex, wrappers)).Methods("GET")
 	a.Router.HandleFunc("/{key}", Wrap(a.actionGet, wrappers)).Methods("GET")
 	a.Router.HandleFunc("/{key}", Wrap(a.actionSet, wrappers)).Methods("POST")
@@ -93,7 +165,8 @@ func (a *App) actionGet(w http.ResponseWriter, r *http.Request) {
 		respondWithAppError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	respondWithJSON(w, http.StatusOK, value)
+	setETag(w, value)
+	respondWithValue(w, r, value)
 }
 
 func (a *App) actionSet(w http.ResponseWriter, r *http.Request) {
@@ -106,23 +179,43 @@ func (a *App) actionSet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t, err := decodeJSONBody(r.Body)
+	t, err := decodeValueBody(r)
 	if err != nil {
 		respondWithAppError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 	defer r.Body.Close()
 
+	nx, xx, get := processBool(q.Get("nx")), processBool(q.Get("xx")), processBool(q.Get("get"))
+	ifMatch := r.Header.Get("If-Match")
+	if (nx && xx) || (get && (nx || xx)) || (ifMatch != "" && (nx || xx || get)) {
+		respondWithAppError(w, http.StatusBadRequest, ErrConflictingOptions.Error())
+		return
+	}
+	if ifMatch != "" {
+		a.actionCompareAndSet(w, vars["key"], ifMatch, t, ttl)
+		return
+	}
+	if nx || xx || get {
+		a.actionSetConditional(w, vars["key"], t, ttl, nx, xx)
+		return
+	}
+
 	value, err := a.Cache.Set(vars["key"], t, ttl)
 	if err != nil {
 		respondWithAppError(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	setETag(w, value)
 	respondWithJSON(w, http.StatusOK, value)
 }
 
 func (a *App) actionRemove(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if processBool(r.URL.Query().Get("get")) {
+		a.actionGetDel(w, vars["key"])
+		return
+	}
 	err := a.Cache.Remove(vars["key"])
 	if err != nil {
 		respondWithAppError(w, http.StatusBadRequest, err.Error())
@@ -132,6 +225,11 @@ func (a *App) actionRemove(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) actionKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("match") != "" || q.Get("cursor") != "" || q.Get("count") != "" {
+		a.actionScan(w, r)
+		return
+	}
 	result, err := a.Cache.Keys()
 	if err != nil {
 		respondWithAppError(w, http.StatusBadRequest, err.Error())
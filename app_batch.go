@@ -0,0 +1,91 @@
+package rest
+
+import (
+	"errors"
+	"github.com/shpaktakur1/TestAvito/db"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrExpectedKeyValues = errors.New(`Request body should be a JSON array of {"key":...,"value":...} objects`)
+	ErrUnknownBatchOp    = errors.New("Batch op should be one of get, set and del")
+)
+
+// initializeBatchRoutes регистрирует POST / - пакетную операцию над массивом
+// ключей. Операция выбирается параметром op
+func (a *App) initializeBatchRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/", Wrap(a.actionBatch, wrappers)).Methods("POST")
+}
+
+func decodeKeyValues(body io.Reader) ([]db.KeyValue, error) {
+	t, err := decodeJSONBody(body)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := t.([]interface{})
+	if !ok {
+		return nil, ErrExpectedKeyValues
+	}
+	result := make([]db.KeyValue, len(items))
+	for i := range items {
+		item, ok := items[i].(map[string]interface{})
+		if !ok {
+			return nil, ErrExpectedKeyValues
+		}
+		key, ok := item["key"].(string)
+		if !ok {
+			return nil, ErrExpectedKeyValues
+		}
+		result[i] = db.KeyValue{Key: key, Value: item["value"]}
+	}
+	return result, nil
+}
+
+func (a *App) actionBatch(w http.ResponseWriter, r *http.Request) {
+	b, ok := a.Cache.(db.Batcher)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	var result interface{}
+	var err error
+	switch r.URL.Query().Get("op") {
+	case "get":
+		var keys []string
+		if keys, err = decodeStrings(r.Body); err == nil {
+			result, err = b.MGet(keys...)
+		}
+	case "set":
+		ttl, ttlErr := processTTL(r.URL.Query().Get("ttl"))
+		if ttlErr != nil {
+			respondWithAppError(w, http.StatusBadRequest, ttlErr.Error())
+			return
+		}
+		var items []db.KeyValue
+		if items, err = decodeKeyValues(r.Body); err == nil {
+			for _, item := range items {
+				if err = checkKeys(item.Key); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			result, err = b.MSet(items, ttl)
+		}
+	case "del":
+		var keys []string
+		if keys, err = decodeStrings(r.Body); err == nil {
+			result, err = b.MDel(keys...)
+		}
+	default:
+		err = ErrUnknownBatchOp
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,128 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"strconv"
+)
+
+func (a *App) initializeBitmapRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/bits/count", Wrap(a.actionBitCount, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/bits/op", Wrap(a.actionBitOp, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/bits/{offset}", Wrap(a.actionGetBit, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/bits/{offset}", Wrap(a.actionSetBit, wrappers)).Methods("PUT")
+}
+
+func (a *App) bitOperator(w http.ResponseWriter) (db.BitOperator, bool) {
+	bo, ok := a.Cache.(db.BitOperator)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return bo, ok
+}
+
+func bitOffset(r *http.Request) (int64, error) {
+	offset, err := strconv.ParseInt(mux.Vars(r)["offset"], 10, 64)
+	if err != nil {
+		return 0, db.ErrBitOffset
+	}
+	return offset, nil
+}
+
+func (a *App) actionGetBit(w http.ResponseWriter, r *http.Request) {
+	bo, ok := a.bitOperator(w)
+	if !ok {
+		return
+	}
+	offset, err := bitOffset(r)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bit, err := bo.GetBit(mux.Vars(r)["key"], offset)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, bit)
+}
+
+// actionSetBit принимает в теле 0 или 1 и возвращает предыдущее значение бита
+func (a *App) actionSetBit(w http.ResponseWriter, r *http.Request) {
+	bo, ok := a.bitOperator(w)
+	if !ok {
+		return
+	}
+	offset, err := bitOffset(r)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	t, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+	bit, ok := t.(float64)
+	if !ok || (bit != 0 && bit != 1) {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrBitValue.Error())
+		return
+	}
+
+	previous, err := bo.SetBit(mux.Vars(r)["key"], offset, int(bit))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, previous)
+}
+
+// actionBitCount обрабатывает GET /{key}/bits/count?start=0&stop=-1,
+// позиции задаются в байтах
+func (a *App) actionBitCount(w http.ResponseWriter, r *http.Request) {
+	bo, ok := a.bitOperator(w)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	start, err := processInt(q.Get("start"), 0)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stop, err := processInt(q.Get("stop"), -1)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	count, err := bo.BitCount(mux.Vars(r)["key"], start, stop)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, count)
+}
+
+// actionBitOp обрабатывает POST /{destkey}/bits/op?op=and|or|xor|not
+// с массивом ключей-источников в теле и возвращает длину результата
+func (a *App) actionBitOp(w http.ResponseWriter, r *http.Request) {
+	bo, ok := a.bitOperator(w)
+	if !ok {
+		return
+	}
+	keys, err := decodeStrings(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	length, err := bo.BitOp(r.URL.Query().Get("op"), mux.Vars(r)["key"], keys...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, length)
+}
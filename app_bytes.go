@@ -0,0 +1,45 @@
+package rest
+
+import (
+	"github.com/shpaktakur1/TestAvito/db"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const octetStream = "application/octet-stream"
+
+// decodeValueBody читает значение из тела запроса: тело с типом
+// application/octet-stream сохраняется как BYTES без изменений, остальные
+// разбираются как JSON
+func decodeValueBody(r *http.Request) (interface{}, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == octetStream {
+		return ioutil.ReadAll(r.Body)
+	}
+	return decodeJSONBody(r.Body)
+}
+
+// acceptsJSON проверяет, что клиент явно запросил JSON вместо двоичных данных
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, _ := mime.ParseMediaType(accept); mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithValue отдает BYTES как есть, если клиент не запросил JSON.
+// Остальные типы всегда отдаются в JSON
+func respondWithValue(w http.ResponseWriter, r *http.Request, value *db.Value) {
+	data, ok := value.Data.([]byte)
+	if value.Type != db.BYTES || !ok || acceptsJSON(r) {
+		respondWithJSON(w, http.StatusOK, value)
+		return
+	}
+	w.Header().Set("Content-type", octetStream)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
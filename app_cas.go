@@ -0,0 +1,69 @@
+package rest
+
+import (
+	"errors"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrMalformedETag = errors.New("Malformed ETag")
+
+// setETag передает версию значения в заголовке ETag
+func setETag(w http.ResponseWriter, value *db.Value) {
+	if value != nil && value.Version != 0 {
+		w.Header().Set("ETag", `"`+strconv.FormatUint(value.Version, 10)+`"`)
+	}
+}
+
+// parseETag извлекает версию из строгого ETag вида "42"
+func parseETag(tag string) (uint64, error) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, ErrMalformedETag
+	}
+	version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+	if err != nil {
+		return 0, ErrMalformedETag
+	}
+	return version, nil
+}
+
+// actionCompareAndSet обрабатывает POST /{key} с заголовком If-Match.
+// If-Match: * требует только существования ключа. Невыполненное условие
+// возвращается как 412 Precondition Failed
+func (a *App) actionCompareAndSet(w http.ResponseWriter, key string, ifMatch string, t interface{}, ttl time.Duration) {
+	var value *db.Value
+	var err error
+	if ifMatch == "*" {
+		cw, ok := a.conditionalWriter(w)
+		if !ok {
+			return
+		}
+		value, err = cw.SetXX(key, t, ttl)
+	} else {
+		cas, ok := a.Cache.(db.CompareAndSetter)
+		if !ok {
+			respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+			return
+		}
+		version, parseErr := parseETag(ifMatch)
+		if parseErr != nil {
+			respondWithAppError(w, http.StatusBadRequest, parseErr.Error())
+			return
+		}
+		value, err = cas.CompareAndSet(key, version, t, ttl)
+	}
+	if err == db.ErrVersionMismatch || err == db.ErrConditionNotMet {
+		respondWithAppError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	setETag(w, value)
+	respondWithJSON(w, http.StatusOK, value)
+}
@@ -0,0 +1,63 @@
+package rest
+
+import (
+	"errors"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"time"
+)
+
+var ErrConflictingOptions = errors.New("Only one of nx, xx, get and If-Match may be set")
+
+func (a *App) conditionalWriter(w http.ResponseWriter) (db.ConditionalWriter, bool) {
+	cw, ok := a.Cache.(db.ConditionalWriter)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return cw, ok
+}
+
+// actionSetConditional обрабатывает POST /{key} с параметрами nx, xx или get.
+// Невыполненное условие возвращается как 409 Conflict
+func (a *App) actionSetConditional(w http.ResponseWriter, key string, t interface{}, ttl time.Duration, nx, xx bool) {
+	cw, ok := a.conditionalWriter(w)
+	if !ok {
+		return
+	}
+
+	var value *db.Value
+	var err error
+	switch {
+	case nx:
+		value, err = cw.SetNX(key, t, ttl)
+	case xx:
+		value, err = cw.SetXX(key, t, ttl)
+	default:
+		value, _, err = cw.GetSet(key, t, ttl)
+	}
+	if err == db.ErrConditionNotMet {
+		respondWithAppError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if nx || xx {
+		setETag(w, value)
+	}
+	respondWithJSON(w, http.StatusOK, value)
+}
+
+func (a *App) actionGetDel(w http.ResponseWriter, key string) {
+	cw, ok := a.conditionalWriter(w)
+	if !ok {
+		return
+	}
+	value, err := cw.GetDel(key)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, value)
+}
@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"strconv"
+)
+
+func (a *App) initializeCounterRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/incr", Wrap(a.actionIncr(1), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/decr", Wrap(a.actionIncr(-1), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/incrbyfloat", Wrap(a.actionIncrByFloat, wrappers)).Methods("POST")
+}
+
+func (a *App) counter(w http.ResponseWriter) (db.Counter, bool) {
+	c, ok := a.Cache.(db.Counter)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return c, ok
+}
+
+// actionIncr увеличивает значение на by (по умолчанию 1), умноженное на sign
+func (a *App) actionIncr(sign int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := a.counter(w)
+		if !ok {
+			return
+		}
+		by := int64(1)
+		if q := r.URL.Query().Get("by"); q != "" {
+			var err error
+			if by, err = strconv.ParseInt(q, 10, 64); err != nil {
+				respondWithAppError(w, http.StatusBadRequest, ErrMalformedInteger.Error())
+				return
+			}
+		}
+
+		value, err := c.IncrBy(mux.Vars(r)["key"], sign*by)
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, value)
+	}
+}
+
+func (a *App) actionIncrByFloat(w http.ResponseWriter, r *http.Request) {
+	c, ok := a.counter(w)
+	if !ok {
+		return
+	}
+	by, err := processFloat(r.URL.Query().Get("by"), 1)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	value, err := c.IncrByFloat(mux.Vars(r)["key"], by)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, value)
+}
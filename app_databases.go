@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+// DefaultDatabases - число баз, создаваемых Initialize, как в Redis
+const DefaultDatabases = 16
+
+// initializeDatabaseRoutes направляет /db/{db}/... в маршруты выбранной базы.
+// Регистрируется первым, поэтому ключ "db" доступен по вложенным маршрутам
+// только через явный префикс /db/0/db/...
+func (a *App) initializeDatabaseRoutes(wrappers []wrapper) {
+	if a.Databases != nil {
+		a.Router.PathPrefix("/db/{db}/").HandlerFunc(Wrap(a.actionDatabase, wrappers))
+	}
+	a.Router.HandleFunc("/", Wrap(a.actionFlushDB, wrappers)).Methods("POST").Queries("op", "flushdb")
+}
+
+// database возвращает приложение с маршрутами базы name. Авторизация уже
+// выполнена маршрутом /db/{db}/, поэтому у вложенного приложения ее нет
+func (a *App) database(name string) (*App, error) {
+	a.dbMu.Lock()
+	defer a.dbMu.Unlock()
+	if sub, ok := a.dbApps[name]; ok {
+		return sub, nil
+	}
+	cache, err := a.Databases.DB(name)
+	if err != nil {
+		return nil, err
+	}
+	sub := &App{Cache: cache, Router: mux.NewRouter(), initialized: true}
+	sub.initializeRoutes()
+	if a.dbApps == nil {
+		a.dbApps = map[string]*App{}
+	}
+	a.dbApps[name] = sub
+	return sub, nil
+}
+
+func (a *App) actionDatabase(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["db"]
+	sub, err := a.database(name)
+	if err == db.ErrUnknownDB {
+		respondWithAppError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	http.StripPrefix("/db/"+name, sub.Router).ServeHTTP(w, r)
+}
+
+// actionFlushDB обрабатывает POST /?op=flushdb - удаление всех ключей базы
+func (a *App) actionFlushDB(w http.ResponseWriter, r *http.Request) {
+	f, ok := a.Cache.(db.Flusher)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	if err := f.FlushDB(); err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "OK")
+}
+
+// selectDatabase находит базу для команды SELECT. Без Databases доступна
+// только база по умолчанию
+func selectDatabase(dbs *db.Databases, fallback db.Cache, name string) (db.Cache, error) {
+	if dbs == nil {
+		if name != db.DefaultDB {
+			return nil, db.ErrUnknownDB
+		}
+		return fallback, nil
+	}
+	return dbs.DB(name)
+}
@@ -0,0 +1,152 @@
+package rest
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"sort"
+)
+
+var (
+	ErrExpectedCoordinates = errors.New("Request body should be a JSON object of member [longitude, latitude] pairs")
+	ErrMissingGeoCenter    = errors.New("search center should be set by member or by lon and lat")
+)
+
+func (a *App) initializeGeoRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/geo/add", Wrap(a.actionGeoAdd, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/geo/pos", Wrap(a.actionGeoPos, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/geo/dist", Wrap(a.actionGeoDist, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/geo/search", Wrap(a.actionGeoSearch, wrappers)).Methods("GET")
+}
+
+func (a *App) geoOperator(w http.ResponseWriter) (db.GeoOperator, bool) {
+	g, ok := a.Cache.(db.GeoOperator)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return g, ok
+}
+
+// actionGeoAdd принимает объект вида {"member":[longitude, latitude]}
+func (a *App) actionGeoAdd(w http.ResponseWriter, r *http.Request) {
+	g, ok := a.geoOperator(w)
+	if !ok {
+		return
+	}
+	t, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	points, ok := t.(map[string]interface{})
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, ErrExpectedCoordinates.Error())
+		return
+	}
+	members := make([]db.GeoMember, 0, len(points))
+	for member, point := range points {
+		pair, ok := point.([]interface{})
+		if !ok || len(pair) != 2 {
+			respondWithAppError(w, http.StatusBadRequest, ErrExpectedCoordinates.Error())
+			return
+		}
+		lon, okLon := pair[0].(float64)
+		lat, okLat := pair[1].(float64)
+		if !okLon || !okLat {
+			respondWithAppError(w, http.StatusBadRequest, ErrExpectedCoordinates.Error())
+			return
+		}
+		members = append(members, db.GeoMember{Member: member, Longitude: lon, Latitude: lat})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Member < members[j].Member })
+
+	added, err := g.GeoAdd(mux.Vars(r)["key"], members...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, added)
+}
+
+// actionGeoPos обрабатывает GET /{key}/geo/pos?member=a&member=b
+func (a *App) actionGeoPos(w http.ResponseWriter, r *http.Request) {
+	g, ok := a.geoOperator(w)
+	if !ok {
+		return
+	}
+	positions, err := g.GeoPos(mux.Vars(r)["key"], r.URL.Query()["member"]...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, positions)
+}
+
+// actionGeoDist обрабатывает GET /{key}/geo/dist?from=a&to=b&unit=km
+func (a *App) actionGeoDist(w http.ResponseWriter, r *http.Request) {
+	g, ok := a.geoOperator(w)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	distance, err := g.GeoDist(mux.Vars(r)["key"], q.Get("from"), q.Get("to"), q.Get("unit"))
+	if err == db.ErrMemberNotFound {
+		respondWithAppError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, distance)
+}
+
+// geoQuery разбирает параметры поиска: центр member или lon и lat,
+// область radius или width и height, unit, sort и count
+func geoQuery(r *http.Request) (query db.GeoQuery, err error) {
+	q := r.URL.Query()
+	query.FromMember, query.Unit, query.Sort = q.Get("member"), q.Get("unit"), q.Get("sort")
+	if query.FromMember == "" && (q.Get("lon") == "" || q.Get("lat") == "") {
+		return query, ErrMissingGeoCenter
+	}
+	floats := []struct {
+		name   string
+		target *float64
+	}{
+		{"lon", &query.Longitude}, {"lat", &query.Latitude},
+		{"radius", &query.Radius}, {"width", &query.Width}, {"height", &query.Height},
+	}
+	for _, f := range floats {
+		if *f.target, err = processFloat(q.Get(f.name), 0); err != nil {
+			return
+		}
+	}
+	query.Count, err = processInt(q.Get("count"), 0)
+	return
+}
+
+// actionGeoSearch обрабатывает GET /{key}/geo/search?lon=37.6&lat=55.7&radius=5&unit=km&count=10
+func (a *App) actionGeoSearch(w http.ResponseWriter, r *http.Request) {
+	g, ok := a.geoOperator(w)
+	if !ok {
+		return
+	}
+	query, err := geoQuery(r)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	results, err := g.GeoSearch(mux.Vars(r)["key"], query)
+	if err == db.ErrMemberNotFound {
+		respondWithAppError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, results)
+}
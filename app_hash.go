@@ -0,0 +1,84 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+func (a *App) initializeHashRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/hash/keys", Wrap(a.actionHKeys, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/hash/len", Wrap(a.actionHLen, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/{field}", Wrap(a.actionHSet, wrappers)).Methods("PUT")
+	a.Router.HandleFunc("/{key}/{field}", Wrap(a.actionHDel, wrappers)).Methods("DELETE")
+}
+
+func (a *App) hasher(w http.ResponseWriter) (db.Hasher, bool) {
+	h, ok := a.Cache.(db.Hasher)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return h, ok
+}
+
+func (a *App) actionHSet(w http.ResponseWriter, r *http.Request) {
+	h, ok := a.hasher(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+
+	t, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	value, err := h.HSet(vars["key"], vars["field"], t)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, value)
+}
+
+func (a *App) actionHDel(w http.ResponseWriter, r *http.Request) {
+	h, ok := a.hasher(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	removed, err := h.HDel(vars["key"], vars["field"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, removed)
+}
+
+func (a *App) actionHKeys(w http.ResponseWriter, r *http.Request) {
+	h, ok := a.hasher(w)
+	if !ok {
+		return
+	}
+	keys, err := h.HKeys(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, keys)
+}
+
+func (a *App) actionHLen(w http.ResponseWriter, r *http.Request) {
+	h, ok := a.hasher(w)
+	if !ok {
+		return
+	}
+	n, err := h.HLen(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
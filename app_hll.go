@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+func (a *App) initializeHyperLogLogRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/hll/add", Wrap(a.actionPFAdd, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/hll/count", Wrap(a.actionPFCount, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/hll/merge", Wrap(a.actionPFMerge, wrappers)).Methods("POST")
+}
+
+func (a *App) hyperLogLogOperator(w http.ResponseWriter) (db.HyperLogLogOperator, bool) {
+	ho, ok := a.Cache.(db.HyperLogLogOperator)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return ho, ok
+}
+
+func (a *App) actionPFAdd(w http.ResponseWriter, r *http.Request) {
+	ho, ok := a.hyperLogLogOperator(w)
+	if !ok {
+		return
+	}
+	elements, err := decodeStrings(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	changed, err := ho.PFAdd(mux.Vars(r)["key"], elements...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, changed)
+}
+
+// actionPFCount обрабатывает GET /{key}/hll/count?with=key2&with=key3 и
+// оценивает число уникальных элементов в объединении ключей
+func (a *App) actionPFCount(w http.ResponseWriter, r *http.Request) {
+	ho, ok := a.hyperLogLogOperator(w)
+	if !ok {
+		return
+	}
+	n, err := ho.PFCount(setKeys(r)...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
+
+// actionPFMerge обрабатывает POST /{destkey}/hll/merge с массивом
+// ключей-источников в теле
+func (a *App) actionPFMerge(w http.ResponseWriter, r *http.Request) {
+	ho, ok := a.hyperLogLogOperator(w)
+	if !ok {
+		return
+	}
+	keys, err := decodeStrings(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	if err = ho.PFMerge(mux.Vars(r)["key"], keys...); err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "OK")
+}
@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+var ErrMissingTarget = errors.New("Query parameter 'to' is required")
+
+// initializeKeyRoutes регистрирует операции над ключами без передачи значений.
+// GET /?op=dbsize и GET /?op=randomkey регистрируются раньше GET /
+func (a *App) initializeKeyRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/meta/exists", Wrap(a.actionExists, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/meta/type", Wrap(a.actionType, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/meta/rename", Wrap(a.actionRename, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/meta/copy", Wrap(a.actionCopy, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/", Wrap(a.actionDBSize, wrappers)).Methods("GET").Queries("op", "dbsize")
+	a.Router.HandleFunc("/", Wrap(a.actionRandomKey, wrappers)).Methods("GET").Queries("op", "randomkey")
+}
+
+func (a *App) keyManager(w http.ResponseWriter) (db.KeyManager, bool) {
+	km, ok := a.Cache.(db.KeyManager)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return km, ok
+}
+
+func (a *App) actionExists(w http.ResponseWriter, r *http.Request) {
+	km, ok := a.keyManager(w)
+	if !ok {
+		return
+	}
+	n, err := km.Exists(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n == 1)
+}
+
+func (a *App) actionType(w http.ResponseWriter, r *http.Request) {
+	km, ok := a.keyManager(w)
+	if !ok {
+		return
+	}
+	t, err := km.KeyType(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, t.String())
+}
+
+// actionRename обрабатывает POST /{key}/meta/rename?to=newkey
+func (a *App) actionRename(w http.ResponseWriter, r *http.Request) {
+	km, ok := a.keyManager(w)
+	if !ok {
+		return
+	}
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		respondWithAppError(w, http.StatusBadRequest, ErrMissingTarget.Error())
+		return
+	}
+	value, err := km.Rename(mux.Vars(r)["key"], to)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	setETag(w, value)
+	respondWithJSON(w, http.StatusOK, value)
+}
+
+// actionCopy обрабатывает POST /{key}/meta/copy?to=newkey[&replace=1].
+// Без replace существующий ключ не перезаписывается и возвращается 409
+func (a *App) actionCopy(w http.ResponseWriter, r *http.Request) {
+	km, ok := a.keyManager(w)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	to := q.Get("to")
+	if to == "" {
+		respondWithAppError(w, http.StatusBadRequest, ErrMissingTarget.Error())
+		return
+	}
+	value, err := km.Copy(mux.Vars(r)["key"], to, processBool(q.Get("replace")))
+	if err == db.ErrKeyExists {
+		respondWithAppError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	setETag(w, value)
+	respondWithJSON(w, http.StatusOK, value)
+}
+
+func (a *App) actionDBSize(w http.ResponseWriter, r *http.Request) {
+	km, ok := a.keyManager(w)
+	if !ok {
+		return
+	}
+	n, err := km.DBSize()
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
+
+func (a *App) actionRandomKey(w http.ResponseWriter, r *http.Request) {
+	km, ok := a.keyManager(w)
+	if !ok {
+		return
+	}
+	key, err := km.RandomKey()
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, key)
+}
@@ -0,0 +1,41 @@
+package rest
+
+import (
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+func (a *App) initializeKeyspaceRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/keyspace/subscribe", Wrap(a.actionSubscribeKeyspace, wrappers)).Methods("GET")
+}
+
+// actionSubscribeKeyspace обрабатывает GET /keyspace/subscribe?match=user:*&buffer=64
+// и передает изменения ключей базы как Server-Sent Events: имя события -
+// тип изменения (set, del, expired), data - {"type","key","expires"}
+func (a *App) actionSubscribeKeyspace(w http.ResponseWriter, r *http.Request) {
+	kn, ok := a.Cache.(db.KeyspaceNotifier)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, ErrStreamingUnsupported.Error())
+		return
+	}
+	q := r.URL.Query()
+	buffer, err := processBuffer(q.Get("buffer"))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sub, err := kn.SubscribeKeyspace(q.Get("match"), buffer)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	streamSubscription(w, flusher, r, sub, func(m db.Message) (string, interface{}) {
+		e := m.Data.(db.KeyEvent)
+		return e.Type, e
+	})
+}
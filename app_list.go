@@ -0,0 +1,190 @@
+package rest
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"strconv"
+)
+
+var ErrExpectedArray = errors.New("Request body should be a JSON array")
+
+func (a *App) initializeListRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/list/lpush", Wrap(a.actionPush(true), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/list/rpush", Wrap(a.actionPush(false), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/list/lpop", Wrap(a.actionPop(true), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/list/rpop", Wrap(a.actionPop(false), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/list/blpop", Wrap(a.actionBlockingPop(true), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/list/brpop", Wrap(a.actionBlockingPop(false), wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/list/range", Wrap(a.actionLRange, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/list/len", Wrap(a.actionLLen, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/list/{index}", Wrap(a.actionLSet, wrappers)).Methods("PUT")
+}
+
+func (a *App) lister(w http.ResponseWriter) (db.Lister, bool) {
+	l, ok := a.Cache.(db.Lister)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return l, ok
+}
+
+func (a *App) actionPush(left bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := a.lister(w)
+		if !ok {
+			return
+		}
+		vars := mux.Vars(r)
+
+		t, err := decodeJSONBody(r.Body)
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer r.Body.Close()
+		items, ok := t.([]interface{})
+		if !ok {
+			respondWithAppError(w, http.StatusBadRequest, ErrExpectedArray.Error())
+			return
+		}
+
+		var length int
+		if left {
+			length, err = l.LPush(vars["key"], items...)
+		} else {
+			length, err = l.RPush(vars["key"], items...)
+		}
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, length)
+	}
+}
+
+func (a *App) actionPop(left bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := a.lister(w)
+		if !ok {
+			return
+		}
+		vars := mux.Vars(r)
+
+		var item interface{}
+		var err error
+		if left {
+			item, err = l.LPop(vars["key"])
+		} else {
+			item, err = l.RPop(vars["key"])
+		}
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, item)
+	}
+}
+
+// actionBlockingPop обрабатывает POST /{key}/list/blpop?timeout=10s. Если
+// элемент не появился за timeout, отвечает 204 без тела. Без timeout запрос
+// ждет, пока клиент не закроет соединение
+func (a *App) actionBlockingPop(left bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l, ok := a.Cache.(db.BlockingLister)
+		if !ok {
+			respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+			return
+		}
+		timeout, err := processTTL(r.URL.Query().Get("timeout"))
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		disableWriteDeadline(w)
+		var item interface{}
+		if left {
+			item, err = l.BLPop(r.Context(), mux.Vars(r)["key"], timeout)
+		} else {
+			item, err = l.BRPop(r.Context(), mux.Vars(r)["key"], timeout)
+		}
+		switch {
+		case err == nil:
+			respondWithJSON(w, http.StatusOK, item)
+		case err == db.ErrPopTimeout:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Context().Err() != nil:
+			// клиент ушел, отвечать некому
+		default:
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+		}
+	}
+}
+
+func (a *App) actionLRange(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lister(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	q := r.URL.Query()
+
+	start, err := processInt(q.Get("start"), 0)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stop, err := processInt(q.Get("stop"), -1)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	items, err := l.LRange(vars["key"], start, stop)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, items)
+}
+
+func (a *App) actionLLen(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lister(w)
+	if !ok {
+		return
+	}
+	n, err := l.LLen(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
+
+func (a *App) actionLSet(w http.ResponseWriter, r *http.Request) {
+	l, ok := a.lister(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrNonIntegerSubkey.Error())
+		return
+	}
+	t, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	if err = l.LSet(vars["key"], index, t); err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "OK")
+}
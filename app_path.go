@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+func (a *App) initializePathRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/path/{path}", Wrap(a.actionGetPath, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/path/{path}", Wrap(a.actionSetPath, wrappers)).Methods("PUT")
+	a.Router.HandleFunc("/{key}/path/{path}", Wrap(a.actionDelPath, wrappers)).Methods("DELETE")
+}
+
+func (a *App) pathOperator(w http.ResponseWriter) (db.PathOperator, bool) {
+	po, ok := a.Cache.(db.PathOperator)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return po, ok
+}
+
+func (a *App) actionGetPath(w http.ResponseWriter, r *http.Request) {
+	po, ok := a.pathOperator(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	result, err := po.GetPath(vars["key"], vars["path"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+func (a *App) actionSetPath(w http.ResponseWriter, r *http.Request) {
+	po, ok := a.pathOperator(w)
+	if !ok {
+		return
+	}
+	t, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	value, err := po.SetPath(vars["key"], vars["path"], t)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	setETag(w, value)
+	respondWithJSON(w, http.StatusOK, value)
+}
+
+func (a *App) actionDelPath(w http.ResponseWriter, r *http.Request) {
+	po, ok := a.pathOperator(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	value, err := po.DelPath(vars["key"], vars["path"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	setETag(w, value)
+	respondWithJSON(w, http.StatusOK, value)
+}
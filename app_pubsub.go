@@ -0,0 +1,119 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+var (
+	ErrStreamingUnsupported = errors.New("Streaming responses are not supported by the connection")
+	ErrSubscriptionBuffer   = fmt.Errorf("buffer must be between 0 and %d", maxSubscriptionBuffer)
+)
+
+// maxSubscriptionBuffer ограничивает буфер сообщений подписки: буфер
+// выделяется сразу при подписке
+const maxSubscriptionBuffer = 1 << 16
+
+// initializePubSubRoutes регистрирует каналы, если у приложения есть PubSub.
+// Каналы общие для всех баз, поэтому доступны только без префикса /db/{db}/
+func (a *App) initializePubSubRoutes(wrappers []wrapper) {
+	if a.PubSub == nil {
+		return
+	}
+	a.Router.HandleFunc("/pubsub/publish/{channel}", Wrap(a.actionPublish, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/pubsub/subscribe", Wrap(a.actionSubscribe, wrappers)).Methods("GET")
+}
+
+// actionPublish отправляет тело запроса в канал и возвращает число получателей
+func (a *App) actionPublish(w http.ResponseWriter, r *http.Request) {
+	t, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+	respondWithJSON(w, http.StatusOK, a.PubSub.Publish(mux.Vars(r)["channel"], t))
+}
+
+// actionSubscribe обрабатывает GET /pubsub/subscribe?channel=a&pattern=news.*&buffer=64
+// и передает сообщения как Server-Sent Events: событие message с сообщением
+// в data. Если клиент не успевает читать и буфер переполняется, подписка
+// завершается событием error
+func (a *App) actionSubscribe(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, ErrStreamingUnsupported.Error())
+		return
+	}
+	q := r.URL.Query()
+	buffer, err := processBuffer(q.Get("buffer"))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sub, err := a.PubSub.Subscribe(q["channel"], q["pattern"], buffer)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	streamSubscription(w, flusher, r, sub, func(m db.Message) (string, interface{}) {
+		return "message", m
+	})
+}
+
+// processBuffer читает размер буфера подписки (по умолчанию 0)
+func processBuffer(s string) (int, error) {
+	buffer, err := processInt(s, 0)
+	if err != nil {
+		return 0, err
+	}
+	if buffer < 0 || buffer > maxSubscriptionBuffer {
+		return 0, ErrSubscriptionBuffer
+	}
+	return buffer, nil
+}
+
+// streamSubscription передает сообщения подписки как Server-Sent Events, пока
+// клиент не закроет соединение. event задает имя и данные события. Если
+// подписка отключена, поток завершается событием error
+func streamSubscription(w http.ResponseWriter, flusher http.Flusher, r *http.Request, sub *db.Subscription, event func(m db.Message) (string, interface{})) {
+	defer sub.Close()
+	disableWriteDeadline(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case m, ok := <-sub.C:
+			if !ok {
+				if err := sub.Err(); err != nil {
+					writeEvent(w, "error", map[string]string{"error": err.Error()})
+					flusher.Flush()
+				}
+				return
+			}
+			name, payload := event(m)
+			if err := writeEvent(w, name, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent пишет событие Server-Sent Events с JSON в data
+func writeEvent(w http.ResponseWriter, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,44 @@
+package rest
+
+import (
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+type scanResponse struct {
+	Cursor string   `json:"cursor"`
+	Keys   []string `json:"keys"`
+}
+
+// actionScan обрабатывает GET /?match=...&cursor=...&count=...
+// Без cursor и count возвращает все ключи, подходящие под шаблон,
+// иначе - одну страницу и курсор следующей ("0" - обход закончен)
+func (a *App) actionScan(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.Cache.(db.Scanner)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	q := r.URL.Query()
+	if q.Get("cursor") == "" && q.Get("count") == "" {
+		keys, err := sc.KeysMatch(q.Get("match"))
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondWithJSON(w, http.StatusOK, keys)
+		return
+	}
+
+	count, err := processInt(q.Get("count"), 0)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	keys, next, err := sc.Scan(q.Get("cursor"), q.Get("match"), count)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, scanResponse{Cursor: next, Keys: keys})
+}
@@ -0,0 +1,125 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+)
+
+var ErrExpectedScript = errors.New(`Request body should be a JSON object {"script":...,"keys":[...],"args":[...]}`)
+var ErrScriptTooLarge = errors.New("Request body is too large")
+
+// maxScriptRequestSize ограничивает тело запроса со скриптом
+const maxScriptRequestSize = 1 << 20
+
+type scriptRequest struct {
+	Script string   `json:"script"`
+	Keys   []string `json:"keys"`
+	Args   []string `json:"args"`
+}
+
+// initializeScriptRoutes регистрирует выполнение скриптов (EVAL, EVALSHA) и
+// управление их кэшем
+func (a *App) initializeScriptRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/script/eval", Wrap(a.actionEval, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/script/evalsha/{sha}", Wrap(a.actionEval, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/script/load", Wrap(a.actionScriptLoad, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/script/exists", Wrap(a.actionScriptExists, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/script/flush", Wrap(a.actionScriptFlush, wrappers)).Methods("POST")
+}
+
+func decodeScriptRequest(w http.ResponseWriter, r *http.Request) (scriptRequest, error) {
+	req := scriptRequest{}
+	defer r.Body.Close()
+	body := http.MaxBytesReader(w, r.Body, maxScriptRequestSize)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, ErrScriptTooLarge
+		}
+		return req, ErrExpectedScript
+	}
+	return req, nil
+}
+
+// actionEval обрабатывает POST /script/eval с текстом скрипта в теле и
+// POST /script/evalsha/{sha} для скрипта из кэша. Отвечает результатом скрипта
+func (a *App) actionEval(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.Cache.(db.Scripter)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	req, err := decodeScriptRequest(w, r)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var result interface{}
+	if sha, ok := mux.Vars(r)["sha"]; ok {
+		result, err = sc.EvalSha(sha, req.Keys, req.Args)
+	} else {
+		result, err = sc.Eval(req.Script, req.Keys, req.Args)
+	}
+	if err == db.ErrNoScript {
+		respondWithAppError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+// actionScriptLoad обрабатывает POST /script/load с {"script":...} в теле и
+// отвечает sha1 скрипта
+func (a *App) actionScriptLoad(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.Cache.(db.Scripter)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	req, err := decodeScriptRequest(w, r)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sha, err := sc.ScriptLoad(req.Script)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, sha)
+}
+
+// actionScriptExists обрабатывает GET /script/exists?sha=...&sha=...
+func (a *App) actionScriptExists(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.Cache.(db.Scripter)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	result, err := sc.ScriptExists(r.URL.Query()["sha"]...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
+
+func (a *App) actionScriptFlush(w http.ResponseWriter, r *http.Request) {
+	sc, ok := a.Cache.(db.Scripter)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	if err := sc.ScriptFlush(); err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "OK")
+}
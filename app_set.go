@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"io"
+	"net/http"
+)
+
+var ErrExpectedStrings = errors.New("Request body should be a JSON array of strings")
+
+func (a *App) initializeSetRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/set/add", Wrap(a.actionSAdd, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/set/remove", Wrap(a.actionSRem, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/set/members", Wrap(a.actionSMembers, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/set/members/{member}", Wrap(a.actionSIsMember, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/set/inter", Wrap(a.actionSInter, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/set/union", Wrap(a.actionSUnion, wrappers)).Methods("GET")
+}
+
+func (a *App) setOperator(w http.ResponseWriter) (db.SetOperator, bool) {
+	so, ok := a.Cache.(db.SetOperator)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return so, ok
+}
+
+func decodeStrings(body io.Reader) ([]string, error) {
+	t, err := decodeJSONBody(body)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := t.([]interface{})
+	if !ok {
+		return nil, ErrExpectedStrings
+	}
+	result := make([]string, len(items))
+	for i := range items {
+		if result[i], ok = items[i].(string); !ok {
+			return nil, ErrExpectedStrings
+		}
+	}
+	return result, nil
+}
+
+func (a *App) actionSAdd(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.setOperator(w)
+	if !ok {
+		return
+	}
+	members, err := decodeStrings(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	added, err := so.SAdd(mux.Vars(r)["key"], members...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, added)
+}
+
+func (a *App) actionSRem(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.setOperator(w)
+	if !ok {
+		return
+	}
+	members, err := decodeStrings(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	removed, err := so.SRem(mux.Vars(r)["key"], members...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, removed)
+}
+
+func (a *App) actionSMembers(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.setOperator(w)
+	if !ok {
+		return
+	}
+	members, err := so.SMembers(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (a *App) actionSIsMember(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.setOperator(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	isMember, err := so.SIsMember(vars["key"], vars["member"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, isMember)
+}
+
+// ключи для пересечения и объединения: ключ из пути и параметры with
+func setKeys(r *http.Request) []string {
+	return append([]string{mux.Vars(r)["key"]}, r.URL.Query()["with"]...)
+}
+
+func (a *App) actionSInter(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.setOperator(w)
+	if !ok {
+		return
+	}
+	members, err := so.SInter(setKeys(r)...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (a *App) actionSUnion(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.setOperator(w)
+	if !ok {
+		return
+	}
+	members, err := so.SUnion(setKeys(r)...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
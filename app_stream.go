@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"time"
+)
+
+var ErrExpectedObject = errors.New("Request body should be a JSON object")
+
+func (a *App) initializeStreamRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/stream/add", Wrap(a.actionXAdd, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/stream/len", Wrap(a.actionXLen, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/stream/range", Wrap(a.actionXRange, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/stream/read", Wrap(a.actionXRead, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/stream/groups/{group}", Wrap(a.actionXGroupCreate, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/stream/groups/{group}/read", Wrap(a.actionXReadGroup, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/stream/groups/{group}/ack", Wrap(a.actionXAck, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/stream/groups/{group}/pending", Wrap(a.actionXPending, wrappers)).Methods("GET")
+}
+
+func (a *App) streamOperator(w http.ResponseWriter) (db.StreamOperator, bool) {
+	so, ok := a.Cache.(db.StreamOperator)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return so, ok
+}
+
+func respondWithStreamError(w http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrNoGroup, db.ErrKeyNotFound:
+		respondWithAppError(w, http.StatusNotFound, err.Error())
+	case db.ErrGroupExists:
+		respondWithAppError(w, http.StatusConflict, err.Error())
+	default:
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// streamReadParams разбирает параметры чтения count и block. block задается
+// длительностью (5s, 500ms)
+func streamReadParams(r *http.Request) (count int, block time.Duration, err error) {
+	q := r.URL.Query()
+	if count, err = processInt(q.Get("count"), 0); err != nil {
+		return
+	}
+	block, err = processTTL(q.Get("block"))
+	return
+}
+
+// actionXAdd обрабатывает POST /{key}/stream/add?id=* с полями записи в теле
+// и возвращает идентификатор записи
+func (a *App) actionXAdd(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	body, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+	fields, ok := body.(map[string]interface{})
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, ErrExpectedObject.Error())
+		return
+	}
+
+	id, err := so.XAdd(mux.Vars(r)["key"], r.URL.Query().Get("id"), fields)
+	if err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, id)
+}
+
+func (a *App) actionXLen(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	n, err := so.XLen(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
+
+// actionXRange обрабатывает GET /{key}/stream/range?start=-&end=+&count=10
+func (a *App) actionXRange(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	count, err := processInt(q.Get("count"), 0)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entries, err := so.XRange(mux.Vars(r)["key"], q.Get("start"), q.Get("end"), count)
+	if err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+// actionXRead обрабатывает GET /{key}/stream/read?after=$&block=10s -
+// long-poll: запрос ждет новых записей не дольше block и возвращает пустой
+// массив, если записей не появилось
+func (a *App) actionXRead(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	count, block, err := streamReadParams(r)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if block > 0 {
+		disableWriteDeadline(w)
+	}
+	entries, err := so.XRead(r.Context(), mux.Vars(r)["key"], r.URL.Query().Get("after"), count, block)
+	if err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+// actionXGroupCreate обрабатывает POST /{key}/stream/groups/{group}?start=$&mkstream=1
+func (a *App) actionXGroupCreate(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	q, vars := r.URL.Query(), mux.Vars(r)
+	start := q.Get("start")
+	if start == "" {
+		start = "$"
+	}
+	if err := so.XGroupCreate(vars["key"], vars["group"], start, processBool(q.Get("mkstream"))); err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, "OK")
+}
+
+// actionXReadGroup обрабатывает POST /{key}/stream/groups/{group}/read?consumer=c&block=10s.
+// Без after доставляет новые записи группы (after=>), иначе возвращает
+// неподтвержденные записи потребителя
+func (a *App) actionXReadGroup(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	count, block, err := streamReadParams(r)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if block > 0 {
+		disableWriteDeadline(w)
+	}
+	q, vars := r.URL.Query(), mux.Vars(r)
+	after := q.Get("after")
+	if after == "" {
+		after = ">"
+	}
+	entries, err := so.XReadGroup(r.Context(), vars["key"], vars["group"], q.Get("consumer"), after, count, block)
+	if err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, entries)
+}
+
+// actionXAck принимает массив идентификаторов и возвращает число подтвержденных
+func (a *App) actionXAck(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	ids, err := decodeStrings(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	vars := mux.Vars(r)
+	n, err := so.XAck(vars["key"], vars["group"], ids...)
+	if err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
+
+func (a *App) actionXPending(w http.ResponseWriter, r *http.Request) {
+	so, ok := a.streamOperator(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	pending, err := so.XPending(vars["key"], vars["group"])
+	if err != nil {
+		respondWithStreamError(w, err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, pending)
+}
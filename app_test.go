@@ -1,6 +1,7 @@
 package rest
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"io"
@@ -8,7 +9,10 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func executeRequest(a *App, r *http.Request) *httptest.ResponseRecorder {
@@ -154,3 +158,628 @@ func TestApp_actions(t *testing.T) {
 		t.Errorf("Unexpected final state of keys: expected %v, got %v", expected, keys)
 	}
 }
+
+type routeTest struct {
+	name string
+
+	method string
+	url    string
+	body   io.Reader
+
+	expectedCode int
+	expectedBody string
+}
+
+func runRouteTests(t *testing.T, a *App, tests []routeTest) {
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.url, tt.body)
+		response := executeRequest(a, req)
+		checkResponseCode(t, tt.name, tt.expectedCode, response.Code)
+		checkResponseBody(t, tt.name, tt.expectedBody, response.Body.String())
+	}
+}
+
+func TestApp_hash(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+	a.Cache.Set("string", "something", 0)
+
+	runRouteTests(t, a, []routeTest{
+		{"HSet new key", "PUT", "/map/a", bytes.NewBufferString(`1`), http.StatusOK, `{"type":2,"data":{"a":1}}`},
+		{"HSet second field", "PUT", "/map/b", bytes.NewBufferString(`[1,2]`), http.StatusOK, `{"type":2,"data":{"a":1,"b":[1,2]}}`},
+		{"HSet malformed body", "PUT", "/map/c", bytes.NewBufferString(`{`), http.StatusBadRequest, `{"error":"unexpected EOF"}`},
+		{"HSet on string", "PUT", "/string/a", bytes.NewBufferString(`1`), http.StatusBadRequest, `{"error":"operation against a key holding the wrong kind of value"}`},
+		{"HGet field", "GET", "/map/b", nil, http.StatusOK, `[1,2]`},
+		{"HKeys", "GET", "/map/hash/keys", nil, http.StatusOK, `["a","b"]`},
+		{"HLen", "GET", "/map/hash/len", nil, http.StatusOK, `2`},
+		{"HDel", "DELETE", "/map/a", nil, http.StatusOK, `1`},
+		{"HDel missing", "DELETE", "/map/a", nil, http.StatusOK, `0`},
+		{"HKeys after HDel", "GET", "/map/hash/keys", nil, http.StatusOK, `["b"]`},
+		{"HKeys on missing key", "GET", "/missing/hash/keys", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+	})
+}
+
+func TestApp_list(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"RPush", "POST", "/list/list/rpush", bytes.NewBufferString(`[1,2]`), http.StatusOK, `2`},
+		{"LPush", "POST", "/list/list/lpush", bytes.NewBufferString(`["b","a"]`), http.StatusOK, `4`},
+		{"Push non-array", "POST", "/list/list/lpush", bytes.NewBufferString(`1`), http.StatusBadRequest, `{"error":"Request body should be a JSON array"}`},
+		{"LRange all", "GET", "/list/list/range", nil, http.StatusOK, `["a","b",1,2]`},
+		{"LRange slice", "GET", "/list/list/range?start=1&stop=-2", nil, http.StatusOK, `["b",1]`},
+		{"LRange malformed", "GET", "/list/list/range?start=x", nil, http.StatusBadRequest, `{"error":"Malformed integer"}`},
+		{"LSet", "PUT", "/list/list/-1", bytes.NewBufferString(`{"x":1}`), http.StatusOK, `"OK"`},
+		{"LSet out of range", "PUT", "/list/list/10", bytes.NewBufferString(`1`), http.StatusBadRequest, `{"error":"cant Get item at index"}`},
+		{"LPop", "POST", "/list/list/lpop", nil, http.StatusOK, `"a"`},
+		{"RPop", "POST", "/list/list/rpop", nil, http.StatusOK, `{"x":1}`},
+		{"LLen", "GET", "/list/list/len", nil, http.StatusOK, `2`},
+		{"Pop missing", "POST", "/missing/list/lpop", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+		{"BLPop", "POST", "/list/list/blpop?timeout=1s", nil, http.StatusOK, `"b"`},
+		{"BRPop timeout", "POST", "/missing/list/brpop?timeout=10ms", nil, http.StatusNoContent, ``},
+		{"BRPop bad timeout", "POST", "/list/list/brpop?timeout=x", nil, http.StatusBadRequest, `{"error":"Malformed duration"}`},
+	})
+
+	// запрос ждет элемента, вставленного во время ожидания
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		req, _ := http.NewRequest("POST", "/queue/list/rpush", bytes.NewBufferString(`["job"]`))
+		executeRequest(a, req)
+	}()
+	runRouteTests(t, a, []routeTest{
+		{"BLPop blocking", "POST", "/queue/list/blpop?timeout=1s", nil, http.StatusOK, `"job"`},
+	})
+
+	// ожидание дольше WriteTimeout сервера не теряет элемент
+	srv := httptest.NewUnstartedServer(a.Router)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		req, _ := http.NewRequest("POST", "/queue/list/rpush", bytes.NewBufferString(`["late"]`))
+		executeRequest(a, req)
+	}()
+	resp, err := http.Post(srv.URL+"/queue/list/blpop?timeout=1s", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != `"late"` {
+		t.Errorf("BLPop past WriteTimeout: expected \"late\", got %v %s", resp.StatusCode, body)
+	}
+}
+
+func TestApp_set(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 3, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"SAdd", "POST", "/a/set/add", bytes.NewBufferString(`["x","y","x"]`), http.StatusOK, `2`},
+		{"SAdd other", "POST", "/b/set/add", bytes.NewBufferString(`["y","z"]`), http.StatusOK, `2`},
+		{"SAdd non-strings", "POST", "/a/set/add", bytes.NewBufferString(`[1]`), http.StatusBadRequest, `{"error":"Request body should be a JSON array of strings"}`},
+		{"Get set", "GET", "/a", nil, http.StatusOK, `{"type":3,"data":["x","y"]}`},
+		{"SMembers", "GET", "/a/set/members", nil, http.StatusOK, `["x","y"]`},
+		{"SIsMember", "GET", "/a/set/members/x", nil, http.StatusOK, `true`},
+		{"SIsMember missing", "GET", "/a/set/members/z", nil, http.StatusOK, `false`},
+		{"SInter", "GET", "/a/set/inter?with=b", nil, http.StatusOK, `["y"]`},
+		{"SUnion", "GET", "/a/set/union?with=b&with=missing", nil, http.StatusOK, `["x","y","z"]`},
+		{"SRem", "POST", "/a/set/remove", bytes.NewBufferString(`["x","q"]`), http.StatusOK, `1`},
+		{"SMembers after SRem", "GET", "/a/set/members", nil, http.StatusOK, `["y"]`},
+	})
+}
+
+func TestApp_sortedSet(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"ZAdd", "POST", "/board/zset/add", bytes.NewBufferString(`{"alice":10,"bob":20,"carol":15}`), http.StatusOK, `3`},
+		{"ZAdd malformed", "POST", "/board/zset/add", bytes.NewBufferString(`{"alice":"x"}`), http.StatusBadRequest, `{"error":"Request body should be a JSON object of member scores"}`},
+		{"ZIncrBy", "POST", "/board/zset/incr/alice?by=15", nil, http.StatusOK, `25`},
+		{"ZIncrBy default", "POST", "/board/zset/incr/dave", nil, http.StatusOK, `1`},
+		{"ZScore", "GET", "/board/zset/score/bob", nil, http.StatusOK, `20`},
+		{"ZRank", "GET", "/board/zset/rank/alice", nil, http.StatusOK, `3`},
+		{"ZRank reversed", "GET", "/board/zset/rank/alice?rev=1", nil, http.StatusOK, `0`},
+		{"ZRank missing", "GET", "/board/zset/rank/eve", nil, http.StatusBadRequest, `{"error":"member not found"}`},
+		{"ZRange top 2", "GET", "/board/zset/range?stop=1&rev=true", nil, http.StatusOK, `[{"member":"alice","score":25},{"member":"bob","score":20}]`},
+		{"ZRangeByScore", "GET", "/board/zset/rangebyscore?min=10&max=+inf", nil, http.StatusOK, `[{"member":"carol","score":15},{"member":"bob","score":20},{"member":"alice","score":25}]`},
+		{"ZRem", "POST", "/board/zset/remove", bytes.NewBufferString(`["dave"]`), http.StatusOK, `1`},
+		{"ZCard", "GET", "/board/zset/card", nil, http.StatusOK, `3`},
+	})
+}
+
+func TestApp_counter(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"Incr", "POST", "/hits/incr", nil, http.StatusOK, `{"type":0,"data":1}`},
+		{"Incr by", "POST", "/hits/incr?by=5", nil, http.StatusOK, `{"type":0,"data":6}`},
+		{"Decr by", "POST", "/hits/decr?by=2", nil, http.StatusOK, `{"type":0,"data":4}`},
+		{"Incr malformed", "POST", "/hits/incr?by=x", nil, http.StatusBadRequest, `{"error":"Malformed integer"}`},
+		{"IncrByFloat", "POST", "/hits/incrbyfloat?by=0.5", nil, http.StatusOK, `{"type":0,"data":4.5}`},
+		{"Incr of float", "POST", "/hits/incr", nil, http.StatusBadRequest, `{"error":"value is not an integer or out of range"}`},
+		{"Set text", "POST", "/text", bytes.NewBufferString(`"abc"`), http.StatusOK, `{"type":0,"data":"abc"}`},
+		{"Incr of text", "POST", "/text/incr", nil, http.StatusBadRequest, `{"error":"value is not an integer or out of range"}`},
+		{"Get", "GET", "/hits", nil, http.StatusOK, `{"type":0,"data":4.5}`},
+	})
+}
+
+func TestApp_conditional(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"SetXX missing", "POST", "/lock?xx=1", bytes.NewBufferString(`"a"`), http.StatusConflict, `{"error":"write condition not met"}`},
+		{"SetNX", "POST", "/lock?nx=1", bytes.NewBufferString(`"a"`), http.StatusOK, `{"type":0,"data":"a"}`},
+		{"SetNX existing", "POST", "/lock?nx=1", bytes.NewBufferString(`"b"`), http.StatusConflict, `{"error":"write condition not met"}`},
+		{"SetXX", "POST", "/lock?xx=1", bytes.NewBufferString(`"c"`), http.StatusOK, `{"type":0,"data":"c"}`},
+		{"Conflicting options", "POST", "/lock?nx=1&xx=1", bytes.NewBufferString(`"d"`), http.StatusBadRequest, `{"error":"Only one of nx, xx, get and If-Match may be set"}`},
+		{"GetSet", "POST", "/lock?get=1", bytes.NewBufferString(`"d"`), http.StatusOK, `{"type":0,"data":"c"}`},
+		{"GetSet missing", "POST", "/fresh?get=1", bytes.NewBufferString(`"x"`), http.StatusOK, `null`},
+		{"GetDel", "DELETE", "/lock?get=1", nil, http.StatusOK, `{"type":0,"data":"d"}`},
+		{"GetDel missing", "DELETE", "/lock?get=1", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+	})
+}
+
+func TestApp_etag(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	request := func(method, url, ifMatch, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		return executeRequest(a, req)
+	}
+
+	response := request("POST", "/config", `"0"`, `{"limit":1}`)
+	checkResponseCode(t, "Create with If-Match 0", http.StatusOK, response.Code)
+	created := response.Header().Get("ETag")
+	if created == "" {
+		t.Fatalf("POST should return ETag")
+	}
+
+	response = request("GET", "/config", "", "")
+	checkResponseCode(t, "Get", http.StatusOK, response.Code)
+	if got := response.Header().Get("ETag"); got != created {
+		t.Errorf("Get: expected ETag %v, got %v", created, got)
+	}
+
+	response = request("POST", "/config", created, `{"limit":2}`)
+	checkResponseCode(t, "Update with current ETag", http.StatusOK, response.Code)
+	updated := response.Header().Get("ETag")
+	if updated == "" || updated == created {
+		t.Errorf("Update should change ETag, got %v", updated)
+	}
+
+	response = request("POST", "/config", created, `{"limit":3}`)
+	checkResponseCode(t, "Update with stale ETag", http.StatusPreconditionFailed, response.Code)
+	checkResponseBody(t, "Update with stale ETag", `{"error":"value version does not match"}`, response.Body.String())
+
+	response = request("POST", "/config", "*", `{"limit":4}`)
+	checkResponseCode(t, "Update with If-Match *", http.StatusOK, response.Code)
+	response = request("POST", "/missing", "*", `1`)
+	checkResponseCode(t, "If-Match * of missing key", http.StatusPreconditionFailed, response.Code)
+
+	response = request("POST", "/config", "42", `1`)
+	checkResponseCode(t, "Malformed ETag", http.StatusBadRequest, response.Code)
+	checkResponseBody(t, "Malformed ETag", `{"error":"Malformed ETag"}`, response.Body.String())
+	response = request("POST", "/config?nx=1", updated, `1`)
+	checkResponseCode(t, "If-Match with nx", http.StatusBadRequest, response.Code)
+
+	response = request("GET", "/config", "", "")
+	checkResponseBody(t, "Get after updates", `{"type":2,"data":{"limit":4}}`, response.Body.String())
+}
+
+func TestApp_batch(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 3, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"MSet", "POST", "/?op=set", bytes.NewBufferString(`[{"key":"a","value":1},{"key":"b","value":["x"]}]`), http.StatusOK, `[{"type":0,"data":1},{"type":1,"data":["x"]}]`},
+		{"MSet malformed", "POST", "/?op=set", bytes.NewBufferString(`[{"value":1}]`), http.StatusBadRequest, `{"error":"Request body should be a JSON array of {\"key\":...,\"value\":...} objects"}`},
+		{"MSet reserved key", "POST", "/?op=set", bytes.NewBufferString(`[{"key":"keyspace","value":1}]`), http.StatusBadRequest, `{"error":"Key name is reserved for service routes"}`},
+		{"MSet malformed ttl", "POST", "/?op=set&ttl=x", bytes.NewBufferString(`[]`), http.StatusBadRequest, `{"error":"Malformed duration"}`},
+		{"MGet", "POST", "/?op=get", bytes.NewBufferString(`["b","missing","a"]`), http.StatusOK, `[{"type":1,"data":["x"]},null,{"type":0,"data":1}]`},
+		{"MDel", "POST", "/?op=del", bytes.NewBufferString(`["a","missing"]`), http.StatusOK, `1`},
+		{"Unknown op", "POST", "/", bytes.NewBufferString(`[]`), http.StatusBadRequest, `{"error":"Batch op should be one of get, set and del"}`},
+		{"Keys", "GET", "/", nil, http.StatusOK, `["b"]`},
+	})
+}
+
+func TestApp_transaction(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 3, nil)
+	a.Cache.Set("stale", "x", 0)
+	value, _ := a.Cache.Get("stale")
+	version := strconv.FormatUint(value.Version, 10)
+
+	runRouteTests(t, a, []routeTest{
+		{"Exec", "POST", "/?op=exec", bytes.NewBufferString(`{"watch":{"stale":"` + version + `","new":"0"},"ops":[{"type":"set","key":"a","value":1},{"type":"remove","key":"stale"},{"type":"set","key":"new","value":[1]}]}`), http.StatusOK, `[{"type":0,"data":1},null,{"type":1,"data":[1]}]`},
+		{"Exec aborted", "POST", "/?op=exec", bytes.NewBufferString(`{"watch":{"a":"0"},"ops":[{"type":"set","key":"a","value":2}]}`), http.StatusConflict, `{"error":"transaction aborted: watched key was modified"}`},
+		{"Exec unknown op", "POST", "/?op=exec", bytes.NewBufferString(`{"ops":[{"type":"incr","key":"a"}]}`), http.StatusBadRequest, `{"error":"Unknown operation type"}`},
+		{"Exec reserved key", "POST", "/?op=exec", bytes.NewBufferString(`{"ops":[{"type":"set","key":"script","value":1}]}`), http.StatusBadRequest, `{"error":"Key name is reserved for service routes"}`},
+		{"Exec malformed", "POST", "/?op=exec", bytes.NewBufferString(`[]`), http.StatusBadRequest, `{"error":"Request body should be a JSON object {\"watch\":{...},\"ops\":[...]}"}`},
+		{"Get written", "GET", "/a", nil, http.StatusOK, `{"type":0,"data":1}`},
+		{"Get removed", "GET", "/stale", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+	})
+}
+
+func TestApp_path(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"Set document", "POST", "/user", bytes.NewBufferString(`{"profile":{"addresses":[{"city":"Moscow"},{"city":"Omsk"}]}}`), http.StatusOK, `{"type":2,"data":{"profile":{"addresses":[{"city":"Moscow"},{"city":"Omsk"}]}}}`},
+		{"GetPath", "GET", "/user/path/profile.addresses[1].city", nil, http.StatusOK, `"Omsk"`},
+		{"GetPath out of range", "GET", "/user/path/profile.addresses[5]", nil, http.StatusBadRequest, `{"error":"cant Get item at index"}`},
+		{"GetPath wrong index type", "GET", "/user/path/profile[0]", nil, http.StatusBadRequest, `{"error":"list does not support given index type"}`},
+		{"GetPath malformed", "GET", "/user/path/profile..a", nil, http.StatusBadRequest, `{"error":"malformed path expression"}`},
+		{"SetPath", "PUT", "/user/path/profile.addresses[0].city", bytes.NewBufferString(`"Kazan"`), http.StatusOK, `{"type":2,"data":{"profile":{"addresses":[{"city":"Kazan"},{"city":"Omsk"}]}}}`},
+		{"DelPath", "DELETE", "/user/path/profile.addresses[1]", nil, http.StatusOK, `{"type":2,"data":{"profile":{"addresses":[{"city":"Kazan"}]}}}`},
+	})
+}
+
+func TestApp_scan(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 1, nil)
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		a.Cache.Set(key, 1, 0)
+	}
+
+	runRouteTests(t, a, []routeTest{
+		{"Scan first page", "GET", "/?match=user:*&count=2", nil, http.StatusOK, `{"cursor":"MDp1c2VyOjI","keys":["user:1","user:2"]}`},
+		{"Scan last page", "GET", "/?match=user:*&count=2&cursor=MDp1c2VyOjI", nil, http.StatusOK, `{"cursor":"0","keys":["user:3"]}`},
+		{"Scan bad cursor", "GET", "/?cursor=***", nil, http.StatusBadRequest, `{"error":"invalid cursor"}`},
+		{"Scan bad count", "GET", "/?count=x", nil, http.StatusBadRequest, `{"error":"Malformed integer"}`},
+		{"Keys by pattern", "GET", "/?match=order:*", nil, http.StatusOK, `["order:1"]`},
+		{"Keys bad pattern", "GET", "/?match=user:[", nil, http.StatusBadRequest, `{"error":"syntax error in pattern"}`},
+	})
+}
+
+func TestApp_keys(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+	a.Cache.Set("a", "x", 0)
+	a.Cache.Set("list", []interface{}{1}, 0)
+
+	runRouteTests(t, a, []routeTest{
+		{"Exists", "GET", "/a/meta/exists", nil, http.StatusOK, `true`},
+		{"Exists missing", "GET", "/missing/meta/exists", nil, http.StatusOK, `false`},
+		{"Type", "GET", "/list/meta/type", nil, http.StatusOK, `"list"`},
+		{"Type missing", "GET", "/missing/meta/type", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+		{"DBSize", "GET", "/?op=dbsize", nil, http.StatusOK, `2`},
+		{"Rename without target", "POST", "/a/meta/rename", nil, http.StatusBadRequest, `{"error":"Query parameter 'to' is required"}`},
+		{"Rename", "POST", "/a/meta/rename?to=b", nil, http.StatusOK, `{"type":0,"data":"x"}`},
+		{"Renamed source", "GET", "/a/meta/exists", nil, http.StatusOK, `false`},
+		{"Copy over existing", "POST", "/b/meta/copy?to=list", nil, http.StatusConflict, `{"error":"target key already exists"}`},
+		{"Copy with replace", "POST", "/b/meta/copy?to=list&replace=1", nil, http.StatusOK, `{"type":0,"data":"x"}`},
+		{"Copy missing", "POST", "/missing/meta/copy?to=c", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+		{"Set reserved key", "POST", "/script", bytes.NewBufferString(`"x"`), http.StatusBadRequest, `{"error":"Key name is reserved for service routes"}`},
+		{"HSet reserved key", "PUT", "/pubsub/f", bytes.NewBufferString(`1`), http.StatusBadRequest, `{"error":"Key name is reserved for service routes"}`},
+		{"Rename to reserved key", "POST", "/b/meta/rename?to=db", nil, http.StatusBadRequest, `{"error":"Key name is reserved for service routes"}`},
+		{"Keys still listed", "GET", "/?op=dbsize", nil, http.StatusOK, `2`},
+	})
+
+	a.Cache.Remove("b")
+	runRouteTests(t, a, []routeTest{
+		{"RandomKey", "GET", "/?op=randomkey", nil, http.StatusOK, `"list"`},
+	})
+	a.Cache.Remove("list")
+	runRouteTests(t, a, []routeTest{
+		{"RandomKey of empty cache", "GET", "/?op=randomkey", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+	})
+}
+
+func TestApp_databases(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+	a.Cache.Set("a", "zero", 0)
+
+	runRouteTests(t, a, []routeTest{
+		{"Set in db 1", "POST", "/db/1/a", bytes.NewBufferString(`"one"`), http.StatusOK, `{"type":0,"data":"one"}`},
+		{"Get from db 1", "GET", "/db/1/a", nil, http.StatusOK, `{"type":0,"data":"one"}`},
+		{"Get from db 0 by prefix", "GET", "/db/0/a", nil, http.StatusOK, `{"type":0,"data":"zero"}`},
+		{"Get from default db", "GET", "/a", nil, http.StatusOK, `{"type":0,"data":"zero"}`},
+		{"Nested route in db 1", "GET", "/db/1/a/meta/type", nil, http.StatusOK, `"string"`},
+		{"Unknown db", "GET", "/db/16/a", nil, http.StatusNotFound, `{"error":"database does not exist"}`},
+		{"Invalid db name", "GET", "/db/a.b/a", nil, http.StatusBadRequest, `{"error":"database name should consist of letters, digits, '_' and '-'"}`},
+		{"Flush db 1", "POST", "/db/1/?op=flushdb", nil, http.StatusOK, `"OK"`},
+		{"Keys of flushed db", "GET", "/db/1/", nil, http.StatusOK, `[]`},
+		{"Db 0 is untouched", "GET", "/?op=dbsize", nil, http.StatusOK, `1`},
+	})
+}
+
+func TestApp_bytes(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+	payload := []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x00, 0xff}
+
+	req, _ := http.NewRequest("POST", "/image", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	response := executeRequest(a, req)
+	checkResponseCode(t, "Post bytes", http.StatusOK, response.Code)
+	if value, err := a.Cache.Get("image"); err != nil || value.Type.String() != "bytes" || !bytes.Equal(value.Data.([]byte), payload) {
+		t.Fatalf("Post bytes: stored %v, err %v", value, err)
+	}
+
+	req, _ = http.NewRequest("GET", "/image", nil)
+	response = executeRequest(a, req)
+	checkResponseCode(t, "Get bytes", http.StatusOK, response.Code)
+	if got := response.Header().Get("Content-Type"); got != "application/octet-stream" {
+		t.Errorf("Get bytes: expected octet-stream content type, got %v", got)
+	}
+	if !bytes.Equal(response.Body.Bytes(), payload) {
+		t.Errorf("Get bytes: expected raw payload %v, got %v", payload, response.Body.Bytes())
+	}
+	if response.Header().Get("ETag") == "" {
+		t.Errorf("Get bytes should return ETag")
+	}
+
+	req, _ = http.NewRequest("GET", "/image", nil)
+	req.Header.Set("Accept", "application/json")
+	response = executeRequest(a, req)
+	checkResponseBody(t, "Get bytes as json", `{"type":5,"data":"iVBORw0KAP8="}`, response.Body.String())
+}
+
+func TestApp_bitmap(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"SetBit", "PUT", "/day1/bits/3", bytes.NewBufferString(`1`), http.StatusOK, `0`},
+		{"SetBit again", "PUT", "/day1/bits/3", bytes.NewBufferString(`1`), http.StatusOK, `1`},
+		{"SetBit bad value", "PUT", "/day1/bits/3", bytes.NewBufferString(`2`), http.StatusBadRequest, `{"error":"bit is not an integer or out of range"}`},
+		{"SetBit bad offset", "PUT", "/day1/bits/x", bytes.NewBufferString(`1`), http.StatusBadRequest, `{"error":"bit offset is not an integer or out of range"}`},
+		{"SetBit day2", "PUT", "/day2/bits/5", bytes.NewBufferString(`1`), http.StatusOK, `0`},
+		{"GetBit", "GET", "/day1/bits/3", nil, http.StatusOK, `1`},
+		{"GetBit unset", "GET", "/day1/bits/4", nil, http.StatusOK, `0`},
+		{"BitOp", "POST", "/both/bits/op?op=or", bytes.NewBufferString(`["day1","day2"]`), http.StatusOK, `1`},
+		{"BitCount", "GET", "/both/bits/count", nil, http.StatusOK, `2`},
+		{"BitCount range", "GET", "/both/bits/count?start=1&stop=2", nil, http.StatusOK, `0`},
+		{"BitOp unknown", "POST", "/both/bits/op?op=nand", bytes.NewBufferString(`["day1"]`), http.StatusBadRequest, `{"error":"bit operation should be one of AND, OR, XOR and NOT"}`},
+	})
+}
+
+func TestApp_hyperLogLog(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"PFAdd", "POST", "/page1/hll/add", bytes.NewBufferString(`["alice","bob"]`), http.StatusOK, `true`},
+		{"PFAdd known", "POST", "/page1/hll/add", bytes.NewBufferString(`["bob"]`), http.StatusOK, `false`},
+		{"PFAdd page2", "POST", "/page2/hll/add", bytes.NewBufferString(`["bob","carol"]`), http.StatusOK, `true`},
+		{"PFAdd bad body", "POST", "/page2/hll/add", bytes.NewBufferString(`[1]`), http.StatusBadRequest, `{"error":"Request body should be a JSON array of strings"}`},
+		{"PFCount", "GET", "/page1/hll/count", nil, http.StatusOK, `2`},
+		{"PFCount union", "GET", "/page1/hll/count?with=page2", nil, http.StatusOK, `3`},
+		{"PFMerge", "POST", "/all/hll/merge", bytes.NewBufferString(`["page1","page2"]`), http.StatusOK, `"OK"`},
+		{"PFCount merged", "GET", "/all/hll/count", nil, http.StatusOK, `3`},
+		{"Type", "GET", "/all/meta/type", nil, http.StatusOK, `"hll"`},
+	})
+}
+
+func TestApp_stream(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"XAdd", "POST", "/events/stream/add?id=1-0", bytes.NewBufferString(`{"user":"alice"}`), http.StatusOK, `"1-0"`},
+		{"XAdd next in ms", "POST", "/events/stream/add?id=1-*", bytes.NewBufferString(`{"user":"bob"}`), http.StatusOK, `"1-1"`},
+		{"XAdd too small", "POST", "/events/stream/add?id=1-1", bytes.NewBufferString(`{"user":"bob"}`), http.StatusBadRequest, `{"error":"the ID specified in XADD is equal or smaller than the target stream top item"}`},
+		{"XAdd not object", "POST", "/events/stream/add", bytes.NewBufferString(`[1]`), http.StatusBadRequest, `{"error":"Request body should be a JSON object"}`},
+		{"XLen", "GET", "/events/stream/len", nil, http.StatusOK, `2`},
+		{"XRange", "GET", "/events/stream/range?start=-&end=%2B&count=1", nil, http.StatusOK, `[{"id":"1-0","fields":{"user":"alice"}}]`},
+		{"XRead", "GET", "/events/stream/read?after=1-0", nil, http.StatusOK, `[{"id":"1-1","fields":{"user":"bob"}}]`},
+		{"XRead timeout", "GET", "/events/stream/read?after=$&block=10ms", nil, http.StatusOK, `[]`},
+		{"XRead bad block", "GET", "/events/stream/read?block=x", nil, http.StatusBadRequest, `{"error":"Malformed duration"}`},
+		{"XGroupCreate", "POST", "/events/stream/groups/workers?start=0", nil, http.StatusOK, `"OK"`},
+		{"XGroupCreate existing", "POST", "/events/stream/groups/workers", nil, http.StatusConflict, `{"error":"consumer group name already exists"}`},
+		{"XGroupCreate missing key", "POST", "/missing/stream/groups/workers", nil, http.StatusNotFound, `{"error":"key not found"}`},
+		{"XReadGroup", "POST", "/events/stream/groups/workers/read?consumer=w1&count=1", nil, http.StatusOK, `[{"id":"1-0","fields":{"user":"alice"}}]`},
+		{"XReadGroup no consumer", "POST", "/events/stream/groups/workers/read", nil, http.StatusBadRequest, `{"error":"consumer name should not be empty"}`},
+		{"XReadGroup unknown group", "POST", "/events/stream/groups/other/read?consumer=w1", nil, http.StatusNotFound, `{"error":"no such key or consumer group"}`},
+		{"XReadGroup history", "POST", "/events/stream/groups/workers/read?consumer=w1&after=0", nil, http.StatusOK, `[{"id":"1-0","fields":{"user":"alice"}}]`},
+		{"XAck", "POST", "/events/stream/groups/workers/ack", bytes.NewBufferString(`["1-0","1-1"]`), http.StatusOK, `1`},
+		{"XPending", "GET", "/events/stream/groups/workers/pending", nil, http.StatusOK, `[]`},
+		{"XReadGroup w2", "POST", "/events/stream/groups/workers/read?consumer=w2", nil, http.StatusOK, `[{"id":"1-1","fields":{"user":"bob"}}]`},
+		{"Type", "GET", "/events/meta/type", nil, http.StatusOK, `"stream"`},
+	})
+
+	// long-poll получает запись, добавленную во время ожидания
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		req, _ := http.NewRequest("POST", "/events/stream/add?id=2-0", bytes.NewBufferString(`{"user":"carol"}`))
+		executeRequest(a, req)
+	}()
+	runRouteTests(t, a, []routeTest{
+		{"XReadGroup long-poll", "POST", "/events/stream/groups/workers/read?consumer=w2&block=1s", nil, http.StatusOK, `[{"id":"2-0","fields":{"user":"carol"}}]`},
+		{"XRead", "GET", "/events/stream/read?after=1-1&block=1s", nil, http.StatusOK, `[{"id":"2-0","fields":{"user":"carol"}}]`},
+	})
+
+	// ожидание дольше WriteTimeout сервера не теряет доставленную запись
+	srv := httptest.NewUnstartedServer(a.Router)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		req, _ := http.NewRequest("POST", "/events/stream/add?id=3-0", bytes.NewBufferString(`{"user":"dave"}`))
+		executeRequest(a, req)
+	}()
+	resp, err := http.Post(srv.URL+"/events/stream/groups/workers/read?consumer=w2&block=1s", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	expected := `[{"id":"3-0","fields":{"user":"dave"}}]`
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != expected {
+		t.Errorf("XReadGroup past WriteTimeout: expected %v, got %v %s", expected, resp.StatusCode, body)
+	}
+}
+
+func TestApp_geo(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+
+	runRouteTests(t, a, []routeTest{
+		{"GeoAdd", "POST", "/sicily/geo/add", bytes.NewBufferString(`{"Palermo":[13.361389,38.115556],"Catania":[15.087269,37.502669]}`), http.StatusOK, `2`},
+		{"GeoAdd invalid", "POST", "/sicily/geo/add", bytes.NewBufferString(`{"Pole":[0,89]}`), http.StatusBadRequest, `{"error":"invalid longitude,latitude pair"}`},
+		{"GeoAdd bad body", "POST", "/sicily/geo/add", bytes.NewBufferString(`{"Rome":[12.5]}`), http.StatusBadRequest, `{"error":"Request body should be a JSON object of member [longitude, latitude] pairs"}`},
+		{"GeoPos missing", "GET", "/sicily/geo/pos?member=Rome", nil, http.StatusOK, `[null]`},
+		{"GeoDist missing", "GET", "/sicily/geo/dist?from=Palermo&to=Rome", nil, http.StatusNotFound, `{"error":"member not found"}`},
+		{"GeoSearch no center", "GET", "/sicily/geo/search?radius=100", nil, http.StatusBadRequest, `{"error":"search center should be set by member or by lon and lat"}`},
+		{"GeoSearch unknown member", "GET", "/sicily/geo/search?member=Rome&radius=100", nil, http.StatusNotFound, `{"error":"member not found"}`},
+		{"GeoSearch bad shape", "GET", "/sicily/geo/search?lon=15&lat=37", nil, http.StatusBadRequest, `{"error":"search area should be either a positive radius or a positive width and height"}`},
+	})
+
+	// расстояния и координаты зависят от округления, сравниваются только элементы
+	for _, tt := range []struct {
+		url      string
+		expected []string
+	}{
+		{"/sicily/geo/search?lon=15&lat=37&radius=100&unit=km", []string{"Catania"}},
+		{"/sicily/geo/search?member=Palermo&width=400&height=400&unit=km&sort=desc", []string{"Catania", "Palermo"}},
+	} {
+		req, _ := http.NewRequest("GET", tt.url, nil)
+		response := executeRequest(a, req)
+		var results []struct{ Member string }
+		json.Unmarshal(response.Body.Bytes(), &results)
+		members := []string{}
+		for _, r := range results {
+			members = append(members, r.Member)
+		}
+		if response.Code != http.StatusOK || !reflect.DeepEqual(members, tt.expected) {
+			t.Errorf("GeoSearch %v: expected %v, got %v %v", tt.url, tt.expected, response.Code, response.Body.String())
+		}
+	}
+	req, _ := http.NewRequest("GET", "/sicily/geo/dist?from=Palermo&to=Catania&unit=km", nil)
+	if body := executeRequest(a, req).Body.String(); body[:7] != "166.274" {
+		t.Errorf("GeoDist: expected 166.274..., got %v", body)
+	}
+}
+
+func TestApp_pubsub(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+	srv := httptest.NewUnstartedServer(a.Router)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	runRouteTests(t, a, []routeTest{
+		{"Publish without subscribers", "POST", "/pubsub/publish/news", bytes.NewBufferString(`"hi"`), http.StatusOK, `0`},
+		{"Subscribe without channels", "GET", "/pubsub/subscribe", nil, http.StatusBadRequest, `{"error":"subscription requires at least one channel or pattern"}`},
+		{"Subscribe bad buffer", "GET", "/pubsub/subscribe?channel=a&buffer=x", nil, http.StatusBadRequest, `{"error":"Malformed integer"}`},
+		{"Subscribe huge buffer", "GET", "/pubsub/subscribe?channel=a&buffer=1000000000", nil, http.StatusBadRequest, `{"error":"buffer must be between 0 and 65536"}`},
+		{"Subscribe negative buffer", "GET", "/pubsub/subscribe?channel=a&buffer=-1", nil, http.StatusBadRequest, `{"error":"buffer must be between 0 and 65536"}`},
+	})
+
+	resp, err := http.Get(srv.URL + "/pubsub/subscribe?channel=news&pattern=n*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Subscribe: expected text/event-stream, got %v", ct)
+	}
+	// поток событий переживает WriteTimeout сервера
+	time.Sleep(100 * time.Millisecond)
+
+	runRouteTests(t, a, []routeTest{
+		{"Publish", "POST", "/pubsub/publish/news", bytes.NewBufferString(`{"x":1}`), http.StatusOK, `2`},
+	})
+	expected := []string{
+		"event: message", `data: {"channel":"news","data":{"x":1}}`, "",
+		"event: message", `data: {"channel":"news","pattern":"n*","data":{"x":1}}`, "",
+	}
+	if got := readEventLines(t, bufio.NewReader(resp.Body), len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Subscribe events: expected %q, got %q", expected, got)
+	}
+}
+
+// readEventLines читает n строк потока Server-Sent Events
+func readEventLines(t *testing.T, reader *bufio.Reader, n int) []string {
+	var lines []string
+	for len(lines) < n {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line[:len(line)-1])
+	}
+	return lines
+}
+
+func TestApp_keyspace(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+	srv := httptest.NewUnstartedServer(a.Router)
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	runRouteTests(t, a, []routeTest{
+		{"Subscribe bad pattern", "GET", "/keyspace/subscribe?match=[a", nil, http.StatusBadRequest, `{"error":"syntax error in pattern"}`},
+		{"Subscribe huge buffer", "GET", "/keyspace/subscribe?buffer=1000000000", nil, http.StatusBadRequest, `{"error":"buffer must be between 0 and 65536"}`},
+	})
+
+	resp, err := http.Get(srv.URL + "/keyspace/subscribe?match=user:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	other, err := http.Get(srv.URL + "/db/1/keyspace/subscribe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Body.Close()
+	// поток событий переживает WriteTimeout сервера
+	time.Sleep(100 * time.Millisecond)
+
+	a.Cache.Set("other", "x", 0)
+	a.Cache.Set("user:1", "x", 0)
+	runRouteTests(t, a, []routeTest{
+		{"Remove", "DELETE", "/user:1", nil, http.StatusOK, `"OK"`},
+	})
+	db1, _ := a.Databases.DB("1")
+	db1.Set("user:1", "y", 0)
+	expected := []string{
+		"event: set", `data: {"type":"set","key":"user:1","expires":0}`, "",
+		"event: del", `data: {"type":"del","key":"user:1","expires":0}`, "",
+	}
+	if got := readEventLines(t, bufio.NewReader(resp.Body), len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Keyspace events: expected %q, got %q", expected, got)
+	}
+	// события базы 1 не попадают в подписку базы 0
+	expected = expected[:3]
+	if got := readEventLines(t, bufio.NewReader(other.Body), len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Keyspace events in db 1: expected %q, got %q", expected, got)
+	}
+}
+
+func TestApp_script(t *testing.T) {
+	a := &App{}
+	a.Initialize(0, nil, nil, 500, 2, nil)
+	script := `local n = redis.call('INCR', KEYS[1]) if n > 1 then redis.call('SET', KEYS[2], ARGV[1]) end return n`
+	body := `{"script":"` + script + `","keys":["n","flag"],"args":["on"]}`
+	sha := "fe0194538302c92d313311cf17c18953cb5c32a6"
+
+	runRouteTests(t, a, []routeTest{
+		{"Eval", "POST", "/script/eval", bytes.NewBufferString(body), http.StatusOK, `1`},
+		{"Get untouched", "GET", "/flag", nil, http.StatusBadRequest, `{"error":"key not found"}`},
+		{"EvalSha", "POST", "/script/evalsha/" + sha, bytes.NewBufferString(`{"keys":["n","flag"],"args":["on"]}`), http.StatusOK, `2`},
+		{"Get written", "GET", "/flag", nil, http.StatusOK, `{"type":0,"data":"on"}`},
+		{"Eval syntax error", "POST", "/script/eval", bytes.NewBufferString(`{"script":"return +"}`), http.StatusBadRequest, `{"error":"script error at line 1: unexpected symbol near '+'"}`},
+		{"Eval undeclared key", "POST", "/script/eval", bytes.NewBufferString(`{"script":"return redis.call('GET', 'n')"}`), http.StatusBadRequest, `{"error":"script error at line 1: script accessed a key not declared in keys"}`},
+		{"Eval bad body", "POST", "/script/eval", bytes.NewBufferString(`[]`), http.StatusBadRequest, `{"error":"Request body should be a JSON object {\"script\":...,\"keys\":[...],\"args\":[...]}"}`},
+		{"Eval large body", "POST", "/script/eval", bytes.NewBufferString(`{"script":"` + strings.Repeat("x", 2<<20) + `"}`), http.StatusBadRequest, `{"error":"Request body is too large"}`},
+		{"Load", "POST", "/script/load", bytes.NewBufferString(`{"script":"return 1"}`), http.StatusOK, `"e0e1f9fabfc9d4800c877a703b823ac0578ff8db"`},
+		{"Exists", "GET", "/script/exists?sha=" + sha + "&sha=e0e1f9fabfc9d4800c877a703b823ac0578ff8db&sha=00", nil, http.StatusOK, `[true,true,false]`},
+		{"Flush", "POST", "/script/flush", nil, http.StatusOK, `"OK"`},
+		{"EvalSha flushed", "POST", "/script/evalsha/" + sha, bytes.NewBufferString(`{}`), http.StatusNotFound, `{"error":"no script with given sha1, load it first"}`},
+	})
+}
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net/http"
+	"strings"
+)
+
+var ErrExpectedTransaction = errors.New(`Request body should be a JSON object {"watch":{...},"ops":[...]}`)
+
+type txRequest struct {
+	// ключ -> ETag, полученный при чтении. "0" - ключа не было
+	Watch map[string]string `json:"watch"`
+	Ops   []struct {
+		Type  string      `json:"type"`
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+		TTL   string      `json:"ttl"`
+	} `json:"ops"`
+}
+
+// initializeTxRoutes регистрирует POST /?op=exec - атомарное выполнение
+// набора операций set/remove
+func (a *App) initializeTxRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/", Wrap(a.actionExec, wrappers)).Methods("POST").Queries("op", "exec")
+}
+
+func (a *App) actionExec(w http.ResponseWriter, r *http.Request) {
+	tr, ok := a.Cache.(db.Transactor)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+		return
+	}
+	req := txRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithAppError(w, http.StatusBadRequest, ErrExpectedTransaction.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	watch := make(map[string]uint64, len(req.Watch))
+	for key, tag := range req.Watch {
+		if !strings.HasPrefix(tag, `"`) {
+			tag = `"` + tag + `"`
+		}
+		version, err := parseETag(tag)
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		watch[key] = version
+	}
+	ops := make([]db.TxOp, len(req.Ops))
+	for i, op := range req.Ops {
+		ttl, err := processTTL(op.TTL)
+		if err != nil {
+			respondWithAppError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		switch strings.ToLower(op.Type) {
+		case "set":
+			if err = checkKeys(op.Key); err != nil {
+				respondWithAppError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			ops[i] = db.TxOp{Type: "Set", Key: op.Key, Value: op.Value, Expire: ttl}
+		case "remove":
+			ops[i] = db.TxOp{Type: "Remove", Key: op.Key}
+		default:
+			respondWithAppError(w, http.StatusBadRequest, db.ErrUnknownOperationType.Error())
+			return
+		}
+	}
+
+	result, err := tr.Exec(watch, ops)
+	if err == db.ErrTxAborted {
+		respondWithAppError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,197 @@
+package rest
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/shpaktakur1/TestAvito/db"
+	"math"
+	"net/http"
+	"sort"
+)
+
+var ErrExpectedScores = errors.New("Request body should be a JSON object of member scores")
+
+func (a *App) initializeSortedSetRoutes(wrappers []wrapper) {
+	a.Router.HandleFunc("/{key}/zset/add", Wrap(a.actionZAdd, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/zset/incr/{member}", Wrap(a.actionZIncrBy, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/zset/remove", Wrap(a.actionZRem, wrappers)).Methods("POST")
+	a.Router.HandleFunc("/{key}/zset/score/{member}", Wrap(a.actionZScore, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/zset/rank/{member}", Wrap(a.actionZRank, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/zset/range", Wrap(a.actionZRange, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/zset/rangebyscore", Wrap(a.actionZRangeByScore, wrappers)).Methods("GET")
+	a.Router.HandleFunc("/{key}/zset/card", Wrap(a.actionZCard, wrappers)).Methods("GET")
+}
+
+func (a *App) sortedSetOperator(w http.ResponseWriter) (db.SortedSetOperator, bool) {
+	zo, ok := a.Cache.(db.SortedSetOperator)
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, db.ErrUnsupported.Error())
+	}
+	return zo, ok
+}
+
+func (a *App) actionZAdd(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	t, err := decodeJSONBody(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	scores, ok := t.(map[string]interface{})
+	if !ok {
+		respondWithAppError(w, http.StatusBadRequest, ErrExpectedScores.Error())
+		return
+	}
+	members := make([]db.ZMember, 0, len(scores))
+	for member, score := range scores {
+		f, ok := score.(float64)
+		if !ok {
+			respondWithAppError(w, http.StatusBadRequest, ErrExpectedScores.Error())
+			return
+		}
+		members = append(members, db.ZMember{Member: member, Score: f})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Member < members[j].Member })
+
+	added, err := zo.ZAdd(mux.Vars(r)["key"], members...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, added)
+}
+
+func (a *App) actionZIncrBy(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	delta, err := processFloat(r.URL.Query().Get("by"), 1)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	score, err := zo.ZIncrBy(vars["key"], vars["member"], delta)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, score)
+}
+
+func (a *App) actionZRem(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	members, err := decodeStrings(r.Body)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer r.Body.Close()
+
+	removed, err := zo.ZRem(mux.Vars(r)["key"], members...)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, removed)
+}
+
+func (a *App) actionZScore(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	score, err := zo.ZScore(vars["key"], vars["member"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, score)
+}
+
+func (a *App) actionZRank(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	rank, err := zo.ZRank(vars["key"], vars["member"], processBool(r.URL.Query().Get("rev")))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, rank)
+}
+
+func (a *App) actionZRange(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	start, err := processInt(q.Get("start"), 0)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	stop, err := processInt(q.Get("stop"), -1)
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	members, err := zo.ZRange(mux.Vars(r)["key"], start, stop, processBool(q.Get("rev")))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (a *App) actionZRangeByScore(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	min, err := processFloat(q.Get("min"), math.Inf(-1))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	max, err := processFloat(q.Get("max"), math.Inf(1))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	members, err := zo.ZRangeByScore(mux.Vars(r)["key"], min, max, processBool(q.Get("rev")))
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+func (a *App) actionZCard(w http.ResponseWriter, r *http.Request) {
+	zo, ok := a.sortedSetOperator(w)
+	if !ok {
+		return
+	}
+	n, err := zo.ZCard(mux.Vars(r)["key"])
+	if err != nil {
+		respondWithAppError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, http.StatusOK, n)
+}
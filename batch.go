@@ -0,0 +1,236 @@
+/*
+    Пакетные операции над несколькими ключами. Ключи группируются по шардам,
+    группы обрабатываются параллельно, каждая под одной блокировкой шарда
+*/
+
+package db
+
+import (
+	"sync"
+	"time"
+)
+
+type KeyValue struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// Batcher выполняет операции над несколькими ключами за один вызов.
+// Атомарность гарантируется только в пределах одного шарда
+type Batcher interface {
+	// MGet возвращает значения в порядке ключей, nil для отсутствующих
+	MGet(keys ...string) ([]*Value, error)
+	// MSet записывает все значения, если они допустимы, и возвращает результаты
+	// в порядке items
+	MSet(items []KeyValue, expire time.Duration) ([]*Value, error)
+	// MDel удаляет ключи и возвращает число удаленных
+	MDel(keys ...string) (int, error)
+}
+
+func asBatcher(c Cache) (Batcher, error) {
+	b, ok := c.(Batcher)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return b, nil
+}
+
+// forEachShard группирует позиции ключей по шардам и параллельно вызывает fn
+// для каждой группы под блокировкой шарда. Возвращает первую ошибку
+func (s *sharder) forEachShard(keys []string, write bool, fn func(shard Cache, positions []int) error) error {
+	groups := map[uint32][]int{}
+	for pos, key := range keys {
+		i := s.getTargetShardIdx(key)
+		groups[i] = append(groups[i], pos)
+	}
+
+	errorCh := make(chan error, len(groups))
+	var wg sync.WaitGroup
+	for idx, positions := range groups {
+		wg.Add(1)
+		go func(i uint32, positions []int) {
+			defer wg.Done()
+			if s.needLock {
+				if write {
+					s.locks[i].Lock()
+					defer s.locks[i].Unlock()
+				} else {
+					s.locks[i].RLock()
+					defer s.locks[i].RUnlock()
+				}
+			}
+			errorCh <- fn(s.shards[i], positions)
+		}(idx, positions)
+	}
+	wg.Wait()
+	close(errorCh)
+
+	for err := range errorCh {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sharder) MGet(keys ...string) ([]*Value, error) {
+	result := make([]*Value, len(keys))
+	err := s.forEachShard(keys, false, func(shard Cache, positions []int) error {
+		for _, pos := range positions {
+			value, err := shard.Get(keys[pos])
+			if err == ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			result[pos] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *sharder) MSet(items []KeyValue, expire time.Duration) ([]*Value, error) {
+	if expire < 0 {
+		return nil, ErrInvalidTTL
+	}
+	keys := make([]string, len(items))
+	for i, item := range items {
+		// проверяем все значения заранее, чтобы не записать пакет частично
+		if _, err := typeOf(item.Value); err != nil {
+			return nil, err
+		}
+		keys[i] = item.Key
+	}
+
+	result := make([]*Value, len(items))
+	err := s.forEachShard(keys, true, func(shard Cache, positions []int) error {
+		for _, pos := range positions {
+			value, err := s.setKey(shard, items[pos].Key, items[pos].Value, expire, nil)
+			if err != nil {
+				return err
+			}
+			result[pos] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *sharder) MDel(keys ...string) (int, error) {
+	var mu sync.Mutex
+	removed := 0
+	err := s.forEachShard(keys, true, func(shard Cache, positions []int) error {
+		n := 0
+		for _, pos := range positions {
+			existed, err := s.removeKey(shard, keys[pos], EventDel, nil)
+			if err != nil {
+				return err
+			}
+			if existed {
+				n++
+			}
+		}
+		mu.Lock()
+		removed += n
+		mu.Unlock()
+		return nil
+	})
+	return removed, err
+}
+
+func (l *logger) MGet(keys ...string) ([]*Value, error) {
+	defer l.peekIntoPanic("mget", keys)
+	b, err := asBatcher(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := b.MGet(keys...)
+	l.infoLog.Println("mget", keys, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) MSet(items []KeyValue, expire time.Duration) ([]*Value, error) {
+	defer l.peekIntoPanic("mset", items, expire)
+	b, err := asBatcher(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := b.MSet(items, expire)
+	l.infoLog.Println("mset", items, expire, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) MDel(keys ...string) (int, error) {
+	defer l.peekIntoPanic("mdel", keys)
+	b, err := asBatcher(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := b.MDel(keys...)
+	l.infoLog.Println("mdel", keys, "=>", result, err)
+	return result, err
+}
+
+func (p *persister) MGet(keys ...string) ([]*Value, error) {
+	b, err := asBatcher(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return b.MGet(keys...)
+}
+
+func (p *persister) MSet(items []KeyValue, expire time.Duration) ([]*Value, error) {
+	b, err := asBatcher(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return b.MSet(items, expire)
+}
+
+func (p *persister) MDel(keys ...string) (int, error) {
+	b, err := asBatcher(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return b.MDel(keys...)
+}
+
+func (t *ttl) MGet(keys ...string) ([]*Value, error) {
+	b, err := asBatcher(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return b.MGet(keys...)
+}
+
+func (t *ttl) MSet(items []KeyValue, expire time.Duration) ([]*Value, error) {
+	b, err := asBatcher(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	delay := t.delay(expire)
+	result, err := b.MSet(items, delay)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		t.scheduleRemove(items[i].Key, result[i], delay)
+	}
+	return result, nil
+}
+
+func (t *ttl) MDel(keys ...string) (int, error) {
+	b, err := asBatcher(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return b.MDel(keys...)
+}
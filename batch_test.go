@@ -0,0 +1,96 @@
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatcher_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	b := c.(Batcher)
+
+	items := []KeyValue{}
+	keys := []string{}
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		items = append(items, KeyValue{key, i})
+		keys = append(keys, key)
+	}
+	values, err := b.MSet(items, 0)
+	if err != nil || len(values) != 20 {
+		t.Fatalf("MSet: got %v values, err %v", len(values), err)
+	}
+	for i := range values {
+		if values[i].Data != i {
+			t.Errorf("MSet result %v: got %v", i, values[i])
+		}
+	}
+
+	values, err = b.MGet("key3", "missing", "key17", "key3")
+	if err != nil || len(values) != 4 || values[0].Data != 3 || values[1] != nil || values[2].Data != 17 || values[3].Data != 3 {
+		t.Errorf("MGet: got %v, err %v", values, err)
+	}
+
+	if _, err = b.MSet([]KeyValue{{"new", "x"}, {"bad", nil}}, 0); err != ErrInvalidValueType {
+		t.Errorf("MSet with invalid value: expected %v, got %v", ErrInvalidValueType, err)
+	}
+	if _, err = c.Get("new"); err != ErrKeyNotFound {
+		t.Errorf("MSet with invalid value should not write anything, got %v", err)
+	}
+
+	removed, err := b.MDel(append(keys[:10], "missing")...)
+	if err != nil || removed != 10 {
+		t.Errorf("MDel: expected 10 removed, got %v, err %v", removed, err)
+	}
+	left, _ := c.Keys()
+	if len(left) != 10 {
+		t.Errorf("MDel: expected 10 keys left, got %v", left)
+	}
+}
+
+func TestBatcher_TTL(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	b := c.(Batcher)
+
+	b.MSet([]KeyValue{{"a", 1}, {"b", 2}}, 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	values, _ := b.MGet("a", "b")
+	if !reflect.DeepEqual(values, []*Value{nil, nil}) {
+		t.Errorf("MGet after TTL: got %v", values)
+	}
+}
+
+func TestBatcher_Persist(t *testing.T) {
+	s, _ := newSharder(2, nil)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+	// шарды пишут журнал параллельно, поэтому порядок записей разных ключей
+	// внутри пакета не определен
+	steps := []struct {
+		run      func()
+		expected []string
+	}{
+		{func() { p.MSet([]KeyValue{{"a", 1}, {"b", []interface{}{1, 2}}}, 0) }, []string{
+			`{"Type":"Set","k":"a","v":1,"e":0}`,
+			`{"Type":"Set","k":"b","v":[1,2],"e":0}`,
+		}},
+		{func() { p.MDel("a", "c") }, []string{
+			`{"Type":"Remove","k":"a","v":null,"e":0}`,
+			`{"Type":"Remove","k":"c","v":null,"e":0}`,
+		}},
+	}
+	for _, step := range steps {
+		rw.Reset()
+		step.run()
+		got := strings.Split(strings.TrimSuffix(flushOplog(p, &rw, strings.Join(step.expected, "\n")+"\n"), "\n"), "\n")
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, step.expected) {
+			t.Errorf("TestBatcher_Persist expected:\n%v\ngot:\n%v", step.expected, got)
+		}
+	}
+}
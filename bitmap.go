@@ -0,0 +1,349 @@
+/*
+    Битовые операции над строками и двоичными данными (SETBIT, GETBIT,
+    BITCOUNT, BITOP). Биты нумеруются от старшего бита первого байта
+*/
+
+package db
+
+import (
+	"errors"
+	"math/bits"
+	"strings"
+)
+
+var (
+	ErrBitOffset    = errors.New("bit offset is not an integer or out of range")
+	ErrBitValue     = errors.New("bit is not an integer or out of range")
+	ErrUnknownBitOp = errors.New("bit operation should be one of AND, OR, XOR and NOT")
+	ErrBitOpNot     = errors.New("bit operation NOT must be called with a single source key")
+	ErrBitOpNoKeys  = errors.New("bit operation requires at least one source key")
+)
+
+// maxBitOffset ограничивает битовую строку 512 МБ, как в Redis
+const maxBitOffset = 1<<32 - 1
+
+// BitOperator работает со значениями STRING (строки) и BYTES как с массивом
+// битов. Отсутствующий ключ считается пустой строкой. Измененное значение
+// всегда сохраняется как BYTES: произвольные байты не переживают JSON строки
+type BitOperator interface {
+	// SetBit устанавливает бит и возвращает его предыдущее значение.
+	// Строка дополняется нулевыми байтами до нужной длины
+	SetBit(key string, offset int64, bit int) (int, error)
+	GetBit(key string, offset int64) (int, error)
+	// BitCount считает единичные биты в байтах от start до stop включительно.
+	// Отрицательные позиции отсчитываются от конца
+	BitCount(key string, start int, stop int) (int, error)
+	// BitOp записывает в destKey результат AND, OR, XOR или NOT над ключами
+	// и возвращает длину результата в байтах. Более короткие строки
+	// дополняются нулями. Пустой результат удаляет destKey
+	BitOp(op string, destKey string, keys ...string) (int, error)
+}
+
+func asBitOperator(c Cache) (BitOperator, error) {
+	bo, ok := c.(BitOperator)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return bo, nil
+}
+
+// bitmapBytes возвращает байты значения без копирования
+func bitmapBytes(value *Value) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch data := value.Data.(type) {
+	case []byte:
+		return data, nil
+	case string:
+		return []byte(data), nil
+	}
+	return nil, ErrWrongType
+}
+
+func validOffset(offset int64) error {
+	if offset < 0 || offset > maxBitOffset {
+		return ErrBitOffset
+	}
+	return nil
+}
+
+func (s *sharder) SetBit(key string, offset int64, bit int) (previous int, err error) {
+	if err := validOffset(offset); err != nil {
+		return 0, err
+	}
+	if bit != 0 && bit != 1 {
+		return 0, ErrBitValue
+	}
+	_, err = s.update(key, func(current *Value) (*Value, error) {
+		data, err := bitmapBytes(current)
+		if err != nil {
+			return nil, err
+		}
+		index, mask := offset/8, byte(0x80)>>uint(offset%8)
+		length := len(data)
+		if int64(length) <= index {
+			length = int(index) + 1
+		}
+		// значения не изменяются на месте
+		next := make([]byte, length)
+		copy(next, data)
+		if next[index]&mask != 0 {
+			previous = 1
+		}
+		if bit == 1 {
+			next[index] |= mask
+		} else {
+			next[index] &^= mask
+		}
+		result := &Value{Type: BYTES, Data: next}
+		if current != nil {
+			result.Expires = current.Expires
+		}
+		return result, nil
+	})
+	return previous, err
+}
+
+func (s *sharder) GetBit(key string, offset int64) (bit int, err error) {
+	if err := validOffset(offset); err != nil {
+		return 0, err
+	}
+	err = s.view(key, func(value *Value) error {
+		data, err := bitmapBytes(value)
+		if err != nil {
+			return err
+		}
+		if offset/8 < int64(len(data)) && data[offset/8]&(0x80>>uint(offset%8)) != 0 {
+			bit = 1
+		}
+		return nil
+	})
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	return bit, err
+}
+
+func (s *sharder) BitCount(key string, start int, stop int) (count int, err error) {
+	err = s.view(key, func(value *Value) error {
+		data, err := bitmapBytes(value)
+		if err != nil {
+			return err
+		}
+		start, stop := normalizeIndex(start, len(data)), normalizeIndex(stop, len(data))
+		if start < 0 {
+			start = 0
+		}
+		if stop >= len(data) {
+			stop = len(data) - 1
+		}
+		for i := start; i <= stop; i++ {
+			count += bits.OnesCount8(data[i])
+		}
+		return nil
+	})
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	return count, err
+}
+
+// bitOp вычисляет результат операции над байтами источников
+func bitOp(op string, sources [][]byte) []byte {
+	length := 0
+	for _, src := range sources {
+		if len(src) > length {
+			length = len(src)
+		}
+	}
+	result := make([]byte, length)
+	if op == "NOT" {
+		for i := range result {
+			result[i] = ^sources[0][i]
+		}
+		return result
+	}
+	copy(result, sources[0])
+	for _, src := range sources[1:] {
+		for i := range result {
+			var b byte
+			if i < len(src) {
+				b = src[i]
+			}
+			switch op {
+			case "AND":
+				result[i] &= b
+			case "OR":
+				result[i] |= b
+			case "XOR":
+				result[i] ^= b
+			}
+		}
+	}
+	return result
+}
+
+func (s *sharder) BitOp(op string, destKey string, keys ...string) (int, error) {
+	op = strings.ToUpper(op)
+	switch op {
+	case "AND", "OR", "XOR":
+		if len(keys) == 0 {
+			return 0, ErrBitOpNoKeys
+		}
+	case "NOT":
+		if len(keys) != 1 {
+			return 0, ErrBitOpNot
+		}
+	default:
+		return 0, ErrUnknownBitOp
+	}
+	target, ok := s.shards[s.getTargetShardIdx(destKey)].(updater)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	unlock := s.lockShards(append([]string{destKey}, keys...))
+	defer unlock()
+
+	sources := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, err := s.shards[s.getTargetShardIdx(key)].Get(key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if sources[i], err = bitmapBytes(value); err != nil {
+			return 0, err
+		}
+	}
+	result := bitOp(op, sources)
+	_, err := s.updateShard(target, destKey, func(*Value) (*Value, error) {
+		if len(result) == 0 {
+			return nil, nil
+		}
+		return &Value{Type: BYTES, Data: result}, nil
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return len(result), nil
+}
+
+func (l *logger) SetBit(key string, offset int64, bit int) (int, error) {
+	defer l.peekIntoPanic("setbit", key, offset, bit)
+	bo, err := asBitOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := bo.SetBit(key, offset, bit)
+	l.infoLog.Println("setbit", key, offset, bit, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) GetBit(key string, offset int64) (int, error) {
+	defer l.peekIntoPanic("getbit", key, offset)
+	bo, err := asBitOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := bo.GetBit(key, offset)
+	l.infoLog.Println("getbit", key, offset, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) BitCount(key string, start int, stop int) (int, error) {
+	defer l.peekIntoPanic("bitcount", key, start, stop)
+	bo, err := asBitOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := bo.BitCount(key, start, stop)
+	l.infoLog.Println("bitcount", key, start, stop, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) BitOp(op string, destKey string, keys ...string) (int, error) {
+	defer l.peekIntoPanic("bitop", op, destKey, keys)
+	bo, err := asBitOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := bo.BitOp(op, destKey, keys...)
+	l.infoLog.Println("bitop", op, destKey, keys, "=>", result, err)
+	return result, err
+}
+
+// битовые операции записываются в журнал итоговым значением ключа
+func (p *persister) SetBit(key string, offset int64, bit int) (int, error) {
+	bo, err := asBitOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return bo.SetBit(key, offset, bit)
+}
+
+func (p *persister) GetBit(key string, offset int64) (int, error) {
+	bo, err := asBitOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return bo.GetBit(key, offset)
+}
+
+func (p *persister) BitCount(key string, start int, stop int) (int, error) {
+	bo, err := asBitOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return bo.BitCount(key, start, stop)
+}
+
+func (p *persister) BitOp(op string, destKey string, keys ...string) (int, error) {
+	bo, err := asBitOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return bo.BitOp(op, destKey, keys...)
+}
+
+func (t *ttl) SetBit(key string, offset int64, bit int) (int, error) {
+	bo, err := asBitOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := bo.SetBit(key, offset, bit)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) GetBit(key string, offset int64) (int, error) {
+	bo, err := asBitOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return bo.GetBit(key, offset)
+}
+
+func (t *ttl) BitCount(key string, start int, stop int) (int, error) {
+	bo, err := asBitOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return bo.BitCount(key, start, stop)
+}
+
+func (t *ttl) BitOp(op string, destKey string, keys ...string) (int, error) {
+	bo, err := asBitOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := bo.BitOp(op, destKey, keys...)
+	if err == nil {
+		t.expireCreated(op)
+	}
+	return result, err
+}
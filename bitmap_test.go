@@ -0,0 +1,111 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBitOperator(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	bo := c.(BitOperator)
+
+	if bit, err := bo.GetBit("flags", 100); bit != 0 || err != nil {
+		t.Errorf("GetBit of missing key: got %v, err %v", bit, err)
+	}
+	if prev, err := bo.SetBit("flags", 7, 1); prev != 0 || err != nil {
+		t.Errorf("SetBit: got %v, err %v", prev, err)
+	}
+	if prev, _ := bo.SetBit("flags", 7, 1); prev != 1 {
+		t.Errorf("SetBit of set bit: expected previous 1, got %v", prev)
+	}
+	bo.SetBit("flags", 9, 1)
+	value, _ := c.Get("flags")
+	if value.Type != BYTES || !bytes.Equal(value.Data.([]byte), []byte{0x01, 0x40}) {
+		t.Errorf("SetBit: expected bytes [01 40], got %v", value)
+	}
+	if bit, _ := bo.GetBit("flags", 9); bit != 1 {
+		t.Errorf("GetBit: expected 1, got %v", bit)
+	}
+	if prev, _ := bo.SetBit("flags", 9, 0); prev != 1 {
+		t.Errorf("SetBit clearing bit: expected previous 1, got %v", prev)
+	}
+
+	if _, err := bo.SetBit("flags", -1, 1); err != ErrBitOffset {
+		t.Errorf("SetBit with negative offset: expected %v, got %v", ErrBitOffset, err)
+	}
+	if _, err := bo.SetBit("flags", 0, 2); err != ErrBitValue {
+		t.Errorf("SetBit with bit 2: expected %v, got %v", ErrBitValue, err)
+	}
+	c.Set("list", []interface{}{1}, 0)
+	if _, err := bo.SetBit("list", 0, 1); err != ErrWrongType {
+		t.Errorf("SetBit on list: expected %v, got %v", ErrWrongType, err)
+	}
+
+	// строки читаются как байты: "foobar" содержит 26 единичных битов
+	c.Set("s", "foobar", 0)
+	for _, tt := range []struct{ start, stop, expected int }{
+		{0, -1, 26}, {0, 0, 4}, {1, 1, 6}, {-2, -1, 7}, {5, 100, 4}, {4, 2, 0},
+	} {
+		if n, err := bo.BitCount("s", tt.start, tt.stop); n != tt.expected || err != nil {
+			t.Errorf("BitCount(%v, %v): expected %v, got %v, err %v", tt.start, tt.stop, tt.expected, n, err)
+		}
+	}
+	if n, _ := bo.BitCount("missing", 0, -1); n != 0 {
+		t.Errorf("BitCount of missing key: expected 0, got %v", n)
+	}
+}
+
+func TestBitOperator_BitOp(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	bo := c.(BitOperator)
+	c.Set("a", []byte{0xf0, 0xff}, 0)
+	c.Set("b", []byte{0x3c}, 0)
+
+	for _, tt := range []struct {
+		op       string
+		keys     []string
+		expected []byte
+	}{
+		{"and", []string{"a", "b"}, []byte{0x30, 0x00}},
+		{"OR", []string{"a", "b"}, []byte{0xfc, 0xff}},
+		{"xor", []string{"a", "b", "missing"}, []byte{0xcc, 0xff}},
+		{"not", []string{"b"}, []byte{0xc3}},
+	} {
+		n, err := bo.BitOp(tt.op, "dest", tt.keys...)
+		value, _ := c.Get("dest")
+		if err != nil || n != len(tt.expected) || !bytes.Equal(value.Data.([]byte), tt.expected) {
+			t.Errorf("BitOp %v: expected %x, got %v (%v), err %v", tt.op, tt.expected, value, n, err)
+		}
+	}
+
+	if n, _ := bo.BitOp("and", "dest", "missing"); n != 0 {
+		t.Errorf("BitOp of missing keys: expected 0, got %v", n)
+	}
+	if _, err := c.Get("dest"); err != ErrKeyNotFound {
+		t.Errorf("empty BitOp result should remove destination, got %v", err)
+	}
+	if _, err := bo.BitOp("nand", "dest", "a"); err != ErrUnknownBitOp {
+		t.Errorf("BitOp with unknown op: expected %v, got %v", ErrUnknownBitOp, err)
+	}
+	if _, err := bo.BitOp("not", "dest", "a", "b"); err != ErrBitOpNot {
+		t.Errorf("BitOp NOT with two keys: expected %v, got %v", ErrBitOpNot, err)
+	}
+	if _, err := bo.BitOp("or", "dest"); err != ErrBitOpNoKeys {
+		t.Errorf("BitOp without keys: expected %v, got %v", ErrBitOpNoKeys, err)
+	}
+}
+
+func TestBitOperator_Persist(t *testing.T) {
+	sample := `{"Type":"Set","k":"flags","v":"AQ==","e":0,"t":5}
+{"Type":"Set","k":"copy","v":"AQ==","e":0,"t":5}
+{"Type":"Remove","k":"copy","v":null,"e":0}
+`
+	restored := checkPersist(t, 2, sample, func(p *persister) {
+		p.SetBit("flags", 7, 1)
+		p.BitOp("or", "copy", "flags")
+		p.BitOp("and", "copy", "missing")
+	})
+	if bit, _ := restored.GetBit("flags", 7); bit != 1 {
+		t.Errorf("TestBitOperator_Persist restore: expected bit 1, got %v", bit)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+    Блокирующие извлечения из списков (BLPOP, BRPOP)
+*/
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrPopTimeout = errors.New("timeout waiting for list item")
+
+// BlockingLister извлекает элемент из списка, а если список пуст - ждет
+// вставки не дольше timeout. Нулевой timeout ждет до отмены ctx. Ожидающие
+// одного ключа получают элементы в порядке прихода
+type BlockingLister interface {
+	BLPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error)
+	BRPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error)
+}
+
+func asBlockingLister(c Cache) (BlockingLister, error) {
+	b, ok := c.(BlockingLister)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return b, nil
+}
+
+// blockingPop встает в очередь ключа до первой попытки, поэтому вставка
+// между попыткой и ожиданием не теряется. Извлекать может только первый в
+// очереди: новые запросы не обгоняют тех, кто ждет дольше
+func (s *sharder) blockingPop(ctx context.Context, key string, left bool, timeout time.Duration) (interface{}, error) {
+	e := s.poppers.join(key)
+	defer s.poppers.leave(key, e)
+	w := e.Value.(*queuedWaiter)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		if s.poppers.first(key, e) {
+			item, err := s.pop(key, left)
+			if err != ErrKeyNotFound {
+				return item, err
+			}
+		}
+		select {
+		case <-w.ready:
+		case <-expired:
+			return nil, ErrPopTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *sharder) BLPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	return s.blockingPop(ctx, key, true, timeout)
+}
+
+func (s *sharder) BRPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	return s.blockingPop(ctx, key, false, timeout)
+}
+
+func (l *logger) BLPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	defer l.peekIntoPanic("blpop", key, timeout)
+	target, err := asBlockingLister(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	item, err := target.BLPop(ctx, key, timeout)
+	l.infoLog.Println("blpop", key, timeout, "=>", item, err)
+	return item, err
+}
+
+func (l *logger) BRPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	defer l.peekIntoPanic("brpop", key, timeout)
+	target, err := asBlockingLister(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	item, err := target.BRPop(ctx, key, timeout)
+	l.infoLog.Println("brpop", key, timeout, "=>", item, err)
+	return item, err
+}
+
+// BLPop пишет в журнал обычный LPop: его записывает извлечение под
+// блокировкой шарда (см. journaler)
+func (p *persister) BLPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	target, err := asBlockingLister(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.BLPop(ctx, key, timeout)
+}
+
+func (p *persister) BRPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	target, err := asBlockingLister(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.BRPop(ctx, key, timeout)
+}
+
+func (t *ttl) BLPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	target, err := asBlockingLister(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.BLPop(ctx, key, timeout)
+}
+
+func (t *ttl) BRPop(ctx context.Context, key string, timeout time.Duration) (interface{}, error) {
+	target, err := asBlockingLister(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.BRPop(ctx, key, timeout)
+}
@@ -0,0 +1,222 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueue ждет, пока в очереди ключа не встанут n ожидающих
+func waitQueue(t *testing.T, s *sharder, key string, n int) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.poppers.mu.Lock()
+		l, ok := s.poppers.queues[key]
+		length := 0
+		if ok {
+			length = l.Len()
+		}
+		s.poppers.mu.Unlock()
+		if length == n {
+			return
+		}
+	}
+	t.Fatalf("expected %v waiters on %v", n, key)
+}
+
+func TestBlockingLister_Pop(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	b := c.(BlockingLister)
+	c.(Lister).RPush("list", 1, 2)
+
+	item, err := b.BLPop(context.Background(), "list", time.Second)
+	if item != 1 || err != nil {
+		t.Errorf("BLPop on non-empty list: expected 1, got %v, err %v", item, err)
+	}
+	item, err = b.BRPop(context.Background(), "list", time.Second)
+	if item != 2 || err != nil {
+		t.Errorf("BRPop on non-empty list: expected 2, got %v, err %v", item, err)
+	}
+
+	start := time.Now()
+	if _, err = b.BLPop(context.Background(), "list", 30*time.Millisecond); err != ErrPopTimeout || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("BLPop on empty list: expected %v, got %v", ErrPopTimeout, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.(Lister).LPush("list", "x")
+	}()
+	if item, err = b.BRPop(context.Background(), "list", time.Second); item != "x" || err != nil {
+		t.Errorf("BRPop should wake on push: got %v, err %v", item, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.Set("list", []interface{}{"set"}, 0)
+	}()
+	if item, err = b.BLPop(context.Background(), "list", time.Second); item != "set" || err != nil {
+		t.Errorf("BLPop should wake on set: got %v, err %v", item, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.(Batcher).MSet([]KeyValue{{"other", 1}, {"list", []interface{}{"mset"}}}, 0)
+	}()
+	if item, err = b.BLPop(context.Background(), "list", time.Second); item != "mset" || err != nil {
+		t.Errorf("BLPop should wake on MSet: got %v, err %v", item, err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		c.(Transactor).Exec(nil, []TxOp{{Type: "Set", Key: "list", Value: []interface{}{"exec"}}})
+	}()
+	if item, err = b.BLPop(context.Background(), "list", time.Second); item != "exec" || err != nil {
+		t.Errorf("BLPop should wake on Exec: got %v, err %v", item, err)
+	}
+
+	// SetNX, CompareAndSet и Rename изменяют ключ через updateShard
+	wakers := map[string]func(){
+		"setnx": func() { c.(ConditionalWriter).SetNX("list", []interface{}{"setnx"}, 0) },
+		"cas":   func() { c.(CompareAndSetter).CompareAndSet("list", 0, []interface{}{"cas"}, 0) },
+		"rename": func() {
+			c.Set("source", []interface{}{"rename"}, 0)
+			c.(KeyManager).Rename("source", "list")
+		},
+	}
+	for expected, wake := range wakers {
+		go func(wake func()) {
+			time.Sleep(20 * time.Millisecond)
+			wake()
+		}(wake)
+		if item, err = b.BLPop(context.Background(), "list", time.Second); item != expected || err != nil {
+			t.Errorf("BLPop should wake on %v: got %v, err %v", expected, item, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err = b.BLPop(ctx, "list", 0); err != context.Canceled {
+		t.Errorf("BLPop with canceled context: expected %v, got %v", context.Canceled, err)
+	}
+
+	c.Set("string", "abc", 0)
+	if _, err = b.BLPop(context.Background(), "string", time.Second); err != ErrWrongType {
+		t.Errorf("BLPop on string: expected %v, got %v", ErrWrongType, err)
+	}
+}
+
+func TestBlockingLister_Fairness(t *testing.T) {
+	s, _ := newSharder(2, nil)
+	results := make([]chan interface{}, 3)
+	cancels := make([]context.CancelFunc, 3)
+	for i := range results {
+		results[i] = make(chan interface{}, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels[i] = cancel
+		go func(ctx context.Context, result chan interface{}) {
+			item, err := s.BLPop(ctx, "queue", time.Second)
+			if err != nil {
+				item = err
+			}
+			result <- item
+		}(ctx, results[i])
+		waitQueue(t, s, "queue", i+1)
+	}
+
+	// ушедший из очереди не забирает элемент у следующих
+	cancels[1]()
+	if got := <-results[1]; got != context.Canceled {
+		t.Errorf("canceled waiter: expected %v, got %v", context.Canceled, got)
+	}
+	s.RPush("queue", "a", "b")
+	if got := <-results[0]; got != "a" {
+		t.Errorf("first waiter: expected a, got %v", got)
+	}
+	if got := <-results[2]; got != "b" {
+		t.Errorf("third waiter: expected b, got %v", got)
+	}
+	waitQueue(t, s, "queue", 0)
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+func TestBlockingLister_Persist(t *testing.T) {
+	sample := `{"Type":"RPush","k":"list","v":["a"],"e":0}
+{"Type":"LPop","k":"list","v":null,"e":0}
+`
+	restored := checkPersist(t, 1, sample, func(p *persister) {
+		result := make(chan interface{}, 1)
+		go func() {
+			item, err := p.BLPop(context.Background(), "list", time.Second)
+			if err != nil {
+				item = err
+			}
+			result <- item
+		}()
+		waitQueue(t, p.Cache.(*sharder), "list", 1)
+		p.RPush("list", "a")
+		if item := <-result; item != "a" {
+			t.Errorf("BLPop through persister: expected a, got %v", item)
+		}
+	})
+	if _, err := restored.Get("list"); err != ErrKeyNotFound {
+		t.Errorf("TestBlockingLister_Persist restore: expected popped list to be removed, got %v", err)
+	}
+}
+
+func TestBlockingLister_PersistOrder(t *testing.T) {
+	s, _ := newSharder(2, nil)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+
+	// вставки, извлечения, блокирующие извлечения, запись и удаление ключа
+	// идут в журнал в порядке исполнения, поэтому восстановленный список
+	// совпадает с исходным
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				p.LPush("list", float64(g*100+i))
+				p.RPush("list", float64(g*100+i))
+				p.LSet("list", 0, float64(i))
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				p.BLPop(context.Background(), "list", 10*time.Millisecond)
+				p.RPop("list")
+				switch i % 10 {
+				case 3:
+					p.Remove("list")
+				case 7:
+					p.Set("list", []interface{}{"set"}, 0)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	p.RPush("list", "end")
+	expected, _ := s.LRange("list", 0, -1)
+	for deadline := time.Now().Add(time.Second); !bytes.Contains(rw.Bytes(), []byte(`"end"`)) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		p.writeOplog(p.grabOplog())
+	}
+
+	restored, _ := newSharder(2, nil)
+	if _, err := newPersister(restored, &rw, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.LRange("list", 0, -1); !reflect.DeepEqual(got, expected) {
+		t.Errorf("restored list differs:\nexpected %v\ngot %v", expected, got)
+	}
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	payload := []byte("line\nbreak\x00\xff")
+
+	value, err := c.Set("blob", payload, 0)
+	if err != nil || value.Type != BYTES {
+		t.Fatalf("Set bytes: got %v, err %v", value, err)
+	}
+	// сохраненное значение не зависит от буфера вызывающего кода
+	payload[0] = 'X'
+	stored, _ := c.Get("blob")
+	if !bytes.Equal(stored.Data.([]byte), []byte("line\nbreak\x00\xff")) {
+		t.Errorf("stored bytes changed with caller's buffer: %q", stored.Data)
+	}
+
+	encoded, _ := json.Marshal(stored)
+	decoded := Value{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded.Type != BYTES || !bytes.Equal(decoded.Data.([]byte), stored.Data.([]byte)) {
+		t.Errorf("Value json round trip: got %v, err %v", decoded, err)
+	}
+	if _, err := c.(Counter).IncrBy("blob", 1); err != ErrWrongType {
+		t.Errorf("IncrBy on bytes: expected %v, got %v", ErrWrongType, err)
+	}
+}
+
+func TestBytes_Persist(t *testing.T) {
+	sample := `{"Type":"Set","k":"blob","v":"AAEKDf8=","e":0,"t":5}
+`
+	restored := checkPersist(t, 1, sample, func(p *persister) {
+		p.Set("blob", []byte{0x00, 0x01, '\n', '\r', 0xff}, 0)
+	})
+	value, err := restored.Get("blob")
+	if err != nil || value.Type != BYTES || !bytes.Equal(value.Data.([]byte), []byte{0x00, 0x01, '\n', '\r', 0xff}) {
+		t.Errorf("TestBytes_Persist restore: got %v, err %v", value, err)
+	}
+}
@@ -6,6 +6,8 @@
 package db
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"io"
 	"time"
@@ -26,6 +28,8 @@ var (
 	ErrConversionError  = errors.New("failed to convert item")
 	ErrIllegalIndexType = errors.New("list does not support given index type")
 	ErrNonIntegerSubkey = errors.New("index conversion to int failed")
+	ErrWrongType        = errors.New("operation against a key holding the wrong kind of value")
+	ErrUnsupported      = errors.New("operation is not supported by the cache")
 )
 
 type DataType int
@@ -34,12 +38,96 @@ const (
 	STRING DataType = iota
 	LIST
 	MAP
+	SET
+	ZSET
+	// BYTES - двоичные данные ([]byte). В JSON и журнале хранятся в base64
+	BYTES
+	// HLL - HyperLogLog для оценки числа уникальных элементов
+	HLL
+	// STREAM - поток записей с группами потребителей (*Stream)
+	STREAM
 )
 
+func (t DataType) String() string {
+	switch t {
+	case STRING:
+		return "string"
+	case LIST:
+		return "list"
+	case MAP:
+		return "map"
+	case SET:
+		return "set"
+	case ZSET:
+		return "zset"
+	case BYTES:
+		return "bytes"
+	case HLL:
+		return "hll"
+	case STREAM:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// Value - значение ключа. Version растет при каждой записи ключа и
+// используется для оптимистичных блокировок (CompareAndSet). В JSON не
+// попадает: в REST API версия передается заголовком ETag
 type Value struct {
 	Type    DataType    `json:"type"`
 	Data    interface{} `json:"data"`
 	Expires int64       `json:"expires,omitempty"`
+	Version uint64      `json:"-"`
+}
+
+// UnmarshalJSON восстанавливает Data в представлении, соответствующем Type
+func (v *Value) UnmarshalJSON(b []byte) error {
+	raw := struct {
+		Type    DataType    `json:"type"`
+		Data    interface{} `json:"data"`
+		Expires int64       `json:"expires,omitempty"`
+	}{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	data, err := convertData(raw.Type, raw.Data)
+	if err != nil {
+		return err
+	}
+	v.Type, v.Data, v.Expires = raw.Type, data, raw.Expires
+	return nil
+}
+
+// convertData приводит данные, декодированные из JSON, к типу хранения
+func convertData(t DataType, data interface{}) (interface{}, error) {
+	switch t {
+	case SET:
+		return newSetFromJSON(data)
+	case ZSET:
+		return newSortedSetFromJSON(data)
+	case BYTES:
+		return newBytesFromJSON(data)
+	case HLL:
+		return newHyperLogLogFromJSON(data)
+	case STREAM:
+		return newStreamFromJSON(data)
+	}
+	return data, nil
+}
+
+// newBytesFromJSON декодирует base64, в который json.Marshal кодирует []byte
+func newBytesFromJSON(data interface{}) ([]byte, error) {
+	switch b := data.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		decoded, err := base64.StdEncoding.DecodeString(b)
+		if err != nil {
+			return nil, ErrConversionError
+		}
+		return decoded, nil
+	}
+	return nil, ErrConversionError
 }
 
 func NewCache(defaultTTL time.Duration, out io.Writer, rw io.ReadWriter, saveFreq time.Duration, nShards int, shardingFunc shardFunction) (c Cache, err error) {
@@ -0,0 +1,72 @@
+/*
+    Оптимистичные блокировки: запись при совпадении версии значения
+*/
+
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrVersionMismatch = errors.New("value version does not match")
+
+// CompareAndSetter записывает значение, только если текущая версия ключа
+// равна expectedVersion. Версия 0 означает, что ключа быть не должно
+type CompareAndSetter interface {
+	CompareAndSet(key string, expectedVersion uint64, value interface{}, expire time.Duration) (*Value, error)
+}
+
+func asCompareAndSetter(c Cache) (CompareAndSetter, error) {
+	cas, ok := c.(CompareAndSetter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return cas, nil
+}
+
+func (s *sharder) CompareAndSet(key string, expectedVersion uint64, value interface{}, expire time.Duration) (*Value, error) {
+	return s.update(key, func(current *Value) (*Value, error) {
+		var version uint64
+		if current != nil {
+			version = current.Version
+		}
+		if version != expectedVersion {
+			return nil, ErrVersionMismatch
+		}
+		return newValue(value, expire)
+	})
+}
+
+func (l *logger) CompareAndSet(key string, expectedVersion uint64, value interface{}, expire time.Duration) (*Value, error) {
+	defer l.peekIntoPanic("cas", key, expectedVersion, value, expire)
+	cas, err := asCompareAndSetter(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cas.CompareAndSet(key, expectedVersion, value, expire)
+	l.infoLog.Println("cas", key, expectedVersion, value, expire, "=>", result, err)
+	return result, err
+}
+
+func (p *persister) CompareAndSet(key string, expectedVersion uint64, value interface{}, expire time.Duration) (*Value, error) {
+	cas, err := asCompareAndSetter(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return cas.CompareAndSet(key, expectedVersion, value, expire)
+}
+
+func (t *ttl) CompareAndSet(key string, expectedVersion uint64, value interface{}, expire time.Duration) (*Value, error) {
+	cas, err := asCompareAndSetter(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	delay := t.delay(expire)
+	result, err := cas.CompareAndSet(key, expectedVersion, value, delay)
+	if err != nil {
+		return nil, err
+	}
+	t.scheduleRemove(key, result, delay)
+	return result, nil
+}
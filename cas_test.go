@@ -0,0 +1,108 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSet(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	cas := c.(CompareAndSetter)
+
+	if _, err := cas.CompareAndSet("config", 1, "x", 0); err != ErrVersionMismatch {
+		t.Errorf("CompareAndSet of missing key with version: expected %v, got %v", ErrVersionMismatch, err)
+	}
+	created, err := cas.CompareAndSet("config", 0, "v1", 0)
+	if err != nil || created.Data != "v1" || created.Version == 0 {
+		t.Fatalf("CompareAndSet creating key: got %v, err %v", created, err)
+	}
+	if _, err = cas.CompareAndSet("config", 0, "v1", 0); err != ErrVersionMismatch {
+		t.Errorf("CompareAndSet with version 0 of existing key: expected %v, got %v", ErrVersionMismatch, err)
+	}
+
+	updated, err := cas.CompareAndSet("config", created.Version, "v2", 0)
+	if err != nil || updated.Version <= created.Version {
+		t.Errorf("CompareAndSet: expected version above %v, got %v, err %v", created.Version, updated, err)
+	}
+	if _, err = cas.CompareAndSet("config", created.Version, "v3", 0); err != ErrVersionMismatch {
+		t.Errorf("CompareAndSet with stale version: expected %v, got %v", ErrVersionMismatch, err)
+	}
+
+	current, _ := c.Get("config")
+	if current.Data != "v2" || current.Version != updated.Version {
+		t.Errorf("Get after CompareAndSet: got %v", current)
+	}
+
+	// любая запись меняет версию, в том числе операции над полями
+	c.Set("config", map[string]interface{}{"a": 1}, 0)
+	before, _ := c.Get("config")
+	c.(Hasher).HSet("config", "b", 2)
+	after, _ := c.Get("config")
+	if after.Version <= before.Version {
+		t.Errorf("HSet should bump version: %v -> %v", before.Version, after.Version)
+	}
+	c.(SortedSetOperator).ZAdd("board", ZMember{"a", 1})
+	before, _ = c.Get("board")
+	c.(SortedSetOperator).ZAdd("board", ZMember{"b", 2})
+	after, _ = c.Get("board")
+	if after.Version <= before.Version || before.Version == 0 {
+		t.Errorf("ZAdd should bump version: %v -> %v", before.Version, after.Version)
+	}
+
+	// операции, которые ничего не меняют, версию сохраняют
+	c.(SetOperator).SAdd("tags", "a")
+	before, _ = c.Get("tags")
+	c.(SetOperator).SAdd("tags", "a")
+	c.(SetOperator).SRem("tags", "b")
+	c.(Hasher).HDel("config", "missing")
+	after, _ = c.Get("tags")
+	if after.Version != before.Version {
+		t.Errorf("no-op SAdd/SRem should keep version: %v -> %v", before.Version, after.Version)
+	}
+	before, _ = c.Get("board")
+	c.(SortedSetOperator).ZAdd("board", ZMember{"b", 2})
+	c.(SortedSetOperator).ZRem("board", "missing")
+	after, _ = c.Get("board")
+	if after.Version != before.Version {
+		t.Errorf("no-op ZAdd/ZRem should keep version: %v -> %v", before.Version, after.Version)
+	}
+}
+
+func TestCompareAndSet_NoLostUpdates(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	cas := c.(CompareAndSetter)
+	c.Set("n", 0, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				current, _ := c.Get("n")
+				if _, err := cas.CompareAndSet("n", current.Version, current.Data.(int)+1, 0); err == nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := c.Get("n"); value.Data != 20 {
+		t.Errorf("expected 20 increments, got %v", value.Data)
+	}
+}
+
+func TestCompareAndSet_TTL(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 1, nil)
+	cas := c.(CompareAndSetter)
+
+	created, _ := cas.CompareAndSet("a", 0, "x", 50*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := cas.CompareAndSet("a", created.Version, "y", 0); err != ErrVersionMismatch {
+		t.Errorf("CompareAndSet of expired key: expected %v, got %v", ErrVersionMismatch, err)
+	}
+	if _, err := cas.CompareAndSet("a", 0, "y", 0); err != nil {
+		t.Errorf("CompareAndSet creating expired key: got %v", err)
+	}
+}
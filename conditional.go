@@ -0,0 +1,203 @@
+/*
+    Условная запись (SET NX / SET XX) и атомарные GETSET / GETDEL
+*/
+
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrConditionNotMet = errors.New("write condition not met")
+
+// ConditionalWriter выполняет проверку и запись под одной блокировкой шарда
+type ConditionalWriter interface {
+	// SetNX записывает значение, только если ключа нет
+	SetNX(key string, value interface{}, expire time.Duration) (*Value, error)
+	// SetXX записывает значение, только если ключ уже есть
+	SetXX(key string, value interface{}, expire time.Duration) (*Value, error)
+	// GetSet записывает значение и возвращает предыдущее (nil, если ключа не было)
+	// вместе с новым
+	GetSet(key string, value interface{}, expire time.Duration) (previous *Value, result *Value, err error)
+	// GetDel удаляет ключ и возвращает его последнее значение
+	GetDel(key string) (*Value, error)
+}
+
+func asConditionalWriter(c Cache) (ConditionalWriter, error) {
+	cw, ok := c.(ConditionalWriter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return cw, nil
+}
+
+func (s *sharder) setIf(key string, value interface{}, expire time.Duration, exists bool) (*Value, error) {
+	return s.update(key, func(current *Value) (*Value, error) {
+		if (current != nil) != exists {
+			return nil, ErrConditionNotMet
+		}
+		return newValue(value, expire)
+	})
+}
+
+func (s *sharder) SetNX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	return s.setIf(key, value, expire, false)
+}
+
+func (s *sharder) SetXX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	return s.setIf(key, value, expire, true)
+}
+
+func (s *sharder) GetSet(key string, value interface{}, expire time.Duration) (*Value, *Value, error) {
+	var previous *Value
+	result, err := s.update(key, func(current *Value) (*Value, error) {
+		previous = current
+		return newValue(value, expire)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return previous, result, nil
+}
+
+func (s *sharder) GetDel(key string) (*Value, error) {
+	var previous *Value
+	_, err := s.update(key, func(current *Value) (*Value, error) {
+		if current == nil {
+			return nil, ErrKeyNotFound
+		}
+		previous = current
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+func (l *logger) SetNX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	defer l.peekIntoPanic("setnx", key, value, expire)
+	cw, err := asConditionalWriter(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cw.SetNX(key, value, expire)
+	l.infoLog.Println("setnx", key, value, expire, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) SetXX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	defer l.peekIntoPanic("setxx", key, value, expire)
+	cw, err := asConditionalWriter(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cw.SetXX(key, value, expire)
+	l.infoLog.Println("setxx", key, value, expire, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) GetSet(key string, value interface{}, expire time.Duration) (*Value, *Value, error) {
+	defer l.peekIntoPanic("getset", key, value, expire)
+	cw, err := asConditionalWriter(l.Cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	previous, result, err := cw.GetSet(key, value, expire)
+	l.infoLog.Println("getset", key, value, expire, "=>", previous, result, err)
+	return previous, result, err
+}
+
+func (l *logger) GetDel(key string) (*Value, error) {
+	defer l.peekIntoPanic("getdel", key)
+	cw, err := asConditionalWriter(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := cw.GetDel(key)
+	l.infoLog.Println("getdel", key, "=>", result, err)
+	return result, err
+}
+
+func (p *persister) SetNX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	cw, err := asConditionalWriter(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return cw.SetNX(key, value, expire)
+}
+
+func (p *persister) SetXX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	cw, err := asConditionalWriter(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return cw.SetXX(key, value, expire)
+}
+
+func (p *persister) GetSet(key string, value interface{}, expire time.Duration) (*Value, *Value, error) {
+	cw, err := asConditionalWriter(p.Cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cw.GetSet(key, value, expire)
+}
+
+func (p *persister) GetDel(key string) (*Value, error) {
+	cw, err := asConditionalWriter(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return cw.GetDel(key)
+}
+
+func (t *ttl) SetNX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	cw, err := asConditionalWriter(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	delay := t.delay(expire)
+	result, err := cw.SetNX(key, value, delay)
+	if err != nil {
+		return nil, err
+	}
+	t.scheduleRemove(key, result, delay)
+	return result, nil
+}
+
+func (t *ttl) SetXX(key string, value interface{}, expire time.Duration) (*Value, error) {
+	cw, err := asConditionalWriter(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	delay := t.delay(expire)
+	result, err := cw.SetXX(key, value, delay)
+	if err != nil {
+		return nil, err
+	}
+	t.scheduleRemove(key, result, delay)
+	return result, nil
+}
+
+func (t *ttl) GetSet(key string, value interface{}, expire time.Duration) (*Value, *Value, error) {
+	cw, err := asConditionalWriter(t.Cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	delay := t.delay(expire)
+	previous, result, err := cw.GetSet(key, value, delay)
+	if err != nil {
+		return nil, nil, err
+	}
+	t.scheduleRemove(key, result, delay)
+	return previous, result, nil
+}
+
+func (t *ttl) GetDel(key string) (*Value, error) {
+	cw, err := asConditionalWriter(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return cw.GetDel(key)
+}
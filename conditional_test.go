@@ -0,0 +1,101 @@
+package db
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConditionalWriter_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	cw := c.(ConditionalWriter)
+
+	if _, err := cw.SetXX("lock", "a", 0); err != ErrConditionNotMet {
+		t.Errorf("SetXX of missing key: expected %v, got %v", ErrConditionNotMet, err)
+	}
+	value, err := cw.SetNX("lock", "a", 0)
+	if err != nil || value.Data != "a" {
+		t.Errorf("SetNX of missing key: got %v, err %v", value, err)
+	}
+	if _, err = cw.SetNX("lock", "b", 0); err != ErrConditionNotMet {
+		t.Errorf("SetNX of existing key: expected %v, got %v", ErrConditionNotMet, err)
+	}
+	if value, err = cw.SetXX("lock", "c", 0); err != nil || value.Data != "c" {
+		t.Errorf("SetXX of existing key: got %v, err %v", value, err)
+	}
+
+	previous, result, err := cw.GetSet("lock", "d", 0)
+	if err != nil || previous.Data != "c" || result.Data != "d" {
+		t.Errorf("GetSet: got %v and %v, err %v", previous, result, err)
+	}
+	previous, _, err = cw.GetSet("fresh", "x", 0)
+	if err != nil || previous != nil {
+		t.Errorf("GetSet of missing key: expected nil previous value, got %v, err %v", previous, err)
+	}
+	if _, _, err = cw.GetSet("fresh", nil, 0); err != ErrInvalidValueType {
+		t.Errorf("GetSet of nil: expected %v, got %v", ErrInvalidValueType, err)
+	}
+
+	value, err = cw.GetDel("lock")
+	if err != nil || value.Data != "d" {
+		t.Errorf("GetDel: got %v, err %v", value, err)
+	}
+	if _, err = c.Get("lock"); err != ErrKeyNotFound {
+		t.Errorf("GetDel should remove the key, got %v", err)
+	}
+	if _, err = cw.GetDel("lock"); err != ErrKeyNotFound {
+		t.Errorf("GetDel of missing key: expected %v, got %v", ErrKeyNotFound, err)
+	}
+}
+
+func TestConditionalWriter_SetNXIsExclusive(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	cw := c.(ConditionalWriter)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	acquired := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cw.SetNX("lock", "owner", 0); err == nil {
+				mu.Lock()
+				acquired++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if acquired != 1 {
+		t.Errorf("SetNX: expected exactly one winner, got %v", acquired)
+	}
+}
+
+func TestConditionalWriter_TTL(t *testing.T) {
+	c, _ := NewCache(50*time.Millisecond, nil, nil, 0, 1, nil)
+	cw := c.(ConditionalWriter)
+
+	cw.SetNX("a", "x", 0)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := cw.SetNX("a", "y", 0); err != nil {
+		t.Errorf("SetNX after default TTL expired: got %v", err)
+	}
+}
+
+func TestConditionalWriter_Persist(t *testing.T) {
+	sample := `{"Type":"Set","k":"a","v":"x","e":0}
+{"Type":"Set","k":"a","v":"y","e":0}
+{"Type":"Set","k":"b","v":"z","e":0}
+{"Type":"Remove","k":"a","v":null,"e":0}
+`
+	checkPersist(t, 1, sample, func(p *persister) {
+		p.SetNX("a", "x", 0)
+		p.SetNX("a", "ignored", 0)
+		p.SetXX("a", "y", 0)
+		p.SetXX("missing", "ignored", 0)
+		p.GetSet("b", "z", 0)
+		p.GetDel("a")
+		p.GetDel("a")
+	})
+}
@@ -0,0 +1,186 @@
+/*
+    Атомарные счетчики над значениями типа STRING
+*/
+
+package db
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+var (
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+	ErrNotFloat   = errors.New("value is not a valid float")
+	ErrOverflow   = errors.New("increment would overflow")
+)
+
+// Counter увеличивает числовые значения атомарно под блокировкой шарда.
+// Отсутствующий ключ считается равным 0. Строки с числами тоже принимаются,
+// результат всегда сохраняется числом: int64 для IncrBy и float64 для IncrByFloat
+type Counter interface {
+	IncrBy(key string, delta int64) (*Value, error)
+	IncrByFloat(key string, delta float64) (*Value, error)
+}
+
+func asCounter(c Cache) (Counter, error) {
+	counter, ok := c.(Counter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return counter, nil
+}
+
+func toInt(data interface{}) (int64, error) {
+	if s, ok := data.(string); ok {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+		return n, nil
+	}
+	v := reflect.ValueOf(data)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.Uint() > math.MaxInt64 {
+			return 0, ErrNotInteger
+		}
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f > math.MaxInt64 || f < math.MinInt64 {
+			return 0, ErrNotInteger
+		}
+		return int64(f), nil
+	}
+	return 0, ErrNotInteger
+}
+
+func toFloat(data interface{}) (float64, error) {
+	if s, ok := data.(string); ok {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || !validScore(f) {
+			return 0, ErrNotFloat
+		}
+		return f, nil
+	}
+	v := reflect.ValueOf(data)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+	return 0, ErrNotFloat
+}
+
+func (s *sharder) IncrBy(key string, delta int64) (*Value, error) {
+	return s.update(key, func(current *Value) (*Value, error) {
+		var n, expires int64
+		if current != nil {
+			if current.Type != STRING {
+				return nil, ErrWrongType
+			}
+			var err error
+			if n, err = toInt(current.Data); err != nil {
+				return nil, err
+			}
+			expires = current.Expires
+		}
+		if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+			return nil, ErrOverflow
+		}
+		return &Value{Type: STRING, Data: n + delta, Expires: expires}, nil
+	})
+}
+
+func (s *sharder) IncrByFloat(key string, delta float64) (*Value, error) {
+	return s.update(key, func(current *Value) (*Value, error) {
+		var f float64
+		var expires int64
+		if current != nil {
+			if current.Type != STRING {
+				return nil, ErrWrongType
+			}
+			var err error
+			if f, err = toFloat(current.Data); err != nil {
+				return nil, err
+			}
+			expires = current.Expires
+		}
+		if !validScore(f + delta) {
+			return nil, ErrOverflow
+		}
+		return &Value{Type: STRING, Data: f + delta, Expires: expires}, nil
+	})
+}
+
+func (l *logger) IncrBy(key string, delta int64) (*Value, error) {
+	defer l.peekIntoPanic("incrby", key, delta)
+	counter, err := asCounter(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := counter.IncrBy(key, delta)
+	l.infoLog.Println("incrby", key, delta, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) IncrByFloat(key string, delta float64) (*Value, error) {
+	defer l.peekIntoPanic("incrbyfloat", key, delta)
+	counter, err := asCounter(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := counter.IncrByFloat(key, delta)
+	l.infoLog.Println("incrbyfloat", key, delta, "=>", result, err)
+	return result, err
+}
+
+// счетчики записываются в журнал итоговым значением, чтобы восстановление
+// было точным независимо от порядка записей
+func (p *persister) IncrBy(key string, delta int64) (*Value, error) {
+	counter, err := asCounter(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return counter.IncrBy(key, delta)
+}
+
+func (p *persister) IncrByFloat(key string, delta float64) (*Value, error) {
+	counter, err := asCounter(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return counter.IncrByFloat(key, delta)
+}
+
+func (t *ttl) IncrBy(key string, delta int64) (*Value, error) {
+	counter, err := asCounter(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := counter.IncrBy(key, delta)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) IncrByFloat(key string, delta float64) (*Value, error) {
+	counter, err := asCounter(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := counter.IncrByFloat(key, delta)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
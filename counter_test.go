@@ -0,0 +1,95 @@
+package db
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounter_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	counter := c.(Counter)
+
+	value, err := counter.IncrBy("hits", 1)
+	if err != nil || value.Data != int64(1) {
+		t.Errorf("IncrBy of missing key: expected 1, got %v, err %v", value, err)
+	}
+	value, _ = counter.IncrBy("hits", 5)
+	if value.Data != int64(6) {
+		t.Errorf("IncrBy: expected 6, got %v", value.Data)
+	}
+	value, _ = counter.IncrBy("hits", -10)
+	if value.Data != int64(-4) {
+		t.Errorf("IncrBy with negative delta: expected -4, got %v", value.Data)
+	}
+
+	c.Set("numeric", "41", 0)
+	value, err = counter.IncrBy("numeric", 1)
+	if err != nil || value.Data != int64(42) {
+		t.Errorf("IncrBy of numeric string: expected 42, got %v, err %v", value, err)
+	}
+	c.Set("decoded", float64(7), 0)
+	if value, _ = counter.IncrBy("decoded", 1); value.Data != int64(8) {
+		t.Errorf("IncrBy of JSON number: expected 8, got %v", value.Data)
+	}
+
+	c.Set("text", "abc", 0)
+	if _, err = counter.IncrBy("text", 1); err != ErrNotInteger {
+		t.Errorf("IncrBy of text: expected %v, got %v", ErrNotInteger, err)
+	}
+	c.Set("fraction", 1.5, 0)
+	if _, err = counter.IncrBy("fraction", 1); err != ErrNotInteger {
+		t.Errorf("IncrBy of fraction: expected %v, got %v", ErrNotInteger, err)
+	}
+	c.Set("list", []interface{}{"a"}, 0)
+	if _, err = counter.IncrBy("list", 1); err != ErrWrongType {
+		t.Errorf("IncrBy of list: expected %v, got %v", ErrWrongType, err)
+	}
+	c.Set("max", int64(math.MaxInt64), 0)
+	if _, err = counter.IncrBy("max", 1); err != ErrOverflow {
+		t.Errorf("IncrBy past MaxInt64: expected %v, got %v", ErrOverflow, err)
+	}
+
+	value, err = counter.IncrByFloat("fraction", 0.25)
+	if err != nil || value.Data != 1.75 {
+		t.Errorf("IncrByFloat: expected 1.75, got %v, err %v", value, err)
+	}
+	if value, _ = counter.IncrByFloat("hits", 0.5); value.Data != -3.5 {
+		t.Errorf("IncrByFloat of integer: expected -3.5, got %v", value.Data)
+	}
+	if _, err = counter.IncrByFloat("text", 1); err != ErrNotFloat {
+		t.Errorf("IncrByFloat of text: expected %v, got %v", ErrNotFloat, err)
+	}
+	if _, err = counter.IncrByFloat("fraction", math.Inf(1)); err != ErrOverflow {
+		t.Errorf("IncrByFloat to infinity: expected %v, got %v", ErrOverflow, err)
+	}
+}
+
+func TestCounter_KeepsTTL(t *testing.T) {
+	c, _ := NewCache(time.Minute, nil, nil, 0, 1, nil)
+	counter := c.(Counter)
+
+	c.Set("a", 1, 50*time.Millisecond)
+	counter.IncrBy("a", 1)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Get("a"); err != ErrKeyNotFound {
+		t.Errorf("IncrBy should keep the key TTL, got %v", err)
+	}
+}
+
+func TestCounter_Persist(t *testing.T) {
+	sample := `{"Type":"Set","k":"c","v":1,"e":0}
+{"Type":"Set","k":"c","v":6,"e":0}
+{"Type":"Set","k":"c","v":6.5,"e":0}
+`
+	restored := checkPersist(t, 1, sample, func(p *persister) {
+		p.IncrBy("c", 1)
+		p.IncrBy("c", 5)
+		p.IncrByFloat("c", 0.5)
+		p.IncrBy("c", 1)
+	})
+	value, err := restored.IncrByFloat("c", 1)
+	if err != nil || value.Data != 7.5 {
+		t.Errorf("TestCounter_Persist restore: got %v, err %v", value, err)
+	}
+}
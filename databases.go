@@ -0,0 +1,208 @@
+/*
+    Логические базы: несколько независимых кэшей в одном процессе
+*/
+
+package db
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidDBName = errors.New("database name should consist of letters, digits, '_' and '-'")
+	ErrUnknownDB     = errors.New("database does not exist")
+)
+
+// DefaultDB - имя базы, используемой без явного выбора
+const DefaultDB = "0"
+
+var dbNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// DatabaseFactory создает кэш базы при первом обращении к ней. Каждая база
+// получает собственные шарды, ttl и, если нужно, журнал. Чтобы ограничить
+// набор баз, фабрика возвращает ErrUnknownDB
+type DatabaseFactory func(name string) (Cache, error)
+
+// Databases хранит логические базы по имени
+type Databases struct {
+	mu      sync.RWMutex
+	caches  map[string]Cache
+	factory DatabaseFactory
+}
+
+func NewDatabases(factory DatabaseFactory) *Databases {
+	return &Databases{caches: map[string]Cache{}, factory: factory}
+}
+
+// NumberedDatabases создает фабрику баз с номерами от 0 до n-1. Все базы
+// создаются через NewCache с одинаковыми параметрами. storage возвращает
+// хранилище журнала базы; если storage равен nil, базы не сохраняются
+func NumberedDatabases(n int, defaultTTL time.Duration, out io.Writer, storage func(name string) (io.ReadWriter, error), saveFreq time.Duration, nShards int, shardingFunc shardFunction) DatabaseFactory {
+	return func(name string) (Cache, error) {
+		number, err := strconv.Atoi(name)
+		if err != nil || number < 0 || number >= n || strconv.Itoa(number) != name {
+			return nil, ErrUnknownDB
+		}
+		var rw io.ReadWriter
+		if storage != nil {
+			if rw, err = storage(name); err != nil {
+				return nil, err
+			}
+		}
+		return NewCache(defaultTTL, out, rw, saveFreq, nShards, shardingFunc)
+	}
+}
+
+// Add регистрирует уже созданный кэш под именем name
+func (d *Databases) Add(name string, c Cache) error {
+	if !dbNamePattern.MatchString(name) {
+		return ErrInvalidDBName
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.caches[name] = c
+	return nil
+}
+
+// DB возвращает базу по имени, создавая ее фабрикой при первом обращении
+func (d *Databases) DB(name string) (Cache, error) {
+	if !dbNamePattern.MatchString(name) {
+		return nil, ErrInvalidDBName
+	}
+	d.mu.RLock()
+	c, ok := d.caches[name]
+	d.mu.RUnlock()
+	if ok {
+		return c, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c, ok = d.caches[name]; ok {
+		return c, nil
+	}
+	if d.factory == nil {
+		return nil, ErrUnknownDB
+	}
+	c, err := d.factory(name)
+	if err != nil {
+		return nil, err
+	}
+	d.caches[name] = c
+	return c, nil
+}
+
+// Names возвращает имена созданных баз по возрастанию
+func (d *Databases) Names() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	names := make([]string, 0, len(d.caches))
+	for name := range d.caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Flusher удаляет все ключи базы (FLUSHDB)
+type Flusher interface {
+	FlushDB() error
+}
+
+func asFlusher(c Cache) (Flusher, error) {
+	f, ok := c.(Flusher)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return f, nil
+}
+
+func (s *store) FlushDB() error {
+	s.items = make(map[string]*Value)
+	s.order = newSkipList()
+	return nil
+}
+
+// FlushDB очищает все шарды под их общей блокировкой: запись, выполненная
+// во время очистки, не останется в базе перед записью Flush в журнале
+func (s *sharder) FlushDB() error {
+	indexes := make([]int, len(s.shards))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	unlock := s.lockIndexes(indexes)
+	defer unlock()
+	for _, shard := range s.shards {
+		if !s.events.idle() {
+			forEachShardKey(shard, func(key string) {
+				s.notifyKey(EventDel, key, nil)
+			})
+		}
+		if err := flushShard(shard); err != nil {
+			return err
+		}
+	}
+	s.log(operation{Type: "Flush"})
+	return nil
+}
+
+func flushShard(shard Cache) error {
+	if f, ok := shard.(Flusher); ok {
+		return f.FlushDB()
+	}
+	keys, err := shard.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := shard.Remove(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *logger) FlushDB() error {
+	defer l.peekIntoPanic("flushdb")
+	f, err := asFlusher(l.Cache)
+	if err != nil {
+		return err
+	}
+	err = f.FlushDB()
+	l.infoLog.Println("flushdb", "=>", err)
+	return err
+}
+
+func (p *persister) FlushDB() error {
+	f, err := asFlusher(p.Cache)
+	if err != nil {
+		return err
+	}
+	err = f.FlushDB()
+	if err == nil && !p.journaled {
+		p.op <- operation{Type: "Flush"}
+	}
+	return err
+}
+
+func executeFlush(target Cache) error {
+	f, err := asFlusher(target)
+	if err != nil {
+		return err
+	}
+	return f.FlushDB()
+}
+
+func (t *ttl) FlushDB() error {
+	f, err := asFlusher(t.Cache)
+	if err != nil {
+		return err
+	}
+	return f.FlushDB()
+}
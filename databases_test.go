@@ -0,0 +1,85 @@
+package db
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDatabases(t *testing.T) {
+	dbs := NewDatabases(NumberedDatabases(2, 0, nil, nil, 0, 2, nil))
+
+	zero, err := dbs.DB(DefaultDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one, _ := dbs.DB("1")
+	if again, _ := dbs.DB("1"); again != one {
+		t.Errorf("DB should return the same cache for the same name")
+	}
+	zero.Set("a", "zero", 0)
+	if _, err := one.Get("a"); err != ErrKeyNotFound {
+		t.Errorf("databases should not share keys, got %v", err)
+	}
+
+	for _, name := range []string{"2", "-1", "01", "x"} {
+		if _, err := dbs.DB(name); err != ErrUnknownDB {
+			t.Errorf("DB(%q): expected %v, got %v", name, ErrUnknownDB, err)
+		}
+	}
+	if _, err := dbs.DB("a/b"); err != ErrInvalidDBName {
+		t.Errorf("DB with invalid name: expected %v, got %v", ErrInvalidDBName, err)
+	}
+	if names := dbs.Names(); len(names) != 2 || names[0] != "0" || names[1] != "1" {
+		t.Errorf("Names: got %v", names)
+	}
+
+	// именованная база, добавленная вручную
+	dbs.Add("sessions", one)
+	if c, err := dbs.DB("sessions"); err != nil || c != one {
+		t.Errorf("DB of added database: got %v, err %v", c, err)
+	}
+}
+
+func TestFlushDB(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Set(key, 1, 0)
+	}
+	if err := c.(Flusher).FlushDB(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := c.Keys(); len(keys) != 0 {
+		t.Errorf("FlushDB should remove all keys, got %v", keys)
+	}
+}
+
+func TestFlushDB_Persist(t *testing.T) {
+	sample := `{"Type":"Set","k":"a","v":1,"e":0}
+{"Type":"Flush","k":"","v":null,"e":0}
+{"Type":"Set","k":"b","v":2,"e":0}
+`
+	storage := map[string]*bytes.Buffer{"0": {}, "1": {}}
+	dbs := NewDatabases(NumberedDatabases(2, 0, nil, func(name string) (io.ReadWriter, error) {
+		return storage[name], nil
+	}, time.Hour, 1, nil))
+
+	c, _ := dbs.DB("1")
+	p := c.(*ttl).Cache.(*persister)
+	c.Set("a", 1, 0)
+	c.(Flusher).FlushDB()
+	c.Set("b", 2, 0)
+	if got := flushOplog(p, storage["1"], sample); got != sample {
+		t.Errorf("TestFlushDB_Persist expected:\n%v\ngot:\n%v", sample, got)
+	}
+	if storage["0"].Len() != 0 {
+		t.Errorf("database 0 should not be written, got %v", storage["0"])
+	}
+
+	restored, _ := newSharder(1, nil)
+	newPersister(restored, storage["1"], time.Hour)
+	if keys, _ := restored.Keys(); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("TestFlushDB_Persist restore: expected [b], got %v", keys)
+	}
+}
@@ -0,0 +1,450 @@
+/*
+    Геопространственный индекс (GEOADD, GEOPOS, GEODIST, GEOSEARCH).
+    Как в redis, точки хранятся в сортированном множестве (ZSET): счет
+    элемента - 52-битный geohash, поэтому близкие точки находятся рядом и
+    поиск сводится к нескольким выборкам по диапазону счета
+*/
+
+package db
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidCoordinates = errors.New("invalid longitude,latitude pair")
+	ErrUnknownGeoUnit     = errors.New("unsupported unit provided. please use m, km, ft, mi")
+	ErrInvalidGeoShape    = errors.New("search area should be either a positive radius or a positive width and height")
+	ErrInvalidGeoSort     = errors.New("sort should be ASC or DESC")
+)
+
+const (
+	geoStep   = 26 // бит на координату, 52 бита на хэш
+	geoLatMax = 85.05112878
+	geoLonMax = 180.0
+	// радиус Земли, используемый redis для расстояний
+	earthRadius = 6372797.560856
+)
+
+// GeoMember - точка индекса
+type GeoMember struct {
+	Member    string  `json:"member"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+}
+
+// GeoPosition - координаты элемента. Координаты восстанавливаются из
+// geohash, поэтому отличаются от исходных не более чем на 0.6 м
+type GeoPosition struct {
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+}
+
+// GeoResult - найденный элемент и расстояние до центра поиска
+type GeoResult struct {
+	Member    string  `json:"member"`
+	Distance  float64 `json:"distance"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+}
+
+// GeoQuery описывает GEOSEARCH. Центр задается элементом FromMember или
+// координатами, область - радиусом Radius или прямоугольником Width x Height.
+// Расстояния задаются и возвращаются в Unit: m (по умолчанию), km, mi, ft.
+// Sort - "ASC", "DESC" или пусто; при Count > 0 результаты по умолчанию
+// сортируются по возрастанию расстояния
+type GeoQuery struct {
+	FromMember string
+	Longitude  float64
+	Latitude   float64
+
+	Radius float64
+	Width  float64
+	Height float64
+
+	Unit  string
+	Sort  string
+	Count int
+}
+
+// GeoOperator индексирует элементы по координатам
+type GeoOperator interface {
+	// GeoAdd добавляет или перемещает элементы и возвращает число новых
+	GeoAdd(key string, members ...GeoMember) (int, error)
+	// GeoPos возвращает координаты элементов, nil для отсутствующих
+	GeoPos(key string, members ...string) ([]*GeoPosition, error)
+	GeoDist(key string, member1 string, member2 string, unit string) (float64, error)
+	GeoSearch(key string, query GeoQuery) ([]GeoResult, error)
+}
+
+func asGeoOperator(c Cache) (GeoOperator, error) {
+	g, ok := c.(GeoOperator)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return g, nil
+}
+
+func validCoordinates(lon, lat float64) bool {
+	return lon >= -geoLonMax && lon <= geoLonMax && lat >= -geoLatMax && lat <= geoLatMax
+}
+
+// geoUnit возвращает число метров в единице измерения
+func geoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "", "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "mi":
+		return 1609.34, nil
+	case "ft":
+		return 0.3048, nil
+	}
+	return 0, ErrUnknownGeoUnit
+}
+
+// interleave чередует биты: x - в четных позициях, y - в нечетных
+func interleave(x, y uint64) uint64 {
+	var result uint64
+	for i := uint(0); i < 32; i++ {
+		result |= (x>>i&1)<<(2*i) | (y>>i&1)<<(2*i+1)
+	}
+	return result
+}
+
+func deinterleave(h uint64) (x, y uint64) {
+	for i := uint(0); i < 32; i++ {
+		x |= (h >> (2 * i) & 1) << i
+		y |= (h >> (2*i + 1) & 1) << i
+	}
+	return
+}
+
+// geohash кодирует точку с точностью step бит на координату
+func geohash(lon, lat float64, step uint) uint64 {
+	cells := float64(uint64(1) << step)
+	latBits := uint64((lat + geoLatMax) / (2 * geoLatMax) * cells)
+	lonBits := uint64((lon + geoLonMax) / (2 * geoLonMax) * cells)
+	// точки на верхней границе попадают в последнюю ячейку
+	if latBits >= uint64(cells) {
+		latBits = uint64(cells) - 1
+	}
+	if lonBits >= uint64(cells) {
+		lonBits = uint64(cells) - 1
+	}
+	return interleave(latBits, lonBits)
+}
+
+// geohashCenter возвращает центр ячейки 52-битного хэша
+func geohashCenter(h uint64) (lon, lat float64) {
+	latBits, lonBits := deinterleave(h)
+	cells := float64(uint64(1) << geoStep)
+	lat = -geoLatMax + (float64(latBits)+0.5)/cells*2*geoLatMax
+	lon = -geoLonMax + (float64(lonBits)+0.5)/cells*2*geoLonMax
+	return
+}
+
+func geoScore(lon, lat float64) float64 {
+	return float64(geohash(lon, lat, geoStep))
+}
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// geoDistance - расстояние в метрах по формуле гаверсинусов
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(degToRad(lon2-lon1) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// geoSearchRanges возвращает диапазоны счетов ячеек, покрывающих область
+// с полуразмерами halfWidth и halfHeight метров вокруг точки. Выбирается
+// самая мелкая сетка, в которой область укладывается в 3x3 ячейки вокруг
+// ячейки центра
+func geoSearchRanges(lon, lat, halfWidth, halfHeight float64) [][2]float64 {
+	dLat := halfHeight / earthRadius * 180 / math.Pi
+	// градус долготы короче всего на самой удаленной от экватора широте области
+	dLon := 360.0
+	if cos := math.Cos(degToRad(math.Min(math.Abs(lat)+dLat, 90))); cos > 1e-9 {
+		dLon = math.Min(360, halfWidth/earthRadius*180/math.Pi/cos)
+	}
+	step := uint(geoStep)
+	for step > 0 && (2*geoLatMax/float64(uint64(1)<<step) < dLat || 2*geoLonMax/float64(uint64(1)<<step) < dLon) {
+		step--
+	}
+	if step == 0 {
+		return [][2]float64{{0, float64(uint64(1)<<(2*geoStep) - 1)}}
+	}
+
+	latSize, lonSize := 2*geoLatMax/float64(uint64(1)<<step), 2*geoLonMax/float64(uint64(1)<<step)
+	shift := 2 * (geoStep - step)
+	seen := map[uint64]bool{}
+	ranges := [][2]float64{}
+	for _, dy := range []float64{-1, 0, 1} {
+		cellLat := lat + dy*latSize
+		if cellLat < -geoLatMax || cellLat > geoLatMax {
+			continue
+		}
+		for _, dx := range []float64{-1, 0, 1} {
+			cellLon := lon + dx*lonSize
+			// через антимеридиан
+			if cellLon < -geoLonMax {
+				cellLon += 360
+			} else if cellLon > geoLonMax {
+				cellLon -= 360
+			}
+			h := geohash(cellLon, cellLat, step)
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			ranges = append(ranges, [2]float64{float64(h << shift), float64((h+1)<<shift - 1)})
+		}
+	}
+	return ranges
+}
+
+func (s *sharder) GeoAdd(key string, members ...GeoMember) (int, error) {
+	zmembers, err := geoZMembers(members)
+	if err != nil {
+		return 0, err
+	}
+	return s.ZAdd(key, zmembers...)
+}
+
+// geoZMembers переводит точки в элементы сортированного множества
+func geoZMembers(members []GeoMember) ([]ZMember, error) {
+	result := make([]ZMember, len(members))
+	for i, m := range members {
+		if !validCoordinates(m.Longitude, m.Latitude) {
+			return nil, ErrInvalidCoordinates
+		}
+		result[i] = ZMember{m.Member, geoScore(m.Longitude, m.Latitude)}
+	}
+	return result, nil
+}
+
+// geoIndex читает индекс по ключу. Отсутствующий ключ - пустой индекс
+func (s *sharder) geoIndex(key string) (*SortedSet, error) {
+	z, err := s.sortedSet(key)
+	if err == ErrKeyNotFound {
+		return NewSortedSet(), nil
+	}
+	return z, err
+}
+
+func (s *sharder) GeoPos(key string, members ...string) ([]*GeoPosition, error) {
+	z, err := s.geoIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*GeoPosition, len(members))
+	for i, m := range members {
+		if score, ok := z.Score(m); ok {
+			lon, lat := geohashCenter(uint64(score))
+			result[i] = &GeoPosition{lon, lat}
+		}
+	}
+	return result, nil
+}
+
+func (s *sharder) GeoDist(key string, member1 string, member2 string, unit string) (float64, error) {
+	meters, err := geoUnit(unit)
+	if err != nil {
+		return 0, err
+	}
+	positions, err := s.GeoPos(key, member1, member2)
+	if err != nil {
+		return 0, err
+	}
+	if positions[0] == nil || positions[1] == nil {
+		return 0, ErrMemberNotFound
+	}
+	a, b := positions[0], positions[1]
+	return geoDistance(a.Longitude, a.Latitude, b.Longitude, b.Latitude) / meters, nil
+}
+
+func (s *sharder) GeoSearch(key string, q GeoQuery) ([]GeoResult, error) {
+	meters, err := geoUnit(q.Unit)
+	if err != nil {
+		return nil, err
+	}
+	sortOrder := strings.ToUpper(q.Sort)
+	if sortOrder != "" && sortOrder != "ASC" && sortOrder != "DESC" {
+		return nil, ErrInvalidGeoSort
+	}
+	isBox := q.Radius == 0
+	if (isBox && (q.Width <= 0 || q.Height <= 0)) || (!isBox && (q.Radius < 0 || q.Width != 0 || q.Height != 0)) {
+		return nil, ErrInvalidGeoShape
+	}
+	radius, halfWidth, halfHeight := q.Radius*meters, q.Width*meters/2, q.Height*meters/2
+	if !isBox {
+		halfWidth, halfHeight = radius, radius
+	}
+
+	z, err := s.geoIndex(key)
+	if err != nil {
+		return nil, err
+	}
+	lon, lat := q.Longitude, q.Latitude
+	if q.FromMember != "" {
+		score, ok := z.Score(q.FromMember)
+		if !ok {
+			return nil, ErrMemberNotFound
+		}
+		lon, lat = geohashCenter(uint64(score))
+	} else if !validCoordinates(lon, lat) {
+		return nil, ErrInvalidCoordinates
+	}
+
+	results := []GeoResult{}
+	for _, r := range geoSearchRanges(lon, lat, halfWidth, halfHeight) {
+		for _, m := range z.RangeByScore(r[0], r[1], false) {
+			mLon, mLat := geohashCenter(uint64(m.Score))
+			distance := geoDistance(lon, lat, mLon, mLat)
+			if isBox {
+				// расстояния по широте и по долготе на широте элемента
+				if geoDistance(mLon, lat, mLon, mLat) > halfHeight || geoDistance(lon, mLat, mLon, mLat) > halfWidth {
+					continue
+				}
+			} else if distance > radius {
+				continue
+			}
+			results = append(results, GeoResult{m.Member, distance / meters, mLon, mLat})
+		}
+	}
+
+	if sortOrder == "" && q.Count > 0 {
+		sortOrder = "ASC"
+	}
+	switch sortOrder {
+	case "ASC":
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Distance < results[j].Distance })
+	case "DESC":
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Distance > results[j].Distance })
+	}
+	if q.Count > 0 && len(results) > q.Count {
+		results = results[:q.Count]
+	}
+	return results, nil
+}
+
+func (l *logger) GeoAdd(key string, members ...GeoMember) (int, error) {
+	defer l.peekIntoPanic("geoadd", key, members)
+	g, err := asGeoOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := g.GeoAdd(key, members...)
+	l.infoLog.Println("geoadd", key, members, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) GeoPos(key string, members ...string) ([]*GeoPosition, error) {
+	defer l.peekIntoPanic("geopos", key, members)
+	g, err := asGeoOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := g.GeoPos(key, members...)
+	l.infoLog.Println("geopos", key, members, "=>", len(positions), err)
+	return positions, err
+}
+
+func (l *logger) GeoDist(key string, member1 string, member2 string, unit string) (float64, error) {
+	defer l.peekIntoPanic("geodist", key, member1, member2, unit)
+	g, err := asGeoOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	distance, err := g.GeoDist(key, member1, member2, unit)
+	l.infoLog.Println("geodist", key, member1, member2, unit, "=>", distance, err)
+	return distance, err
+}
+
+func (l *logger) GeoSearch(key string, query GeoQuery) ([]GeoResult, error) {
+	defer l.peekIntoPanic("geosearch", key, query)
+	g, err := asGeoOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	results, err := g.GeoSearch(key, query)
+	l.infoLog.Println("geosearch", key, query, "=>", len(results), err)
+	return results, err
+}
+
+// GeoAdd записывается в журнал как ZAdd с вычисленными счетами
+func (p *persister) GeoAdd(key string, members ...GeoMember) (int, error) {
+	g, err := asGeoOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return g.GeoAdd(key, members...)
+}
+
+func (p *persister) GeoPos(key string, members ...string) ([]*GeoPosition, error) {
+	g, err := asGeoOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return g.GeoPos(key, members...)
+}
+
+func (p *persister) GeoDist(key string, member1 string, member2 string, unit string) (float64, error) {
+	g, err := asGeoOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return g.GeoDist(key, member1, member2, unit)
+}
+
+func (p *persister) GeoSearch(key string, query GeoQuery) ([]GeoResult, error) {
+	g, err := asGeoOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return g.GeoSearch(key, query)
+}
+
+func (t *ttl) GeoAdd(key string, members ...GeoMember) (int, error) {
+	g, err := asGeoOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := g.GeoAdd(key, members...)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) GeoPos(key string, members ...string) ([]*GeoPosition, error) {
+	g, err := asGeoOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return g.GeoPos(key, members...)
+}
+
+func (t *ttl) GeoDist(key string, member1 string, member2 string, unit string) (float64, error) {
+	g, err := asGeoOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return g.GeoDist(key, member1, member2, unit)
+}
+
+func (t *ttl) GeoSearch(key string, query GeoQuery) ([]GeoResult, error) {
+	g, err := asGeoOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return g.GeoSearch(key, query)
+}
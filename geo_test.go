@@ -0,0 +1,165 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func geoMembers(results []GeoResult) []string {
+	members := []string{}
+	for _, r := range results {
+		members = append(members, r.Member)
+	}
+	return members
+}
+
+func sicily(t *testing.T) (Cache, GeoOperator) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	g := c.(GeoOperator)
+	n, err := g.GeoAdd("sicily",
+		GeoMember{"Palermo", 13.361389, 38.115556},
+		GeoMember{"Catania", 15.087269, 37.502669})
+	if n != 2 || err != nil {
+		t.Fatalf("GeoAdd: got %v, err %v", n, err)
+	}
+	return c, g
+}
+
+func TestGeoOperator(t *testing.T) {
+	c, g := sicily(t)
+
+	// значения совпадают с примерами из документации redis
+	if d, err := g.GeoDist("sicily", "Palermo", "Catania", "km"); math.Abs(d-166.2742) > 0.001 || err != nil {
+		t.Errorf("GeoDist: expected 166.2742 km, got %v, err %v", d, err)
+	}
+	if _, err := g.GeoDist("sicily", "Palermo", "Rome", ""); err != ErrMemberNotFound {
+		t.Errorf("GeoDist to missing member: expected %v, got %v", ErrMemberNotFound, err)
+	}
+	if _, err := g.GeoDist("sicily", "Palermo", "Catania", "yd"); err != ErrUnknownGeoUnit {
+		t.Errorf("GeoDist with unknown unit: expected %v, got %v", ErrUnknownGeoUnit, err)
+	}
+
+	positions, _ := g.GeoPos("sicily", "Palermo", "Rome")
+	if positions[1] != nil || math.Abs(positions[0].Longitude-13.361389) > 1e-5 || math.Abs(positions[0].Latitude-38.115556) > 1e-5 {
+		t.Errorf("GeoPos: unexpected %v, %v", positions[0], positions[1])
+	}
+
+	for _, tt := range []struct {
+		name     string
+		query    GeoQuery
+		expected []string
+	}{
+		{"radius", GeoQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km", Sort: "asc"}, []string{"Catania", "Palermo"}},
+		{"radius desc", GeoQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km", Sort: "DESC"}, []string{"Palermo", "Catania"}},
+		{"small radius", GeoQuery{Longitude: 15, Latitude: 37, Radius: 100, Unit: "km"}, []string{"Catania"}},
+		{"count", GeoQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km", Count: 1}, []string{"Catania"}},
+		{"box", GeoQuery{Longitude: 15, Latitude: 37, Width: 400, Height: 400, Unit: "km", Sort: "ASC"}, []string{"Catania", "Palermo"}},
+		{"narrow box", GeoQuery{Longitude: 15, Latitude: 37, Width: 400, Height: 150, Unit: "km"}, []string{"Catania"}},
+		{"from member", GeoQuery{FromMember: "Palermo", Radius: 100, Unit: "km"}, []string{"Palermo"}},
+	} {
+		results, err := g.GeoSearch("sicily", tt.query)
+		if !reflect.DeepEqual(geoMembers(results), tt.expected) || err != nil {
+			t.Errorf("GeoSearch %v: expected %v, got %v, err %v", tt.name, tt.expected, geoMembers(results), err)
+		}
+	}
+	results, _ := g.GeoSearch("sicily", GeoQuery{Longitude: 15, Latitude: 37, Radius: 200, Unit: "km", Sort: "ASC"})
+	if math.Abs(results[0].Distance-56.4413) > 0.001 || math.Abs(results[1].Distance-190.4424) > 0.001 {
+		t.Errorf("GeoSearch distances: expected 56.4413 and 190.4424 km, got %v", results)
+	}
+
+	for _, tt := range []struct {
+		query GeoQuery
+		err   error
+	}{
+		{GeoQuery{Longitude: 15, Latitude: 37}, ErrInvalidGeoShape},
+		{GeoQuery{Longitude: 15, Latitude: 37, Radius: 1, Width: 1, Height: 1}, ErrInvalidGeoShape},
+		{GeoQuery{Longitude: 15, Latitude: 89, Radius: 1}, ErrInvalidCoordinates},
+		{GeoQuery{FromMember: "Rome", Radius: 1}, ErrMemberNotFound},
+		{GeoQuery{Longitude: 15, Latitude: 37, Radius: 1, Sort: "up"}, ErrInvalidGeoSort},
+	} {
+		if _, err := g.GeoSearch("sicily", tt.query); err != tt.err {
+			t.Errorf("GeoSearch %+v: expected %v, got %v", tt.query, tt.err, err)
+		}
+	}
+	if results, err := g.GeoSearch("missing", GeoQuery{Radius: 1}); len(results) != 0 || err != nil {
+		t.Errorf("GeoSearch on missing key: got %v, err %v", results, err)
+	}
+
+	if _, err := g.GeoAdd("sicily", GeoMember{"Pole", 0, 89}); err != ErrInvalidCoordinates {
+		t.Errorf("GeoAdd with invalid latitude: expected %v, got %v", ErrInvalidCoordinates, err)
+	}
+	// индекс остается сортированным множеством
+	if value, _ := c.Get("sicily"); value.Type != ZSET {
+		t.Errorf("geo index: expected type %v, got %v", ZSET, value.Type)
+	}
+	c.Set("list", []interface{}{1}, 0)
+	if _, err := g.GeoSearch("list", GeoQuery{Radius: 1}); err != ErrWrongType {
+		t.Errorf("GeoSearch on list: expected %v, got %v", ErrWrongType, err)
+	}
+}
+
+// поиск по ячейкам geohash должен находить то же, что полный перебор
+func TestGeoOperator_SearchMatchesBruteForce(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 1, nil)
+	g := c.(GeoOperator)
+	r := rand.New(rand.NewSource(1))
+	members := []GeoMember{}
+	for i := 0; i < 2000; i++ {
+		// точки сгущаются у центра, у антимеридиана и у полюса
+		centers := [][2]float64{{37.6, 55.7}, {179.9, 10}, {-20, 84}}
+		center := centers[i%len(centers)]
+		lon := math.Mod(center[0]+r.NormFloat64()*2+540, 360) - 180
+		lat := math.Max(-geoLatMax, math.Min(geoLatMax, center[1]+r.NormFloat64()))
+		members = append(members, GeoMember{fmt.Sprint("m", i), lon, lat})
+	}
+	g.GeoAdd("points", members...)
+
+	for i := 0; i < 60; i++ {
+		center := members[r.Intn(len(members))]
+		q := GeoQuery{Longitude: center.Longitude, Latitude: center.Latitude, Unit: "km"}
+		if i%2 == 0 {
+			q.Radius = r.Float64() * 300
+		} else {
+			q.Width, q.Height = r.Float64()*600+1, r.Float64()*600+1
+		}
+		results, err := g.GeoSearch("points", q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := []string{}
+		for _, m := range members {
+			pos, _ := g.GeoPos("points", m.Member)
+			lon, lat := pos[0].Longitude, pos[0].Latitude
+			if q.Radius > 0 {
+				if geoDistance(q.Longitude, q.Latitude, lon, lat) <= q.Radius*1000 {
+					expected = append(expected, m.Member)
+				}
+			} else if geoDistance(lon, q.Latitude, lon, lat) <= q.Height*500 && geoDistance(q.Longitude, lat, lon, lat) <= q.Width*500 {
+				expected = append(expected, m.Member)
+			}
+		}
+		got := geoMembers(results)
+		sort.Strings(got)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("GeoSearch %+v: found %v members, brute force %v", q, len(got), len(expected))
+		}
+	}
+}
+
+func TestGeoOperator_Persist(t *testing.T) {
+	sample := fmt.Sprintf(`{"Type":"ZAdd","k":"sicily","v":[{"member":"Palermo","score":%v}],"e":0}
+`, int64(geoScore(13.361389, 38.115556)))
+	restored := checkPersist(t, 2, sample, func(p *persister) {
+		p.GeoAdd("sicily", GeoMember{"Palermo", 13.361389, 38.115556})
+	})
+	results, _ := restored.GeoSearch("sicily", GeoQuery{Longitude: 13.36, Latitude: 38.11, Radius: 1, Unit: "km"})
+	if !reflect.DeepEqual(geoMembers(results), []string{"Palermo"}) {
+		t.Errorf("TestGeoOperator_Persist restore: got %v", results)
+	}
+}
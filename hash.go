@@ -0,0 +1,293 @@
+/*
+    Операции над отдельными полями значений типа MAP
+*/
+
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Hasher изменяет поля словаря атомарно под блокировкой шарда, поэтому
+// параллельные записи в разные поля не затирают друг друга
+type Hasher interface {
+	HSet(key string, field string, value interface{}) (*Value, error)
+	HGet(key string, field string) (interface{}, error)
+	HDel(key string, fields ...string) (int, error)
+	HKeys(key string) ([]string, error)
+	HLen(key string) (int, error)
+}
+
+func asHasher(c Cache) (Hasher, error) {
+	h, ok := c.(Hasher)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return h, nil
+}
+
+// copyMap копирует словарь с ключами любого типа в map[string]interface{}
+func copyMap(data interface{}) map[string]interface{} {
+	if m, ok := data.(map[string]interface{}); ok {
+		result := make(map[string]interface{}, len(m)+1)
+		for k, v := range m {
+			result[k] = v
+		}
+		return result
+	}
+	m := reflect.ValueOf(data)
+	result := make(map[string]interface{}, m.Len()+1)
+	for _, k := range m.MapKeys() {
+		result[fmt.Sprint(k.Interface())] = m.MapIndex(k).Interface()
+	}
+	return result
+}
+
+func mapKeys(data interface{}) []string {
+	m := reflect.ValueOf(data)
+	keys := make([]string, 0, m.Len())
+	for _, k := range m.MapKeys() {
+		keys = append(keys, fmt.Sprint(k.Interface()))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *sharder) HSet(key string, field string, value interface{}) (*Value, error) {
+	record := operation{Type: "HSet", Key: key, Field: field, Value: value}
+	return s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		result := &Value{Type: MAP}
+		fields := map[string]interface{}{}
+		if current != nil {
+			if current.Type != MAP {
+				return nil, ErrWrongType
+			}
+			fields = copyMap(current.Data)
+			result.Expires = current.Expires
+		}
+		fields[field] = value
+		result.Data = fields
+		return result, nil
+	})
+}
+
+func (s *sharder) HGet(key string, field string) (result interface{}, err error) {
+	err = s.view(key, func(value *Value) error {
+		if value.Type != MAP {
+			return ErrWrongType
+		}
+		result, err = itemAtIndex(value, field)
+		return err
+	})
+	return
+}
+
+func (s *sharder) HDel(key string, fields ...string) (removed int, err error) {
+	record := func(*Value) []operation {
+		ops := make([]operation, 0, len(fields))
+		for _, field := range fields {
+			ops = append(ops, operation{Type: "HDel", Key: key, Field: field})
+		}
+		return ops
+	}
+	_, err = s.updateLogged(key, record, func(current *Value) (*Value, error) {
+		if current == nil {
+			return nil, nil
+		}
+		if current.Type != MAP {
+			return nil, ErrWrongType
+		}
+		data := copyMap(current.Data)
+		for _, field := range fields {
+			if _, ok := data[field]; ok {
+				delete(data, field)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return current, nil
+		}
+		if len(data) == 0 {
+			return nil, nil
+		}
+		return &Value{Type: MAP, Data: data, Expires: current.Expires}, nil
+	})
+	return
+}
+
+func (s *sharder) HKeys(key string) (keys []string, err error) {
+	err = s.view(key, func(value *Value) error {
+		if value.Type != MAP {
+			return ErrWrongType
+		}
+		keys = mapKeys(value.Data)
+		return nil
+	})
+	return
+}
+
+func (s *sharder) HLen(key string) (n int, err error) {
+	err = s.view(key, func(value *Value) error {
+		if value.Type != MAP {
+			return ErrWrongType
+		}
+		n = reflect.ValueOf(value.Data).Len()
+		return nil
+	})
+	return
+}
+
+func (l *logger) HSet(key string, field string, value interface{}) (*Value, error) {
+	defer l.peekIntoPanic("hset", key, field, value)
+	h, err := asHasher(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.HSet(key, field, value)
+	l.infoLog.Println("hset", key, field, value, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) HGet(key string, field string) (interface{}, error) {
+	defer l.peekIntoPanic("hget", key, field)
+	h, err := asHasher(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.HGet(key, field)
+	l.infoLog.Println("hget", key, field, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) HDel(key string, fields ...string) (int, error) {
+	defer l.peekIntoPanic("hdel", key, fields)
+	h, err := asHasher(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := h.HDel(key, fields...)
+	l.infoLog.Println("hdel", key, fields, "=>", removed, err)
+	return removed, err
+}
+
+func (l *logger) HKeys(key string) ([]string, error) {
+	defer l.peekIntoPanic("hkeys", key)
+	h, err := asHasher(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := h.HKeys(key)
+	l.infoLog.Println("hkeys", key, "=>", keys, err)
+	return keys, err
+}
+
+func (l *logger) HLen(key string) (int, error) {
+	defer l.peekIntoPanic("hlen", key)
+	h, err := asHasher(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := h.HLen(key)
+	l.infoLog.Println("hlen", key, "=>", n, err)
+	return n, err
+}
+
+func (p *persister) HSet(key string, field string, value interface{}) (*Value, error) {
+	h, err := asHasher(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return h.HSet(key, field, value)
+}
+
+func (p *persister) HGet(key string, field string) (interface{}, error) {
+	h, err := asHasher(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return h.HGet(key, field)
+}
+
+func (p *persister) HDel(key string, fields ...string) (int, error) {
+	h, err := asHasher(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return h.HDel(key, fields...)
+}
+
+func (p *persister) HKeys(key string) ([]string, error) {
+	h, err := asHasher(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return h.HKeys(key)
+}
+
+func (p *persister) HLen(key string) (int, error) {
+	h, err := asHasher(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return h.HLen(key)
+}
+
+func (o *operation) executeHash(target Cache) error {
+	h, err := asHasher(target)
+	if err != nil {
+		return err
+	}
+	switch o.Type {
+	case "HSet":
+		_, err = h.HSet(o.Key, o.Field, o.Value)
+	case "HDel":
+		_, err = h.HDel(o.Key, o.Field)
+	}
+	return err
+}
+
+func (t *ttl) HSet(key string, field string, value interface{}) (*Value, error) {
+	h, err := asHasher(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := h.HSet(key, field, value)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) HGet(key string, field string) (interface{}, error) {
+	h, err := asHasher(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return h.HGet(key, field)
+}
+
+func (t *ttl) HDel(key string, fields ...string) (int, error) {
+	h, err := asHasher(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return h.HDel(key, fields...)
+}
+
+func (t *ttl) HKeys(key string) ([]string, error) {
+	h, err := asHasher(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return h.HKeys(key)
+}
+
+func (t *ttl) HLen(key string) (int, error) {
+	h, err := asHasher(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return h.HLen(key)
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHash_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 3, nil)
+	h := c.(Hasher)
+
+	c.Set("string", "something", 0)
+	if _, err := h.HSet("string", "a", 1); err != ErrWrongType {
+		t.Errorf("HSet on string: expected %v, got %v", ErrWrongType, err)
+	}
+
+	c.Set("map", map[int]int{42: 24}, time.Minute)
+	value, err := h.HSet("map", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"42": 24, "a": "b"}
+	if value.Type != MAP || value.Expires == 0 || !reflect.DeepEqual(value.Data, expected) {
+		t.Errorf("HSet: expected %v with preserved expiration, got %v", expected, value)
+	}
+
+	item, err := h.HGet("map", "42")
+	if err != nil || item != 24 {
+		t.Errorf("HGet: expected 24, got %v, err %v", item, err)
+	}
+	if _, err = h.HGet("map", "missing"); err != ErrIndexAccess {
+		t.Errorf("HGet missing field: expected %v, got %v", ErrIndexAccess, err)
+	}
+
+	keys, err := h.HKeys("map")
+	if err != nil || !reflect.DeepEqual(keys, []string{"42", "a"}) {
+		t.Errorf("HKeys: got %v, err %v", keys, err)
+	}
+
+	removed, err := h.HDel("map", "42", "missing")
+	if err != nil || removed != 1 {
+		t.Errorf("HDel: expected 1 removed, got %v, err %v", removed, err)
+	}
+	n, err := h.HLen("map")
+	if err != nil || n != 1 {
+		t.Errorf("HLen: expected 1, got %v, err %v", n, err)
+	}
+
+	h.HDel("map", "a")
+	if _, err = c.Get("map"); err != ErrKeyNotFound {
+		t.Errorf("HDel of the last field should remove key, got %v", err)
+	}
+}
+
+func TestHash_ConcurrentFields(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	h := c.(Hasher)
+	done := make(chan bool)
+	for i := 0; i < 100; i++ {
+		go func(field string) {
+			h.HSet("map", field, field)
+			done <- true
+		}(strconv.Itoa(i))
+	}
+	for i := 0; i < 100; i++ {
+		<-done
+	}
+	n, _ := h.HLen("map")
+	if n != 100 {
+		t.Errorf("concurrent HSet lost updates: expected 100 fields, got %v", n)
+	}
+}
+
+func TestHash_Persist(t *testing.T) {
+	sample := `{"Type":"HSet","k":"map","v":1,"e":0,"f":"a"}
+{"Type":"HSet","k":"map","v":2,"e":0,"f":"b"}
+{"Type":"HDel","k":"map","v":null,"e":0,"f":"a"}
+{"Type":"HDel","k":"map","v":null,"e":0,"f":"missing"}
+`
+	restored := checkPersist(t, 1, sample, func(p *persister) {
+		p.HSet("map", "a", 1)
+		p.HSet("map", "b", 2)
+		p.HDel("map", "a", "missing")
+	})
+	value, err := restored.Get("map")
+	if err != nil || !reflect.DeepEqual(value.Data, map[string]interface{}{"b": 2.0}) {
+		t.Errorf("TestHash_Persist restore: got %v, err %v", value, err)
+	}
+}
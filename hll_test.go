@@ -0,0 +1,106 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLog_Count(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 20000, 100000} {
+		h := NewHyperLogLog()
+		for i := 0; i < n; i++ {
+			h.add(fmt.Sprint("element-", i))
+		}
+		// повтор тех же элементов не меняет оценку
+		h.add("element-0")
+		got := h.Count()
+		if math.Abs(float64(got)-float64(n)) > float64(n)*0.03+1 {
+			t.Errorf("Count of %v elements: got %v", n, got)
+		}
+	}
+}
+
+func TestHyperLogLogOperator(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	ho := c.(HyperLogLogOperator)
+
+	if changed, err := ho.PFAdd("page1", "a", "b", "c"); !changed || err != nil {
+		t.Errorf("PFAdd: got %v, err %v", changed, err)
+	}
+	if changed, _ := ho.PFAdd("page1", "b", "c"); changed {
+		t.Error("PFAdd of known elements should not change registers")
+	}
+	if changed, _ := ho.PFAdd("empty"); !changed {
+		t.Error("PFAdd without elements should create the key")
+	}
+	ho.PFAdd("page2", "c", "d")
+	if value, _ := c.Get("page1"); value.Type != HLL {
+		t.Errorf("PFAdd: expected type %v, got %v", HLL, value.Type)
+	}
+
+	for _, tt := range []struct {
+		keys     []string
+		expected int64
+	}{
+		{[]string{"page1"}, 3},
+		{[]string{"missing"}, 0},
+		{[]string{"page1", "page2"}, 4},
+		{[]string{"page1", "page2", "missing", "empty"}, 4},
+	} {
+		if n, err := ho.PFCount(tt.keys...); n != tt.expected || err != nil {
+			t.Errorf("PFCount %v: expected %v, got %v, err %v", tt.keys, tt.expected, n, err)
+		}
+	}
+
+	ho.PFAdd("all", "e")
+	if err := ho.PFMerge("all", "page1", "page2", "missing"); err != nil {
+		t.Errorf("PFMerge: %v", err)
+	}
+	if n, _ := ho.PFCount("all"); n != 5 {
+		t.Errorf("PFMerge should keep destination elements: expected 5, got %v", n)
+	}
+	if n, _ := ho.PFCount("page1"); n != 3 {
+		t.Errorf("PFMerge should not change sources: expected 3, got %v", n)
+	}
+
+	c.Set("list", []interface{}{1}, 0)
+	if _, err := ho.PFAdd("list", "a"); err != ErrWrongType {
+		t.Errorf("PFAdd on list: expected %v, got %v", ErrWrongType, err)
+	}
+	if _, err := ho.PFCount("page1", "list"); err != ErrWrongType {
+		t.Errorf("PFCount on list: expected %v, got %v", ErrWrongType, err)
+	}
+	if err := ho.PFMerge("all", "list"); err != ErrWrongType {
+		t.Errorf("PFMerge from list: expected %v, got %v", ErrWrongType, err)
+	}
+	if err := ho.PFMerge("list", "page1"); err != ErrWrongType {
+		t.Errorf("PFMerge into list: expected %v, got %v", ErrWrongType, err)
+	}
+}
+
+func TestHyperLogLogOperator_Persist(t *testing.T) {
+	ops := func(h HyperLogLogOperator) {
+		h.PFAdd("page1", "a", "b")
+		h.PFAdd("page1", "a")
+		h.PFAdd("page2", "c")
+		h.PFMerge("all", "page1", "page2")
+	}
+	// регистры объединения берем у такого же шардера без журнала
+	reference, _ := newSharder(2, nil)
+	ops(reference)
+	all, _ := reference.Get("all")
+	merged, _ := json.Marshal(setOperation("all", all))
+	sample := `{"Type":"PFAdd","k":"page1","v":["a","b"],"e":0}
+{"Type":"PFAdd","k":"page2","v":["c"],"e":0}
+` + string(merged) + "\n"
+
+	restored := checkPersist(t, 2, sample, func(p *persister) { ops(p) })
+	for _, key := range []string{"page1", "page2", "all"} {
+		expected, _ := reference.PFCount(key)
+		if n, err := restored.PFCount(key); n != expected || err != nil {
+			t.Errorf("TestHyperLogLogOperator_Persist restore %v: expected %v, got %v, err %v", key, expected, n, err)
+		}
+	}
+}
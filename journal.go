@@ -0,0 +1,63 @@
+/*
+    Журнал изменений: sharder пишет записи под блокировкой шарда, поэтому
+    записи одного ключа попадают в журнал в порядке изменений
+*/
+
+package db
+
+// journaler устанавливает функцию записи в журнал. Ее вызывают под
+// блокировкой шарда при каждом изменении. false - хранилище не пишет журнал
+type journaler interface {
+	setJournal(fn func(op operation)) bool
+}
+
+func (s *sharder) setJournal(fn func(op operation)) bool {
+	s.journal = fn
+	return true
+}
+
+func (l *logger) setJournal(fn func(op operation)) bool {
+	target, ok := l.Cache.(journaler)
+	return ok && target.setJournal(fn)
+}
+
+// journalFunc возвращает записи журнала для изменения ключа по его новому
+// значению (nil - ключ удален). Без нее записывается значение целиком
+type journalFunc func(value *Value) []operation
+
+// journalOp записывает изменение операцией op
+func journalOp(op operation) journalFunc {
+	return func(*Value) []operation {
+		return []operation{op}
+	}
+}
+
+// journalBatch - изменение записывает вызывающий вместе с остальными одной
+// записью
+func journalBatch(*Value) []operation {
+	return nil
+}
+
+// log пишет запись в журнал. Шарды ключей записи должны быть заблокированы
+func (s *sharder) log(op operation) {
+	if s.journal != nil {
+		s.journal(op)
+	}
+}
+
+// logChange записывает изменение ключа. Шард должен быть заблокирован
+func (s *sharder) logChange(key string, value *Value, record journalFunc) {
+	if s.journal == nil {
+		return
+	}
+	switch {
+	case record != nil:
+		for _, op := range record(value) {
+			s.journal(op)
+		}
+	case value == nil:
+		s.journal(operation{Type: "Remove", Key: key})
+	default:
+		s.journal(setOperation(key, value))
+	}
+}
@@ -0,0 +1,525 @@
+/*
+    Управление ключами: проверка существования, тип, переименование,
+    копирование, размер базы и случайный ключ
+*/
+
+package db
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrKeyExists = errors.New("target key already exists")
+	ErrSameKey   = errors.New("source and destination keys are the same")
+)
+
+// KeyManager работает с ключами, не передавая их значения.
+// Rename и Copy переносят значение между шардами под блокировками обоих
+// шардов и сохраняют время истечения
+type KeyManager interface {
+	// Exists возвращает число существующих ключей из переданных
+	Exists(keys ...string) (int, error)
+	KeyType(key string) (DataType, error)
+	// Expire атомарно задает время жизни существующего ключа. expire <= 0
+	// удаляет ключ, тогда возвращается nil. Отсутствующий ключ - ErrKeyNotFound
+	Expire(key string, expire time.Duration) (*Value, error)
+	// Rename переносит значение под новый ключ, перезаписывая его
+	Rename(key string, newKey string) (*Value, error)
+	// Copy копирует значение. Существующий newKey перезаписывается только
+	// при replace, иначе возвращается ErrKeyExists
+	Copy(key string, newKey string, replace bool) (*Value, error)
+	// DBSize возвращает число ключей. Истекшие, но еще не удаленные ключи
+	// учитываются, как и в Redis
+	DBSize() (int, error)
+	// RandomKey возвращает случайный ключ или ErrKeyNotFound для пустой базы
+	RandomKey() (string, error)
+}
+
+func asKeyManager(c Cache) (KeyManager, error) {
+	km, ok := c.(KeyManager)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return km, nil
+}
+
+// keySampler реализуется хранилищами, которые знают свой размер и выбирают
+// случайный ключ без перебора всех ключей
+type keySampler interface {
+	size() int
+	randomKey() (string, bool)
+}
+
+func (s *store) size() int {
+	return len(s.items)
+}
+
+// randomKey полагается на случайный порядок обхода map
+func (s *store) randomKey() (string, bool) {
+	for key := range s.items {
+		if s.lookup(key) != nil {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func shardSize(shard Cache) (int, error) {
+	if ks, ok := shard.(keySampler); ok {
+		return ks.size(), nil
+	}
+	keys, err := shard.Keys()
+	return len(keys), err
+}
+
+func shardRandomKey(shard Cache) (string, bool, error) {
+	if ks, ok := shard.(keySampler); ok {
+		key, found := ks.randomKey()
+		return key, found, nil
+	}
+	keys, err := shard.Keys()
+	if err != nil || len(keys) == 0 {
+		return "", false, err
+	}
+	return keys[rand.Intn(len(keys))], true, nil
+}
+
+// cloneData копирует данные, которые могут изменяться на месте. Остальные
+// типы изменяются только заменой значения и могут разделяться
+func cloneData(value *Value) interface{} {
+	switch data := value.Data.(type) {
+	case *SortedSet:
+		return data.copy()
+	case Set:
+		return data.copy()
+	case HyperLogLog:
+		return data.copy()
+	case *Stream:
+		return data.copy()
+	}
+	return value.Data
+}
+
+func (s *sharder) Exists(keys ...string) (int, error) {
+	found := 0
+	for _, key := range keys {
+		err := s.view(key, func(*Value) error { return nil })
+		if err == nil {
+			found++
+		} else if err != ErrKeyNotFound {
+			return 0, err
+		}
+	}
+	return found, nil
+}
+
+func (s *sharder) KeyType(key string) (t DataType, err error) {
+	err = s.view(key, func(value *Value) error {
+		t = value.Type
+		return nil
+	})
+	return t, err
+}
+
+func (s *sharder) Expire(key string, expire time.Duration) (*Value, error) {
+	return s.update(key, func(current *Value) (*Value, error) {
+		if current == nil {
+			return nil, ErrKeyNotFound
+		}
+		if expire <= 0 {
+			return nil, nil
+		}
+		return &Value{Type: current.Type, Data: current.Data, Expires: time.Now().Add(expire).UnixNano()}, nil
+	})
+}
+
+// expireNew записывается в журнал только временем истечения: полное значение
+// повторило бы изменение, создавшее ключ
+func (s *sharder) expireNew(key string, expire time.Duration) (result *Value, err error) {
+	record := func(value *Value) []operation {
+		return []operation{{Type: "Expire", Key: key, Expire: value.Expires}}
+	}
+	_, err = s.updateLogged(key, record, func(current *Value) (*Value, error) {
+		if current == nil || current.Expires != 0 {
+			return current, nil
+		}
+		result = &Value{Type: current.Type, Data: current.Data, Expires: time.Now().Add(expire).UnixNano()}
+		return result, nil
+	})
+	return
+}
+
+// move записывает значение key под newKey. Шарды обоих ключей должны быть
+// заблокированы. Перенос записывается в журнал одной записью Exec, чтобы при
+// восстановлении он был атомарным
+func (s *sharder) move(key string, newKey string, replace bool, remove bool) (*Value, error) {
+	source := s.shards[s.getTargetShardIdx(key)]
+	target, ok := s.shards[s.getTargetShardIdx(newKey)].(updater)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	value, err := source.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	data := value.Data
+	record := journalFunc(nil)
+	if !remove {
+		data = cloneData(value)
+	} else {
+		record = journalBatch
+	}
+	result, err := s.updateShard(target, newKey, func(current *Value) (*Value, error) {
+		if current != nil && !replace {
+			return nil, ErrKeyExists
+		}
+		return &Value{Type: value.Type, Data: data, Expires: value.Expires}, nil
+	}, record)
+	if err != nil {
+		return nil, err
+	}
+	if remove {
+		if _, err := s.removeKey(source, key, EventDel, journalBatch); err != nil {
+			return nil, err
+		}
+		s.log(operation{Type: "Exec", Ops: []operation{
+			setOperation(newKey, result),
+			{Type: "Remove", Key: key},
+		}})
+	}
+	return result, nil
+}
+
+func (s *sharder) Rename(key string, newKey string) (*Value, error) {
+	unlock := s.lockShards([]string{key, newKey})
+	defer unlock()
+	if key == newKey {
+		return s.shards[s.getTargetShardIdx(key)].Get(key)
+	}
+	return s.move(key, newKey, true, true)
+}
+
+func (s *sharder) Copy(key string, newKey string, replace bool) (*Value, error) {
+	if key == newKey {
+		return nil, ErrSameKey
+	}
+	unlock := s.lockShards([]string{key, newKey})
+	defer unlock()
+	return s.move(key, newKey, replace, false)
+}
+
+func (s *sharder) DBSize() (int, error) {
+	total := 0
+	for i, shard := range s.shards {
+		if s.needLock {
+			s.locks[i].RLock()
+		}
+		n, err := shardSize(shard)
+		if s.needLock {
+			s.locks[i].RUnlock()
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// RandomKey выбирает шард с вероятностью, пропорциональной его размеру.
+// Если в выбранном шарде остались только истекшие ключи, проверяются
+// следующие шарды
+func (s *sharder) RandomKey() (string, error) {
+	sizes := make([]int, len(s.shards))
+	total := 0
+	for i := range s.shards {
+		if s.needLock {
+			s.locks[i].RLock()
+		}
+		n, err := shardSize(s.shards[i])
+		if s.needLock {
+			s.locks[i].RUnlock()
+		}
+		if err != nil {
+			return "", err
+		}
+		sizes[i] = n
+		total += n
+	}
+	if total == 0 {
+		return "", ErrKeyNotFound
+	}
+
+	start, r := 0, rand.Intn(total)
+	for r >= sizes[start] {
+		r -= sizes[start]
+		start++
+	}
+	for j := 0; j < len(s.shards); j++ {
+		i := (start + j) % len(s.shards)
+		if s.needLock {
+			s.locks[i].RLock()
+		}
+		key, found, err := shardRandomKey(s.shards[i])
+		if s.needLock {
+			s.locks[i].RUnlock()
+		}
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return key, nil
+		}
+	}
+	return "", ErrKeyNotFound
+}
+
+func (l *logger) Exists(keys ...string) (int, error) {
+	defer l.peekIntoPanic("exists", keys)
+	km, err := asKeyManager(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := km.Exists(keys...)
+	l.infoLog.Println("exists", keys, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) KeyType(key string) (DataType, error) {
+	defer l.peekIntoPanic("type", key)
+	km, err := asKeyManager(l.Cache)
+	if err != nil {
+		return STRING, err
+	}
+	result, err := km.KeyType(key)
+	l.infoLog.Println("type", key, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) Expire(key string, expire time.Duration) (*Value, error) {
+	defer l.peekIntoPanic("expire", key, expire)
+	km, err := asKeyManager(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := km.Expire(key, expire)
+	l.infoLog.Println("expire", key, expire, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) expireNew(key string, expire time.Duration) (*Value, error) {
+	e, ok := l.Cache.(defaultExpirer)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	result, err := e.expireNew(key, expire)
+	if result != nil || err != nil {
+		l.infoLog.Println("expire", key, expire, "=>", result, err)
+	}
+	return result, err
+}
+
+func (l *logger) Rename(key string, newKey string) (*Value, error) {
+	defer l.peekIntoPanic("rename", key, newKey)
+	km, err := asKeyManager(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := km.Rename(key, newKey)
+	l.infoLog.Println("rename", key, newKey, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) Copy(key string, newKey string, replace bool) (*Value, error) {
+	defer l.peekIntoPanic("copy", key, newKey, replace)
+	km, err := asKeyManager(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := km.Copy(key, newKey, replace)
+	l.infoLog.Println("copy", key, newKey, replace, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) DBSize() (int, error) {
+	defer l.peekIntoPanic("dbsize")
+	km, err := asKeyManager(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := km.DBSize()
+	l.infoLog.Println("dbsize", "=>", result, err)
+	return result, err
+}
+
+func (l *logger) RandomKey() (string, error) {
+	defer l.peekIntoPanic("randomkey")
+	km, err := asKeyManager(l.Cache)
+	if err != nil {
+		return "", err
+	}
+	result, err := km.RandomKey()
+	l.infoLog.Println("randomkey", "=>", result, err)
+	return result, err
+}
+
+func (p *persister) Exists(keys ...string) (int, error) {
+	km, err := asKeyManager(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return km.Exists(keys...)
+}
+
+func (p *persister) KeyType(key string) (DataType, error) {
+	km, err := asKeyManager(p.Cache)
+	if err != nil {
+		return STRING, err
+	}
+	return km.KeyType(key)
+}
+
+func (p *persister) Expire(key string, expire time.Duration) (*Value, error) {
+	km, err := asKeyManager(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return km.Expire(key, expire)
+}
+
+func (p *persister) expireNew(key string, expire time.Duration) (*Value, error) {
+	e, ok := p.Cache.(defaultExpirer)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return e.expireNew(key, expire)
+}
+
+// executeExpire восстанавливает TTL по умолчанию, назначенный ключу после
+// создания
+func (o *operation) executeExpire(target Cache) error {
+	e, ok := target.(defaultExpirer)
+	if !ok {
+		return ErrUnsupported
+	}
+	left := time.Until(time.Unix(0, o.Expire))
+	if left <= 0 {
+		target.Remove(o.Key)
+		return ErrInvalidTTL
+	}
+	_, err := e.expireNew(o.Key, left)
+	return err
+}
+
+func (p *persister) Rename(key string, newKey string) (*Value, error) {
+	km, err := asKeyManager(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return km.Rename(key, newKey)
+}
+
+func (p *persister) Copy(key string, newKey string, replace bool) (*Value, error) {
+	km, err := asKeyManager(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return km.Copy(key, newKey, replace)
+}
+
+func (p *persister) DBSize() (int, error) {
+	km, err := asKeyManager(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return km.DBSize()
+}
+
+func (p *persister) RandomKey() (string, error) {
+	km, err := asKeyManager(p.Cache)
+	if err != nil {
+		return "", err
+	}
+	return km.RandomKey()
+}
+
+func (t *ttl) Exists(keys ...string) (int, error) {
+	km, err := asKeyManager(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return km.Exists(keys...)
+}
+
+func (t *ttl) KeyType(key string) (DataType, error) {
+	km, err := asKeyManager(t.Cache)
+	if err != nil {
+		return STRING, err
+	}
+	return km.KeyType(key)
+}
+
+// scheduleMoved планирует удаление ключа, получившего значение с
+// сохраненным временем истечения
+func (t *ttl) scheduleMoved(key string, result *Value) {
+	if result.Expires != 0 {
+		t.scheduleRemove(key, result, time.Until(time.Unix(0, result.Expires)))
+	}
+}
+
+func (t *ttl) Expire(key string, expire time.Duration) (*Value, error) {
+	km, err := asKeyManager(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := km.Expire(key, expire)
+	if err == nil && result != nil {
+		t.scheduleRemove(key, result, expire)
+	}
+	return result, err
+}
+
+func (t *ttl) Rename(key string, newKey string) (*Value, error) {
+	km, err := asKeyManager(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := km.Rename(key, newKey)
+	if err != nil {
+		return nil, err
+	}
+	t.scheduleMoved(newKey, result)
+	return result, nil
+}
+
+func (t *ttl) Copy(key string, newKey string, replace bool) (*Value, error) {
+	km, err := asKeyManager(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := km.Copy(key, newKey, replace)
+	if err != nil {
+		return nil, err
+	}
+	t.scheduleMoved(newKey, result)
+	return result, nil
+}
+
+func (t *ttl) DBSize() (int, error) {
+	km, err := asKeyManager(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return km.DBSize()
+}
+
+func (t *ttl) RandomKey() (string, error) {
+	km, err := asKeyManager(t.Cache)
+	if err != nil {
+		return "", err
+	}
+	return km.RandomKey()
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyManager(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	km := c.(KeyManager)
+
+	c.Set("a", "x", 0)
+	c.Set("list", []interface{}{1, 2}, 0)
+	if n, err := km.Exists("a", "list", "missing", "a"); n != 3 || err != nil {
+		t.Errorf("Exists: expected 3, got %v, err %v", n, err)
+	}
+	if typ, err := km.KeyType("list"); typ != LIST || err != nil {
+		t.Errorf("KeyType: expected list, got %v, err %v", typ, err)
+	}
+	if _, err := km.KeyType("missing"); err != ErrKeyNotFound {
+		t.Errorf("KeyType of missing key: expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if n, _ := km.DBSize(); n != 2 {
+		t.Errorf("DBSize: expected 2, got %v", n)
+	}
+
+	// ключи подобраны так, чтобы попадать в разные шарды
+	s := c.(*ttl).Cache.(*sharder)
+	dst := "b"
+	for i := 0; s.getTargetShardIdx(dst) == s.getTargetShardIdx("a"); i++ {
+		dst = string(rune('b' + i))
+	}
+	renamed, err := km.Rename("a", dst)
+	if err != nil || renamed.Data != "x" {
+		t.Fatalf("Rename: got %v, err %v", renamed, err)
+	}
+	if _, err := c.Get("a"); err != ErrKeyNotFound {
+		t.Errorf("Rename should remove the source key, got %v", err)
+	}
+	if _, err := km.Rename("a", dst); err != ErrKeyNotFound {
+		t.Errorf("Rename of missing key: expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if value, err := km.Rename(dst, dst); err != nil || value.Data != "x" {
+		t.Errorf("Rename to itself: got %v, err %v", value, err)
+	}
+
+	if _, err := km.Copy(dst, "list", false); err != ErrKeyExists {
+		t.Errorf("Copy over existing key: expected %v, got %v", ErrKeyExists, err)
+	}
+	if _, err := km.Copy(dst, dst, true); err != ErrSameKey {
+		t.Errorf("Copy to itself: expected %v, got %v", ErrSameKey, err)
+	}
+	if value, err := km.Copy(dst, "list", true); err != nil || value.Data != "x" || value.Type != STRING {
+		t.Errorf("Copy with replace: got %v, err %v", value, err)
+	}
+	if n, _ := km.DBSize(); n != 2 {
+		t.Errorf("DBSize after copy: expected 2, got %v", n)
+	}
+}
+
+func TestKeyManager_CopyIsIndependent(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	km := c.(KeyManager)
+	z := c.(SortedSetOperator)
+
+	z.ZAdd("board", ZMember{"a", 1})
+	km.Copy("board", "backup", false)
+	z.ZAdd("board", ZMember{"b", 2})
+	if n, _ := z.ZCard("backup"); n != 1 {
+		t.Errorf("copy should not see later changes of the source, got %v members", n)
+	}
+}
+
+func TestKeyManager_PreservesExpires(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	km := c.(KeyManager)
+
+	original, _ := c.Set("session", "x", 50*time.Millisecond)
+	copied, _ := km.Copy("session", "copy", false)
+	renamed, _ := km.Rename("session", "moved")
+	if copied.Expires != original.Expires || renamed.Expires != original.Expires {
+		t.Errorf("expected expires %v, got %v and %v", original.Expires, copied.Expires, renamed.Expires)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if n, _ := km.Exists("copy", "moved"); n != 0 {
+		t.Errorf("copied and renamed keys should expire, %v still exist", n)
+	}
+	if n, _ := km.DBSize(); n != 0 {
+		t.Errorf("expired keys should be removed, DBSize %v", n)
+	}
+}
+
+func TestKeyManager_Expire(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	km := c.(KeyManager)
+	c.Set("a", "x", 0)
+	c.Set("b", "y", 0)
+
+	if _, err := km.Expire("missing", time.Second); err != ErrKeyNotFound {
+		t.Errorf("Expire of missing key: expected %v, got %v", ErrKeyNotFound, err)
+	}
+	value, err := km.Expire("a", 30*time.Millisecond)
+	if err != nil || value.Data != "x" || value.Expires == 0 {
+		t.Errorf("Expire: got %v, err %v", value, err)
+	}
+	if value, err = km.Expire("b", 0); err != nil || value != nil {
+		t.Errorf("Expire with zero ttl should remove key, got %v, err %v", value, err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if n, _ := km.Exists("a", "b"); n != 0 {
+		t.Errorf("expected keys to be removed, %v still exist", n)
+	}
+}
+
+func TestKeyManager_RandomKey(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	km := c.(KeyManager)
+
+	if _, err := km.RandomKey(); err != ErrKeyNotFound {
+		t.Errorf("RandomKey of empty cache: expected %v, got %v", ErrKeyNotFound, err)
+	}
+	keys := map[string]bool{"a": true, "b": true, "c": true, "d": true, "e": true}
+	for key := range keys {
+		c.Set(key, 1, 0)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		key, err := km.RandomKey()
+		if err != nil || !keys[key] {
+			t.Fatalf("RandomKey: got %q, err %v", key, err)
+		}
+		seen[key] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("RandomKey should not always return the same key, got %v", seen)
+	}
+}
+
+func TestKeyManager_Persist(t *testing.T) {
+	sample := `{"Type":"Set","k":"a","v":"x","e":0}
+{"Type":"Set","k":"b","v":"x","e":0}
+{"Type":"Exec","k":"","v":null,"e":0,"ops":[{"Type":"Set","k":"c","v":"x","e":0},{"Type":"Remove","k":"a","v":null,"e":0}]}
+`
+	restored := checkPersist(t, 2, sample, func(p *persister) {
+		p.Set("a", "x", 0)
+		p.Copy("a", "b", false)
+		p.Rename("a", "c")
+	})
+	if _, err := restored.Get("a"); err != ErrKeyNotFound {
+		t.Errorf("TestKeyManager_Persist restore should remove a, got %v", err)
+	}
+	for _, key := range []string{"b", "c"} {
+		if value, err := restored.Get(key); err != nil || value.Data != "x" {
+			t.Errorf("TestKeyManager_Persist restore %v: got %v, err %v", key, value, err)
+		}
+	}
+}
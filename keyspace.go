@@ -0,0 +1,206 @@
+/*
+    Уведомления об изменениях ключей: запись, удаление и истечение срока
+*/
+
+package db
+
+import "time"
+
+// Типы событий ключей. EventSet публикуется при любом изменении значения,
+// в том числе при изменении срока жизни
+const (
+	EventSet     = "set"
+	EventDel     = "del"
+	EventExpired = "expired"
+)
+
+// KeyEvent - изменение ключа. Expires - новое время истечения значения в
+// наносекундах (0 - бессрочно или ключ удален)
+type KeyEvent struct {
+	Type    string `json:"type"`
+	Key     string `json:"key"`
+	Expires int64  `json:"expires"`
+}
+
+// KeyspaceNotifier подписывает на события ключей, подходящих под шаблон.
+// Сообщения подписки приходят с Channel, равным ключу, и KeyEvent в Data.
+// События публикуются под блокировкой шарда, поэтому события одного ключа
+// приходят в порядке изменений
+type KeyspaceNotifier interface {
+	SubscribeKeyspace(pattern string, buffer int) (*Subscription, error)
+}
+
+func asKeyspaceNotifier(c Cache) (KeyspaceNotifier, error) {
+	kn, ok := c.(KeyspaceNotifier)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return kn, nil
+}
+
+// keyExpirer удаляет ключ, только если его время истечения не изменилось
+// с момента планирования удаления
+type keyExpirer interface {
+	expireKey(key string, expires int64) (bool, error)
+}
+
+func (s *store) expireKey(key string, expires int64) (bool, error) {
+	// lookup уже не отдает истекший ключ, поэтому проверяем items напрямую
+	v, ok := s.items[key]
+	if !ok || v.Expires != expires {
+		return false, nil
+	}
+	s.drop(key)
+	return true, nil
+}
+
+// notifyKey публикует событие ключа. Вызывается под блокировкой шарда
+func (s *sharder) notifyKey(event string, key string, value *Value) {
+	if s.events.idle() {
+		return
+	}
+	e := KeyEvent{Type: event, Key: key}
+	if value != nil {
+		e.Expires = value.Expires
+	}
+	s.events.Publish(key, e)
+}
+
+// notifyPoppers будит первого ожидающего извлечения, если ключ стал списком.
+// Сигнал не блокирует, поэтому его можно подать под блокировкой шарда
+func (s *sharder) notifyPoppers(key string, value *Value) {
+	if value.Type == LIST {
+		s.poppers.notify(key)
+	}
+}
+
+// updateShard изменяет значение в шарде, публикует EventSet или EventDel,
+// будит ожидающих извлечения, если получился список, и пишет изменение в
+// журнал записями record. Если fn ничего не изменила, ничего не публикуется
+// и не записывается. Шард должен быть заблокирован
+func (s *sharder) updateShard(target updater, key string, fn updateFunc, record journalFunc) (*Value, error) {
+	existed, changed := false, false
+	value, err := target.Update(key, func(current *Value) (*Value, error) {
+		existed = current != nil
+		next, err := fn(current)
+		changed = next != current
+		return next, err
+	})
+	if err != nil || !changed {
+		return value, err
+	}
+	if value != nil {
+		s.notifyKey(EventSet, key, value)
+		s.notifyPoppers(key, value)
+	} else if existed {
+		s.notifyKey(EventDel, key, nil)
+	}
+	s.logChange(key, value, record)
+	return value, nil
+}
+
+// setKey записывает значение в заблокированный шард, публикует EventSet,
+// будит ожидающих извлечения и пишет изменение в журнал записями record
+func (s *sharder) setKey(shard Cache, key string, value interface{}, expire time.Duration, record journalFunc) (*Value, error) {
+	result, err := shard.Set(key, value, expire)
+	if err != nil {
+		return nil, err
+	}
+	s.notifyKey(EventSet, key, result)
+	s.notifyPoppers(key, result)
+	s.logChange(key, result, record)
+	return result, nil
+}
+
+// removeKey удаляет ключ из заблокированного шарда, публикует event, если
+// ключ существовал, и пишет удаление в журнал записями record
+func (s *sharder) removeKey(shard Cache, key string, event string, record journalFunc) (bool, error) {
+	_, err := shard.Get(key)
+	existed := err == nil
+	if err = shard.Remove(key); err != nil {
+		return false, err
+	}
+	if existed {
+		s.notifyKey(event, key, nil)
+	}
+	s.logChange(key, nil, record)
+	return existed, nil
+}
+
+func (s *sharder) SubscribeKeyspace(pattern string, buffer int) (*Subscription, error) {
+	pattern, err := normalizePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return s.events.Subscribe(nil, []string{pattern}, buffer)
+}
+
+func (s *sharder) expireKey(key string, expires int64) (bool, error) {
+	i := s.getTargetShardIdx(key)
+	if s.needLock {
+		s.locks[i].Lock()
+		defer s.locks[i].Unlock()
+	}
+	shard := s.shards[i]
+	removed := false
+	var err error
+	if e, ok := shard.(keyExpirer); ok {
+		removed, err = e.expireKey(key, expires)
+	} else if value, getErr := shard.Get(key); getErr == nil && value.Expires == expires {
+		removed, err = true, shard.Remove(key)
+	}
+	if err != nil {
+		return false, err
+	}
+	if removed {
+		s.notifyKey(EventExpired, key, nil)
+		s.log(operation{Type: "Remove", Key: key})
+	}
+	return removed, nil
+}
+
+func (l *logger) SubscribeKeyspace(pattern string, buffer int) (*Subscription, error) {
+	defer l.peekIntoPanic("subscribekeyspace", pattern, buffer)
+	kn, err := asKeyspaceNotifier(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := kn.SubscribeKeyspace(pattern, buffer)
+	l.infoLog.Println("subscribekeyspace", pattern, buffer, "=>", err)
+	return sub, err
+}
+
+func (l *logger) expireKey(key string, expires int64) (bool, error) {
+	defer l.peekIntoPanic("expire", key, expires)
+	e, ok := l.Cache.(keyExpirer)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	removed, err := e.expireKey(key, expires)
+	l.infoLog.Println("expire", key, expires, "=>", removed, err)
+	return removed, err
+}
+
+func (p *persister) SubscribeKeyspace(pattern string, buffer int) (*Subscription, error) {
+	kn, err := asKeyspaceNotifier(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return kn.SubscribeKeyspace(pattern, buffer)
+}
+
+func (p *persister) expireKey(key string, expires int64) (bool, error) {
+	e, ok := p.Cache.(keyExpirer)
+	if !ok {
+		return false, ErrUnsupported
+	}
+	return e.expireKey(key, expires)
+}
+
+func (t *ttl) SubscribeKeyspace(pattern string, buffer int) (*Subscription, error) {
+	kn, err := asKeyspaceNotifier(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return kn.SubscribeKeyspace(pattern, buffer)
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// nextKeyEvent ждет событие подписки не дольше секунды
+func nextKeyEvent(t *testing.T, sub *Subscription) KeyEvent {
+	select {
+	case m := <-sub.C:
+		return m.Data.(KeyEvent)
+	case <-time.After(time.Second):
+		t.Fatal("expected key event")
+	}
+	return KeyEvent{}
+}
+
+func TestKeyspaceNotifier_Events(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	sub, err := c.(KeyspaceNotifier).SubscribeKeyspace("user:*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	value, _ := c.Set("user:1", "a", 50*time.Millisecond)
+	c.Set("other", "b", 0)
+	c.(Hasher).HSet("user:2", "name", "bob")
+	c.(KeyManager).Rename("user:2", "user:3")
+	c.Remove("user:3")
+	c.Remove("user:3")
+
+	expected := []KeyEvent{
+		{EventSet, "user:1", value.Expires},
+		{EventSet, "user:2", 0},
+		{EventSet, "user:3", 0},
+		{EventDel, "user:2", 0},
+		{EventDel, "user:3", 0},
+		{EventExpired, "user:1", 0},
+	}
+	for _, e := range expected {
+		if got := nextKeyEvent(t, sub); got != e {
+			t.Errorf("expected event %+v, got %+v", e, got)
+		}
+	}
+
+	// операции, которые ничего не меняют, событий не публикуют
+	c.(SetOperator).SAdd("user:5", "a")
+	c.(SetOperator).SAdd("user:5", "a")
+	c.(SetOperator).SRem("user:5", "b")
+	c.(SetOperator).SRem("user:6", "b")
+	c.Remove("user:5")
+	for _, e := range []KeyEvent{{EventSet, "user:5", 0}, {EventDel, "user:5", 0}} {
+		if got := nextKeyEvent(t, sub); got != e {
+			t.Errorf("expected event %+v, got %+v", e, got)
+		}
+	}
+
+	c.Set("user:4", "a", 0)
+	c.(Flusher).FlushDB()
+	for _, e := range []KeyEvent{{EventSet, "user:4", 0}, {EventDel, "user:4", 0}} {
+		if got := nextKeyEvent(t, sub); got != e {
+			t.Errorf("expected event %+v, got %+v", e, got)
+		}
+	}
+
+	if _, err = c.(KeyspaceNotifier).SubscribeKeyspace("[a", 0); err != ErrBadPattern {
+		t.Errorf("SubscribeKeyspace with bad pattern: expected %v, got %v", ErrBadPattern, err)
+	}
+}
+
+func TestKeyspaceNotifier_ExpireRechecked(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 1, nil)
+	sub, _ := c.(KeyspaceNotifier).SubscribeKeyspace("", 0)
+	defer sub.Close()
+
+	// перезапись без срока отменяет запланированное удаление
+	c.Set("key", "a", 20*time.Millisecond)
+	c.Set("key", "b", 0)
+	time.Sleep(50 * time.Millisecond)
+	if value, err := c.Get("key"); err != nil || value.Data != "b" {
+		t.Errorf("rewritten key should not expire, got %v, err %v", value, err)
+	}
+	nextKeyEvent(t, sub)
+	nextKeyEvent(t, sub)
+	select {
+	case m := <-sub.C:
+		t.Errorf("unexpected event %+v", m.Data)
+	default:
+	}
+}
+
+func TestKeyspaceNotifier_PersistExpired(t *testing.T) {
+	s, _ := newSharder(1, nil)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+	tl, _ := newTtl(p, 0)
+	sub, _ := tl.SubscribeKeyspace("key", 0)
+	defer sub.Close()
+
+	value, _ := tl.Set("key", "a", 20*time.Millisecond)
+	nextKeyEvent(t, sub)
+	if e := nextKeyEvent(t, sub); e.Type != EventExpired {
+		t.Errorf("expected expired event, got %+v", e)
+	}
+	sample := `{"Type":"Set","k":"key","v":"a","e":` + strconv.FormatInt(value.Expires, 10) + `}
+{"Type":"Remove","k":"key","v":null,"e":0}
+`
+	if got := flushOplog(p, &rw, sample); got != sample {
+		t.Errorf("TestKeyspaceNotifier_PersistExpired expected:\n%v\ngot:\n%v", sample, got)
+	}
+}
@@ -0,0 +1,410 @@
+/*
+    Операции над значениями типа LIST
+*/
+
+package db
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// Lister изменяет списки атомарно под блокировкой шарда.
+// Индексы могут быть отрицательными и отсчитываются от конца списка
+type Lister interface {
+	LPush(key string, items ...interface{}) (int, error)
+	RPush(key string, items ...interface{}) (int, error)
+	LPop(key string) (interface{}, error)
+	RPop(key string) (interface{}, error)
+	LRange(key string, start int, stop int) ([]interface{}, error)
+	LSet(key string, index int, item interface{}) error
+	LLen(key string) (int, error)
+}
+
+func asLister(c Cache) (Lister, error) {
+	l, ok := c.(Lister)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return l, nil
+}
+
+// copyList копирует срез любого типа в []interface{}
+func copyList(data interface{}, extra int) []interface{} {
+	if l, ok := data.([]interface{}); ok {
+		result := make([]interface{}, len(l), len(l)+extra)
+		copy(result, l)
+		return result
+	}
+	l := reflect.ValueOf(data)
+	result := make([]interface{}, l.Len(), l.Len()+extra)
+	for i := range result {
+		result[i] = l.Index(i).Interface()
+	}
+	return result
+}
+
+// normalizeIndex переводит отрицательный индекс в индекс от начала списка
+func normalizeIndex(index int, length int) int {
+	if index < 0 {
+		index += length
+	}
+	return index
+}
+
+func (s *sharder) push(key string, left bool, items []interface{}) (length int, err error) {
+	record := operation{Type: "RPush", Key: key, Value: items}
+	if left {
+		record.Type = "LPush"
+	}
+	_, err = s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		result := &Value{Type: LIST}
+		var list []interface{}
+		if current != nil {
+			if current.Type != LIST {
+				return nil, ErrWrongType
+			}
+			list = copyList(current.Data, len(items))
+			result.Expires = current.Expires
+		}
+		if left {
+			head := make([]interface{}, len(items), len(items)+len(list))
+			for i := range items {
+				head[len(items)-1-i] = items[i]
+			}
+			list = append(head, list...)
+		} else {
+			list = append(list, items...)
+		}
+		result.Data = list
+		length = len(list)
+		return result, nil
+	})
+	return
+}
+
+func (s *sharder) pop(key string, left bool) (item interface{}, err error) {
+	record := operation{Type: "RPop", Key: key}
+	if left {
+		record.Type = "LPop"
+	}
+	_, err = s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		if current == nil {
+			return nil, ErrKeyNotFound
+		}
+		if current.Type != LIST {
+			return nil, ErrWrongType
+		}
+		list := copyList(current.Data, 0)
+		if len(list) == 0 {
+			return nil, ErrKeyNotFound
+		}
+		if left {
+			item, list = list[0], list[1:]
+		} else {
+			item, list = list[len(list)-1], list[:len(list)-1]
+		}
+		if len(list) == 0 {
+			return nil, nil
+		}
+		return &Value{Type: LIST, Data: list, Expires: current.Expires}, nil
+	})
+	return
+}
+
+func (s *sharder) LPush(key string, items ...interface{}) (int, error) {
+	return s.push(key, true, items)
+}
+
+func (s *sharder) RPush(key string, items ...interface{}) (int, error) {
+	return s.push(key, false, items)
+}
+
+func (s *sharder) LPop(key string) (interface{}, error) {
+	return s.pop(key, true)
+}
+
+func (s *sharder) RPop(key string) (interface{}, error) {
+	return s.pop(key, false)
+}
+
+func (s *sharder) LRange(key string, start int, stop int) (items []interface{}, err error) {
+	err = s.view(key, func(value *Value) error {
+		if value.Type != LIST {
+			return ErrWrongType
+		}
+		list := reflect.ValueOf(value.Data)
+		length := list.Len()
+		start, stop = normalizeIndex(start, length), normalizeIndex(stop, length)
+		if start < 0 {
+			start = 0
+		}
+		if stop >= length {
+			stop = length - 1
+		}
+		items = []interface{}{}
+		for i := start; i <= stop; i++ {
+			items = append(items, list.Index(i).Interface())
+		}
+		return nil
+	})
+	return
+}
+
+func (s *sharder) LSet(key string, index int, item interface{}) error {
+	record := operation{Type: "LSet", Key: key, Field: strconv.Itoa(index), Value: item}
+	_, err := s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		if current == nil {
+			return nil, ErrKeyNotFound
+		}
+		if current.Type != LIST {
+			return nil, ErrWrongType
+		}
+		list := copyList(current.Data, 0)
+		i := normalizeIndex(index, len(list))
+		if i < 0 || i >= len(list) {
+			return nil, ErrIndexAccess
+		}
+		list[i] = item
+		return &Value{Type: LIST, Data: list, Expires: current.Expires}, nil
+	})
+	return err
+}
+
+func (s *sharder) LLen(key string) (n int, err error) {
+	err = s.view(key, func(value *Value) error {
+		if value.Type != LIST {
+			return ErrWrongType
+		}
+		n = reflect.ValueOf(value.Data).Len()
+		return nil
+	})
+	return
+}
+
+func (l *logger) LPush(key string, items ...interface{}) (int, error) {
+	defer l.peekIntoPanic("lpush", key, items)
+	target, err := asLister(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := target.LPush(key, items...)
+	l.infoLog.Println("lpush", key, items, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) RPush(key string, items ...interface{}) (int, error) {
+	defer l.peekIntoPanic("rpush", key, items)
+	target, err := asLister(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := target.RPush(key, items...)
+	l.infoLog.Println("rpush", key, items, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) LPop(key string) (interface{}, error) {
+	defer l.peekIntoPanic("lpop", key)
+	target, err := asLister(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	item, err := target.LPop(key)
+	l.infoLog.Println("lpop", key, "=>", item, err)
+	return item, err
+}
+
+func (l *logger) RPop(key string) (interface{}, error) {
+	defer l.peekIntoPanic("rpop", key)
+	target, err := asLister(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	item, err := target.RPop(key)
+	l.infoLog.Println("rpop", key, "=>", item, err)
+	return item, err
+}
+
+func (l *logger) LRange(key string, start int, stop int) ([]interface{}, error) {
+	defer l.peekIntoPanic("lrange", key, start, stop)
+	target, err := asLister(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	items, err := target.LRange(key, start, stop)
+	l.infoLog.Println("lrange", key, start, stop, "=>", items, err)
+	return items, err
+}
+
+func (l *logger) LSet(key string, index int, item interface{}) error {
+	defer l.peekIntoPanic("lset", key, index, item)
+	target, err := asLister(l.Cache)
+	if err != nil {
+		return err
+	}
+	err = target.LSet(key, index, item)
+	l.infoLog.Println("lset", key, index, item, "=>", err)
+	return err
+}
+
+func (l *logger) LLen(key string) (int, error) {
+	defer l.peekIntoPanic("llen", key)
+	target, err := asLister(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := target.LLen(key)
+	l.infoLog.Println("llen", key, "=>", n, err)
+	return n, err
+}
+
+func (p *persister) LPush(key string, items ...interface{}) (int, error) {
+	target, err := asLister(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return target.LPush(key, items...)
+}
+
+func (p *persister) RPush(key string, items ...interface{}) (int, error) {
+	target, err := asLister(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return target.RPush(key, items...)
+}
+
+func (p *persister) LPop(key string) (interface{}, error) {
+	target, err := asLister(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.LPop(key)
+}
+
+func (p *persister) RPop(key string) (interface{}, error) {
+	target, err := asLister(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.RPop(key)
+}
+
+func (p *persister) LRange(key string, start int, stop int) ([]interface{}, error) {
+	target, err := asLister(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.LRange(key, start, stop)
+}
+
+func (p *persister) LSet(key string, index int, item interface{}) error {
+	target, err := asLister(p.Cache)
+	if err != nil {
+		return err
+	}
+	return target.LSet(key, index, item)
+}
+
+func (p *persister) LLen(key string) (int, error) {
+	target, err := asLister(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return target.LLen(key)
+}
+
+func (o *operation) executeList(target Cache) error {
+	l, err := asLister(target)
+	if err != nil {
+		return err
+	}
+	switch o.Type {
+	case "LPush", "RPush":
+		items, ok := o.Value.([]interface{})
+		if !ok {
+			return ErrConversionError
+		}
+		if o.Type == "LPush" {
+			_, err = l.LPush(o.Key, items...)
+		} else {
+			_, err = l.RPush(o.Key, items...)
+		}
+	case "LPop":
+		_, err = l.LPop(o.Key)
+	case "RPop":
+		_, err = l.RPop(o.Key)
+	case "LSet":
+		index, convErr := strconv.Atoi(o.Field)
+		if convErr != nil {
+			return ErrNonIntegerSubkey
+		}
+		err = l.LSet(o.Key, index, o.Value)
+	}
+	return err
+}
+
+func (t *ttl) LPush(key string, items ...interface{}) (int, error) {
+	target, err := asLister(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := target.LPush(key, items...)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) RPush(key string, items ...interface{}) (int, error) {
+	target, err := asLister(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := target.RPush(key, items...)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) LPop(key string) (interface{}, error) {
+	target, err := asLister(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.LPop(key)
+}
+
+func (t *ttl) RPop(key string) (interface{}, error) {
+	target, err := asLister(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.RPop(key)
+}
+
+func (t *ttl) LRange(key string, start int, stop int) ([]interface{}, error) {
+	target, err := asLister(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return target.LRange(key, start, stop)
+}
+
+func (t *ttl) LSet(key string, index int, item interface{}) error {
+	target, err := asLister(t.Cache)
+	if err != nil {
+		return err
+	}
+	return target.LSet(key, index, item)
+}
+
+func (t *ttl) LLen(key string) (int, error) {
+	target, err := asLister(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return target.LLen(key)
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestList_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 3, nil)
+	l := c.(Lister)
+
+	c.Set("list", []int{1, 2, 3}, time.Minute)
+	n, err := l.LPush("list", "b", "a")
+	if err != nil || n != 5 {
+		t.Errorf("LPush: expected length 5, got %v, err %v", n, err)
+	}
+	n, err = l.RPush("list", 4)
+	if err != nil || n != 6 {
+		t.Errorf("RPush: expected length 6, got %v, err %v", n, err)
+	}
+
+	items, err := l.LRange("list", 0, -1)
+	if err != nil || !reflect.DeepEqual(items, []interface{}{"a", "b", 1, 2, 3, 4}) {
+		t.Errorf("LRange: got %v, err %v", items, err)
+	}
+	items, _ = l.LRange("list", -2, 100)
+	if !reflect.DeepEqual(items, []interface{}{3, 4}) {
+		t.Errorf("LRange with negative start: got %v", items)
+	}
+	items, _ = l.LRange("list", 4, 2)
+	if !reflect.DeepEqual(items, []interface{}{}) {
+		t.Errorf("LRange with start > stop: got %v", items)
+	}
+
+	if err = l.LSet("list", -1, "last"); err != nil {
+		t.Error(err)
+	}
+	if err = l.LSet("list", 6, "x"); err != ErrIndexAccess {
+		t.Errorf("LSet out of range: expected %v, got %v", ErrIndexAccess, err)
+	}
+
+	item, err := l.LPop("list")
+	if err != nil || item != "a" {
+		t.Errorf("LPop: expected a, got %v, err %v", item, err)
+	}
+	item, err = l.RPop("list")
+	if err != nil || item != "last" {
+		t.Errorf("RPop: expected last, got %v, err %v", item, err)
+	}
+
+	value, _ := c.Get("list")
+	if value.Expires == 0 {
+		t.Errorf("list operations should preserve expiration")
+	}
+	n, _ = l.LLen("list")
+	if n != 4 {
+		t.Errorf("LLen: expected 4, got %v", n)
+	}
+
+	for i := 0; i < 4; i++ {
+		l.LPop("list")
+	}
+	if _, err = l.LPop("list"); err != ErrKeyNotFound {
+		t.Errorf("LPop on emptied list: expected %v, got %v", ErrKeyNotFound, err)
+	}
+
+	c.Set("string", "something", 0)
+	if _, err = l.RPush("string", 1); err != ErrWrongType {
+		t.Errorf("RPush on string: expected %v, got %v", ErrWrongType, err)
+	}
+}
+
+func TestList_Persist(t *testing.T) {
+	sample := `{"Type":"RPush","k":"list","v":[1,2,3],"e":0}
+{"Type":"LPush","k":"list","v":[0],"e":0}
+{"Type":"RPop","k":"list","v":null,"e":0}
+{"Type":"LSet","k":"list","v":"x","e":0,"f":"1"}
+{"Type":"LPop","k":"list","v":null,"e":0}
+`
+	s, _ := newSharder(1, nil)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+	p.RPush("list", 1, 2, 3)
+	p.LPush("list", 0)
+	p.RPop("list")
+	p.LSet("list", 1, "x")
+	p.LPop("list")
+	p.LPop("missing")
+	if got := flushOplog(p, &rw, sample); got != sample {
+		t.Errorf("TestList_Persist expected:\n%v\ngot:\n%v", sample, got)
+	}
+
+	restored, _ := newSharder(1, nil)
+	rw.Reset()
+	rw.WriteString(sample)
+	p, _ = newPersister(restored, &rw, time.Hour)
+	value, err := restored.Get("list")
+	if err != nil || !reflect.DeepEqual(value.Data, []interface{}{"x", 2.0}) {
+		t.Errorf("TestList_Persist restore: got %v, err %v", value, err)
+	}
+	// восстановление не забирает записи, сделанные после него
+	rw.Reset()
+	p.RPush("list", 3)
+	p.RPush("list", 4)
+	expected := `{"Type":"RPush","k":"list","v":[3],"e":0}
+{"Type":"RPush","k":"list","v":[4],"e":0}
+`
+	if got := flushOplog(p, &rw, expected); got != expected {
+		t.Errorf("TestList_Persist after restore expected:\n%v\ngot:\n%v", expected, got)
+	}
+
+	// журнал пишется в порядке исполнения, поэтому ошибка восстановления -
+	// поврежденный журнал
+	rw.Reset()
+	rw.WriteString(`{"Type":"LPop","k":"missing","v":null,"e":0}` + "\n")
+	if _, err = newPersister(restored, &rw, time.Hour); err != ErrKeyNotFound {
+		t.Errorf("TestList_Persist restore of pop from missing list: expected %v, got %v", ErrKeyNotFound, err)
+	}
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"flag"
+	"github.com/shpaktakur1/TestAvito/db"
 	"github.com/shpaktakur1/TestAvito/rest"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -13,9 +17,13 @@ func main() {
 	addr := flag.String("addr", ":8080", "http server address")
 	readTimeout := flag.Int("readTimeout", 10, "http read timeout")
 	writeTimeout := flag.Int("writeTimeout", 10, "http write timeout")
+	respAddr := flag.String("respAddr", "", "RESP (redis protocol) tcp server address. Disabled if empty")
+	telnetAddr := flag.String("telnetAddr", "", "plain text telnet server address. Disabled if empty")
 
 	defaultTtl := flag.Int("defaultTTL", 0, "default ttl in seconds for every entry")
 	nShards := flag.Int("shards", 1, "number of shards for concurrent writes")
+	databases := flag.Int("databases", rest.DefaultDatabases, "number of logical databases, available as /db/{n}/ and by SELECT")
+	scriptTimeLimit := flag.Int("scriptTimeLimit", 5000, "max execution time of EVAL scripts in ms. Shards of script keys stay locked meanwhile")
 
 	login := flag.String("login", "", "login for basic auth")
 	password := flag.String("password", "", "password for basic auth")
@@ -26,23 +34,52 @@ func main() {
 	logTo := flag.String("log", "", "stdout/stderr/path_to_log_file. Does not log if empty")
 
 	flag.Parse()
+	db.ScriptTimeLimit = time.Duration(*scriptTimeLimit) * time.Millisecond
 	app := rest.App{}
 
 	if *login != "" && *password != "" {
 		app.Authorization = &rest.BasicAuthorizer{Username: *login, Password: *password}
 	}
 
-	var rw io.ReadWriteCloser
 	var err error
+	var storage func(name string) (io.ReadWriter, error)
 
+	// файлы баз закрываются при остановке сервера
+	var filesMu sync.Mutex
+	var files []*os.File
 	if *filename != "" {
-		rw, err = os.OpenFile(*filename, os.O_RDWR|os.O_CREATE, 0600)
-		if err != nil {
-			log.Fatal(err)
+		// база 0 хранится в file, остальные - в file.<номер базы>
+		storage = func(name string) (io.ReadWriter, error) {
+			path := *filename
+			if name != db.DefaultDB {
+				path += "." + name
+			}
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+			if err != nil {
+				return nil, err
+			}
+			filesMu.Lock()
+			files = append(files, f)
+			filesMu.Unlock()
+			return f, nil
 		}
-		defer rw.Close()
 	}
 
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		<-stop
+		// журнал пишется на диск раз в saveFreq: ждем последнюю запись
+		time.Sleep(time.Duration(*saveFreq) * time.Millisecond)
+		filesMu.Lock()
+		for _, f := range files {
+			f.Sync()
+			f.Close()
+		}
+		filesMu.Unlock()
+		os.Exit(0)
+	}()
+
 	var writer io.Writer = nil
 	if *logTo != "" {
 		switch *logTo {
@@ -60,10 +97,18 @@ func main() {
 		}
 	}
 
+	app.Databases = db.NewDatabases(db.NumberedDatabases(
+		*databases,
+		time.Duration(*defaultTtl)*time.Second,
+		writer,
+		storage,
+		time.Duration(*saveFreq)*time.Millisecond,
+		*nShards,
+		nil))
 	err = app.Initialize(
 		time.Duration(*defaultTtl)*time.Second,
 		writer,
-		rw,
+		nil,
 		time.Duration(*saveFreq)*time.Millisecond,
 		*nShards,
 		nil)
@@ -71,5 +116,14 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if *respAddr != "" {
+		resp := &rest.RespServer{Authorization: app.Authorization, Cache: app.Cache, Databases: app.Databases, PubSub: app.PubSub}
+		go resp.Run(*respAddr)
+	}
+	if *telnetAddr != "" {
+		telnet := &rest.TelnetServer{Authorization: app.Authorization, Cache: app.Cache, Databases: app.Databases}
+		go telnet.Run(*telnetAddr)
+	}
+
 	app.Run(*addr, *readTimeout, *writeTimeout)
 }
@@ -0,0 +1,329 @@
+/*
+    Доступ к вложенным элементам значений по пути вида profile.addresses[2].city
+*/
+
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrMalformedPath = errors.New("malformed path expression")
+
+// PathOperator читает и изменяет вложенные элементы LIST и MAP.
+// Сегмент-имя обращается к полю словаря, [n] - к элементу списка,
+// отрицательный индекс считается с конца. Промежуточные элементы должны
+// существовать
+type PathOperator interface {
+	GetPath(key string, path string) (interface{}, error)
+	// SetPath заменяет элемент списка или записывает поле словаря
+	SetPath(key string, path string, value interface{}) (*Value, error)
+	// DelPath удаляет поле словаря или элемент списка со сдвигом
+	DelPath(key string, path string) (*Value, error)
+}
+
+func asPathOperator(c Cache) (PathOperator, error) {
+	po, ok := c.(PathOperator)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return po, nil
+}
+
+// parsePath разбирает путь в последовательность сегментов: string для полей
+// словаря и int для индексов списка
+func parsePath(path string) ([]interface{}, error) {
+	segments := []interface{}{}
+	for i, part := range strings.Split(path, ".") {
+		name := part
+		if j := strings.IndexByte(part, '['); j >= 0 {
+			name = part[:j]
+			part = part[j:]
+		} else {
+			part = ""
+		}
+		if strings.IndexByte(name, ']') >= 0 {
+			return nil, ErrMalformedPath
+		}
+		if name != "" {
+			segments = append(segments, name)
+		} else if i > 0 || part == "" {
+			return nil, ErrMalformedPath
+		}
+		for part != "" {
+			end := strings.IndexByte(part, ']')
+			if part[0] != '[' || end < 0 {
+				return nil, ErrMalformedPath
+			}
+			index, err := strconv.Atoi(part[1:end])
+			if err != nil {
+				return nil, ErrNonIntegerSubkey
+			}
+			segments = append(segments, index)
+			part = part[end+1:]
+		}
+	}
+	return segments, nil
+}
+
+// childAt возвращает элемент контейнера по сегменту пути
+func childAt(data interface{}, segment interface{}) (interface{}, error) {
+	container := reflect.ValueOf(data)
+	switch container.Kind() {
+	case reflect.Map:
+		name, ok := segment.(string)
+		if !ok || container.Type().Key().Kind() != reflect.String {
+			return nil, ErrIllegalIndexType
+		}
+		item := container.MapIndex(reflect.ValueOf(name).Convert(container.Type().Key()))
+		if !item.IsValid() {
+			return nil, ErrIndexAccess
+		}
+		return item.Interface(), nil
+	case reflect.Slice, reflect.Array:
+		index, ok := segment.(int)
+		if !ok {
+			return nil, ErrIllegalIndexType
+		}
+		index = normalizeIndex(index, container.Len())
+		if index < 0 || index >= container.Len() {
+			return nil, ErrIndexAccess
+		}
+		return container.Index(index).Interface(), nil
+	}
+	return nil, ErrIndexAccess
+}
+
+func valueAtPath(data interface{}, path []interface{}) (interface{}, error) {
+	var err error
+	for _, segment := range path {
+		if data, err = childAt(data, segment); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// rewritePath возвращает копию data, в которой последний сегмент пути изменен
+// функцией leaf. Копируются только контейнеры на пути, остальное разделяется
+// с исходным значением
+func rewritePath(data interface{}, path []interface{}, leaf func(container interface{}, segment interface{}) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return leaf(data, path[0])
+	}
+	child, err := childAt(data, path[0])
+	if err != nil {
+		return nil, err
+	}
+	child, err = rewritePath(child, path[1:], leaf)
+	if err != nil {
+		return nil, err
+	}
+	return setChild(data, path[0], child)
+}
+
+func setChild(data interface{}, segment interface{}, child interface{}) (interface{}, error) {
+	container := reflect.ValueOf(data)
+	switch container.Kind() {
+	case reflect.Map:
+		name, ok := segment.(string)
+		if !ok || container.Type().Key().Kind() != reflect.String {
+			return nil, ErrIllegalIndexType
+		}
+		m := copyMap(data)
+		m[name] = child
+		return m, nil
+	case reflect.Slice, reflect.Array:
+		index, ok := segment.(int)
+		if !ok {
+			return nil, ErrIllegalIndexType
+		}
+		l := copyList(data, 0)
+		index = normalizeIndex(index, len(l))
+		if index < 0 || index >= len(l) {
+			return nil, ErrIndexAccess
+		}
+		l[index] = child
+		return l, nil
+	}
+	return nil, ErrIndexAccess
+}
+
+func deleteChild(data interface{}, segment interface{}) (interface{}, error) {
+	if _, err := childAt(data, segment); err != nil {
+		return nil, err
+	}
+	if name, ok := segment.(string); ok {
+		m := copyMap(data)
+		delete(m, name)
+		return m, nil
+	}
+	l := copyList(data, 0)
+	index := normalizeIndex(segment.(int), len(l))
+	return append(l[:index], l[index+1:]...), nil
+}
+
+func containerType(t DataType) error {
+	if t != LIST && t != MAP {
+		return ErrIndexAccess
+	}
+	return nil
+}
+
+func (s *sharder) GetPath(key string, path string) (result interface{}, err error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	err = s.view(key, func(value *Value) error {
+		if err := containerType(value.Type); err != nil {
+			return err
+		}
+		result, err = valueAtPath(value.Data, segments)
+		return err
+	})
+	return result, err
+}
+
+// modifyPath изменяет значение по пути и пишет в журнал запись record под
+// блокировкой шарда: удаление элемента сдвигает индексы списка, поэтому
+// изменения одного ключа должны восстанавливаться в порядке выполнения
+func (s *sharder) modifyPath(key string, path string, record operation, leaf func(container interface{}, segment interface{}) (interface{}, error)) (*Value, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	journal := func(value *Value) []operation {
+		record.Expire = value.Expires
+		return []operation{record}
+	}
+	return s.updateLogged(key, journal, func(current *Value) (*Value, error) {
+		if current == nil {
+			return nil, ErrKeyNotFound
+		}
+		if err := containerType(current.Type); err != nil {
+			return nil, err
+		}
+		data, err := rewritePath(current.Data, segments, leaf)
+		if err != nil {
+			return nil, err
+		}
+		return &Value{Type: current.Type, Data: data, Expires: current.Expires}, nil
+	})
+}
+
+func (s *sharder) SetPath(key string, path string, value interface{}) (*Value, error) {
+	record := operation{Type: "PSet", Key: key, Field: path, Value: value}
+	return s.modifyPath(key, path, record, func(container interface{}, segment interface{}) (interface{}, error) {
+		return setChild(container, segment, value)
+	})
+}
+
+func (s *sharder) DelPath(key string, path string) (*Value, error) {
+	return s.modifyPath(key, path, operation{Type: "PDel", Key: key, Field: path}, deleteChild)
+}
+
+func (l *logger) GetPath(key string, path string) (interface{}, error) {
+	defer l.peekIntoPanic("getpath", key, path)
+	po, err := asPathOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := po.GetPath(key, path)
+	l.infoLog.Println("getpath", key, path, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) SetPath(key string, path string, value interface{}) (*Value, error) {
+	defer l.peekIntoPanic("setpath", key, path, value)
+	po, err := asPathOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := po.SetPath(key, path, value)
+	l.infoLog.Println("setpath", key, path, value, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) DelPath(key string, path string) (*Value, error) {
+	defer l.peekIntoPanic("delpath", key, path)
+	po, err := asPathOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := po.DelPath(key, path)
+	l.infoLog.Println("delpath", key, path, "=>", result, err)
+	return result, err
+}
+
+func (p *persister) GetPath(key string, path string) (interface{}, error) {
+	po, err := asPathOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return po.GetPath(key, path)
+}
+
+func (p *persister) SetPath(key string, path string, value interface{}) (*Value, error) {
+	po, err := asPathOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return po.SetPath(key, path, value)
+}
+
+func (p *persister) DelPath(key string, path string) (*Value, error) {
+	po, err := asPathOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return po.DelPath(key, path)
+}
+
+func (o *operation) executePath(target Cache) error {
+	po, err := asPathOperator(target)
+	if err != nil {
+		return err
+	}
+	if o.Type == "PSet" {
+		_, err = po.SetPath(o.Key, o.Field, o.Value)
+	} else {
+		_, err = po.DelPath(o.Key, o.Field)
+	}
+	if err == ErrKeyNotFound {
+		// ключ мог истечь до перезапуска
+		return nil
+	}
+	return err
+}
+
+func (t *ttl) GetPath(key string, path string) (interface{}, error) {
+	po, err := asPathOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return po.GetPath(key, path)
+}
+
+func (t *ttl) SetPath(key string, path string, value interface{}) (*Value, error) {
+	po, err := asPathOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := po.SetPath(key, path, value)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) DelPath(key string, path string) (*Value, error) {
+	po, err := asPathOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return po.DelPath(key, path)
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []interface{}
+		err      error
+	}{
+		{"profile.addresses[2].city", []interface{}{"profile", "addresses", 2, "city"}, nil},
+		{"[0][-1]", []interface{}{0, -1}, nil},
+		{"a.b", []interface{}{"a", "b"}, nil},
+		{"", nil, ErrMalformedPath},
+		{"a..b", nil, ErrMalformedPath},
+		{"a.[1]", nil, ErrMalformedPath},
+		{"a[1", nil, ErrMalformedPath},
+		{"a[1]b", nil, ErrMalformedPath},
+		{"a]", nil, ErrMalformedPath},
+		{"a[x]", nil, ErrNonIntegerSubkey},
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if err != tt.err || (err == nil && !reflect.DeepEqual(got, tt.expected)) {
+			t.Errorf("parsePath(%q): expected %v, %v, got %v, %v", tt.path, tt.expected, tt.err, got, err)
+		}
+	}
+}
+
+func decodeDocument(t *testing.T, doc string) interface{} {
+	var data interface{}
+	if err := json.Unmarshal([]byte(doc), &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPathOperator_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	po := c.(PathOperator)
+	original := decodeDocument(t, `{"profile":{"name":"ann","addresses":[{"city":"Moscow"},{"city":"Kazan"},{"city":"Omsk"}]}}`)
+	c.Set("user", original, 0)
+	c.Set("string", "text", 0)
+
+	got, err := po.GetPath("user", "profile.addresses[2].city")
+	if err != nil || got != "Omsk" {
+		t.Errorf("GetPath: got %v, err %v", got, err)
+	}
+	if got, _ = po.GetPath("user", "profile.addresses[-1].city"); got != "Omsk" {
+		t.Errorf("GetPath with negative index: got %v", got)
+	}
+
+	errorTests := []struct {
+		key  string
+		path string
+		err  error
+	}{
+		{"user", "profile.addresses[3]", ErrIndexAccess},
+		{"user", "profile.missing", ErrIndexAccess},
+		{"user", "profile[0]", ErrIllegalIndexType},
+		{"user", "profile.addresses.city", ErrIllegalIndexType},
+		{"user", "profile.name.first", ErrIndexAccess},
+		{"string", "a", ErrIndexAccess},
+		{"missing", "a", ErrKeyNotFound},
+	}
+	for _, tt := range errorTests {
+		if _, err = po.GetPath(tt.key, tt.path); err != tt.err {
+			t.Errorf("GetPath(%v, %v): expected %v, got %v", tt.key, tt.path, tt.err, err)
+		}
+	}
+
+	value, err := po.SetPath("user", "profile.addresses[1].city", "Kazan-2")
+	if err != nil || value.Type != MAP {
+		t.Fatalf("SetPath: got %v, err %v", value, err)
+	}
+	if _, err = po.SetPath("user", "profile.age", 30); err != nil {
+		t.Errorf("SetPath of new map field: %v", err)
+	}
+	if _, err = po.SetPath("user", "profile.addresses[3]", 1); err != ErrIndexAccess {
+		t.Errorf("SetPath past the end of list: expected %v, got %v", ErrIndexAccess, err)
+	}
+	if _, err = po.DelPath("user", "profile.addresses[0]"); err != nil {
+		t.Errorf("DelPath of list item: %v", err)
+	}
+	if _, err = po.DelPath("user", "profile.name"); err != nil {
+		t.Errorf("DelPath of map field: %v", err)
+	}
+	if _, err = po.DelPath("user", "profile.name"); err != ErrIndexAccess {
+		t.Errorf("DelPath of missing field: expected %v, got %v", ErrIndexAccess, err)
+	}
+
+	current, _ := c.Get("user")
+	encoded, _ := json.Marshal(current.Data)
+	if string(encoded) != `{"profile":{"addresses":[{"city":"Kazan-2"},{"city":"Omsk"}],"age":30}}` {
+		t.Errorf("unexpected document after path updates: %s", encoded)
+	}
+	encoded, _ = json.Marshal(original)
+	if string(encoded) != `{"profile":{"addresses":[{"city":"Moscow"},{"city":"Kazan"},{"city":"Omsk"}],"name":"ann"}}` {
+		t.Errorf("path updates should not modify the previous value: %s", encoded)
+	}
+}
+
+func TestPathOperator_Persist(t *testing.T) {
+	sample := `{"Type":"Set","k":"doc","v":{"a":[1,2,3]},"e":0}
+{"Type":"PSet","k":"doc","v":"x","e":0,"f":"a[0]"}
+{"Type":"PDel","k":"doc","v":null,"e":0,"f":"a[1]"}
+`
+	restored := checkPersist(t, 1, sample, func(p *persister) {
+		p.Set("doc", map[string]interface{}{"a": []interface{}{1, 2, 3}}, 0)
+		p.SetPath("doc", "a[0]", "x")
+		p.DelPath("doc", "a[1]")
+		p.DelPath("doc", "missing")
+	})
+	value, err := restored.GetPath("doc", "a")
+	if err != nil || !reflect.DeepEqual(value, []interface{}{"x", float64(3)}) {
+		t.Errorf("TestPathOperator_Persist restore: got %v, err %v", value, err)
+	}
+}
@@ -15,11 +15,15 @@ var ErrUnknownOperationType = errors.New("Unknown operation type")
 type persister struct {
 	Cache
 
-	oplog chan operation
+	op    chan operation
 	oplog []operation
 
 	rw io.ReadWriter
 
+	// journaled - изменения записывает sharder под блокировкой шарда. Иначе
+	// persister сам пишет Set и Remove
+	journaled bool
+
 	sync.RWMutex
 }
 
@@ -28,9 +32,39 @@ type operation struct {
 	Key    string      `json:"k"`
 	Value  interface{} `json:"v"`
 	Expire int64       `json:"e"`
+	Field  string      `json:"f,omitempty"`
+	// операции транзакции, записываются и восстанавливаются одной записью
+	Ops []operation `json:"ops,omitempty"`
+
+	// тип значения для Set, если его нельзя однозначно восстановить из JSON
+	DataType DataType `json:"t,omitempty"`
+}
+
+func jsonAmbiguous(t DataType) bool {
+	return t != STRING && t != LIST && t != MAP
 }
 
 func (p *persister) restore(source io.Reader) error {
+	// записи, которые оставляют восстановленные операции, уже есть в журнале.
+	// Операция может оставить любое их число, поэтому они отбрасываются до
+	// конца восстановления
+	done := make(chan struct{})
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for {
+			select {
+			case <-p.op:
+			case <-done:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(done)
+		<-drained
+	}()
+
 	scanner := bufio.NewScanner(source)
 	for scanner.Scan() {
 		if err := scanner.Err(); err != nil {
@@ -43,7 +77,6 @@ func (p *persister) restore(source io.Reader) error {
 			return err
 		}
 
-		go func() { <-p.op }()
 		err = op.execute(p)
 
 		if err != nil && err != ErrInvalidTTL {
@@ -67,9 +100,34 @@ func (o *operation) execute(target Cache) (err error) {
 				o.Expire = o.Expire - nowNano
 			}
 		}
-		_, err = target.Set(o.Key, o.Value, time.Duration(o.Expire))
+		var data interface{}
+		data, err = convertData(o.DataType, o.Value)
+		if err != nil {
+			return
+		}
+		_, err = target.Set(o.Key, data, time.Duration(o.Expire))
 	case "Remove":
 		err = target.Remove(o.Key)
+	case "HSet", "HDel":
+		err = o.executeHash(target)
+	case "LPush", "RPush", "LPop", "RPop", "LSet":
+		err = o.executeList(target)
+	case "SAdd", "SRem":
+		err = o.executeSet(target)
+	case "ZAdd", "ZRem":
+		err = o.executeSortedSet(target)
+	case "PSet", "PDel":
+		err = o.executePath(target)
+	case "PFAdd":
+		err = o.executeHyperLogLog(target)
+	case "XAdd", "XGroupCreate", "XReadGroup", "XAck":
+		err = o.executeStream(target)
+	case "Exec":
+		err = o.executeTx(target)
+	case "Flush":
+		err = executeFlush(target)
+	case "Expire":
+		err = o.executeExpire(target)
 	default:
 		err = ErrUnknownOperationType
 	}
@@ -93,8 +151,8 @@ func (p *persister) writeOplogEvery(frequency time.Duration) {
 }
 
 func (p *persister) grabOplog() []operation {
-	p.RWMutex.RLock()
-	defer p.RWMutex.RUnlock()
+	p.RWMutex.Lock()
+	defer p.RWMutex.Unlock()
 	ops := p.oplog
 	p.oplog = []operation{}
 	return ops
@@ -119,6 +177,10 @@ func newPersister(target Cache, srcDst io.ReadWriter, writeFrequency time.Durati
 		oplog: []operation{},
 		rw:    srcDst,
 	}
+	// до восстановления: его записи отбрасывает restore
+	if target, ok := target.(journaler); ok {
+		p.journaled = target.setJournal(func(op operation) { p.op <- op })
+	}
 
 	if srcDst != nil {
 		err := p.restore(srcDst)
@@ -137,16 +199,28 @@ func newPersister(target Cache, srcDst io.ReadWriter, writeFrequency time.Durati
 
 func (p *persister) Set(key string, value interface{}, expire time.Duration) (*Value, error) {
 	result, err := p.Cache.Set(key, value, expire)
-	if err == nil {
-		p.op <- operation{"Set", key, value, result.Expires}
+	if err == nil && !p.journaled {
+		p.op <- setOperation(key, result)
 	}
 	return result, err
 }
 
+func setOperation(key string, result *Value) operation {
+	op := operation{Type: "Set", Key: key, Value: result.Data, Expire: result.Expires}
+	if jsonAmbiguous(result.Type) {
+		// значение может измениться до записи журнала на диск
+		if encoded, err := json.Marshal(result.Data); err == nil {
+			op.Value = json.RawMessage(encoded)
+		}
+		op.DataType = result.Type
+	}
+	return op
+}
+
 func (p *persister) Remove(key string) error {
 	err := p.Cache.Remove(key)
-	if err == nil {
-		p.op <- operation{"Remove", key, nil, 0}
+	if err == nil && !p.journaled {
+		p.op <- operation{Type: "Remove", Key: key}
 	}
 	return err
 }
@@ -64,3 +64,33 @@ func TestPersister_ReadWrite(t *testing.T) {
 	}
 
 }
+
+// flushOplog сбрасывает журнал p в rw, пока в нем не окажется столько же
+// записей, сколько в expected, но не дольше секунды
+func flushOplog(p *persister, rw *bytes.Buffer, expected string) string {
+	deadline := time.Now().Add(time.Second)
+	for bytes.Count(rw.Bytes(), []byte("\n")) < bytes.Count([]byte(expected), []byte("\n")) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		p.writeOplog(p.grabOplog())
+	}
+	return rw.String()
+}
+
+// checkPersist выполняет ops через persister над шардером из shards шардов,
+// сравнивает журнал с expected и возвращает шардер, восстановленный из
+// журнала
+func checkPersist(t *testing.T, shards int, expected string, ops func(p *persister)) *sharder {
+	t.Helper()
+	s, _ := newSharder(shards, nil)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+	ops(p)
+	if got := flushOplog(p, &rw, expected); got != expected {
+		t.Errorf("%v expected:\n%v\ngot:\n%v", t.Name(), expected, got)
+	}
+	restored, _ := newSharder(shards, nil)
+	rw.Reset()
+	rw.WriteString(expected)
+	newPersister(restored, &rw, time.Hour)
+	return restored
+}
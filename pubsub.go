@@ -0,0 +1,180 @@
+/*
+    Публикация сообщений в каналы и подписка на каналы и шаблоны каналов
+    (PUBLISH, SUBSCRIBE, PSUBSCRIBE)
+*/
+
+package db
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrSlowConsumer = errors.New("subscriber could not keep up with messages and was disconnected")
+	ErrNoChannels   = errors.New("subscription requires at least one channel or pattern")
+)
+
+// DefaultSubscriberBuffer - число сообщений, которые подписчик может не
+// забрать, прежде чем будет отключен
+const DefaultSubscriberBuffer = 64
+
+// Message - сообщение канала. Pattern заполняется, если подписчик получил
+// сообщение по шаблону
+type Message struct {
+	Channel string      `json:"channel"`
+	Pattern string      `json:"pattern,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+// PubSub рассылает сообщения подписчикам. Каналы не связаны с ключами и
+// базами, сообщения не сохраняются: получают их только текущие подписчики.
+// Publish не ждет подписчиков: тот, чей буфер заполнен, отключается с
+// ErrSlowConsumer
+type PubSub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*Subscription]struct{}
+	patterns map[string]map[*Subscription]struct{}
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels: map[string]map[*Subscription]struct{}{},
+		patterns: map[string]map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription получает сообщения из C, пока канал не будет закрыт. После
+// закрытия Err возвращает причину: nil после Close или ErrSlowConsumer
+type Subscription struct {
+	C <-chan Message
+
+	c        chan Message
+	ps       *PubSub
+	channels []string
+	patterns []string
+	closed   bool
+	err      error
+}
+
+// Subscribe подписывается на каналы и шаблоны каналов (синтаксис шаблонов
+// как у KeysMatch). buffer <= 0 означает DefaultSubscriberBuffer
+func (ps *PubSub) Subscribe(channels []string, patterns []string, buffer int) (*Subscription, error) {
+	if len(channels) == 0 && len(patterns) == 0 {
+		return nil, ErrNoChannels
+	}
+	for _, pattern := range patterns {
+		if !validPattern(pattern) {
+			return nil, ErrBadPattern
+		}
+	}
+	if buffer <= 0 {
+		buffer = DefaultSubscriberBuffer
+	}
+	c := make(chan Message, buffer)
+	sub := &Subscription{C: c, c: c, ps: ps, channels: channels, patterns: patterns}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	for _, channel := range channels {
+		addSubscriber(ps.channels, channel, sub)
+	}
+	for _, pattern := range patterns {
+		addSubscriber(ps.patterns, pattern, sub)
+	}
+	return sub, nil
+}
+
+func addSubscriber(index map[string]map[*Subscription]struct{}, name string, sub *Subscription) {
+	subs, ok := index[name]
+	if !ok {
+		subs = map[*Subscription]struct{}{}
+		index[name] = subs
+	}
+	subs[sub] = struct{}{}
+}
+
+func removeSubscriber(index map[string]map[*Subscription]struct{}, name string, sub *Subscription) {
+	delete(index[name], sub)
+	if len(index[name]) == 0 {
+		delete(index, name)
+	}
+}
+
+// Publish рассылает сообщение и возвращает число получивших его подписок.
+// Подписка на канал и подходящий шаблон получает сообщение дважды
+func (ps *PubSub) Publish(channel string, data interface{}) int {
+	received := 0
+	var slow []*Subscription
+	deliver := func(sub *Subscription, m Message) {
+		select {
+		case sub.c <- m:
+			received++
+		default:
+			slow = append(slow, sub)
+		}
+	}
+
+	// каналы закрываются под ps.mu.Lock, поэтому отправка под RLock безопасна
+	ps.mu.RLock()
+	for sub := range ps.channels[channel] {
+		deliver(sub, Message{Channel: channel, Data: data})
+	}
+	for pattern, subs := range ps.patterns {
+		if !matchGlob(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			deliver(sub, Message{Channel: channel, Pattern: pattern, Data: data})
+		}
+	}
+	ps.mu.RUnlock()
+
+	for _, sub := range slow {
+		sub.close(ErrSlowConsumer)
+	}
+	return received
+}
+
+// idle сообщает, что подписчиков нет и публиковать некому
+func (ps *PubSub) idle() bool {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.channels) == 0 && len(ps.patterns) == 0
+}
+
+// NumSub возвращает число подписок на канал без учета шаблонов
+func (ps *PubSub) NumSub(channel string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.channels[channel])
+}
+
+// Close отписывается и закрывает C. Сообщения, оставшиеся в буфере, можно
+// дочитать
+func (s *Subscription) Close() {
+	s.close(nil)
+}
+
+func (s *Subscription) close(err error) {
+	s.ps.mu.Lock()
+	defer s.ps.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed, s.err = true, err
+	for _, channel := range s.channels {
+		removeSubscriber(s.ps.channels, channel, s)
+	}
+	for _, pattern := range s.patterns {
+		removeSubscriber(s.ps.patterns, pattern, s)
+	}
+	close(s.c)
+}
+
+// Err возвращает причину закрытия подписки
+func (s *Subscription) Err() error {
+	s.ps.mu.RLock()
+	defer s.ps.mu.RUnlock()
+	return s.err
+}
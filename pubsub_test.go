@@ -0,0 +1,73 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPubSub_Publish(t *testing.T) {
+	ps := NewPubSub()
+	news, _ := ps.Subscribe([]string{"news"}, nil, 0)
+	all, _ := ps.Subscribe(nil, []string{"n*"}, 0)
+
+	if n := ps.Publish("news", "hello"); n != 2 {
+		t.Errorf("Publish: expected 2 receivers, got %v", n)
+	}
+	if n := ps.Publish("notes", 1); n != 1 {
+		t.Errorf("Publish by pattern: expected 1 receiver, got %v", n)
+	}
+	if n := ps.Publish("other", 1); n != 0 {
+		t.Errorf("Publish without subscribers: expected 0, got %v", n)
+	}
+
+	if m := <-news.C; !reflect.DeepEqual(m, Message{Channel: "news", Data: "hello"}) {
+		t.Errorf("channel subscriber: got %+v", m)
+	}
+	if m := <-all.C; !reflect.DeepEqual(m, Message{Channel: "news", Pattern: "n*", Data: "hello"}) {
+		t.Errorf("pattern subscriber: got %+v", m)
+	}
+	if m := <-all.C; !reflect.DeepEqual(m, Message{Channel: "notes", Pattern: "n*", Data: 1}) {
+		t.Errorf("pattern subscriber: got %+v", m)
+	}
+
+	news.Close()
+	if _, ok := <-news.C; ok || news.Err() != nil {
+		t.Errorf("closed subscription should have closed channel and no error, got %v", news.Err())
+	}
+	if n := ps.NumSub("news"); n != 0 {
+		t.Errorf("NumSub after Close: expected 0, got %v", n)
+	}
+	all.Close()
+	all.Close()
+
+	if _, err := ps.Subscribe(nil, nil, 0); err != ErrNoChannels {
+		t.Errorf("Subscribe without channels: expected %v, got %v", ErrNoChannels, err)
+	}
+	if _, err := ps.Subscribe(nil, []string{"[a"}, 0); err != ErrBadPattern {
+		t.Errorf("Subscribe with bad pattern: expected %v, got %v", ErrBadPattern, err)
+	}
+}
+
+func TestPubSub_SlowConsumer(t *testing.T) {
+	ps := NewPubSub()
+	slow, _ := ps.Subscribe([]string{"c"}, nil, 2)
+	fast, _ := ps.Subscribe([]string{"c"}, nil, 2)
+
+	for i := 0; i < 3; i++ {
+		ps.Publish("c", i)
+		<-fast.C
+	}
+
+	// отключенный подписчик дочитывает буфер и получает причину
+	var got []interface{}
+	for m := range slow.C {
+		got = append(got, m.Data)
+	}
+	if !reflect.DeepEqual(got, []interface{}{0, 1}) || slow.Err() != ErrSlowConsumer {
+		t.Errorf("slow subscriber: got %v, err %v", got, slow.Err())
+	}
+	if n := ps.NumSub("c"); n != 1 {
+		t.Errorf("NumSub after disconnect: fast subscriber should stay, got %v subscribers", n)
+	}
+	fast.Close()
+}
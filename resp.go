@@ -0,0 +1,216 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrProtocol      = errors.New("Protocol error")
+	ErrBulkTooLarge  = errors.New("Protocol error: invalid bulk length")
+	ErrArrayTooLarge = errors.New("Protocol error: invalid multibulk length")
+)
+
+const (
+	maxBulkLen = 512 * 1024 * 1024
+	// maxArrayLen - наибольшее число элементов массива
+	maxArrayLen = 1024 * 1024
+)
+
+// RespError - ошибка, полученная от сервера в виде "-ERR ..."
+type RespError string
+
+func (e RespError) Error() string {
+	return string(e)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return strings.TrimRight(line, "\n"), nil
+	}
+	return line[:len(line)-2], nil
+}
+
+func readBulk(r *bufio.Reader, header string) (*string, error) {
+	n, err := strconv.Atoi(header)
+	if err != nil {
+		return nil, ErrProtocol
+	}
+	if n == -1 {
+		return nil, nil
+	}
+	if n < -1 || n > maxBulkLen {
+		return nil, ErrBulkTooLarge
+	}
+	// буфер растет по мере получения данных, а не по заявленной длине
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, r, int64(n)+2); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	s := string(buf.Bytes()[:n])
+	return &s, nil
+}
+
+// readArrayLen разбирает длину массива. -1 - null-массив
+func readArrayLen(header string) (int, error) {
+	n, err := strconv.Atoi(header)
+	if err != nil {
+		return 0, ErrProtocol
+	}
+	if n < -1 || n > maxArrayLen {
+		return 0, ErrArrayTooLarge
+	}
+	return n, nil
+}
+
+// initialCap ограничивает заранее выделяемую емкость: заявленная длина
+// массива еще не подтверждена данными
+func initialCap(n int) int {
+	if n > 64 {
+		return 64
+	}
+	return n
+}
+
+// readCommand читает команду клиента: массив bulk-строк или inline-команду
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return []string{}, nil
+	}
+	if line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := readArrayLen(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return nil, ErrArrayTooLarge
+	}
+	args := make([]string, 0, initialCap(n))
+	for i := 0; i < n; i++ {
+		header, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(header) == 0 || header[0] != '$' {
+			return nil, ErrProtocol
+		}
+		arg, err := readBulk(r, header[1:])
+		if err != nil {
+			return nil, err
+		}
+		if arg == nil {
+			return nil, ErrProtocol
+		}
+		args = append(args, *arg)
+	}
+	return args, nil
+}
+
+// readReply читает ответ сервера. Возвращает string, int64, []interface{},
+// nil для null-ответов и RespError в качестве ошибки для "-" ответов
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, ErrProtocol
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RespError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		return n, nil
+	case '$':
+		s, err := readBulk(r, line[1:])
+		if err != nil || s == nil {
+			return nil, err
+		}
+		return *s, nil
+	case '*':
+		n, err := readArrayLen(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		result := make([]interface{}, 0, initialCap(n))
+		for i := 0; i < n; i++ {
+			item, err := readReply(r)
+			if _, ok := err.(RespError); err != nil && !ok {
+				return nil, err
+			}
+			result = append(result, item)
+		}
+		return result, nil
+	}
+	return nil, ErrProtocol
+}
+
+type respWriter struct {
+	*bufio.Writer
+}
+
+func newRespWriter(w io.Writer) *respWriter {
+	return &respWriter{bufio.NewWriter(w)}
+}
+
+func (w *respWriter) writeSimple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w *respWriter) writeError(s string) {
+	w.WriteString("-" + strings.Replace(s, "\r\n", " ", -1) + "\r\n")
+}
+
+func (w *respWriter) writeInt(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *respWriter) writeBulk(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w *respWriter) writeNull() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) writeArrayHeader(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w *respWriter) writeStrings(items []string) {
+	w.writeArrayHeader(len(items))
+	for i := range items {
+		w.writeBulk(items[i])
+	}
+}
+
+func (w *respWriter) writeCommand(args ...string) {
+	w.writeStrings(args)
+}
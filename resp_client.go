@@ -0,0 +1,233 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnexpectedReply = errors.New("unexpected reply from server")
+
+// ошибки db, которые клиент восстанавливает из ответов сервера
+var knownErrors = []error{
+	db.ErrKeyNotFound,
+	db.ErrInvalidValueType,
+	db.ErrIndexAccess,
+	db.ErrConversionError,
+	db.ErrIllegalIndexType,
+	db.ErrNonIntegerSubkey,
+	db.ErrInvalidTTL,
+}
+
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *respWriter
+}
+
+// RespClient реализует интерфейс Cache поверх RESP сервера.
+// Соединения переиспользуются через пул
+type RespClient struct {
+	addr     string
+	timeout  time.Duration
+	user     string
+	password string
+
+	pool chan *respConn
+}
+
+// NewRespClient создает клиента к RespServer. poolSize - максимальное
+// число простаивающих соединений, timeout - таймаут в секундах
+func NewRespClient(addr string, poolSize int, timeout int, user string, password string) *RespClient {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	if timeout == 0 {
+		timeout = 15
+	}
+	return &RespClient{
+		addr:     addr,
+		timeout:  time.Duration(timeout) * time.Second,
+		user:     user,
+		password: password,
+		pool:     make(chan *respConn, poolSize),
+	}
+}
+
+func (c *RespClient) dial() (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	rc := &respConn{conn, bufio.NewReader(conn), newRespWriter(conn)}
+	if c.password != "" {
+		if _, err = rc.do(c.timeout, "AUTH", c.user, c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (c *RespClient) get() (*respConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+		return c.dial()
+	}
+}
+
+func (c *RespClient) put(conn *respConn) {
+	select {
+	case c.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// Close закрывает простаивающие соединения пула
+func (c *RespClient) Close() error {
+	for {
+		select {
+		case conn := <-c.pool:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (rc *respConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	rc.SetDeadline(time.Now().Add(timeout))
+	rc.w.writeCommand(args...)
+	if err := rc.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(rc.r)
+}
+
+func (c *RespClient) do(args ...string) (interface{}, error) {
+	conn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(c.timeout, args...)
+	if _, ok := err.(RespError); err != nil && !ok {
+		// после сетевой ошибки состояние соединения неизвестно
+		conn.Close()
+		return nil, err
+	}
+	c.put(conn)
+	if err != nil {
+		return nil, translateError(err.(RespError))
+	}
+	return reply, nil
+}
+
+func translateError(err RespError) error {
+	message := strings.TrimPrefix(string(err), "ERR ")
+	for _, known := range knownErrors {
+		if known.Error() == message {
+			return known
+		}
+	}
+	return err
+}
+
+func decodeValue(reply interface{}) (*db.Value, error) {
+	raw, ok := reply.(string)
+	if !ok {
+		return nil, ErrUnexpectedReply
+	}
+	value := &db.Value{}
+	if err := json.Unmarshal([]byte(raw), value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (c *RespClient) Set(key string, value interface{}, expire time.Duration) (*db.Value, error) {
+	if expire < 0 {
+		return nil, db.ErrInvalidTTL
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"JSON.SET", key, string(encoded)}
+	if expire > 0 {
+		ms := int64(expire / time.Millisecond)
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	reply, err := c.do(args...)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(reply)
+}
+
+func (c *RespClient) Get(key string) (*db.Value, error) {
+	reply, err := c.do("JSON.GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, db.ErrKeyNotFound
+	}
+	return decodeValue(reply)
+}
+
+func (c *RespClient) Remove(key string) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+func (c *RespClient) Keys() ([]string, error) {
+	reply, err := c.do("KEYS")
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, ErrUnexpectedReply
+	}
+	keys := make([]string, len(items))
+	for i := range items {
+		if keys[i], ok = items[i].(string); !ok {
+			return nil, ErrUnexpectedReply
+		}
+	}
+	return keys, nil
+}
+
+func (c *RespClient) GetAtIndex(key string, index interface{}) (interface{}, error) {
+	var subkey string
+	switch idx := index.(type) {
+	case string:
+		subkey = idx
+	case int:
+		subkey = strconv.Itoa(idx)
+	default:
+		return nil, db.ErrIllegalIndexType
+	}
+	reply, err := c.do("JSON.GET", key, subkey)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := reply.(string)
+	if !ok {
+		return nil, ErrUnexpectedReply
+	}
+	var item interface{}
+	err = json.Unmarshal([]byte(raw), &item)
+	return item, err
+}
@@ -0,0 +1,89 @@
+package rest
+
+import (
+	"github.com/shpaktakur1/TestAvito/db"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRespClient_Cache(t *testing.T) {
+	l, _ := startRespServer(t, &BasicAuthorizer{Username: "Alladin", Password: "Open Sesame"})
+	defer l.Close()
+
+	var c db.Cache = NewRespClient(l.Addr().String(), 2, 1, "Alladin", "Open Sesame")
+	defer c.(*RespClient).Close()
+
+	value, err := c.Set("map", map[string]interface{}{"a": 1, "b": []interface{}{"x"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &db.Value{Type: db.MAP, Data: map[string]interface{}{"a": 1.0, "b": []interface{}{"x"}}}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("Set: expected %v, got %v", expected, value)
+	}
+
+	value, err = c.Set("string", "something", time.Minute)
+	if err != nil || value.Expires == 0 {
+		t.Errorf("Set with ttl: got %v, err %v", value, err)
+	}
+	if _, err = c.Set("ill", "something", -1); err != db.ErrInvalidTTL {
+		t.Errorf("Set with negative ttl: expected %v, got %v", db.ErrInvalidTTL, err)
+	}
+
+	value, err = c.Get("map")
+	if err != nil || !reflect.DeepEqual(value, expected) {
+		t.Errorf("Get: expected %v, got %v, err %v", expected, value, err)
+	}
+	if _, err = c.Get("missing"); err != db.ErrKeyNotFound {
+		t.Errorf("Get missing: expected %v, got %v", db.ErrKeyNotFound, err)
+	}
+
+	item, err := c.GetAtIndex("map", "b")
+	if err != nil || !reflect.DeepEqual(item, []interface{}{"x"}) {
+		t.Errorf("GetAtIndex: got %v, err %v", item, err)
+	}
+	if _, err = c.GetAtIndex("map", "c"); err != db.ErrIndexAccess {
+		t.Errorf("GetAtIndex missing: expected %v, got %v", db.ErrIndexAccess, err)
+	}
+
+	keys, err := c.Keys()
+	sort.Strings(keys)
+	if err != nil || !reflect.DeepEqual(keys, []string{"map", "string"}) {
+		t.Errorf("Keys: got %v, err %v", keys, err)
+	}
+
+	if err = c.Remove("map"); err != nil {
+		t.Error(err)
+	}
+	if _, err = c.Get("map"); err != db.ErrKeyNotFound {
+		t.Errorf("Get removed: expected %v, got %v", db.ErrKeyNotFound, err)
+	}
+}
+
+func TestRespClient_Shard(t *testing.T) {
+	l1, _ := startRespServer(t, nil)
+	defer l1.Close()
+	l2, _ := startRespServer(t, nil)
+	defer l2.Close()
+
+	c, err := db.Shard(nil, false, NewRespClient(l1.Addr().String(), 4, 1, "", ""), NewRespClient(l2.Addr().String(), 4, 1, "", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	for i := 0; i < 20; i++ {
+		go func(key string) {
+			c.Set(key, key, 0)
+			done <- true
+		}(string(rune('a' + i)))
+	}
+	for i := 0; i < 20; i++ {
+		<-done
+	}
+	keys, err := c.Keys()
+	if err != nil || len(keys) != 20 {
+		t.Errorf("Keys across shards: got %v, err %v", keys, err)
+	}
+}
@@ -0,0 +1,656 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/shpaktakur1/TestAvito/db"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errRespSyntax   = errors.New("ERR syntax error")
+	errRespNoPubSub = errors.New("ERR pubsub is not enabled")
+)
+
+// RespServer - tcp сервер, совместимый с протоколом Redis (RESP2)
+type RespServer struct {
+	Authorization Authorizer
+	Cache         db.Cache
+	// Databases - базы, доступные через SELECT. Без них доступна только Cache
+	Databases *db.Databases
+	// PubSub - каналы для PUBLISH и SUBSCRIBE. Без них команды недоступны
+	PubSub *db.PubSub
+}
+
+type respSession struct {
+	authorized bool
+	quit       bool
+	// cache - база, выбранная командой SELECT
+	cache db.Cache
+	// r нужен командам, которые сами читают соединение (SUBSCRIBE)
+	r *bufio.Reader
+}
+
+type respHandler func(s *RespServer, session *respSession, w *respWriter, args []string)
+
+type respCommand struct {
+	arity   int // как в redis: отрицательное значение - минимальное число аргументов
+	handler respHandler
+}
+
+var respCommands = map[string]respCommand{
+	"PING":   {-1, respPing},
+	"QUIT":   {1, respQuit},
+	"AUTH":   {-2, respAuth},
+	"GET":    {2, respGet},
+	"SET":    {-3, respSet},
+	"DEL":    {-2, respDel},
+	"KEYS":   {-1, respKeys},
+	"SCAN":   {-2, respScan},
+	"EXPIRE": {3, respExpire},
+
+	"SELECT":  {2, respSelect},
+	"FLUSHDB": {1, respFlushDB},
+
+	"EVAL":    {-3, respEval},
+	"EVALSHA": {-3, respEvalSha},
+	"SCRIPT":  {-2, respScript},
+
+	"PUBLISH":    {3, respPublish},
+	"SUBSCRIBE":  {-2, respSubscribe},
+	"PSUBSCRIBE": {-2, respPSubscribe},
+
+	// типизированный доступ для RespClient: значения передаются в JSON
+	"JSON.SET": {-3, respJSONSet},
+	"JSON.GET": {-2, respJSONGet},
+}
+
+// Запуск сервера по представленному адресу
+func (s *RespServer) Run(addr string) {
+	if s.Cache == nil {
+		log.Fatal("Cannot run RESP server without cache")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(s.Serve(l))
+}
+
+func (s *RespServer) Serve(l net.Listener) error {
+	return serveConnections(l, s.serveConn)
+}
+
+func (s *RespServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := newRespWriter(conn)
+	session := &respSession{authorized: s.Authorization == nil, cache: s.Cache, r: r}
+
+	for !session.quit {
+		args, err := readCommand(r)
+		if err != nil {
+			if err != io.EOF {
+				w.writeError("ERR " + err.Error())
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.execute(session, w, args)
+		if err = w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *RespServer) execute(session *respSession, w *respWriter, args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, ok := respCommands[name]
+	if !ok {
+		w.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+	if !session.authorized && name != "AUTH" && name != "QUIT" {
+		w.writeError("NOAUTH Authentication required.")
+		return
+	}
+	cmd.handler(s, session, w, args[1:])
+}
+
+// formatData приводит значение из кэша к строке: строки и двоичные данные
+// отдаются как есть, остальные типы - в виде JSON
+func formatData(data interface{}) string {
+	switch str := data.(type) {
+	case string:
+		return str
+	case []byte:
+		return string(str)
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Sprint(data)
+	}
+	return string(encoded)
+}
+
+func respPing(s *RespServer, session *respSession, w *respWriter, args []string) {
+	if len(args) > 0 {
+		w.writeBulk(args[0])
+		return
+	}
+	w.writeSimple("PONG")
+}
+
+func respQuit(s *RespServer, session *respSession, w *respWriter, args []string) {
+	session.quit = true
+	w.writeSimple("OK")
+}
+
+func respAuth(s *RespServer, session *respSession, w *respWriter, args []string) {
+	if s.Authorization == nil {
+		w.writeError("ERR Client sent AUTH, but no password is set")
+		return
+	}
+	user, password := "", args[0]
+	if len(args) > 1 {
+		user, password = args[0], args[1]
+	}
+	if !s.Authorization.Authorize(user, password) {
+		session.authorized = false
+		w.writeError("WRONGPASS invalid username-password pair")
+		return
+	}
+	session.authorized = true
+	w.writeSimple("OK")
+}
+
+func respGet(s *RespServer, session *respSession, w *respWriter, args []string) {
+	value, err := session.cache.Get(args[0])
+	if err == db.ErrKeyNotFound {
+		w.writeNull()
+		return
+	}
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeBulk(formatData(value.Data))
+}
+
+// parseExpireOptions разбирает опции вида [EX seconds|PX milliseconds]
+func parseExpireOptions(command string, args []string) (ttl time.Duration, err error) {
+	for i := 0; i < len(args); i++ {
+		var unit time.Duration
+		switch strings.ToUpper(args[i]) {
+		case "EX":
+			unit = time.Second
+		case "PX":
+			unit = time.Millisecond
+		default:
+			return 0, errRespSyntax
+		}
+		if i+1 >= len(args) || ttl != 0 {
+			return 0, errRespSyntax
+		}
+		i++
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("ERR invalid expire time in '%s' command", command)
+		}
+		ttl = time.Duration(n) * unit
+	}
+	return ttl, nil
+}
+
+func respSet(s *RespServer, session *respSession, w *respWriter, args []string) {
+	ttl, err := parseExpireOptions("set", args[2:])
+	if err != nil {
+		w.writeError(err.Error())
+		return
+	}
+	if _, err = session.cache.Set(args[0], args[1], ttl); err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeSimple("OK")
+}
+
+func respDel(s *RespServer, session *respSession, w *respWriter, args []string) {
+	cw, ok := session.cache.(db.ConditionalWriter)
+	if !ok {
+		w.writeError("ERR " + db.ErrUnsupported.Error())
+		return
+	}
+	var removed int64
+	for _, key := range args {
+		// GetDel проверяет и удаляет ключ под одной блокировкой
+		_, err := cw.GetDel(key)
+		if err == db.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		removed++
+	}
+	w.writeInt(removed)
+}
+
+// respKeys понимает шаблоны redis так же, как REST API (см. db.Scanner)
+func respKeys(s *RespServer, session *respSession, w *respWriter, args []string) {
+	sc, ok := session.cache.(db.Scanner)
+	if !ok {
+		w.writeError("ERR " + db.ErrUnsupported.Error())
+		return
+	}
+	pattern := ""
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	keys, err := sc.KeysMatch(pattern)
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeStrings(keys)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+func respScan(s *RespServer, session *respSession, w *respWriter, args []string) {
+	sc, ok := session.cache.(db.Scanner)
+	if !ok {
+		w.writeError("ERR " + db.ErrUnsupported.Error())
+		return
+	}
+	pattern, count := "", 0
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			w.writeError(errRespSyntax.Error())
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			count = n
+		default:
+			w.writeError(errRespSyntax.Error())
+			return
+		}
+	}
+	keys, next, err := sc.Scan(args[0], pattern, count)
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeArrayHeader(2)
+	w.writeBulk(next)
+	w.writeStrings(keys)
+}
+
+func respExpire(s *RespServer, session *respSession, w *respWriter, args []string) {
+	seconds, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		w.writeError("ERR value is not an integer or out of range")
+		return
+	}
+	if seconds > int64(math.MaxInt64/time.Second) {
+		w.writeError("ERR invalid expire time in 'expire' command")
+		return
+	}
+	km, ok := session.cache.(db.KeyManager)
+	if !ok {
+		w.writeError("ERR " + db.ErrUnsupported.Error())
+		return
+	}
+	_, err = km.Expire(args[0], time.Duration(seconds)*time.Second)
+	if err == db.ErrKeyNotFound {
+		w.writeInt(0)
+		return
+	}
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeInt(1)
+}
+
+func respSelect(s *RespServer, session *respSession, w *respWriter, args []string) {
+	cache, err := selectDatabase(s.Databases, s.Cache, args[0])
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	session.cache = cache
+	w.writeSimple("OK")
+}
+
+func respFlushDB(s *RespServer, session *respSession, w *respWriter, args []string) {
+	f, ok := session.cache.(db.Flusher)
+	if !ok {
+		w.writeError("ERR " + db.ErrUnsupported.Error())
+		return
+	}
+	if err := f.FlushDB(); err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeSimple("OK")
+}
+
+func respEval(s *RespServer, session *respSession, w *respWriter, args []string) {
+	runScript(session, w, args, db.Scripter.Eval)
+}
+
+func respEvalSha(s *RespServer, session *respSession, w *respWriter, args []string) {
+	runScript(session, w, args, db.Scripter.EvalSha)
+}
+
+// runScript разбирает аргументы EVAL и EVALSHA: script (или sha) numkeys
+// key... arg...
+func runScript(session *respSession, w *respWriter, args []string,
+	eval func(sc db.Scripter, script string, keys []string, args []string) (interface{}, error)) {
+	sc, ok := session.cache.(db.Scripter)
+	if !ok {
+		w.writeError("ERR " + db.ErrUnsupported.Error())
+		return
+	}
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys < 0 {
+		w.writeError("ERR value is not an integer or out of range")
+		return
+	}
+	if numKeys > len(args)-2 {
+		w.writeError("ERR Number of keys can't be greater than number of args")
+		return
+	}
+	result, err := eval(sc, args[0], args[2:2+numKeys], args[2+numKeys:])
+	if err == db.ErrNoScript {
+		w.writeError("NOSCRIPT No matching script. Please use EVAL.")
+		return
+	}
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	writeScriptReply(w, result)
+}
+
+// writeScriptReply переводит результат скрипта в ответ RESP по правилам
+// redis: true - 1, false - null, таблица {err=...} - ошибка, {ok=...} -
+// простая строка. Прочие таблицы с полями передаются в JSON
+func writeScriptReply(w *respWriter, result interface{}) {
+	switch r := result.(type) {
+	case nil:
+		w.writeNull()
+	case bool:
+		if r {
+			w.writeInt(1)
+		} else {
+			w.writeNull()
+		}
+	case int64:
+		w.writeInt(r)
+	case []interface{}:
+		w.writeArrayHeader(len(r))
+		for _, item := range r {
+			writeScriptReply(w, item)
+		}
+	case map[string]interface{}:
+		if msg, ok := r["err"].(string); ok {
+			w.writeError(msg)
+		} else if status, ok := r["ok"].(string); ok {
+			w.writeSimple(status)
+		} else {
+			w.writeBulk(formatData(r))
+		}
+	default:
+		w.writeBulk(formatData(r))
+	}
+}
+
+// respScript выполняет SCRIPT LOAD script, SCRIPT EXISTS sha... и SCRIPT FLUSH
+func respScript(s *RespServer, session *respSession, w *respWriter, args []string) {
+	sc, ok := session.cache.(db.Scripter)
+	if !ok {
+		w.writeError("ERR " + db.ErrUnsupported.Error())
+		return
+	}
+	switch strings.ToUpper(args[0]) {
+	case "LOAD":
+		if len(args) != 2 {
+			w.writeError("ERR wrong number of arguments for 'script|load' command")
+			return
+		}
+		sha, err := sc.ScriptLoad(args[1])
+		if err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeBulk(sha)
+	case "EXISTS":
+		exists, err := sc.ScriptExists(args[1:]...)
+		if err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeArrayHeader(len(exists))
+		for _, ok := range exists {
+			if ok {
+				w.writeInt(1)
+			} else {
+				w.writeInt(0)
+			}
+		}
+	case "FLUSH":
+		if err := sc.ScriptFlush(); err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeSimple("OK")
+	default:
+		w.writeError("ERR unknown subcommand '" + args[0] + "'")
+	}
+}
+
+func respPublish(s *RespServer, session *respSession, w *respWriter, args []string) {
+	if s.PubSub == nil {
+		w.writeError(errRespNoPubSub.Error())
+		return
+	}
+	w.writeInt(int64(s.PubSub.Publish(args[0], args[1])))
+}
+
+func respSubscribe(s *RespServer, session *respSession, w *respWriter, args []string) {
+	subscribe(s, session, w, args, nil)
+}
+
+func respPSubscribe(s *RespServer, session *respSession, w *respWriter, args []string) {
+	subscribe(s, session, w, nil, args)
+}
+
+type respRead struct {
+	args []string
+	err  error
+}
+
+// subscribe переводит соединение в режим подписки: сервер передает сообщения,
+// пока клиент не отпишется или не закроет соединение. В этом режиме
+// принимаются только UNSUBSCRIBE, PUNSUBSCRIBE (завершают всю подписку), PING
+// и QUIT. Если клиент не успевает читать, соединение закрывается
+func subscribe(s *RespServer, session *respSession, w *respWriter, channels []string, patterns []string) {
+	if s.PubSub == nil {
+		w.writeError(errRespNoPubSub.Error())
+		return
+	}
+	sub, err := s.PubSub.Subscribe(channels, patterns, 0)
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	defer sub.Close()
+	count := 0
+	for _, channel := range channels {
+		count++
+		writeSubscribeReply(w, "subscribe", channel, count)
+	}
+	for _, pattern := range patterns {
+		count++
+		writeSubscribeReply(w, "psubscribe", pattern, count)
+	}
+	if err = w.Flush(); err != nil {
+		session.quit = true
+		return
+	}
+
+	// команды читаются по одной: следующая - только после ответа на
+	// предыдущую, чтобы после выхода из режима соединение читал serveConn
+	commands := make(chan respRead)
+	next := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			args, err := readCommand(session.r)
+			select {
+			case commands <- respRead{args, err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+			select {
+			case <-next:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case m, ok := <-sub.C:
+			if !ok {
+				if err := sub.Err(); err != nil {
+					w.writeError("ERR " + err.Error())
+					w.Flush()
+				}
+				session.quit = true
+				return
+			}
+			if m.Pattern != "" {
+				w.writeStrings([]string{"pmessage", m.Pattern, m.Channel, formatData(m.Data)})
+			} else {
+				w.writeStrings([]string{"message", m.Channel, formatData(m.Data)})
+			}
+		case cmd := <-commands:
+			if cmd.err != nil {
+				session.quit = true
+				return
+			}
+			if len(cmd.args) > 0 {
+				switch strings.ToUpper(cmd.args[0]) {
+				case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+					for _, channel := range channels {
+						count--
+						writeSubscribeReply(w, "unsubscribe", channel, count)
+					}
+					for _, pattern := range patterns {
+						count--
+						writeSubscribeReply(w, "punsubscribe", pattern, count)
+					}
+					return
+				case "PING":
+					w.writeStrings([]string{"pong", strings.Join(cmd.args[1:], " ")})
+				case "QUIT":
+					w.writeSimple("OK")
+					session.quit = true
+					return
+				default:
+					w.writeError(fmt.Sprintf("ERR Can't execute '%s': only (P)UNSUBSCRIBE / PING / QUIT are allowed in this context",
+						strings.ToLower(cmd.args[0])))
+				}
+			}
+			next <- struct{}{}
+		}
+		if err = w.Flush(); err != nil {
+			session.quit = true
+			return
+		}
+	}
+}
+
+func writeSubscribeReply(w *respWriter, kind string, name string, count int) {
+	w.writeArrayHeader(3)
+	w.writeBulk(kind)
+	w.writeBulk(name)
+	w.writeInt(int64(count))
+}
+
+func respJSONSet(s *RespServer, session *respSession, w *respWriter, args []string) {
+	ttl, err := parseExpireOptions("json.set", args[2:])
+	if err != nil {
+		w.writeError(err.Error())
+		return
+	}
+	data, err := decodeJSONBody(strings.NewReader(args[1]))
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	value, err := session.cache.Set(args[0], data, ttl)
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeBulk(formatData(value))
+}
+
+func respJSONGet(s *RespServer, session *respSession, w *respWriter, args []string) {
+	if len(args) > 2 {
+		w.writeError("ERR wrong number of arguments for 'json.get' command")
+		return
+	}
+	if len(args) == 2 {
+		item, err := session.cache.GetAtIndex(args[0], args[1])
+		if err != nil {
+			w.writeError("ERR " + err.Error())
+			return
+		}
+		w.writeBulk(formatData(item))
+		return
+	}
+
+	value, err := session.cache.Get(args[0])
+	if err == db.ErrKeyNotFound {
+		w.writeNull()
+		return
+	}
+	if err != nil {
+		w.writeError("ERR " + err.Error())
+		return
+	}
+	w.writeBulk(formatData(value))
+}
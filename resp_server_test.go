@@ -0,0 +1,223 @@
+package rest
+
+import (
+	"bufio"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func startRespServer(t *testing.T, authorizer Authorizer) (net.Listener, db.Cache) {
+	c, err := db.NewCache(0, nil, nil, 500, 2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RespServer{Authorization: authorizer, Cache: c}
+	go s.Serve(l)
+	return l, c
+}
+
+type respTest struct {
+	name     string
+	command  []string
+	expected interface{}
+}
+
+func runRespTests(t *testing.T, addr string, tests []respTest) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := newRespWriter(conn)
+
+	for _, tt := range tests {
+		w.writeCommand(tt.command...)
+		w.Flush()
+		got, err := readReply(r)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%v: expected %#v, got %#v", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestRespServer_commands(t *testing.T) {
+	l, c := startRespServer(t, nil)
+	defer l.Close()
+	c.Set("list", []interface{}{1, "abc"}, 0)
+	c.Set("dir/file", "x", 0)
+
+	runRespTests(t, l.Addr().String(), []respTest{
+		{"Ping", []string{"PING"}, "PONG"},
+		{"Ping with message", []string{"ping", "hello"}, "hello"},
+		{"Get missing key", []string{"GET", "missing"}, nil},
+		{"Set", []string{"SET", "string", "something"}, "OK"},
+		{"Get string", []string{"GET", "string"}, "something"},
+		{"Get list as json", []string{"GET", "list"}, `[1,"abc"]`},
+		{"Set with EX", []string{"SET", "ttl", "v", "EX", "10"}, "OK"},
+		{"Set with bad PX", []string{"SET", "ttl", "v", "PX", "-1"}, RespError("ERR invalid expire time in 'set' command")},
+		{"Set with bad option", []string{"SET", "ttl", "v", "ZZ"}, RespError("ERR syntax error")},
+		{"Keys by pattern", []string{"KEYS", "str*"}, []interface{}{"string"}},
+		{"Keys across slash", []string{"KEYS", "dir*"}, []interface{}{"dir/file"}},
+		{"Keys bad pattern", []string{"KEYS", "[a"}, RespError("ERR syntax error in pattern")},
+		{"Scan", []string{"SCAN", "0", "MATCH", "str*", "COUNT", "5"}, []interface{}{"0", []interface{}{"string"}}},
+		{"Scan bad option", []string{"SCAN", "0", "MATCH"}, RespError("ERR syntax error")},
+		{"Expire missing", []string{"EXPIRE", "missing", "10"}, int64(0)},
+		{"Expire existing", []string{"EXPIRE", "string", "10"}, int64(1)},
+		{"Del", []string{"DEL", "string", "missing", "list", "dir/file"}, int64(3)},
+		{"Get removed key", []string{"GET", "string"}, nil},
+		{"Wrong arity", []string{"GET"}, RespError("ERR wrong number of arguments for 'get' command")},
+		{"Unknown command", []string{"FOO"}, RespError("ERR unknown command 'FOO'")},
+		{"Quit", []string{"QUIT"}, "OK"},
+	})
+}
+
+func TestRespServer_authorization(t *testing.T) {
+	l, _ := startRespServer(t, &BasicAuthorizer{Username: "Alladin", Password: "Open Sesame"})
+	defer l.Close()
+
+	runRespTests(t, l.Addr().String(), []respTest{
+		{"NoAuth", []string{"GET", "key"}, RespError("NOAUTH Authentication required.")},
+		{"WrongAuth", []string{"AUTH", "admin", "admin"}, RespError("WRONGPASS invalid username-password pair")},
+		{"CorrectAuth", []string{"AUTH", "Alladin", "Open Sesame"}, "OK"},
+		{"Get after auth", []string{"GET", "key"}, nil},
+	})
+}
+
+func TestRespServer_select(t *testing.T) {
+	dbs := db.NewDatabases(db.NumberedDatabases(2, 0, nil, nil, 0, 2, nil))
+	c, _ := dbs.DB(db.DefaultDB)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &RespServer{Cache: c, Databases: dbs}
+	go s.Serve(l)
+
+	runRespTests(t, l.Addr().String(), []respTest{
+		{"Set in db 0", []string{"SET", "a", "zero"}, "OK"},
+		{"Select db 1", []string{"SELECT", "1"}, "OK"},
+		{"Get from empty db", []string{"GET", "a"}, nil},
+		{"Set in db 1", []string{"SET", "a", "one"}, "OK"},
+		{"Select unknown db", []string{"SELECT", "2"}, RespError("ERR database does not exist")},
+		{"Flush db 1", []string{"FLUSHDB"}, "OK"},
+		{"Keys after flush", []string{"KEYS"}, []interface{}{}},
+		{"Select db 0", []string{"SELECT", "0"}, "OK"},
+		{"Db 0 is untouched", []string{"GET", "a"}, "zero"},
+	})
+}
+
+func TestRespServer_script(t *testing.T) {
+	l, c := startRespServer(t, nil)
+	defer l.Close()
+	c.Set("n", 1, 0)
+
+	script := "local n = redis.call('INCR', KEYS[1]) return {n, ARGV[1], n > 1, n > 5}"
+	sha := "99819b24768c30d1df0551e54ff03682af077e1d"
+	runRespTests(t, l.Addr().String(), []respTest{
+		{"Eval", []string{"EVAL", script, "1", "n", "a"}, []interface{}{int64(2), "a", int64(1), nil}},
+		{"Eval pcall error", []string{"EVAL", "return redis.pcall('GET', 'x')", "0"}, RespError("script accessed a key not declared in keys")},
+		{"Eval status", []string{"EVAL", "return {ok = 'DONE'}", "0"}, "DONE"},
+		{"Eval bad numkeys", []string{"EVAL", "return 1", "2", "k"}, RespError("ERR Number of keys can't be greater than number of args")},
+		{"Eval error", []string{"EVAL", "return x.y", "0"}, RespError("ERR script error at line 1: attempt to index a nil value")},
+		{"Script load", []string{"SCRIPT", "LOAD", script}, sha},
+		{"EvalSha", []string{"EVALSHA", sha, "1", "n", "b"}, []interface{}{int64(3), "b", int64(1), nil}},
+		{"Script exists", []string{"SCRIPT", "EXISTS", sha, "00"}, []interface{}{int64(1), int64(0)}},
+		{"Script flush", []string{"script", "flush"}, "OK"},
+		{"EvalSha flushed", []string{"EVALSHA", sha, "0"}, RespError("NOSCRIPT No matching script. Please use EVAL.")},
+	})
+}
+
+func TestRespServer_malformed(t *testing.T) {
+	l, _ := startRespServer(t, nil)
+	defer l.Close()
+
+	for _, probe := range []string{"*-1\r\n", "*2147483647\r\n", "*1\r\n$-5\r\n", "*1\r\n$2147483647\r\nabc"} {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(probe))
+		conn.(*net.TCPConn).CloseWrite()
+		_, err = readReply(bufio.NewReader(conn))
+		conn.Close()
+		if _, ok := err.(RespError); !ok {
+			t.Errorf("%q: expected protocol error, got %v", probe, err)
+		}
+	}
+	// сервер продолжает работать
+	runRespTests(t, l.Addr().String(), []respTest{
+		{"Ping", []string{"PING"}, "PONG"},
+	})
+}
+
+func TestRespServer_pubsub(t *testing.T) {
+	c, _ := db.NewCache(0, nil, nil, 500, 2, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &RespServer{Cache: c, PubSub: db.NewPubSub()}
+	go s.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := newRespWriter(conn)
+	expectReply := func(name string, expected interface{}) {
+		got, err := readReply(r)
+		if err != nil {
+			got = err
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%v: expected %#v, got %#v", name, expected, got)
+		}
+	}
+
+	w.writeCommand("SUBSCRIBE", "news", "sport")
+	w.Flush()
+	expectReply("Subscribe news", []interface{}{"subscribe", "news", int64(1)})
+	expectReply("Subscribe sport", []interface{}{"subscribe", "sport", int64(2)})
+	runRespTests(t, l.Addr().String(), []respTest{
+		{"Publish", []string{"PUBLISH", "news", "hi"}, int64(1)},
+		{"Publish without subscribers", []string{"PUBLISH", "other", "hi"}, int64(0)},
+	})
+	expectReply("Message", []interface{}{"message", "news", "hi"})
+
+	w.writeCommand("PING")
+	w.writeCommand("GET", "a")
+	w.writeCommand("UNSUBSCRIBE")
+	w.writeCommand("PSUBSCRIBE", "n*")
+	w.Flush()
+	expectReply("Ping in subscribe mode", []interface{}{"pong", ""})
+	expectReply("Get in subscribe mode", RespError("ERR Can't execute 'get': only (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"))
+	expectReply("Unsubscribe news", []interface{}{"unsubscribe", "news", int64(1)})
+	expectReply("Unsubscribe sport", []interface{}{"unsubscribe", "sport", int64(0)})
+	expectReply("Psubscribe", []interface{}{"psubscribe", "n*", int64(1)})
+	s.PubSub.Publish("news", map[string]interface{}{"x": 1})
+	expectReply("Pattern message", []interface{}{"pmessage", "n*", "news", `{"x":1}`})
+
+	w.writeCommand("PUNSUBSCRIBE")
+	w.writeCommand("GET", "a")
+	w.Flush()
+	expectReply("Punsubscribe", []interface{}{"punsubscribe", "n*", int64(0)})
+	expectReply("Get after unsubscribe", nil)
+
+	runRespTests(t, l.Addr().String(), []respTest{
+		{"Subscribe without channels", []string{"SUBSCRIBE"}, RespError("ERR wrong number of arguments for 'subscribe' command")},
+	})
+}
@@ -0,0 +1,422 @@
+/*
+    Поиск ключей по glob-шаблону и постраничный обход ключей (SCAN)
+*/
+
+package db
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	ErrBadPattern    = errors.New("syntax error in pattern")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+const (
+	// ScanStart - курсор начала и конца обхода
+	ScanStart        = "0"
+	defaultScanCount = 10
+	// scanWork - сколько ключей шарда SCAN просматривает на каждый
+	// запрошенный ключ страницы
+	scanWork = 10
+)
+
+// Scanner перечисляет ключи, не собирая их все в одну выборку.
+// Шаблон поддерживает *, ?, [abc], [^abc], [a-z] и экранирование \.
+// Scan обходит шарды по очереди и внутри шарда возвращает ключи по
+// возрастанию, поэтому ключи, существовавшие на протяжении всего обхода,
+// возвращаются ровно один раз. Страница продолжает обход с курсора и может
+// содержать меньше count ключей, даже если обход не закончен
+type Scanner interface {
+	KeysMatch(pattern string) ([]string, error)
+	Scan(cursor string, pattern string, count int) (keys []string, next string, err error)
+}
+
+func asScanner(c Cache) (Scanner, error) {
+	sc, ok := c.(Scanner)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return sc, nil
+}
+
+// shardScanner реализуется хранилищами, которые умеют перебирать ключи
+// без копирования
+type shardScanner interface {
+	forEachKey(fn func(key string))
+}
+
+func (s *store) forEachKey(fn func(key string)) {
+	for key := range s.items {
+		if s.lookup(key) != nil {
+			fn(key)
+		}
+	}
+}
+
+func forEachShardKey(shard Cache, fn func(key string)) error {
+	if sc, ok := shard.(shardScanner); ok {
+		sc.forEachKey(fn)
+		return nil
+	}
+	keys, err := shard.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		fn(key)
+	}
+	return nil
+}
+
+// validPattern проверяет, что скобки в шаблоне закрыты
+func validPattern(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return false
+			}
+			i += end + 1
+		}
+	}
+	return true
+}
+
+// matchGlob сопоставляет строку с шаблоном в стиле Redis без рекурсии: при
+// несовпадении последняя * поглощает еще один символ строки, поэтому
+// сопоставление занимает O(len(pattern)*len(s))
+func matchGlob(pattern, s string) bool {
+	// star - позиция в шаблоне после последней *, starAt - позиция в строке,
+	// с которой ее сопоставление продолжится
+	star, starAt := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, starAt = p+1, i
+				p++
+				continue
+			case '?':
+				_, size := utf8.DecodeRuneInString(s[i:])
+				p, i = p+1, i+size
+				continue
+			case '[':
+				end := p + 1 + strings.IndexByte(pattern[p+1:], ']')
+				r, size := utf8.DecodeRuneInString(s[i:])
+				if matchClass(pattern[p+1:end], r) {
+					p, i = end+1, i+size
+					continue
+				}
+			default:
+				c, next := pattern[p], p+1
+				if c == '\\' && next < len(pattern) {
+					c, next = pattern[next], next+1
+				}
+				if s[i] == c {
+					p, i = next, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		_, size := utf8.DecodeRuneInString(s[starAt:])
+		starAt += size
+		p, i = star, starAt
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func matchClass(class string, r rune) bool {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for len(class) > 0 {
+		lo, size := utf8.DecodeRuneInString(class)
+		class = class[size:]
+		hi := lo
+		if len(class) > 1 && class[0] == '-' {
+			hi, size = utf8.DecodeRuneInString(class[1:])
+			class = class[1+size:]
+		}
+		if lo <= r && r <= hi {
+			matched = true
+		}
+	}
+	return matched != negate
+}
+
+// keyHeap - max-heap, хранящий count наименьших ключей страницы
+type keyHeap []string
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h keyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	key := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return key
+}
+
+// orderedScanner реализуется хранилищами, которые хранят ключи по
+// возрастанию: обход продолжается сразу после курсора, не просматривая шард
+// с начала
+type orderedScanner interface {
+	scanFrom(after string, fn func(key string) bool)
+}
+
+// scanFrom передает fn ключи больше after по возрастанию, пока fn не вернет
+// false
+func (s *store) scanFrom(after string, fn func(key string) bool) {
+	for x := s.order.firstAfter(0, after); x != nil; x = x.level[0].forward {
+		if s.lookup(x.member) != nil && !fn(x.member) {
+			return
+		}
+	}
+}
+
+// scanShard возвращает до count ключей шарда больше after, подходящих под
+// шаблон, последний просмотренный ключ и признак того, что в шарде остались
+// ключи. Упорядоченный шард просматривает не больше count*scanWork ключей,
+// поэтому страница редкого шаблона может оказаться неполной
+func scanShard(shard Cache, after string, pattern string, count int) ([]string, string, bool, error) {
+	sc, ok := shard.(orderedScanner)
+	if !ok {
+		page, more, err := scanShardKeys(shard, after, pattern, count)
+		if len(page) > 0 {
+			after = page[len(page)-1]
+		}
+		return page, after, more, err
+	}
+	page := []string{}
+	last, more, budget := after, false, count*scanWork
+	sc.scanFrom(after, func(key string) bool {
+		if len(page) == count || budget == 0 {
+			more = true
+			return false
+		}
+		budget--
+		last = key
+		if matchGlob(pattern, key) {
+			page = append(page, key)
+		}
+		return true
+	})
+	return page, last, more, nil
+}
+
+// scanShardKeys перебирает все ключи шарда и возвращает до count
+// наименьших ключей больше after, подходящих под шаблон
+func scanShardKeys(shard Cache, after string, pattern string, count int) ([]string, bool, error) {
+	h := &keyHeap{}
+	more := false
+	err := forEachShardKey(shard, func(key string) {
+		if key <= after || !matchGlob(pattern, key) {
+			return
+		}
+		if h.Len() < count {
+			heap.Push(h, key)
+			return
+		}
+		more = true
+		if key < (*h)[0] {
+			(*h)[0] = key
+			heap.Fix(h, 0)
+		}
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	page := make([]string, h.Len())
+	for i := len(page) - 1; i >= 0; i-- {
+		page[i] = heap.Pop(h).(string)
+	}
+	return page, more, nil
+}
+
+// курсор содержит номер шарда и последний возвращенный ключ
+func encodeCursor(shard int, after string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(shard) + ":" + after))
+}
+
+func decodeCursor(cursor string) (shard int, after string, err error) {
+	if cursor == "" || cursor == ScanStart {
+		return 0, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return 0, "", ErrInvalidCursor
+	}
+	if shard, err = strconv.Atoi(parts[0]); err != nil || shard < 0 {
+		return 0, "", ErrInvalidCursor
+	}
+	return shard, parts[1], nil
+}
+
+// normalizePattern заменяет пустой шаблон на *
+func normalizePattern(pattern string) (string, error) {
+	if pattern == "" {
+		return "*", nil
+	}
+	if !validPattern(pattern) {
+		return "", ErrBadPattern
+	}
+	return pattern, nil
+}
+
+func (s *sharder) KeysMatch(pattern string) ([]string, error) {
+	pattern, err := normalizePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+	var mu sync.Mutex
+	result := []string{}
+	errorCh := make(chan error, len(s.shards))
+	var wg sync.WaitGroup
+	for idx := range s.shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if s.needLock {
+				s.locks[i].RLock()
+				defer s.locks[i].RUnlock()
+			}
+			matched := []string{}
+			errorCh <- forEachShardKey(s.shards[i], func(key string) {
+				if matchGlob(pattern, key) {
+					matched = append(matched, key)
+				}
+			})
+			mu.Lock()
+			result = append(result, matched...)
+			mu.Unlock()
+		}(idx)
+	}
+	wg.Wait()
+	close(errorCh)
+	for err := range errorCh {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (s *sharder) Scan(cursor string, pattern string, count int) ([]string, string, error) {
+	pattern, err := normalizePattern(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	shard, after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if shard >= len(s.shards) {
+		return nil, "", ErrInvalidCursor
+	}
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	keys := []string{}
+	for shard < len(s.shards) {
+		if s.needLock {
+			s.locks[shard].RLock()
+		}
+		page, last, more, err := scanShard(s.shards[shard], after, pattern, count-len(keys))
+		if s.needLock {
+			s.locks[shard].RUnlock()
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, page...)
+		if more {
+			return keys, encodeCursor(shard, last), nil
+		}
+		shard, after = shard+1, ""
+		if len(keys) == count && shard < len(s.shards) {
+			return keys, encodeCursor(shard, ""), nil
+		}
+	}
+	return keys, ScanStart, nil
+}
+
+func (l *logger) KeysMatch(pattern string) ([]string, error) {
+	defer l.peekIntoPanic("keys", pattern)
+	sc, err := asScanner(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := sc.KeysMatch(pattern)
+	l.infoLog.Println("keys", pattern, "=>", len(result), err)
+	return result, err
+}
+
+func (l *logger) Scan(cursor string, pattern string, count int) ([]string, string, error) {
+	defer l.peekIntoPanic("scan", cursor, pattern, count)
+	sc, err := asScanner(l.Cache)
+	if err != nil {
+		return nil, "", err
+	}
+	keys, next, err := sc.Scan(cursor, pattern, count)
+	l.infoLog.Println("scan", cursor, pattern, count, "=>", len(keys), next, err)
+	return keys, next, err
+}
+
+func (p *persister) KeysMatch(pattern string) ([]string, error) {
+	sc, err := asScanner(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return sc.KeysMatch(pattern)
+}
+
+func (p *persister) Scan(cursor string, pattern string, count int) ([]string, string, error) {
+	sc, err := asScanner(p.Cache)
+	if err != nil {
+		return nil, "", err
+	}
+	return sc.Scan(cursor, pattern, count)
+}
+
+func (t *ttl) KeysMatch(pattern string) ([]string, error) {
+	sc, err := asScanner(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return sc.KeysMatch(pattern)
+}
+
+func (t *ttl) Scan(cursor string, pattern string, count int) ([]string, string, error) {
+	sc, err := asScanner(t.Cache)
+	if err != nil {
+		return nil, "", err
+	}
+	return sc.Scan(cursor, pattern, count)
+}
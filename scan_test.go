@@ -0,0 +1,146 @@
+package db
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "users:42", false},
+		{"user:*:name", "user:a/b:name", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "héllo", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "a-b-b-c", true},
+		{"a*b*c", "a-b-b-", false},
+		{"*?", "é", true},
+		{"*[é]*", "xéy", true},
+		{`*\*`, "a*", true},
+		{"**a", "ba", true},
+		// рекурсивный перебор здесь экспоненциален
+		{"*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 64), false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.key); got != tt.match {
+			t.Errorf("matchGlob(%q, %q): expected %v, got %v", tt.pattern, tt.key, tt.match, got)
+		}
+	}
+	if validPattern("user:[a") {
+		t.Errorf("unterminated class should be invalid")
+	}
+}
+
+func TestScanner_KeysMatch(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 3, nil)
+	sc := c.(Scanner)
+	for _, key := range []string{"user:1", "user:2", "order:1", "user"} {
+		c.Set(key, 1, 0)
+	}
+
+	keys, err := sc.KeysMatch("user:*")
+	sort.Strings(keys)
+	if err != nil || len(keys) != 2 || keys[0] != "user:1" || keys[1] != "user:2" {
+		t.Errorf("KeysMatch: got %v, err %v", keys, err)
+	}
+	if keys, _ = sc.KeysMatch(""); len(keys) != 4 {
+		t.Errorf("KeysMatch with empty pattern should return every key, got %v", keys)
+	}
+	if _, err = sc.KeysMatch("user:[1"); err != ErrBadPattern {
+		t.Errorf("KeysMatch with bad pattern: expected %v, got %v", ErrBadPattern, err)
+	}
+}
+
+func TestScanner_Scan(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	sc := c.(Scanner)
+	expected := map[string]bool{}
+	for i := 0; i < 250; i++ {
+		key := "user:" + strconv.Itoa(i)
+		c.Set(key, i, 0)
+		expected[key] = true
+		c.Set("order:"+strconv.Itoa(i), i, 0)
+	}
+
+	seen := map[string]bool{}
+	cursor := ScanStart
+	pages := 0
+	for {
+		keys, next, err := sc.Scan(cursor, "user:*", 30)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) > 30 {
+			t.Errorf("Scan returned %v keys, more than count", len(keys))
+		}
+		for _, key := range keys {
+			if seen[key] {
+				t.Errorf("Scan returned %v twice", key)
+			}
+			seen[key] = true
+		}
+		pages++
+		if next == ScanStart {
+			break
+		}
+		if pages > 100 {
+			t.Fatal("Scan does not terminate")
+		}
+		cursor = next
+	}
+	if len(seen) != len(expected) {
+		t.Errorf("Scan: expected %v keys, got %v", len(expected), len(seen))
+	}
+	for key := range seen {
+		if !expected[key] {
+			t.Errorf("Scan returned unexpected key %v", key)
+		}
+	}
+
+	// ключ, добавленный во время обхода перед курсором, не сдвигает
+	// обход: остальные ключи возвращаются ровно один раз
+	keys, next, _ := sc.Scan(ScanStart, "", 5)
+	c.Set("a", 1, 0)
+	seen = map[string]bool{}
+	for _, key := range keys {
+		seen[key] = true
+	}
+	for next != ScanStart {
+		if keys, next, _ = sc.Scan(next, "", 5); len(keys) > 5 {
+			t.Errorf("Scan returned %v keys, more than count", len(keys))
+		}
+		for _, key := range keys {
+			if seen[key] && key != "a" {
+				t.Errorf("Scan returned %v twice", key)
+			}
+			seen[key] = true
+		}
+	}
+	if len(seen) < 500 {
+		t.Errorf("Scan with concurrent insert: expected at least 500 keys, got %v", len(seen))
+	}
+
+	if _, _, err := sc.Scan("garbage", "", 10); err != ErrInvalidCursor {
+		t.Errorf("Scan with bad cursor: expected %v, got %v", ErrInvalidCursor, err)
+	}
+	if _, _, err := sc.Scan(encodeCursor(10, ""), "", 10); err != ErrInvalidCursor {
+		t.Errorf("Scan with cursor past the last shard: expected %v, got %v", ErrInvalidCursor, err)
+	}
+}
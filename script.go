@@ -0,0 +1,785 @@
+/*
+    Серверные скрипты (EVAL). Скрипт выполняется под блокировками шардов
+    объявленных ключей и может обращаться только к ним. Записи скрипта
+    накапливаются в промежуточном слое над шардами и применяются, только
+    если скрипт завершился без ошибки: прерванный по ошибке или по времени
+    скрипт ничего не меняет
+*/
+
+package db
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoScript      = errors.New("no script with given sha1, load it first")
+	ErrUndeclaredKey = errors.New("script accessed a key not declared in keys")
+)
+
+// ScriptTimeLimit - наибольшее время выполнения скрипта. Все это время
+// шарды объявленных ключей заблокированы
+var ScriptTimeLimit = 5 * time.Second
+
+// Scripter выполняет скрипты на подмножестве Lua. Скрипту доступны таблицы
+// KEYS и ARGV, функции redis.call и redis.pcall, tonumber, tostring и type.
+// Скрипты кэшируются по sha1 текста
+type Scripter interface {
+	// Eval выполняет скрипт и сохраняет его в кэше
+	Eval(script string, keys []string, args []string) (interface{}, error)
+	// EvalSha выполняет скрипт из кэша, ErrNoScript - если его там нет
+	EvalSha(sha string, keys []string, args []string) (interface{}, error)
+	// ScriptLoad сохраняет скрипт в кэше без выполнения и возвращает sha1
+	ScriptLoad(script string) (string, error)
+	ScriptExists(shas ...string) ([]bool, error)
+	ScriptFlush() error
+}
+
+func asScripter(c Cache) (Scripter, error) {
+	sc, ok := c.(Scripter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return sc, nil
+}
+
+// scriptRunner выполняет скрипт (script или, если он пуст, скрипт из кэша по
+// sha) и кроме результата возвращает записанные значения ключей, nil для
+// удаленных. По ним внешние слои ведут журнал и планируют истечение
+type scriptRunner interface {
+	runScript(script string, sha string, keys []string, args []string) (interface{}, map[string]*Value, error)
+}
+
+func asScriptRunner(c Cache) (scriptRunner, error) {
+	r, ok := c.(scriptRunner)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return r, nil
+}
+
+// scriptCacheSize - наибольшее число скриптов в кэше. Сверх него
+// вытесняется скрипт, который дольше всех не использовался
+const scriptCacheSize = 1024
+
+// scriptCache хранит разобранные скрипты по sha1. Последние использованные
+// скрипты - в начале order
+type scriptCache struct {
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+type cachedScript struct {
+	sha  string
+	body []scriptStmt
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{items: map[string]*list.Element{}, order: list.New()}
+}
+
+func scriptSha(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *scriptCache) load(script string) (string, []scriptStmt, error) {
+	sha := scriptSha(script)
+	if body, ok := c.get(sha); ok {
+		return sha, body, nil
+	}
+	body, err := compileScript(script)
+	if err != nil {
+		return "", nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[sha]; ok {
+		c.order.MoveToFront(e)
+		return sha, body, nil
+	}
+	c.items[sha] = c.order.PushFront(&cachedScript{sha: sha, body: body})
+	if c.order.Len() > scriptCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedScript).sha)
+	}
+	return sha, body, nil
+}
+
+func (c *scriptCache) get(sha string) ([]scriptStmt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[strings.ToLower(sha)]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedScript).body, true
+}
+
+func (c *scriptCache) flush() {
+	c.mu.Lock()
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+	c.mu.Unlock()
+}
+
+// scriptShard - промежуточный слой над шардом: чтения видят записи скрипта,
+// сами записи копятся в written (nil - ключ удален) до фиксации
+type scriptShard struct {
+	base    Cache
+	written map[string]*Value
+	order   []string
+}
+
+func newScriptShard(base Cache) *scriptShard {
+	return &scriptShard{base: base, written: map[string]*Value{}}
+}
+
+func (s *scriptShard) write(key string, value *Value) {
+	if _, ok := s.written[key]; !ok {
+		s.order = append(s.order, key)
+	}
+	s.written[key] = value
+}
+
+func (s *scriptShard) Set(key string, value interface{}, expire time.Duration) (*Value, error) {
+	v, err := newValue(value, expire)
+	if err != nil {
+		return nil, err
+	}
+	s.write(key, v)
+	return v, nil
+}
+
+func (s *scriptShard) Get(key string) (*Value, error) {
+	if v, ok := s.written[key]; ok {
+		if v == nil {
+			return nil, ErrKeyNotFound
+		}
+		return v, nil
+	}
+	return s.base.Get(key)
+}
+
+func (s *scriptShard) Remove(key string) error {
+	if _, err := s.Get(key); err == nil {
+		s.write(key, nil)
+	}
+	return nil
+}
+
+func (s *scriptShard) Keys() ([]string, error) {
+	keys, err := s.base.Keys()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := s.written[key]; !ok {
+			result = append(result, key)
+		}
+	}
+	for _, key := range s.order {
+		if s.written[key] != nil {
+			result = append(result, key)
+		}
+	}
+	return result, nil
+}
+
+func (s *scriptShard) GetAtIndex(key string, index interface{}) (interface{}, error) {
+	v, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return itemAtIndex(v, index)
+}
+
+func (s *scriptShard) Update(key string, fn updateFunc) (*Value, error) {
+	current, err := s.Get(key)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	next, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	// значение не изменилось
+	if next == current {
+		return current, nil
+	}
+	if next != nil || current != nil {
+		s.write(key, next)
+	}
+	return next, nil
+}
+
+func (s *sharder) runScript(script string, sha string, keys []string, args []string) (interface{}, map[string]*Value, error) {
+	var body []scriptStmt
+	var err error
+	if script != "" {
+		if _, body, err = s.scripts.load(script); err != nil {
+			return nil, nil, err
+		}
+	} else if cached, ok := s.scripts.get(sha); ok {
+		body = cached
+	} else {
+		return nil, nil, ErrNoScript
+	}
+
+	// скрипт работает с шардами через sharder без блокировок, поэтому ему
+	// доступны те же операции, что и клиентам
+	layers := make([]*scriptShard, len(s.shards))
+	view := &sharder{
+		fn:      s.fn,
+		shards:  make([]Cache, len(s.shards)),
+		waiters: newKeyWaiters(),
+		poppers: newKeyQueues(),
+		events:  NewPubSub(),
+	}
+	for i, shard := range s.shards {
+		if _, ok := shard.(updater); !ok {
+			return nil, nil, ErrUnsupported
+		}
+		layers[i] = newScriptShard(shard)
+		view.shards[i] = layers[i]
+	}
+
+	unlock := s.lockShards(keys)
+	vm := newScriptVM(view, keys, args, time.Now().Add(ScriptTimeLimit))
+	result, err := vm.run(body)
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+
+	// изменения записываются в журнал одной записью Exec, чтобы при
+	// восстановлении они применились вместе
+	written := map[string]*Value{}
+	for i, layer := range layers {
+		target := s.shards[i].(updater)
+		for _, key := range layer.order {
+			next := layer.written[key]
+			value, err := s.updateShard(target, key, func(*Value) (*Value, error) {
+				return next, nil
+			}, journalBatch)
+			if err != nil {
+				unlock()
+				return nil, nil, err
+			}
+			written[key] = value
+		}
+	}
+	if len(written) > 0 {
+		s.log(scriptRecord(written))
+	}
+	unlock()
+	return scriptToGo(result), written, nil
+}
+
+func (s *sharder) Eval(script string, keys []string, args []string) (interface{}, error) {
+	result, _, err := s.runScript(script, "", keys, args)
+	return result, err
+}
+
+func (s *sharder) EvalSha(sha string, keys []string, args []string) (interface{}, error) {
+	result, _, err := s.runScript("", sha, keys, args)
+	return result, err
+}
+
+func (s *sharder) ScriptLoad(script string) (string, error) {
+	sha, _, err := s.scripts.load(script)
+	return sha, err
+}
+
+func (s *sharder) ScriptExists(shas ...string) ([]bool, error) {
+	result := make([]bool, len(shas))
+	for i, sha := range shas {
+		_, result[i] = s.scripts.get(sha)
+	}
+	return result, nil
+}
+
+func (s *sharder) ScriptFlush() error {
+	s.scripts.flush()
+	return nil
+}
+
+// Вызов команд из скрипта
+
+type scriptCommand struct {
+	arity int // как в redis: отрицательное значение - минимальное число аргументов
+	// allKeys - все аргументы команды являются ключами, иначе ключ - первый
+	allKeys bool
+	handler func(view *sharder, args []string) (interface{}, error)
+}
+
+var scriptCommands = map[string]scriptCommand{
+	"GET":    {2, false, scriptGet},
+	"SET":    {-3, false, scriptSet},
+	"DEL":    {-2, true, scriptDel},
+	"EXISTS": {-2, true, scriptExists},
+
+	"INCR":   {2, false, scriptIncrBy(1)},
+	"DECR":   {2, false, scriptIncrBy(-1)},
+	"INCRBY": {3, false, scriptIncrBy(1)},
+	"DECRBY": {3, false, scriptIncrBy(-1)},
+
+	"HGET":    {3, false, scriptHGet},
+	"HSET":    {4, false, scriptHSet},
+	"HDEL":    {-3, false, scriptHDel},
+	"HLEN":    {2, false, scriptHLen},
+	"HGETALL": {2, false, scriptHGetAll},
+
+	"LPUSH":  {-3, false, scriptPush(true)},
+	"RPUSH":  {-3, false, scriptPush(false)},
+	"LPOP":   {2, false, scriptPop(true)},
+	"RPOP":   {2, false, scriptPop(false)},
+	"LLEN":   {2, false, scriptLLen},
+	"LRANGE": {4, false, scriptLRange},
+
+	"SADD":      {-3, false, scriptSAdd},
+	"SREM":      {-3, false, scriptSRem},
+	"SISMEMBER": {3, false, scriptSIsMember},
+	"SMEMBERS":  {2, false, scriptSMembers},
+}
+
+func newScriptVM(view *sharder, keys []string, args []string, deadline time.Time) *scriptVM {
+	declared := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		declared[key] = true
+	}
+	call := func(protected bool) scriptFunc {
+		return func(line int, callArgs []interface{}) (interface{}, error) {
+			result, err := callScriptCommand(view, declared, callArgs)
+			if err == nil {
+				return result, nil
+			}
+			if protected {
+				table := newScriptTable()
+				table.set("err", err.Error())
+				return table, nil
+			}
+			return nil, &ScriptError{Line: line, Message: err.Error()}
+		}
+	}
+	redis := newScriptTable()
+	redis.set("call", call(false))
+	redis.set("pcall", call(true))
+
+	globals := scriptBuiltins()
+	globals["KEYS"] = scriptFromGo(keys)
+	globals["ARGV"] = scriptFromGo(args)
+	globals["redis"] = redis
+	return &scriptVM{globals: globals, deadline: deadline}
+}
+
+// callScriptCommand проверяет аргументы redis.call и выполняет команду
+func callScriptCommand(view *sharder, declared map[string]bool, callArgs []interface{}) (interface{}, error) {
+	args := make([]string, len(callArgs))
+	for i, arg := range callArgs {
+		s, ok := scriptString(arg)
+		if !ok {
+			return nil, errors.New("command arguments must be strings or numbers")
+		}
+		args[i] = s
+	}
+	if len(args) == 0 {
+		return nil, errors.New("command name expected")
+	}
+	name := strings.ToUpper(args[0])
+	cmd, ok := scriptCommands[name]
+	if !ok {
+		return nil, errors.New("unknown command '" + args[0] + "'")
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		return nil, errors.New("wrong number of arguments for '" + args[0] + "'")
+	}
+	keys := args[1:2]
+	if cmd.allKeys {
+		keys = args[1:]
+	}
+	for _, key := range keys {
+		if !declared[key] {
+			return nil, ErrUndeclaredKey
+		}
+	}
+	result, err := cmd.handler(view, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return scriptFromGo(result), nil
+}
+
+func scriptGet(view *sharder, args []string) (interface{}, error) {
+	value, err := view.Get(args[0])
+	if err == ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value.Data, nil
+}
+
+// scriptSet поддерживает SET key value [EX seconds | PX milliseconds].
+// TTL по умолчанию к записям скриптов не применяется
+func scriptSet(view *sharder, args []string) (interface{}, error) {
+	var expire time.Duration
+	switch len(args) {
+	case 2:
+	case 4:
+		n, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil || n <= 0 {
+			return nil, ErrInvalidTTL
+		}
+		switch strings.ToUpper(args[2]) {
+		case "EX":
+			expire = time.Duration(n) * time.Second
+		case "PX":
+			expire = time.Duration(n) * time.Millisecond
+		default:
+			return nil, errors.New("syntax error")
+		}
+	default:
+		return nil, errors.New("syntax error")
+	}
+	if _, err := view.Set(args[0], args[1], expire); err != nil {
+		return nil, err
+	}
+	return "OK", nil
+}
+
+func scriptDel(view *sharder, args []string) (interface{}, error) {
+	removed := 0
+	for _, key := range args {
+		if _, err := view.Get(key); err != nil {
+			continue
+		}
+		if err := view.Remove(key); err != nil {
+			return nil, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func scriptExists(view *sharder, args []string) (interface{}, error) {
+	n := 0
+	for _, key := range args {
+		if _, err := view.Get(key); err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func scriptIncrBy(sign int64) func(view *sharder, args []string) (interface{}, error) {
+	return func(view *sharder, args []string) (interface{}, error) {
+		delta := int64(1)
+		if len(args) > 1 {
+			var err error
+			if delta, err = strconv.ParseInt(args[1], 10, 64); err != nil {
+				return nil, ErrNotInteger
+			}
+		}
+		value, err := view.IncrBy(args[0], sign*delta)
+		if err != nil {
+			return nil, err
+		}
+		return value.Data, nil
+	}
+}
+
+func scriptHGet(view *sharder, args []string) (interface{}, error) {
+	result, err := view.HGet(args[0], args[1])
+	if err == ErrKeyNotFound || err == ErrIndexAccess {
+		return nil, nil
+	}
+	return result, err
+}
+
+// scriptHSet возвращает 1, если поле добавлено, и 0, если перезаписано
+func scriptHSet(view *sharder, args []string) (interface{}, error) {
+	before, err := view.HLen(args[0])
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	value, err := view.HSet(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return len(value.Data.(map[string]interface{})) - before, nil
+}
+
+func scriptHDel(view *sharder, args []string) (interface{}, error) {
+	return view.HDel(args[0], args[1:]...)
+}
+
+func scriptHLen(view *sharder, args []string) (interface{}, error) {
+	n, err := view.HLen(args[0])
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	return n, err
+}
+
+// scriptHGetAll возвращает таблицу поле - значение
+func scriptHGetAll(view *sharder, args []string) (interface{}, error) {
+	value, err := view.Get(args[0])
+	if err == ErrKeyNotFound {
+		return map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value.Type != MAP {
+		return nil, ErrWrongType
+	}
+	return copyMap(value.Data), nil
+}
+
+func scriptPush(left bool) func(view *sharder, args []string) (interface{}, error) {
+	return func(view *sharder, args []string) (interface{}, error) {
+		items := make([]interface{}, len(args)-1)
+		for i, item := range args[1:] {
+			items[i] = item
+		}
+		return view.push(args[0], left, items)
+	}
+}
+
+func scriptPop(left bool) func(view *sharder, args []string) (interface{}, error) {
+	return func(view *sharder, args []string) (interface{}, error) {
+		item, err := view.pop(args[0], left)
+		if err == ErrKeyNotFound {
+			return nil, nil
+		}
+		return item, err
+	}
+}
+
+func scriptLLen(view *sharder, args []string) (interface{}, error) {
+	n, err := view.LLen(args[0])
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	return n, err
+}
+
+func scriptLRange(view *sharder, args []string) (interface{}, error) {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, ErrNotInteger
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, ErrNotInteger
+	}
+	items, err := view.LRange(args[0], start, stop)
+	if err == ErrKeyNotFound {
+		return []interface{}{}, nil
+	}
+	return items, err
+}
+
+func scriptSAdd(view *sharder, args []string) (interface{}, error) {
+	return view.SAdd(args[0], args[1:]...)
+}
+
+func scriptSRem(view *sharder, args []string) (interface{}, error) {
+	return view.SRem(args[0], args[1:]...)
+}
+
+func scriptSIsMember(view *sharder, args []string) (interface{}, error) {
+	ok, err := view.SIsMember(args[0], args[1])
+	if err != nil || !ok {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func scriptSMembers(view *sharder, args []string) (interface{}, error) {
+	return view.SMembers(args[0])
+}
+
+// Обертки
+
+func (l *logger) runScript(script string, sha string, keys []string, args []string) (interface{}, map[string]*Value, error) {
+	defer l.peekIntoPanic("eval", script, sha, keys, args)
+	r, err := asScriptRunner(l.Cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, written, err := r.runScript(script, sha, keys, args)
+	l.infoLog.Println("eval", script, sha, keys, args, "=>", result, err)
+	return result, written, err
+}
+
+func (l *logger) Eval(script string, keys []string, args []string) (interface{}, error) {
+	result, _, err := l.runScript(script, "", keys, args)
+	return result, err
+}
+
+func (l *logger) EvalSha(sha string, keys []string, args []string) (interface{}, error) {
+	result, _, err := l.runScript("", sha, keys, args)
+	return result, err
+}
+
+func (l *logger) ScriptLoad(script string) (string, error) {
+	defer l.peekIntoPanic("script load", script)
+	sc, err := asScripter(l.Cache)
+	if err != nil {
+		return "", err
+	}
+	result, err := sc.ScriptLoad(script)
+	l.infoLog.Println("script load", script, "=>", result, err)
+	return result, err
+}
+
+func (l *logger) ScriptExists(shas ...string) ([]bool, error) {
+	sc, err := asScripter(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return sc.ScriptExists(shas...)
+}
+
+func (l *logger) ScriptFlush() error {
+	defer l.peekIntoPanic("script flush")
+	sc, err := asScripter(l.Cache)
+	if err != nil {
+		return err
+	}
+	err = sc.ScriptFlush()
+	l.infoLog.Println("script flush", "=>", err)
+	return err
+}
+
+// scriptRecord собирает изменения скрипта в запись Exec
+func scriptRecord(written map[string]*Value) operation {
+	changed := make([]string, 0, len(written))
+	for key := range written {
+		changed = append(changed, key)
+	}
+	sort.Strings(changed)
+	record := operation{Type: "Exec", Ops: make([]operation, len(changed))}
+	for i, key := range changed {
+		if value := written[key]; value != nil {
+			record.Ops[i] = setOperation(key, value)
+		} else {
+			record.Ops[i] = operation{Type: "Remove", Key: key}
+		}
+	}
+	return record
+}
+
+func (p *persister) runScript(script string, sha string, keys []string, args []string) (interface{}, map[string]*Value, error) {
+	r, err := asScriptRunner(p.Cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.runScript(script, sha, keys, args)
+}
+
+func (p *persister) Eval(script string, keys []string, args []string) (interface{}, error) {
+	result, _, err := p.runScript(script, "", keys, args)
+	return result, err
+}
+
+func (p *persister) EvalSha(sha string, keys []string, args []string) (interface{}, error) {
+	result, _, err := p.runScript("", sha, keys, args)
+	return result, err
+}
+
+func (p *persister) ScriptLoad(script string) (string, error) {
+	sc, err := asScripter(p.Cache)
+	if err != nil {
+		return "", err
+	}
+	return sc.ScriptLoad(script)
+}
+
+func (p *persister) ScriptExists(shas ...string) ([]bool, error) {
+	sc, err := asScripter(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return sc.ScriptExists(shas...)
+}
+
+func (p *persister) ScriptFlush() error {
+	sc, err := asScripter(p.Cache)
+	if err != nil {
+		return err
+	}
+	return sc.ScriptFlush()
+}
+
+func (t *ttl) runScript(script string, sha string, keys []string, args []string) (interface{}, map[string]*Value, error) {
+	r, err := asScriptRunner(t.Cache)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, written, err := r.runScript(script, sha, keys, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	// ключи без времени истечения скрипт создал или перезаписал командой
+	// без TTL: как и другие операции, они получают TTL по умолчанию
+	for key, value := range written {
+		switch {
+		case value == nil:
+		case value.Expires == 0:
+			t.expireCreated(key)
+		default:
+			t.scheduleMoved(key, value)
+		}
+	}
+	return result, written, nil
+}
+
+func (t *ttl) Eval(script string, keys []string, args []string) (interface{}, error) {
+	result, _, err := t.runScript(script, "", keys, args)
+	return result, err
+}
+
+func (t *ttl) EvalSha(sha string, keys []string, args []string) (interface{}, error) {
+	result, _, err := t.runScript("", sha, keys, args)
+	return result, err
+}
+
+func (t *ttl) ScriptLoad(script string) (string, error) {
+	sc, err := asScripter(t.Cache)
+	if err != nil {
+		return "", err
+	}
+	return sc.ScriptLoad(script)
+}
+
+func (t *ttl) ScriptExists(shas ...string) ([]bool, error) {
+	sc, err := asScripter(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return sc.ScriptExists(shas...)
+}
+
+func (t *ttl) ScriptFlush() error {
+	sc, err := asScripter(t.Cache)
+	if err != nil {
+		return err
+	}
+	return sc.ScriptFlush()
+}
@@ -0,0 +1,249 @@
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScripter_Eval(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	sc := c.(Scripter)
+	c.Set("counter", 5, 0)
+
+	// прочитать счетчик, сравнить и записать два ключа
+	script := `
+local n = tonumber(redis.call("GET", KEYS[1]))
+if n >= tonumber(ARGV[1]) then
+	redis.call("SET", KEYS[2], "big")
+	redis.call("INCRBY", KEYS[1], -n)
+	return {n, "reset"}
+end
+return {n, "kept"}`
+	result, err := sc.Eval(script, []string{"counter", "flag"}, []string{"3"})
+	if err != nil || !reflect.DeepEqual(result, []interface{}{int64(5), "reset"}) {
+		t.Fatalf("Eval: got %v, err %v", result, err)
+	}
+	if value, _ := c.Get("counter"); value.Data != int64(0) {
+		t.Errorf("counter should be reset, got %v", value.Data)
+	}
+	if value, _ := c.Get("flag"); value == nil || value.Data != "big" {
+		t.Errorf("flag should be set, got %v", value)
+	}
+
+	sha := scriptSha(script)
+	result, err = sc.EvalSha(strings.ToUpper(sha), []string{"counter", "flag"}, []string{"3"})
+	if err != nil || !reflect.DeepEqual(result, []interface{}{int64(0), "kept"}) {
+		t.Errorf("EvalSha: got %v, err %v", result, err)
+	}
+
+	loaded, err := sc.ScriptLoad("return 1")
+	if err != nil || loaded != scriptSha("return 1") {
+		t.Errorf("ScriptLoad: got %v, err %v", loaded, err)
+	}
+	if exists, _ := sc.ScriptExists(sha, loaded, "unknown"); !reflect.DeepEqual(exists, []bool{true, true, false}) {
+		t.Errorf("ScriptExists: got %v", exists)
+	}
+	sc.ScriptFlush()
+	if _, err = sc.EvalSha(sha, nil, nil); err != ErrNoScript {
+		t.Errorf("EvalSha after flush: expected %v, got %v", ErrNoScript, err)
+	}
+
+	// кэш вытесняет скрипт, который дольше всех не использовался
+	first, _ := sc.ScriptLoad("return 0")
+	second, _ := sc.ScriptLoad("return -1")
+	for i := 1; i < scriptCacheSize; i++ {
+		sc.ScriptLoad("return " + strconv.Itoa(i))
+		if i == 1 {
+			sc.EvalSha(first, nil, nil)
+		}
+	}
+	if exists, _ := sc.ScriptExists(first, second); !reflect.DeepEqual(exists, []bool{true, false}) {
+		t.Errorf("ScriptExists after eviction: expected [true false], got %v", exists)
+	}
+}
+
+func TestScripter_Language(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 1, nil)
+	sc := c.(Scripter)
+	tests := []struct {
+		script   string
+		expected interface{}
+	}{
+		{`return 1 + 2 * 3 ^ 2 / 9 - -1`, int64(4)},
+		{`return 7 % 3`, int64(1)},
+		{`return "a" .. 1 .. 'b\n'`, "a1b\n"},
+		{`return 2 ^ 3 ^ 2`, int64(512)},
+		{`return 1 < 2 and "yes" or "no"`, "yes"},
+		{`return nil or false`, false},
+		{`return not nil == true`, true},
+		{`return #"abc" + #{1, 2, 3}`, int64(6)},
+		{`return 0.5`, 0.5},
+		{`return`, nil},
+		{`local s = 0 for i = 1, 10 do s = s + i end return s`, int64(55)},
+		{`local s = 0 for i = 10, 1, -3 do s = s + i end return s`, int64(22)},
+		{`local i = 0 while true do i = i + 1 if i == 3 then break end end return i`, int64(3)},
+		{`local t = {} t[1] = "a" t[2] = "b" return t`, []interface{}{"a", "b"}},
+		{`local t = {x = 1, ["y"] = {true}} t.x = nil return t`, map[string]interface{}{"y": []interface{}{true}}},
+		{`
+-- комментарий
+--[[ многострочный
+комментарий ]]
+local a, b = 1
+if b then return "b" elseif a == 2 then return 2 else return type(b) .. tostring(a) end`, "nil1"},
+		{`local x = 1 do local x = 2 end return x`, int64(1)},
+		{`return tonumber("0x") == nil and tonumber(" 12 ") == 12`, true},
+		{`return {KEYS[1], ARGV[1], #KEYS, #ARGV}`, []interface{}{"k", "a", int64(1), int64(2)}},
+	}
+	for _, test := range tests {
+		result, err := sc.Eval(test.script, []string{"k"}, []string{"a", "b"})
+		if err != nil || !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Eval %q: expected %#v, got %#v, err %v", test.script, test.expected, result, err)
+		}
+	}
+
+	failures := map[string]string{
+		`return 1 +`:               "script error at line 1: unexpected symbol near '<eof>'",
+		"\nx = 1":                  "script error at line 2: attempt to assign to undeclared global 'x', use local",
+		`return 1 < "2"`:           "script error at line 1: attempt to compare number with string",
+		`return {} .. "a"`:         "script error at line 1: attempt to concatenate a table value",
+		`return nothing()`:         "script error at line 1: attempt to call a nil value",
+		`return "a`:                "script error at line 1: unfinished string",
+		`if true then return 1`:    "script error at line 1: 'end' expected near '<eof>'",
+		`local t = nil return t.x`: "script error at line 1: attempt to index a nil value",
+		"return " + strings.Repeat("(", 300) + "1" + strings.Repeat(")", 300):                    "script error at line 1: chunk has too many syntax levels",
+		`local s = "x" for i = 1, 30 do s = s .. s end`:                                          "script error at line 1: string length overflow",
+		`local t = {} for i = 1, 70000 do t[i], t[-i], t[i .. ""], t[-i .. ""] = 1, 1, 1, 1 end`: "script error at line 1: table overflow",
+	}
+	for script, expected := range failures {
+		if _, err := sc.Eval(script, nil, nil); err == nil || err.Error() != expected {
+			t.Errorf("Eval %q: expected error %q, got %v", script, expected, err)
+		}
+	}
+}
+
+func TestScripter_Commands(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	sc := c.(Scripter)
+	script := `
+local h, l, s = KEYS[1], KEYS[2], KEYS[3]
+local r = {}
+r[1] = redis.call("HSET", h, "a", "1") + redis.call("hset", h, "a", "2")
+r[2] = redis.call("HGET", h, "a")
+r[3] = redis.call("HGETALL", h).a
+r[4] = redis.call("RPUSH", l, "x", "y", "z")
+r[5] = redis.call("LPOP", l) .. redis.call("RPOP", l)
+r[6] = redis.call("LRANGE", l, 0, -1)[1]
+r[7] = redis.call("SADD", s, "m", "n") + redis.call("SISMEMBER", s, "m")
+r[8] = #redis.call("SMEMBERS", s) + redis.call("SREM", s, "m")
+r[9] = redis.call("EXISTS", h, l, s) + redis.call("DEL", h)
+r[10] = redis.call("HLEN", h) + redis.call("LLEN", l)
+return r`
+	result, err := sc.Eval(script, []string{"h", "l", "s"}, nil)
+	expected := []interface{}{int64(1), "2", "2", int64(3), "xz", "y", int64(3), int64(3), int64(4), int64(1)}
+	if err != nil || !reflect.DeepEqual(result, expected) {
+		t.Errorf("Eval commands: expected %v, got %v, err %v", expected, result, err)
+	}
+	if _, err = c.Get("h"); err != ErrKeyNotFound {
+		t.Errorf("h should be removed, got %v", err)
+	}
+	if items, _ := c.(Lister).LRange("l", 0, -1); !reflect.DeepEqual(items, []interface{}{"y"}) {
+		t.Errorf("l: got %v", items)
+	}
+
+	result, err = sc.Eval(`return redis.pcall("GET", "other")`, []string{"k"}, nil)
+	if err != nil || !reflect.DeepEqual(result, map[string]interface{}{"err": ErrUndeclaredKey.Error()}) {
+		t.Errorf("pcall of undeclared key: got %v, err %v", result, err)
+	}
+	c.Set("list", []interface{}{1}, 0)
+	if _, err = sc.Eval(`return redis.call("INCR", KEYS[1])`, []string{"list"}, nil); err == nil ||
+		err.Error() != "script error at line 1: "+ErrWrongType.Error() {
+		t.Errorf("INCR of list: got %v", err)
+	}
+}
+
+func TestScripter_Atomic(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	sc := c.(Scripter)
+	c.Set("a", "1", 0)
+
+	// ошибка после записи отменяет все записи скрипта
+	_, err := sc.Eval(`redis.call("SET", KEYS[1], "2") redis.call("DEL", KEYS[2]) redis.call("GET", "c")`,
+		[]string{"a", "b"}, nil)
+	if err == nil || !strings.Contains(err.Error(), ErrUndeclaredKey.Error()) {
+		t.Errorf("Eval with undeclared key: got %v", err)
+	}
+	if value, _ := c.Get("a"); value.Data != "1" {
+		t.Errorf("failed script should not write, got %v", value.Data)
+	}
+
+	limit := ScriptTimeLimit
+	ScriptTimeLimit = 20 * time.Millisecond
+	defer func() { ScriptTimeLimit = limit }()
+	_, err = sc.Eval(`redis.call("SET", KEYS[1], "3") while true do end`, []string{"a"}, nil)
+	if err != ErrScriptTimeout {
+		t.Errorf("endless script: expected %v, got %v", ErrScriptTimeout, err)
+	}
+	if value, _ := c.Get("a"); value.Data != "1" {
+		t.Errorf("timed out script should not write, got %v", value.Data)
+	}
+
+	// сотни шагов, но каждая конкатенация копирует 16 МБ
+	start := time.Now()
+	_, err = sc.Eval(`local s = "x" for i = 1, 23 do s = s .. s end for i = 1, 300 do local t = s .. s end`, nil, nil)
+	if err != ErrScriptTimeout || time.Since(start) > time.Second {
+		t.Errorf("script of long concatenations: expected %v in time, got %v after %v", ErrScriptTimeout, err, time.Since(start))
+	}
+}
+
+func TestScripter_Concurrent(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	sc := c.(Scripter)
+	// без блокировок параллельные чтения и записи теряли бы увеличения
+	script := `
+local n = tonumber(redis.call("GET", KEYS[1]) or 0)
+redis.call("SET", KEYS[1], n + 1)
+redis.call("SET", KEYS[2], n + 1)`
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := sc.Eval(script, []string{"n", "copy"}, nil); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	n, _ := c.Get("n")
+	copied, _ := c.Get("copy")
+	if n.Data != "400" || copied.Data != "400" {
+		t.Errorf("expected 400, got %v and %v", n.Data, copied.Data)
+	}
+}
+
+func TestScripter_Persist(t *testing.T) {
+	sample := `{"Type":"Exec","k":"","v":null,"e":0,"ops":[{"Type":"Set","k":"a","v":"1","e":0},{"Type":"Remove","k":"b","v":null,"e":0},{"Type":"Set","k":"l","v":["x"],"e":0}]}
+`
+	s, _ := newSharder(2, nil)
+	s.Set("b", 2, 0)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+
+	script := `redis.call("RPUSH", KEYS[3], "x") redis.call("DEL", KEYS[2]) redis.call("SET", KEYS[1], ARGV[1])`
+	if _, err := p.Eval(script, []string{"a", "b", "l"}, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	// скрипт только для чтения в журнал не попадает
+	p.Eval(`return redis.call("GET", KEYS[1])`, []string{"a"}, nil)
+	if got := flushOplog(p, &rw, sample); got != sample {
+		t.Errorf("TestScripter_Persist expected:\n%v\ngot:\n%v", sample, got)
+	}
+}
@@ -0,0 +1,1394 @@
+/*
+    Язык скриптов EVAL - подмножество Lua: локальные переменные, if, while,
+    числовой for, таблицы, строки и числа. Пользовательских функций нет,
+    доступны только встроенные. Скрипт разбирается в дерево, которое
+    исполняется обходом с проверкой времени выполнения
+*/
+
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrScriptTimeout = errors.New("script exceeded the execution time limit")
+
+// ScriptError - ошибка разбора или выполнения скрипта
+type ScriptError struct {
+	Line    int
+	Message string
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("script error at line %d: %s", e.Line, e.Message)
+}
+
+func scriptErrorf(line int, format string, args ...interface{}) error {
+	return &ScriptError{Line: line, Message: fmt.Sprintf(format, args...)}
+}
+
+// Лексический анализ
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokKeyword
+	tokNumber
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	line int
+}
+
+var scriptKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "if": true, "local": true,
+	"nil": true, "not": true, "or": true, "return": true, "then": true,
+	"true": true, "while": true,
+}
+
+// scriptOperators перечислены так, что длинные операторы проверяются раньше
+var scriptOperators = []string{
+	"..", "==", "~=", "<=", ">=",
+	"+", "-", "*", "/", "%", "^", "#", "<", ">", "=",
+	"(", ")", "{", "}", "[", "]", ";", ",", ".",
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "--[["):
+			end := strings.Index(src[i:], "]]")
+			if end < 0 {
+				return nil, scriptErrorf(line, "unfinished long comment")
+			}
+			line += strings.Count(src[i:i+end], "\n")
+			i += end + 2
+		case strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isNameStart(c):
+			j := i
+			for j < len(src) && (isNameStart(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			kind := tokName
+			if scriptKeywords[src[i:j]] {
+				kind = tokKeyword
+			}
+			tokens = append(tokens, token{kind: kind, text: src[i:j], line: line})
+			i = j
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			j := i
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				((src[j] == '+' || src[j] == '-') && (src[j-1] == 'e' || src[j-1] == 'E'))) {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, scriptErrorf(line, "malformed number near '%s'", src[i:j])
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[i:j], num: n, line: line})
+			i = j
+		case c == '"' || c == '\'':
+			s, n, err := readScriptString(src[i:], line)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, line: line})
+			i += n
+		default:
+			op := ""
+			for _, candidate := range scriptOperators {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, scriptErrorf(line, "unexpected symbol near '%c'", c)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, line: line})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, text: "<eof>", line: line}), nil
+}
+
+// readScriptString читает строку в кавычках и возвращает ее значение и
+// длину вместе с кавычками
+func readScriptString(src string, line int) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, scriptErrorf(line, "unfinished string")
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case '\\', '"', '\'':
+				b.WriteByte(src[i])
+			default:
+				return "", 0, scriptErrorf(line, "invalid escape sequence '\\%c'", src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, scriptErrorf(line, "unfinished string")
+}
+
+// Синтаксическое дерево
+
+type scriptExpr interface{}
+
+type scriptStmt interface{}
+
+type constExpr struct{ value interface{} }
+
+type nameExpr struct {
+	name string
+	line int
+}
+
+type indexExpr struct {
+	object, key scriptExpr
+	line        int
+}
+
+type callExpr struct {
+	fn   scriptExpr
+	args []scriptExpr
+	line int
+}
+
+type binaryExpr struct {
+	op          string
+	left, right scriptExpr
+	line        int
+}
+
+type unaryExpr struct {
+	op      string
+	operand scriptExpr
+	line    int
+}
+
+type tableField struct {
+	key, value scriptExpr // key == nil для элементов массива
+}
+
+type tableExpr struct{ fields []tableField }
+
+type localStmt struct {
+	names  []string
+	values []scriptExpr
+}
+
+type assignStmt struct {
+	targets []scriptExpr
+	values  []scriptExpr
+	line    int
+}
+
+type callStmt struct{ call *callExpr }
+
+type ifStmt struct {
+	conds     []scriptExpr
+	blocks    [][]scriptStmt
+	elseBlock []scriptStmt
+}
+
+type whileStmt struct {
+	cond scriptExpr
+	body []scriptStmt
+}
+
+type forStmt struct {
+	name              string
+	start, stop, step scriptExpr
+	body              []scriptStmt
+	line              int
+}
+
+type doStmt struct{ body []scriptStmt }
+
+type returnStmt struct{ value scriptExpr }
+
+type breakStmt struct{}
+
+// Разбор
+
+// scriptMaxDepth ограничивает вложенность выражений и блоков: разбор и
+// исполнение рекурсивны
+const scriptMaxDepth = 200
+
+type scriptParser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// compileScript разбирает текст скрипта в дерево
+func compileScript(src string) ([]scriptStmt, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &scriptParser{tokens: tokens}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, scriptErrorf(t.line, "'<eof>' expected near '%s'", t.text)
+	}
+	return body, nil
+}
+
+func (p *scriptParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *scriptParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is проверяет, что текущая лексема - оператор или ключевое слово text
+func (p *scriptParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokOp || t.kind == tokKeyword) && t.text == text
+}
+
+func (p *scriptParser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *scriptParser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return scriptErrorf(t.line, "'%s' expected near '%s'", text, t.text)
+	}
+	return nil
+}
+
+// enter учитывает уровень вложенности, leave возвращает его
+func (p *scriptParser) enter() error {
+	p.depth++
+	if p.depth > scriptMaxDepth {
+		return scriptErrorf(p.peek().line, "chunk has too many syntax levels")
+	}
+	return nil
+}
+
+func (p *scriptParser) leave() {
+	p.depth--
+}
+
+func (p *scriptParser) name() (string, error) {
+	t := p.peek()
+	if t.kind != tokName {
+		return "", scriptErrorf(t.line, "name expected near '%s'", t.text)
+	}
+	p.next()
+	return t.text, nil
+}
+
+// block читает операторы до end, else, elseif или конца скрипта
+func (p *scriptParser) block() ([]scriptStmt, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	var stmts []scriptStmt
+	for {
+		if p.peek().kind == tokEOF || p.is("end") || p.is("else") || p.is("elseif") {
+			return stmts, nil
+		}
+		if p.accept(";") {
+			continue
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+}
+
+func (p *scriptParser) statement() (scriptStmt, error) {
+	t := p.peek()
+	switch {
+	case p.accept("local"):
+		return p.localStatement()
+	case p.accept("if"):
+		return p.ifStatement()
+	case p.accept("while"):
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.doBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &whileStmt{cond: cond, body: body}, nil
+	case p.accept("for"):
+		return p.forStatement(t.line)
+	case p.is("do"):
+		body, err := p.doBlock()
+		if err != nil {
+			return nil, err
+		}
+		return &doStmt{body: body}, nil
+	case p.accept("return"):
+		stmt := &returnStmt{}
+		if p.peek().kind == tokEOF || p.is("end") || p.is("else") || p.is("elseif") || p.is(";") {
+			return stmt, nil
+		}
+		var err error
+		stmt.value, err = p.expr()
+		return stmt, err
+	case p.accept("break"):
+		return &breakStmt{}, nil
+	}
+	return p.exprStatement()
+}
+
+func (p *scriptParser) doBlock() ([]scriptStmt, error) {
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return body, p.expect("end")
+}
+
+func (p *scriptParser) localStatement() (scriptStmt, error) {
+	stmt := &localStmt{}
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		stmt.names = append(stmt.names, name)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if p.accept("=") {
+		var err error
+		if stmt.values, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *scriptParser) ifStatement() (scriptStmt, error) {
+	stmt := &ifStmt{}
+	for {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		stmt.conds = append(stmt.conds, cond)
+		stmt.blocks = append(stmt.blocks, body)
+		if !p.accept("elseif") {
+			break
+		}
+	}
+	if p.accept("else") {
+		var err error
+		if stmt.elseBlock, err = p.block(); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, p.expect("end")
+}
+
+func (p *scriptParser) forStatement(line int) (scriptStmt, error) {
+	stmt := &forStmt{line: line}
+	var err error
+	if stmt.name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err = p.expect("="); err != nil {
+		return nil, err
+	}
+	if stmt.start, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if err = p.expect(","); err != nil {
+		return nil, err
+	}
+	if stmt.stop, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if p.accept(",") {
+		if stmt.step, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	stmt.body, err = p.doBlock()
+	return stmt, err
+}
+
+// exprStatement разбирает присваивание или вызов функции
+func (p *scriptParser) exprStatement() (scriptStmt, error) {
+	line := p.peek().line
+	first, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+	if call, ok := first.(*callExpr); ok && !p.is("=") && !p.is(",") {
+		return &callStmt{call: call}, nil
+	}
+	stmt := &assignStmt{targets: []scriptExpr{first}, line: line}
+	for p.accept(",") {
+		target, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.targets = append(stmt.targets, target)
+	}
+	for _, target := range stmt.targets {
+		switch target.(type) {
+		case *nameExpr, *indexExpr:
+		default:
+			return nil, scriptErrorf(line, "syntax error near '%s'", p.peek().text)
+		}
+	}
+	if err = p.expect("="); err != nil {
+		return nil, err
+	}
+	stmt.values, err = p.exprList()
+	return stmt, err
+}
+
+func (p *scriptParser) exprList() ([]scriptExpr, error) {
+	var list []scriptExpr
+	for {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+		if !p.accept(",") {
+			return list, nil
+		}
+	}
+}
+
+// Приоритеты бинарных операторов слева и справа, как в Lua: правый
+// приоритет меньше левого у правоассоциативных .. и ^
+var binaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {9, 8},
+	"+":  {10, 10}, "-": {10, 10},
+	"*": {11, 11}, "/": {11, 11}, "%": {11, 11},
+	"^": {14, 13},
+}
+
+const unaryPriority = 12
+
+func (p *scriptParser) expr() (scriptExpr, error) {
+	return p.subExpr(0)
+}
+
+func (p *scriptParser) subExpr(limit int) (scriptExpr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	var left scriptExpr
+	t := p.peek()
+	if p.is("not") || p.is("-") || p.is("#") {
+		p.next()
+		operand, err := p.subExpr(unaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		left = &unaryExpr{op: t.text, operand: operand, line: t.line}
+	} else {
+		var err error
+		if left, err = p.simpleExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		t = p.peek()
+		priority, ok := binaryPriority[t.text]
+		if !ok || (t.kind != tokOp && t.kind != tokKeyword) || priority[0] <= limit {
+			return left, nil
+		}
+		p.next()
+		right, err := p.subExpr(priority[1])
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: t.text, left: left, right: right, line: t.line}
+	}
+}
+
+func (p *scriptParser) simpleExpr() (scriptExpr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		return &constExpr{t.num}, nil
+	case t.kind == tokString:
+		p.next()
+		return &constExpr{t.text}, nil
+	case p.accept("nil"):
+		return &constExpr{nil}, nil
+	case p.accept("true"):
+		return &constExpr{true}, nil
+	case p.accept("false"):
+		return &constExpr{false}, nil
+	case p.is("{"):
+		return p.tableConstructor()
+	}
+	return p.suffixedExpr()
+}
+
+func (p *scriptParser) primaryExpr() (scriptExpr, error) {
+	t := p.peek()
+	if t.kind == tokName {
+		p.next()
+		return &nameExpr{name: t.text, line: t.line}, nil
+	}
+	if p.accept("(") {
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	return nil, scriptErrorf(t.line, "unexpected symbol near '%s'", t.text)
+}
+
+func (p *scriptParser) suffixedExpr() (scriptExpr, error) {
+	e, err := p.primaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			e = &indexExpr{object: e, key: &constExpr{name}, line: t.line}
+		case p.accept("["):
+			key, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{object: e, key: key, line: t.line}
+		case p.accept("("):
+			call := &callExpr{fn: e, line: t.line}
+			if !p.is(")") {
+				if call.args, err = p.exprList(); err != nil {
+					return nil, err
+				}
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			e = call
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *scriptParser) tableConstructor() (scriptExpr, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	table := &tableExpr{}
+	for !p.is("}") {
+		var field tableField
+		var err error
+		switch {
+		case p.accept("["):
+			if field.key, err = p.expr(); err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err = p.expect("="); err != nil {
+				return nil, err
+			}
+		case p.peek().kind == tokName && p.tokens[p.pos+1].text == "=" && p.tokens[p.pos+1].kind == tokOp:
+			field.key = &constExpr{p.next().text}
+			p.next()
+		}
+		if field.value, err = p.expr(); err != nil {
+			return nil, err
+		}
+		table.fields = append(table.fields, field)
+		if !p.accept(",") && !p.accept(";") {
+			break
+		}
+	}
+	return table, p.expect("}")
+}
+
+// Значения
+
+// scriptTable - таблица Lua. Числовые ключи хранятся как float64
+type scriptTable struct {
+	items map[interface{}]interface{}
+}
+
+func newScriptTable() *scriptTable {
+	return &scriptTable{items: map[interface{}]interface{}{}}
+}
+
+func (t *scriptTable) get(key interface{}) interface{} {
+	return t.items[key]
+}
+
+func (t *scriptTable) set(key interface{}, value interface{}) {
+	if value == nil {
+		delete(t.items, key)
+		return
+	}
+	t.items[key] = value
+}
+
+// length возвращает длину последовательности 1..n, как оператор #
+func (t *scriptTable) length() int {
+	n := 0
+	for t.items[float64(n+1)] != nil {
+		n++
+	}
+	return n
+}
+
+func (t *scriptTable) append(value interface{}) {
+	t.set(float64(t.length()+1), value)
+}
+
+// scriptFunc - встроенная функция
+type scriptFunc func(line int, args []interface{}) (interface{}, error)
+
+func scriptType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *scriptTable:
+		return "table"
+	case scriptFunc:
+		return "function"
+	}
+	return "userdata"
+}
+
+func scriptTruthy(v interface{}) bool {
+	if b, ok := v.(bool); ok {
+		return b
+	}
+	return v != nil
+}
+
+func formatScriptNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// scriptString приводит строку или число к строке
+func scriptString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case float64:
+		return formatScriptNumber(s), true
+	}
+	return "", false
+}
+
+// scriptNumber приводит число или строку с числом к числу
+func scriptNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func scriptEqual(a, b interface{}) bool {
+	if _, ok := a.(scriptFunc); ok {
+		return false
+	}
+	if _, ok := b.(scriptFunc); ok {
+		return false
+	}
+	return a == b
+}
+
+// Исполнение
+
+// scriptCheckEvery - число шагов между проверками времени выполнения.
+// Конкатенация, длина и рост таблицы считаются за несколько шагов по
+// объему работы: на каждые scriptBytesPerStep байт строки или элемент таблицы
+const (
+	scriptCheckEvery   = 1000
+	scriptBytesPerStep = 64
+)
+
+// Размеры строк и таблиц ограничены: удвоение строки исчерпало бы память
+// за несколько шагов, задолго до проверки времени
+const (
+	scriptMaxString = 16 << 20
+	scriptMaxTable  = 1 << 18
+)
+
+type scriptFlow int
+
+const (
+	flowNormal scriptFlow = iota
+	flowBreak
+	flowReturn
+)
+
+type scriptScope struct {
+	vars   map[string]interface{}
+	parent *scriptScope
+}
+
+func (s *scriptScope) lookup(name string) (*scriptScope, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if _, ok := scope.vars[name]; ok {
+			return scope, true
+		}
+	}
+	return nil, false
+}
+
+type scriptVM struct {
+	globals  map[string]interface{}
+	deadline time.Time
+	// steps - шаги после последней проверки времени
+	steps int
+}
+
+// run исполняет скрипт и возвращает значение return (nil без него)
+func (vm *scriptVM) run(body []scriptStmt) (interface{}, error) {
+	_, result, err := vm.execBlock(body, nil)
+	return result, err
+}
+
+func (vm *scriptVM) step() error {
+	return vm.work(1)
+}
+
+// work учитывает n шагов и проверяет время, если с последней проверки
+// накопилось scriptCheckEvery шагов
+func (vm *scriptVM) work(n int) error {
+	vm.steps += n
+	if vm.steps < scriptCheckEvery {
+		return nil
+	}
+	vm.steps = 0
+	if time.Now().After(vm.deadline) {
+		return ErrScriptTimeout
+	}
+	return nil
+}
+
+func (vm *scriptVM) execBlock(body []scriptStmt, parent *scriptScope) (scriptFlow, interface{}, error) {
+	scope := &scriptScope{vars: map[string]interface{}{}, parent: parent}
+	for _, stmt := range body {
+		if err := vm.step(); err != nil {
+			return flowNormal, nil, err
+		}
+		flow, result, err := vm.exec(stmt, scope)
+		if err != nil || flow != flowNormal {
+			return flow, result, err
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (vm *scriptVM) exec(stmt scriptStmt, scope *scriptScope) (scriptFlow, interface{}, error) {
+	switch s := stmt.(type) {
+	case *localStmt:
+		values, err := vm.evalList(s.values, scope)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		for i, name := range s.names {
+			var value interface{}
+			if i < len(values) {
+				value = values[i]
+			}
+			scope.vars[name] = value
+		}
+	case *assignStmt:
+		values, err := vm.evalList(s.values, scope)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		for i, target := range s.targets {
+			var value interface{}
+			if i < len(values) {
+				value = values[i]
+			}
+			if err = vm.assign(target, value, scope); err != nil {
+				return flowNormal, nil, err
+			}
+		}
+	case *callStmt:
+		_, err := vm.eval(s.call, scope)
+		return flowNormal, nil, err
+	case *ifStmt:
+		for i, cond := range s.conds {
+			v, err := vm.eval(cond, scope)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if scriptTruthy(v) {
+				return vm.execBlock(s.blocks[i], scope)
+			}
+		}
+		if s.elseBlock != nil {
+			return vm.execBlock(s.elseBlock, scope)
+		}
+	case *whileStmt:
+		for {
+			if err := vm.step(); err != nil {
+				return flowNormal, nil, err
+			}
+			v, err := vm.eval(s.cond, scope)
+			if err != nil {
+				return flowNormal, nil, err
+			}
+			if !scriptTruthy(v) {
+				break
+			}
+			flow, result, err := vm.execBlock(s.body, scope)
+			if err != nil || flow == flowReturn {
+				return flow, result, err
+			}
+			if flow == flowBreak {
+				break
+			}
+		}
+	case *forStmt:
+		return vm.execFor(s, scope)
+	case *doStmt:
+		return vm.execBlock(s.body, scope)
+	case *returnStmt:
+		if s.value == nil {
+			return flowReturn, nil, nil
+		}
+		v, err := vm.eval(s.value, scope)
+		return flowReturn, v, err
+	case *breakStmt:
+		return flowBreak, nil, nil
+	}
+	return flowNormal, nil, nil
+}
+
+func (vm *scriptVM) execFor(s *forStmt, scope *scriptScope) (scriptFlow, interface{}, error) {
+	bounds := []scriptExpr{s.start, s.stop, s.step}
+	numbers := []float64{0, 0, 1}
+	for i, e := range bounds {
+		if e == nil {
+			continue
+		}
+		v, err := vm.eval(e, scope)
+		if err != nil {
+			return flowNormal, nil, err
+		}
+		n, ok := scriptNumber(v)
+		if !ok {
+			return flowNormal, nil, scriptErrorf(s.line, "'for' limit must be a number")
+		}
+		numbers[i] = n
+	}
+	start, stop, step := numbers[0], numbers[1], numbers[2]
+	if step == 0 {
+		return flowNormal, nil, scriptErrorf(s.line, "'for' step is zero")
+	}
+	for i := start; (step > 0 && i <= stop) || (step < 0 && i >= stop); i += step {
+		if err := vm.step(); err != nil {
+			return flowNormal, nil, err
+		}
+		loop := &scriptScope{vars: map[string]interface{}{s.name: i}, parent: scope}
+		flow, result, err := vm.execBlock(s.body, loop)
+		if err != nil || flow == flowReturn {
+			return flow, result, err
+		}
+		if flow == flowBreak {
+			break
+		}
+	}
+	return flowNormal, nil, nil
+}
+
+func (vm *scriptVM) assign(target scriptExpr, value interface{}, scope *scriptScope) error {
+	switch t := target.(type) {
+	case *nameExpr:
+		owner, ok := scope.lookup(t.name)
+		if !ok {
+			return scriptErrorf(t.line, "attempt to assign to undeclared global '%s', use local", t.name)
+		}
+		owner.vars[t.name] = value
+		return nil
+	case *indexExpr:
+		object, err := vm.eval(t.object, scope)
+		if err != nil {
+			return err
+		}
+		table, ok := object.(*scriptTable)
+		if !ok {
+			return scriptErrorf(t.line, "attempt to index a %s value", scriptType(object))
+		}
+		key, err := vm.eval(t.key, scope)
+		if err != nil {
+			return err
+		}
+		if err = checkTableKey(key, t.line); err != nil {
+			return err
+		}
+		if value != nil && table.get(key) == nil {
+			if len(table.items) >= scriptMaxTable {
+				return scriptErrorf(t.line, "table overflow")
+			}
+			if err = vm.work(1); err != nil {
+				return err
+			}
+		}
+		table.set(key, value)
+	}
+	return nil
+}
+
+func checkTableKey(key interface{}, line int) error {
+	switch k := key.(type) {
+	case nil:
+		return scriptErrorf(line, "table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return scriptErrorf(line, "table index is NaN")
+		}
+	case scriptFunc:
+		return scriptErrorf(line, "table index is a function")
+	}
+	return nil
+}
+
+func (vm *scriptVM) evalList(list []scriptExpr, scope *scriptScope) ([]interface{}, error) {
+	values := make([]interface{}, len(list))
+	for i, e := range list {
+		v, err := vm.eval(e, scope)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+func (vm *scriptVM) eval(e scriptExpr, scope *scriptScope) (interface{}, error) {
+	switch x := e.(type) {
+	case *constExpr:
+		return x.value, nil
+	case *nameExpr:
+		if owner, ok := scope.lookup(x.name); ok {
+			return owner.vars[x.name], nil
+		}
+		return vm.globals[x.name], nil
+	case *indexExpr:
+		object, err := vm.eval(x.object, scope)
+		if err != nil {
+			return nil, err
+		}
+		table, ok := object.(*scriptTable)
+		if !ok {
+			return nil, scriptErrorf(x.line, "attempt to index a %s value", scriptType(object))
+		}
+		key, err := vm.eval(x.key, scope)
+		if err != nil {
+			return nil, err
+		}
+		return table.get(key), nil
+	case *callExpr:
+		fn, err := vm.eval(x.fn, scope)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := fn.(scriptFunc)
+		if !ok {
+			return nil, scriptErrorf(x.line, "attempt to call a %s value", scriptType(fn))
+		}
+		args, err := vm.evalList(x.args, scope)
+		if err != nil {
+			return nil, err
+		}
+		return f(x.line, args)
+	case *tableExpr:
+		table := newScriptTable()
+		n := 0
+		for _, field := range x.fields {
+			value, err := vm.eval(field.value, scope)
+			if err != nil {
+				return nil, err
+			}
+			if err = vm.work(1); err != nil {
+				return nil, err
+			}
+			if field.key == nil {
+				n++
+				table.set(float64(n), value)
+				continue
+			}
+			key, err := vm.eval(field.key, scope)
+			if err != nil {
+				return nil, err
+			}
+			if err = checkTableKey(key, 0); err != nil {
+				return nil, err
+			}
+			table.set(key, value)
+		}
+		return table, nil
+	case *unaryExpr:
+		return vm.evalUnary(x, scope)
+	case *binaryExpr:
+		return vm.evalBinary(x, scope)
+	}
+	return nil, nil
+}
+
+func (vm *scriptVM) evalUnary(x *unaryExpr, scope *scriptScope) (interface{}, error) {
+	v, err := vm.eval(x.operand, scope)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "not":
+		return !scriptTruthy(v), nil
+	case "-":
+		n, ok := scriptNumber(v)
+		if !ok {
+			return nil, scriptErrorf(x.line, "attempt to perform arithmetic on a %s value", scriptType(v))
+		}
+		return -n, nil
+	}
+	switch t := v.(type) {
+	case string:
+		return float64(len(t)), nil
+	case *scriptTable:
+		// длина ищется перебором 1..n
+		n := t.length()
+		if err = vm.work(n); err != nil {
+			return nil, err
+		}
+		return float64(n), nil
+	}
+	return nil, scriptErrorf(x.line, "attempt to get length of a %s value", scriptType(v))
+}
+
+func (vm *scriptVM) evalBinary(x *binaryExpr, scope *scriptScope) (interface{}, error) {
+	left, err := vm.eval(x.left, scope)
+	if err != nil {
+		return nil, err
+	}
+	// and и or не вычисляют правую часть без необходимости
+	switch x.op {
+	case "and":
+		if !scriptTruthy(left) {
+			return left, nil
+		}
+		return vm.eval(x.right, scope)
+	case "or":
+		if scriptTruthy(left) {
+			return left, nil
+		}
+		return vm.eval(x.right, scope)
+	}
+	right, err := vm.eval(x.right, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch x.op {
+	case "==":
+		return scriptEqual(left, right), nil
+	case "~=":
+		return !scriptEqual(left, right), nil
+	case "..":
+		l, okLeft := scriptString(left)
+		r, okRight := scriptString(right)
+		if !okLeft || !okRight {
+			bad := left
+			if okLeft {
+				bad = right
+			}
+			return nil, scriptErrorf(x.line, "attempt to concatenate a %s value", scriptType(bad))
+		}
+		if len(l)+len(r) > scriptMaxString {
+			return nil, scriptErrorf(x.line, "string length overflow")
+		}
+		if err = vm.work((len(l) + len(r)) / scriptBytesPerStep); err != nil {
+			return nil, err
+		}
+		return l + r, nil
+	case "<", "<=", ">", ">=":
+		return compareScriptValues(x, left, right)
+	}
+
+	l, okLeft := scriptNumber(left)
+	r, okRight := scriptNumber(right)
+	if !okLeft || !okRight {
+		bad := left
+		if okLeft {
+			bad = right
+		}
+		return nil, scriptErrorf(x.line, "attempt to perform arithmetic on a %s value", scriptType(bad))
+	}
+	switch x.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	case "%":
+		return l - math.Floor(l/r)*r, nil
+	}
+	return math.Pow(l, r), nil
+}
+
+func compareScriptValues(x *binaryExpr, left, right interface{}) (interface{}, error) {
+	var less, equal bool
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, scriptErrorf(x.line, "attempt to compare number with %s", scriptType(right))
+		}
+		less, equal = l < r, l == r
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, scriptErrorf(x.line, "attempt to compare string with %s", scriptType(right))
+		}
+		less, equal = l < r, l == r
+	default:
+		return nil, scriptErrorf(x.line, "attempt to compare two %s values", scriptType(left))
+	}
+	switch x.op {
+	case "<":
+		return less, nil
+	case "<=":
+		return less || equal, nil
+	case ">":
+		return !less && !equal, nil
+	}
+	return !less, nil
+}
+
+// Встроенные функции
+
+func scriptBuiltins() map[string]interface{} {
+	return map[string]interface{}{
+		"tonumber": scriptFunc(func(line int, args []interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, scriptErrorf(line, "bad argument #1 to 'tonumber' (value expected)")
+			}
+			if n, ok := scriptNumber(args[0]); ok {
+				return n, nil
+			}
+			return nil, nil
+		}),
+		"tostring": scriptFunc(func(line int, args []interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, scriptErrorf(line, "bad argument #1 to 'tostring' (value expected)")
+			}
+			if s, ok := scriptString(args[0]); ok {
+				return s, nil
+			}
+			if b, ok := args[0].(bool); ok {
+				return strconv.FormatBool(b), nil
+			}
+			return scriptType(args[0]), nil
+		}),
+		"type": scriptFunc(func(line int, args []interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return nil, scriptErrorf(line, "bad argument #1 to 'type' (value expected)")
+			}
+			return scriptType(args[0]), nil
+		}),
+	}
+}
+
+// scriptToGo переводит значение скрипта в значение кэша: таблица с ключами
+// 1..n становится []interface{}, остальные таблицы - map[string]interface{},
+// целые числа - int64
+func scriptToGo(v interface{}) interface{} {
+	t, ok := v.(*scriptTable)
+	if !ok {
+		switch d := v.(type) {
+		case scriptFunc:
+			return nil
+		case float64:
+			// целые числа возвращаем как int64, как это делает Counter
+			if d == math.Trunc(d) && math.Abs(d) < 1e18 {
+				return int64(d)
+			}
+		}
+		return v
+	}
+	if n := t.length(); n == len(t.items) {
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = scriptToGo(t.items[float64(i+1)])
+		}
+		return list
+	}
+	result := make(map[string]interface{}, len(t.items))
+	for key, value := range t.items {
+		s, ok := scriptString(key)
+		if !ok {
+			s = fmt.Sprint(key)
+		}
+		result[s] = scriptToGo(value)
+	}
+	return result
+}
+
+// scriptFromGo переводит значение кэша в значение скрипта: числа - в
+// float64, срезы и словари - в таблицы
+func scriptFromGo(v interface{}) interface{} {
+	switch d := v.(type) {
+	case nil, bool, string, float64:
+		return d
+	case []byte:
+		return string(d)
+	case int:
+		return float64(d)
+	case int64:
+		return float64(d)
+	case []interface{}:
+		table := newScriptTable()
+		for i, item := range d {
+			table.set(float64(i+1), scriptFromGo(item))
+		}
+		return table
+	case []string:
+		table := newScriptTable()
+		for i, item := range d {
+			table.set(float64(i+1), item)
+		}
+		return table
+	case map[string]interface{}:
+		table := newScriptTable()
+		keys := make([]string, 0, len(d))
+		for key := range d {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			table.set(key, scriptFromGo(d[key]))
+		}
+		return table
+	case Set:
+		return scriptFromGo(d.Members())
+	}
+	if n, err := toFloat(v); err == nil {
+		return n
+	}
+	// прочие срезы и словари приводим к общему виду через JSON
+	var generic interface{}
+	encoded, err := json.Marshal(v)
+	if err != nil || json.Unmarshal(encoded, &generic) != nil {
+		return nil
+	}
+	return scriptFromGo(generic)
+}
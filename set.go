@@ -0,0 +1,385 @@
+/*
+    Множества строк (тип SET)
+*/
+
+package db
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Set хранит уникальные строки. В JSON представляется отсортированным массивом
+type Set map[string]struct{}
+
+func NewSet(members ...string) Set {
+	s := make(Set, len(members))
+	for _, m := range members {
+		s[m] = struct{}{}
+	}
+	return s
+}
+
+func (s Set) Members() []string {
+	members := make([]string, 0, len(s))
+	for m := range s {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (s Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Members())
+}
+
+func (s Set) copy() Set {
+	result := make(Set, len(s))
+	for m := range s {
+		result[m] = struct{}{}
+	}
+	return result
+}
+
+func newSetFromJSON(data interface{}) (Set, error) {
+	items, ok := data.([]interface{})
+	if !ok {
+		return nil, ErrConversionError
+	}
+	s := make(Set, len(items))
+	for _, item := range items {
+		m, ok := item.(string)
+		if !ok {
+			return nil, ErrConversionError
+		}
+		s[m] = struct{}{}
+	}
+	return s, nil
+}
+
+// SetOperator изменяет множества атомарно под блокировкой шарда.
+// Операции над несколькими ключами читают каждый ключ под блокировкой его шарда
+type SetOperator interface {
+	SAdd(key string, members ...string) (int, error)
+	SRem(key string, members ...string) (int, error)
+	SMembers(key string) ([]string, error)
+	SIsMember(key string, member string) (bool, error)
+	SInter(keys ...string) ([]string, error)
+	SUnion(keys ...string) ([]string, error)
+}
+
+func asSetOperator(c Cache) (SetOperator, error) {
+	so, ok := c.(SetOperator)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return so, nil
+}
+
+func (s *sharder) SAdd(key string, members ...string) (added int, err error) {
+	record := operation{Type: "SAdd", Key: key, Value: members}
+	_, err = s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		result := &Value{Type: SET}
+		set := Set{}
+		if current != nil {
+			if current.Type != SET {
+				return nil, ErrWrongType
+			}
+			set = current.Data.(Set).copy()
+			result.Expires = current.Expires
+		}
+		for _, m := range members {
+			if _, ok := set[m]; !ok {
+				set[m] = struct{}{}
+				added++
+			}
+		}
+		if added == 0 {
+			return current, nil
+		}
+		result.Data = set
+		return result, nil
+	})
+	return
+}
+
+func (s *sharder) SRem(key string, members ...string) (removed int, err error) {
+	record := operation{Type: "SRem", Key: key, Value: members}
+	_, err = s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		if current == nil {
+			return nil, nil
+		}
+		if current.Type != SET {
+			return nil, ErrWrongType
+		}
+		set := current.Data.(Set).copy()
+		for _, m := range members {
+			if _, ok := set[m]; ok {
+				delete(set, m)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return current, nil
+		}
+		if len(set) == 0 {
+			return nil, nil
+		}
+		return &Value{Type: SET, Data: set, Expires: current.Expires}, nil
+	})
+	return
+}
+
+// members читает множество по ключу. Отсутствующий ключ - пустое множество
+func (s *sharder) members(key string) (set Set, err error) {
+	err = s.view(key, func(value *Value) error {
+		if value.Type != SET {
+			return ErrWrongType
+		}
+		set = value.Data.(Set)
+		return nil
+	})
+	if err == ErrKeyNotFound {
+		return Set{}, nil
+	}
+	return
+}
+
+func (s *sharder) SMembers(key string) ([]string, error) {
+	set, err := s.members(key)
+	if err != nil {
+		return nil, err
+	}
+	return set.Members(), nil
+}
+
+func (s *sharder) SIsMember(key string, member string) (bool, error) {
+	set, err := s.members(key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := set[member]
+	return ok, nil
+}
+
+func (s *sharder) SInter(keys ...string) ([]string, error) {
+	if len(keys) == 0 {
+		return []string{}, nil
+	}
+	result, err := s.members(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys[1:] {
+		set, err := s.members(key)
+		if err != nil {
+			return nil, err
+		}
+		inter := Set{}
+		for m := range result {
+			if _, ok := set[m]; ok {
+				inter[m] = struct{}{}
+			}
+		}
+		result = inter
+	}
+	return result.Members(), nil
+}
+
+func (s *sharder) SUnion(keys ...string) ([]string, error) {
+	result := Set{}
+	for _, key := range keys {
+		set, err := s.members(key)
+		if err != nil {
+			return nil, err
+		}
+		for m := range set {
+			result[m] = struct{}{}
+		}
+	}
+	return result.Members(), nil
+}
+
+func (l *logger) SAdd(key string, members ...string) (int, error) {
+	defer l.peekIntoPanic("sadd", key, members)
+	so, err := asSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := so.SAdd(key, members...)
+	l.infoLog.Println("sadd", key, members, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) SRem(key string, members ...string) (int, error) {
+	defer l.peekIntoPanic("srem", key, members)
+	so, err := asSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := so.SRem(key, members...)
+	l.infoLog.Println("srem", key, members, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) SMembers(key string) ([]string, error) {
+	defer l.peekIntoPanic("smembers", key)
+	so, err := asSetOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	members, err := so.SMembers(key)
+	l.infoLog.Println("smembers", key, "=>", members, err)
+	return members, err
+}
+
+func (l *logger) SIsMember(key string, member string) (bool, error) {
+	defer l.peekIntoPanic("sismember", key, member)
+	so, err := asSetOperator(l.Cache)
+	if err != nil {
+		return false, err
+	}
+	ok, err := so.SIsMember(key, member)
+	l.infoLog.Println("sismember", key, member, "=>", ok, err)
+	return ok, err
+}
+
+func (l *logger) SInter(keys ...string) ([]string, error) {
+	defer l.peekIntoPanic("sinter", keys)
+	so, err := asSetOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	members, err := so.SInter(keys...)
+	l.infoLog.Println("sinter", keys, "=>", members, err)
+	return members, err
+}
+
+func (l *logger) SUnion(keys ...string) ([]string, error) {
+	defer l.peekIntoPanic("sunion", keys)
+	so, err := asSetOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	members, err := so.SUnion(keys...)
+	l.infoLog.Println("sunion", keys, "=>", members, err)
+	return members, err
+}
+
+func (p *persister) SAdd(key string, members ...string) (int, error) {
+	so, err := asSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return so.SAdd(key, members...)
+}
+
+func (p *persister) SRem(key string, members ...string) (int, error) {
+	so, err := asSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return so.SRem(key, members...)
+}
+
+func (p *persister) SMembers(key string) ([]string, error) {
+	so, err := asSetOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.SMembers(key)
+}
+
+func (p *persister) SIsMember(key string, member string) (bool, error) {
+	so, err := asSetOperator(p.Cache)
+	if err != nil {
+		return false, err
+	}
+	return so.SIsMember(key, member)
+}
+
+func (p *persister) SInter(keys ...string) ([]string, error) {
+	so, err := asSetOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.SInter(keys...)
+}
+
+func (p *persister) SUnion(keys ...string) ([]string, error) {
+	so, err := asSetOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.SUnion(keys...)
+}
+
+func (o *operation) executeSet(target Cache) error {
+	so, err := asSetOperator(target)
+	if err != nil {
+		return err
+	}
+	members, err := newSetFromJSON(o.Value)
+	if err != nil {
+		return err
+	}
+	switch o.Type {
+	case "SAdd":
+		_, err = so.SAdd(o.Key, members.Members()...)
+	case "SRem":
+		_, err = so.SRem(o.Key, members.Members()...)
+	}
+	return err
+}
+
+func (t *ttl) SAdd(key string, members ...string) (int, error) {
+	so, err := asSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := so.SAdd(key, members...)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) SRem(key string, members ...string) (int, error) {
+	so, err := asSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return so.SRem(key, members...)
+}
+
+func (t *ttl) SMembers(key string) ([]string, error) {
+	so, err := asSetOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.SMembers(key)
+}
+
+func (t *ttl) SIsMember(key string, member string) (bool, error) {
+	so, err := asSetOperator(t.Cache)
+	if err != nil {
+		return false, err
+	}
+	return so.SIsMember(key, member)
+}
+
+func (t *ttl) SInter(keys ...string) ([]string, error) {
+	so, err := asSetOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.SInter(keys...)
+}
+
+func (t *ttl) SUnion(keys ...string) ([]string, error) {
+	so, err := asSetOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.SUnion(keys...)
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSet_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 5, nil)
+	so := c.(SetOperator)
+
+	n, err := so.SAdd("a", "x", "y", "z", "x")
+	if err != nil || n != 3 {
+		t.Errorf("SAdd: expected 3 added, got %v, err %v", n, err)
+	}
+	so.SAdd("b", "y", "z", "w")
+	so.SAdd("c", "z")
+
+	value, _ := c.Get("a")
+	if value.Type != SET {
+		t.Errorf("SAdd should create value of type SET, got %v", value.Type)
+	}
+
+	members, _ := so.SMembers("a")
+	if !reflect.DeepEqual(members, []string{"x", "y", "z"}) {
+		t.Errorf("SMembers: got %v", members)
+	}
+	ok, _ := so.SIsMember("a", "x")
+	if !ok {
+		t.Errorf("SIsMember: x should be a member of a")
+	}
+	ok, _ = so.SIsMember("missing", "x")
+	if ok {
+		t.Errorf("SIsMember: missing key should be an empty set")
+	}
+
+	members, _ = so.SInter("a", "b", "c")
+	if !reflect.DeepEqual(members, []string{"z"}) {
+		t.Errorf("SInter: got %v", members)
+	}
+	members, _ = so.SInter("a", "missing")
+	if !reflect.DeepEqual(members, []string{}) {
+		t.Errorf("SInter with missing key: got %v", members)
+	}
+	members, _ = so.SUnion("a", "b", "missing")
+	if !reflect.DeepEqual(members, []string{"w", "x", "y", "z"}) {
+		t.Errorf("SUnion: got %v", members)
+	}
+
+	n, _ = so.SRem("c", "z", "q")
+	if n != 1 {
+		t.Errorf("SRem: expected 1 removed, got %v", n)
+	}
+	if _, err = c.Get("c"); err != ErrKeyNotFound {
+		t.Errorf("SRem of the last member should remove key, got %v", err)
+	}
+
+	c.Set("list", []interface{}{1}, 0)
+	if _, err = so.SInter("a", "list"); err != ErrWrongType {
+		t.Errorf("SInter with list: expected %v, got %v", ErrWrongType, err)
+	}
+}
+
+func TestSet_JSON(t *testing.T) {
+	value := &Value{Type: SET, Data: NewSet("b", "a")}
+	encoded, _ := json.Marshal(value)
+	if string(encoded) != `{"type":3,"data":["a","b"]}` {
+		t.Errorf("unexpected JSON for set value: %s", encoded)
+	}
+	decoded := &Value{}
+	if err := json.Unmarshal(encoded, decoded); err != nil || !reflect.DeepEqual(decoded, value) {
+		t.Errorf("decoded set value %v does not match %v, err %v", decoded, value, err)
+	}
+}
+
+func TestSet_Persist(t *testing.T) {
+	sample := `{"Type":"SAdd","k":"s","v":["a","b"],"e":0}
+{"Type":"SRem","k":"s","v":["a"],"e":0}
+{"Type":"Set","k":"copy","v":["x"],"e":0,"t":3}
+`
+	restored := checkPersist(t, 1, sample, func(p *persister) {
+		p.SAdd("s", "a", "b")
+		p.SRem("s", "a")
+		p.SRem("s", "missing")
+		p.Set("copy", NewSet("x"), 0)
+	})
+	for key, expected := range map[string]Set{"s": NewSet("b"), "copy": NewSet("x")} {
+		value, err := restored.Get(key)
+		if err != nil || value.Type != SET || !reflect.DeepEqual(value.Data, expected) {
+			t.Errorf("TestSet_Persist restore %v: got %v, err %v", key, value, err)
+		}
+	}
+}
@@ -17,6 +17,17 @@ type sharder struct {
 	needLock bool
 	shards   []Cache
 	locks    []sync.RWMutex
+
+	// waiters будит блокирующие чтения ключей
+	waiters *keyWaiters
+	// poppers - очереди блокирующих извлечений из списков
+	poppers *keyQueues
+	// events - события изменения ключей
+	events *PubSub
+	// scripts - кэш скриптов EVAL
+	scripts *scriptCache
+	// journal пишет изменения в журнал persister под блокировкой шарда
+	journal func(op operation)
 }
 
 
@@ -35,6 +46,10 @@ func Shard(function shardFunction, needLock bool, caches ...Cache) (s Cache, err
 		needLock: needLock,
 		locks:    make([]sync.RWMutex, n),
 		fn:       function,
+		waiters:  newKeyWaiters(),
+		poppers:  newKeyQueues(),
+		events:   NewPubSub(),
+		scripts:  newScriptCache(),
 	}
 	return
 }
@@ -74,9 +89,12 @@ func (s *sharder) Set(key string, value interface{}, expire time.Duration) (*Val
 	i := s.getTargetShardIdx(key)
 	if s.needLock {
 		s.locks[i].Lock()
-		defer s.locks[i].Unlock()
 	}
-	return s.shards[i].Set(key, value, expire)
+	result, err := s.setKey(s.shards[i], key, value, expire, nil)
+	if s.needLock {
+		s.locks[i].Unlock()
+	}
+	return result, err
 }
 
 func (s *sharder) Remove(key string) error {
@@ -85,7 +103,8 @@ func (s *sharder) Remove(key string) error {
 		s.locks[i].Lock()
 		defer s.locks[i].Unlock()
 	}
-	return s.shards[i].Remove(key)
+	_, err := s.removeKey(s.shards[i], key, EventDel, nil)
+	return err
 }
 
 func (s *sharder) Get(key string) (*Value, error) {
@@ -105,6 +124,41 @@ func (s *sharder) GetAtIndex(key string, subkey interface{}) (interface{}, error
 	}
 	return s.shards[i].GetAtIndex(key, subkey)
 }
+
+// update атомарно изменяет значение под блокировкой шарда и записывает
+// новое значение в журнал
+func (s *sharder) update(key string, fn updateFunc) (*Value, error) {
+	return s.updateLogged(key, nil, fn)
+}
+
+// updateLogged изменяет значение как update, но пишет в журнал записи record
+func (s *sharder) updateLogged(key string, record journalFunc, fn updateFunc) (*Value, error) {
+	i := s.getTargetShardIdx(key)
+	target, ok := s.shards[i].(updater)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	if s.needLock {
+		s.locks[i].Lock()
+		defer s.locks[i].Unlock()
+	}
+	return s.updateShard(target, key, fn, record)
+}
+
+// view читает значение под блокировкой шарда на чтение
+func (s *sharder) view(key string, fn func(value *Value) error) error {
+	i := s.getTargetShardIdx(key)
+	if s.needLock {
+		s.locks[i].RLock()
+		defer s.locks[i].RUnlock()
+	}
+	value, err := s.shards[i].Get(key)
+	if err != nil {
+		return err
+	}
+	return fn(value)
+}
+
 func (s *sharder) Keys() ([]string, error) {
 	n := len(s.shards) - 1
 	resultCh := make(chan []string, n+1)
@@ -0,0 +1,205 @@
+/*
+    Skip list со счетчиками пролетов (span) для сортированных множеств:
+    вставка, удаление и поиск по рангу за O(log n)
+*/
+
+package db
+
+import (
+	"math/rand"
+)
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+type skipLevel struct {
+	forward *skipNode
+	span    int
+}
+
+type skipNode struct {
+	member   string
+	score    float64
+	backward *skipNode
+	level    []skipLevel
+}
+
+type skipList struct {
+	head   *skipNode
+	tail   *skipNode
+	length int
+	level  int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{level: make([]skipLevel, skipListMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// before сравнивает элементы по счету, при равенстве - по имени
+func before(score1 float64, member1 string, score2 float64, member2 string) bool {
+	return score1 < score2 || (score1 == score2 && member1 < member2)
+}
+
+func (sl *skipList) insert(score float64, member string) {
+	var update [skipListMaxLevel]*skipNode
+	var rank [skipListMaxLevel]int
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for next := x.level[i].forward; next != nil && before(next.score, next.member, score, member); next = x.level[i].forward {
+			rank[i] += x.level[i].span
+			x = next
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.head
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skipNode{member: member, score: score, level: make([]skipLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.head {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+func (sl *skipList) remove(score float64, member string) bool {
+	var update [skipListMaxLevel]*skipNode
+
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && before(next.score, next.member, score, member); next = x.level[i].forward {
+			x = next
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.head.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	return true
+}
+
+// rank возвращает позицию элемента, начиная с 1, или 0, если его нет
+func (sl *skipList) rank(score float64, member string) int {
+	rank := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && !before(score, member, next.score, next.member); next = x.level[i].forward {
+			rank += x.level[i].span
+			x = next
+		}
+		if x != sl.head && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank возвращает элемент на позиции rank (начиная с 1)
+func (sl *skipList) byRank(rank int) *skipNode {
+	traversed := 0
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// firstFrom возвращает первый элемент со счетом не меньше min
+func (sl *skipList) firstFrom(min float64) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score < min {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// firstAfter возвращает первый элемент после (score, member)
+func (sl *skipList) firstAfter(score float64, member string) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for next := x.level[i].forward; next != nil && !before(score, member, next.score, next.member); next = x.level[i].forward {
+			x = next
+		}
+	}
+	return x.level[0].forward
+}
+
+// lastUpTo возвращает последний элемент со счетом не больше max
+func (sl *skipList) lastUpTo(max float64) *skipNode {
+	x := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score <= max {
+			x = x.level[i].forward
+		}
+	}
+	if x == sl.head {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,226 @@
+/*
+    Хранилище одного шарда. Не потокобезопасно - блокировки выполняет sharder
+*/
+
+package db
+
+import (
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// updateFunc получает текущее значение (nil, если ключа нет) и возвращает
+// новое. Возврат nil удаляет ключ, возврат current означает, что ничего не
+// изменилось: версия значения сохраняется
+type updateFunc func(current *Value) (*Value, error)
+
+// updater реализуется хранилищами, которые умеют атомарно изменять значение
+type updater interface {
+	Update(key string, fn updateFunc) (*Value, error)
+}
+
+type store struct {
+	items map[string]*Value
+	// order - ключи по возрастанию с нулевым счетом, по ним SCAN продолжает
+	// обход с последнего возвращенного ключа
+	order *skipList
+	// version - последняя выданная версия. Начинается с текущего времени,
+	// чтобы версии не повторялись после перезапуска
+	version uint64
+}
+
+func newStore() *store {
+	return &store{
+		items:   make(map[string]*Value),
+		order:   newSkipList(),
+		version: uint64(time.Now().UnixNano()),
+	}
+}
+
+func (s *store) nextVersion() uint64 {
+	s.version++
+	return s.version
+}
+
+// put сохраняет значение ключа и добавляет новый ключ в order
+func (s *store) put(key string, v *Value) {
+	if _, ok := s.items[key]; !ok {
+		s.order.insert(0, key)
+	}
+	s.items[key] = v
+}
+
+// drop удаляет ключ из items и order
+func (s *store) drop(key string) {
+	if _, ok := s.items[key]; ok {
+		delete(s.items, key)
+		s.order.remove(0, key)
+	}
+}
+
+func typeOf(value interface{}) (DataType, error) {
+	if value == nil {
+		return STRING, ErrInvalidValueType
+	}
+	switch value.(type) {
+	case []byte:
+		return BYTES, nil
+	case Set:
+		return SET, nil
+	case *SortedSet:
+		return ZSET, nil
+	case HyperLogLog:
+		return HLL, nil
+	case *Stream:
+		return STREAM, nil
+	}
+	switch reflect.TypeOf(value).Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return STRING, nil
+	case reflect.Slice, reflect.Array:
+		return LIST, nil
+	case reflect.Map:
+		return MAP, nil
+	}
+	return STRING, ErrInvalidValueType
+}
+
+// lookup возвращает значение по ключу, не отдавая просроченные.
+// Get вызывается под блокировкой на чтение, поэтому ключ здесь не удаляется
+func (s *store) lookup(key string) *Value {
+	v, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	if v.Expires != 0 && v.Expires < time.Now().UnixNano() {
+		return nil
+	}
+	return v
+}
+
+// newValue оборачивает данные в Value с временем истечения через expire
+func newValue(value interface{}, expire time.Duration) (*Value, error) {
+	if expire < 0 {
+		return nil, ErrInvalidTTL
+	}
+	t, err := typeOf(value)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := value.([]byte); ok {
+		// вызывающий код может переиспользовать буфер
+		value = append([]byte{}, b...)
+	}
+	v := &Value{Type: t, Data: value}
+	if expire > 0 {
+		v.Expires = time.Now().Add(expire).UnixNano()
+	}
+	return v, nil
+}
+
+func (s *store) Set(key string, value interface{}, expire time.Duration) (*Value, error) {
+	v, err := newValue(value, expire)
+	if err != nil {
+		return nil, err
+	}
+	v.Version = s.nextVersion()
+	s.put(key, v)
+	return v, nil
+}
+
+func (s *store) Get(key string) (*Value, error) {
+	v := s.lookup(key)
+	if v == nil {
+		return nil, ErrKeyNotFound
+	}
+	return v, nil
+}
+
+func (s *store) Remove(key string) error {
+	s.drop(key)
+	return nil
+}
+
+func (s *store) Keys() ([]string, error) {
+	keys := make([]string, 0, len(s.items))
+	for k := range s.items {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *store) GetAtIndex(key string, index interface{}) (interface{}, error) {
+	v, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return itemAtIndex(v, index)
+}
+
+// Update вызывает fn с текущим значением и сохраняет результат.
+// Значения не изменяются на месте: их могут читать вне блокировки шарда
+func (s *store) Update(key string, fn updateFunc) (*Value, error) {
+	current := s.lookup(key)
+	next, err := fn(current)
+	if err != nil {
+		return nil, err
+	}
+	if next == nil {
+		s.drop(key)
+		return nil, nil
+	}
+	if next == current {
+		return current, nil
+	}
+	next.Version = s.nextVersion()
+	s.put(key, next)
+	return next, nil
+}
+
+func itemAtIndex(v *Value, index interface{}) (interface{}, error) {
+	data := reflect.ValueOf(v.Data)
+	switch v.Type {
+	case LIST:
+		i, err := listIndex(index)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= data.Len() {
+			return nil, ErrIndexAccess
+		}
+		return data.Index(i).Interface(), nil
+	case MAP:
+		k := reflect.ValueOf(index)
+		keyType := data.Type().Key()
+		if k.Type() != keyType {
+			if !k.Type().ConvertibleTo(keyType) {
+				return nil, ErrIllegalIndexType
+			}
+			k = k.Convert(keyType)
+		}
+		item := data.MapIndex(k)
+		if !item.IsValid() {
+			return nil, ErrIndexAccess
+		}
+		return item.Interface(), nil
+	}
+	return nil, ErrIndexAccess
+}
+
+func listIndex(index interface{}) (int, error) {
+	switch idx := index.(type) {
+	case int:
+		return idx, nil
+	case string:
+		i, err := strconv.Atoi(idx)
+		if err != nil {
+			return 0, ErrNonIntegerSubkey
+		}
+		return i, nil
+	}
+	return 0, ErrIllegalIndexType
+}
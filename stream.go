@@ -0,0 +1,800 @@
+/*
+    Потоки (тип STREAM): журнал записей с возрастающими идентификаторами,
+    блокирующее чтение и группы потребителей (XADD, XRANGE, XREAD, XGROUP,
+    XREADGROUP, XACK, XPENDING)
+*/
+
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrStreamID         = errors.New("invalid stream ID specified as stream command argument")
+	ErrStreamIDTooSmall = errors.New("the ID specified in XADD is equal or smaller than the target stream top item")
+	ErrStreamFields     = errors.New("stream entry should have at least one field")
+	ErrNoGroup          = errors.New("no such key or consumer group")
+	ErrGroupExists      = errors.New("consumer group name already exists")
+	ErrNoConsumer       = errors.New("consumer name should not be empty")
+)
+
+// StreamID - идентификатор записи: время добавления в миллисекундах и номер
+// записи внутри миллисекунды. В JSON представляется строкой "ms-seq"
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+func (id StreamID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *StreamID) UnmarshalText(text []byte) (err error) {
+	*id, err = ParseStreamID(string(text))
+	return
+}
+
+// ParseStreamID разбирает идентификатор "ms-seq" или "ms" (seq = 0)
+func ParseStreamID(in string) (StreamID, error) {
+	return parseStreamID(in, 0)
+}
+
+// parseStreamID подставляет defaultSeq, если номер записи не указан
+func parseStreamID(in string, defaultSeq uint64) (id StreamID, err error) {
+	ms := in
+	id.Seq = defaultSeq
+	if i := strings.IndexByte(in, '-'); i >= 0 {
+		ms = in[:i]
+		if id.Seq, err = strconv.ParseUint(in[i+1:], 10, 64); err != nil {
+			return StreamID{}, ErrStreamID
+		}
+	}
+	if id.Ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return StreamID{}, ErrStreamID
+	}
+	return id, nil
+}
+
+// parseRangeStart и parseRangeEnd разбирают границы XRANGE: "-" и "+"
+// означают минимальный и максимальный идентификаторы
+func parseRangeStart(in string) (StreamID, error) {
+	if in == "-" || in == "" {
+		return StreamID{}, nil
+	}
+	return parseStreamID(in, 0)
+}
+
+func parseRangeEnd(in string) (StreamID, error) {
+	if in == "+" || in == "" {
+		return StreamID{math.MaxUint64, math.MaxUint64}, nil
+	}
+	return parseStreamID(in, math.MaxUint64)
+}
+
+// StreamEntry - запись потока. Значения полей - произвольный JSON
+type StreamEntry struct {
+	ID     StreamID               `json:"id"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// PendingEntry - запись, доставленная потребителю группы и еще не
+// подтвержденная XAck
+type PendingEntry struct {
+	ID       StreamID `json:"id"`
+	Consumer string   `json:"consumer"`
+	// Delivered - время последней доставки в наносекундах
+	Delivered int64 `json:"delivered"`
+	Count     int   `json:"count"`
+}
+
+// ConsumerGroup распределяет записи между потребителями: каждая новая
+// запись доставляется одному потребителю группы
+type ConsumerGroup struct {
+	LastDelivered StreamID                  `json:"last_delivered"`
+	Pending       map[StreamID]PendingEntry `json:"pending,omitempty"`
+}
+
+// Stream хранит записи по возрастанию идентификаторов. Как и остальные
+// значения, поток не изменяется после записи в хранилище: операции создают
+// новую версию
+type Stream struct {
+	Entries []StreamEntry            `json:"entries"`
+	LastID  StreamID                 `json:"last_id"`
+	Groups  map[string]ConsumerGroup `json:"groups,omitempty"`
+
+	// tail - длина общего с другими версиями массива записей. Дописывать в
+	// массив на месте может только последняя версия, остальные копируют его
+	tail *int
+}
+
+func newStreamFromJSON(data interface{}) (*Stream, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, ErrConversionError
+	}
+	st := &Stream{}
+	if err = json.Unmarshal(encoded, st); err != nil {
+		return nil, ErrConversionError
+	}
+	return st, nil
+}
+
+// withEntry возвращает новую версию потока с добавленной записью
+func (st *Stream) withEntry(entry StreamEntry) *Stream {
+	next := &Stream{LastID: entry.ID, Groups: st.Groups}
+	n := len(st.Entries)
+	if st.tail != nil && *st.tail == n {
+		next.Entries, next.tail = append(st.Entries, entry), st.tail
+	} else {
+		next.Entries = append(append(make([]StreamEntry, 0, n+1), st.Entries...), entry)
+		next.tail = new(int)
+	}
+	*next.tail = len(next.Entries)
+	return next
+}
+
+// withGroup возвращает новую версию потока с замененной группой
+func (st *Stream) withGroup(name string, group ConsumerGroup) *Stream {
+	groups := make(map[string]ConsumerGroup, len(st.Groups)+1)
+	for k, g := range st.Groups {
+		groups[k] = g
+	}
+	groups[name] = group
+	return &Stream{Entries: st.Entries, LastID: st.LastID, Groups: groups, tail: st.tail}
+}
+
+func (st *Stream) copy() *Stream {
+	result := &Stream{Entries: append([]StreamEntry{}, st.Entries...), LastID: st.LastID}
+	for name, g := range st.Groups {
+		result = result.withGroup(name, g.copy())
+	}
+	return result
+}
+
+func (g ConsumerGroup) copy() ConsumerGroup {
+	pending := make(map[StreamID]PendingEntry, len(g.Pending))
+	for id, p := range g.Pending {
+		pending[id] = p
+	}
+	return ConsumerGroup{LastDelivered: g.LastDelivered, Pending: pending}
+}
+
+// between возвращает не более count записей с идентификаторами от start до
+// end включительно. count <= 0 - без ограничения
+func (st *Stream) between(start, end StreamID, count int) []StreamEntry {
+	i := sort.Search(len(st.Entries), func(i int) bool { return !st.Entries[i].ID.Less(start) })
+	result := []StreamEntry{}
+	for ; i < len(st.Entries) && !end.Less(st.Entries[i].ID); i++ {
+		if count > 0 && len(result) == count {
+			break
+		}
+		result = append(result, st.Entries[i])
+	}
+	return result
+}
+
+// after возвращает записи с идентификаторами больше id
+func (st *Stream) after(id StreamID, count int) []StreamEntry {
+	if id.Seq == math.MaxUint64 {
+		if id.Ms == math.MaxUint64 {
+			return []StreamEntry{}
+		}
+		return st.between(StreamID{id.Ms + 1, 0}, StreamID{math.MaxUint64, math.MaxUint64}, count)
+	}
+	return st.between(StreamID{id.Ms, id.Seq + 1}, StreamID{math.MaxUint64, math.MaxUint64}, count)
+}
+
+// nextID вычисляет идентификатор новой записи. "*" - автоматический
+// идентификатор по текущему времени, "ms-*" - следующий номер в миллисекунде
+func (st *Stream) nextID(in string) (StreamID, error) {
+	last := st.LastID
+	var id StreamID
+	switch {
+	case in == "*" || in == "":
+		id = StreamID{Ms: uint64(time.Now().UnixNano() / int64(time.Millisecond))}
+		if id.Ms <= last.Ms {
+			if last.Seq == math.MaxUint64 {
+				return StreamID{}, ErrStreamIDTooSmall
+			}
+			id = StreamID{last.Ms, last.Seq + 1}
+		}
+		return id, nil
+	case strings.HasSuffix(in, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(in, "-*"), 10, 64)
+		if err != nil {
+			return StreamID{}, ErrStreamID
+		}
+		id = StreamID{Ms: ms}
+		if ms == last.Ms {
+			if last.Seq == math.MaxUint64 {
+				return StreamID{}, ErrStreamIDTooSmall
+			}
+			id.Seq = last.Seq + 1
+		}
+	default:
+		var err error
+		if id, err = ParseStreamID(in); err != nil {
+			return StreamID{}, err
+		}
+	}
+	if !last.Less(id) {
+		return StreamID{}, ErrStreamIDTooSmall
+	}
+	return id, nil
+}
+
+// StreamOperator работает со значениями типа STREAM.
+// Блокирующие чтения ждут новых записей не дольше block; нулевой block -
+// без ожидания. По истечении block возвращается пустой список без ошибки
+type StreamOperator interface {
+	// XAdd добавляет запись и возвращает ее идентификатор. id: "*" или
+	// пустая строка - по текущему времени, "ms-*" или явный "ms-seq"
+	XAdd(key string, id string, fields map[string]interface{}) (StreamID, error)
+	XLen(key string) (int, error)
+	// XRange возвращает записи от start до end включительно, "-" и "+" -
+	// начало и конец потока. count <= 0 - без ограничения
+	XRange(key string, start string, end string, count int) ([]StreamEntry, error)
+	// XRead возвращает записи после after, "$" - только новые записи
+	XRead(ctx context.Context, key string, after string, count int, block time.Duration) ([]StreamEntry, error)
+
+	// XGroupCreate создает группу, которой будут доставляться записи после
+	// start ("$" - только новые). mkStream создает пустой поток, если его нет
+	XGroupCreate(key string, group string, start string, mkStream bool) error
+	// XReadGroup с after ">" доставляет потребителю новые записи группы и
+	// добавляет их в список ожидающих подтверждения. С другим after
+	// возвращает уже доставленные потребителю и неподтвержденные записи
+	XReadGroup(ctx context.Context, key string, group string, consumer string, after string, count int, block time.Duration) ([]StreamEntry, error)
+	// XAck подтверждает обработку записей и возвращает число подтвержденных
+	XAck(key string, group string, ids ...string) (int, error)
+	XPending(key string, group string) ([]PendingEntry, error)
+}
+
+func asStreamOperator(c Cache) (StreamOperator, error) {
+	so, ok := c.(StreamOperator)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return so, nil
+}
+
+func streamOf(value *Value) (*Stream, error) {
+	if value.Type != STREAM {
+		return nil, ErrWrongType
+	}
+	return value.Data.(*Stream), nil
+}
+
+func (s *sharder) XAdd(key string, id string, fields map[string]interface{}) (added StreamID, err error) {
+	if len(fields) == 0 {
+		return StreamID{}, ErrStreamFields
+	}
+	record := func(*Value) []operation {
+		return []operation{{Type: "XAdd", Key: key, Value: StreamEntry{ID: added, Fields: fields}}}
+	}
+	_, err = s.updateLogged(key, record, func(current *Value) (*Value, error) {
+		st, result := &Stream{}, &Value{Type: STREAM}
+		if current != nil {
+			var err error
+			if st, err = streamOf(current); err != nil {
+				return nil, err
+			}
+			result.Expires = current.Expires
+		}
+		var err error
+		if added, err = st.nextID(id); err != nil {
+			return nil, err
+		}
+		result.Data = st.withEntry(StreamEntry{ID: added, Fields: fields})
+		return result, nil
+	})
+	if err != nil {
+		return StreamID{}, err
+	}
+	s.waiters.notify(key)
+	return added, nil
+}
+
+// stream читает поток по ключу. Отсутствующий ключ - пустой поток
+func (s *sharder) stream(key string, fn func(st *Stream) error) error {
+	err := s.view(key, func(value *Value) error {
+		st, err := streamOf(value)
+		if err != nil {
+			return err
+		}
+		return fn(st)
+	})
+	if err == ErrKeyNotFound {
+		return fn(&Stream{})
+	}
+	return err
+}
+
+func (s *sharder) XLen(key string) (n int, err error) {
+	err = s.stream(key, func(st *Stream) error {
+		n = len(st.Entries)
+		return nil
+	})
+	return
+}
+
+func (s *sharder) XRange(key string, start string, end string, count int) (entries []StreamEntry, err error) {
+	from, err := parseRangeStart(start)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseRangeEnd(end)
+	if err != nil {
+		return nil, err
+	}
+	err = s.stream(key, func(st *Stream) error {
+		entries = st.between(from, to, count)
+		return nil
+	})
+	return
+}
+
+func (s *sharder) XRead(ctx context.Context, key string, after string, count int, block time.Duration) (entries []StreamEntry, err error) {
+	var id StreamID
+	if after == "$" {
+		err = s.stream(key, func(st *Stream) error {
+			id = st.LastID
+			return nil
+		})
+	} else if after != "" {
+		id, err = ParseStreamID(after)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = s.await(ctx, key, block, func() (bool, error) {
+		err := s.stream(key, func(st *Stream) error {
+			entries = st.after(id, count)
+			return nil
+		})
+		return len(entries) > 0, err
+	})
+	return
+}
+
+func (s *sharder) XGroupCreate(key string, group string, start string, mkStream bool) error {
+	record := operation{Type: "XGroupCreate", Key: key, Field: group, Value: start}
+	_, err := s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		st, result := &Stream{}, &Value{Type: STREAM}
+		if current != nil {
+			var err error
+			if st, err = streamOf(current); err != nil {
+				return nil, err
+			}
+			result.Expires = current.Expires
+		} else if !mkStream {
+			return nil, ErrKeyNotFound
+		}
+		if _, ok := st.Groups[group]; ok {
+			return nil, ErrGroupExists
+		}
+		g := ConsumerGroup{LastDelivered: st.LastID, Pending: map[StreamID]PendingEntry{}}
+		if start != "$" {
+			var err error
+			if g.LastDelivered, err = ParseStreamID(start); err != nil {
+				return nil, err
+			}
+		}
+		result.Data = st.withGroup(group, g)
+		return result, nil
+	})
+	return err
+}
+
+// consumerGroup находит группу потока или возвращает ErrNoGroup
+func consumerGroup(value *Value, group string) (*Stream, ConsumerGroup, error) {
+	if value == nil {
+		return nil, ConsumerGroup{}, ErrNoGroup
+	}
+	st, err := streamOf(value)
+	if err != nil {
+		return nil, ConsumerGroup{}, err
+	}
+	g, ok := st.Groups[group]
+	if !ok {
+		return nil, ConsumerGroup{}, ErrNoGroup
+	}
+	return st, g, nil
+}
+
+// deliver доставляет потребителю новые записи группы
+func (s *sharder) deliver(key string, group string, consumer string, count int) (entries []StreamEntry, err error) {
+	record := func(*Value) []operation {
+		return []operation{{Type: "XReadGroup", Key: key, Field: group, Value: streamDelivery{consumer, len(entries)}}}
+	}
+	_, err = s.updateLogged(key, record, func(current *Value) (*Value, error) {
+		st, g, err := consumerGroup(current, group)
+		if err != nil {
+			return nil, err
+		}
+		if entries = st.after(g.LastDelivered, count); len(entries) == 0 {
+			return current, nil
+		}
+		g = g.copy()
+		now := time.Now().UnixNano()
+		for _, e := range entries {
+			g.Pending[e.ID] = PendingEntry{ID: e.ID, Consumer: consumer, Delivered: now, Count: 1}
+		}
+		g.LastDelivered = entries[len(entries)-1].ID
+		return &Value{Type: STREAM, Data: st.withGroup(group, g), Expires: current.Expires}, nil
+	})
+	return
+}
+
+func (s *sharder) XReadGroup(ctx context.Context, key string, group string, consumer string, after string, count int, block time.Duration) (entries []StreamEntry, err error) {
+	if consumer == "" {
+		return nil, ErrNoConsumer
+	}
+	if after != ">" {
+		return s.consumerHistory(key, group, consumer, after, count)
+	}
+	err = s.await(ctx, key, block, func() (bool, error) {
+		var err error
+		entries, err = s.deliver(key, group, consumer, count)
+		return len(entries) > 0, err
+	})
+	return
+}
+
+// consumerHistory возвращает неподтвержденные записи потребителя после after
+func (s *sharder) consumerHistory(key string, group string, consumer string, after string, count int) (entries []StreamEntry, err error) {
+	id, err := ParseStreamID(after)
+	if err != nil {
+		return nil, err
+	}
+	err = s.view(key, func(value *Value) error {
+		st, g, err := consumerGroup(value, group)
+		if err != nil {
+			return err
+		}
+		entries = []StreamEntry{}
+		for _, e := range st.after(id, 0) {
+			if count > 0 && len(entries) == count {
+				break
+			}
+			if p, ok := g.Pending[e.ID]; ok && p.Consumer == consumer {
+				entries = append(entries, e)
+			}
+		}
+		return nil
+	})
+	if err == ErrKeyNotFound {
+		return nil, ErrNoGroup
+	}
+	return
+}
+
+func (s *sharder) XAck(key string, group string, ids ...string) (acked int, err error) {
+	parsed := make([]StreamID, len(ids))
+	for i := range ids {
+		if parsed[i], err = ParseStreamID(ids[i]); err != nil {
+			return 0, err
+		}
+	}
+	record := operation{Type: "XAck", Key: key, Field: group, Value: ids}
+	_, err = s.updateLogged(key, journalOp(record), func(current *Value) (*Value, error) {
+		st, g, err := consumerGroup(current, group)
+		if err != nil {
+			return nil, err
+		}
+		g = g.copy()
+		for _, id := range parsed {
+			if _, ok := g.Pending[id]; ok {
+				delete(g.Pending, id)
+				acked++
+			}
+		}
+		if acked == 0 {
+			return current, nil
+		}
+		return &Value{Type: STREAM, Data: st.withGroup(group, g), Expires: current.Expires}, nil
+	})
+	return
+}
+
+func (s *sharder) XPending(key string, group string) (pending []PendingEntry, err error) {
+	err = s.view(key, func(value *Value) error {
+		_, g, err := consumerGroup(value, group)
+		if err != nil {
+			return err
+		}
+		pending = make([]PendingEntry, 0, len(g.Pending))
+		for _, p := range g.Pending {
+			pending = append(pending, p)
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i].ID.Less(pending[j].ID) })
+		return nil
+	})
+	if err == ErrKeyNotFound {
+		return nil, ErrNoGroup
+	}
+	return
+}
+
+func (l *logger) XAdd(key string, id string, fields map[string]interface{}) (StreamID, error) {
+	defer l.peekIntoPanic("xadd", key, id, fields)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return StreamID{}, err
+	}
+	added, err := so.XAdd(key, id, fields)
+	l.infoLog.Println("xadd", key, id, fields, "=>", added, err)
+	return added, err
+}
+
+func (l *logger) XLen(key string) (int, error) {
+	defer l.peekIntoPanic("xlen", key)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := so.XLen(key)
+	l.infoLog.Println("xlen", key, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) XRange(key string, start string, end string, count int) ([]StreamEntry, error) {
+	defer l.peekIntoPanic("xrange", key, start, end, count)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := so.XRange(key, start, end, count)
+	l.infoLog.Println("xrange", key, start, end, count, "=>", len(entries), err)
+	return entries, err
+}
+
+func (l *logger) XRead(ctx context.Context, key string, after string, count int, block time.Duration) ([]StreamEntry, error) {
+	defer l.peekIntoPanic("xread", key, after, count, block)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := so.XRead(ctx, key, after, count, block)
+	l.infoLog.Println("xread", key, after, count, block, "=>", len(entries), err)
+	return entries, err
+}
+
+func (l *logger) XGroupCreate(key string, group string, start string, mkStream bool) error {
+	defer l.peekIntoPanic("xgroupcreate", key, group, start, mkStream)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return err
+	}
+	err = so.XGroupCreate(key, group, start, mkStream)
+	l.infoLog.Println("xgroupcreate", key, group, start, mkStream, "=>", err)
+	return err
+}
+
+func (l *logger) XReadGroup(ctx context.Context, key string, group string, consumer string, after string, count int, block time.Duration) ([]StreamEntry, error) {
+	defer l.peekIntoPanic("xreadgroup", key, group, consumer, after, count, block)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := so.XReadGroup(ctx, key, group, consumer, after, count, block)
+	l.infoLog.Println("xreadgroup", key, group, consumer, after, count, block, "=>", len(entries), err)
+	return entries, err
+}
+
+func (l *logger) XAck(key string, group string, ids ...string) (int, error) {
+	defer l.peekIntoPanic("xack", key, group, ids)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := so.XAck(key, group, ids...)
+	l.infoLog.Println("xack", key, group, ids, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) XPending(key string, group string) ([]PendingEntry, error) {
+	defer l.peekIntoPanic("xpending", key, group)
+	so, err := asStreamOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := so.XPending(key, group)
+	l.infoLog.Println("xpending", key, group, "=>", len(pending), err)
+	return pending, err
+}
+
+// streamDelivery - запись журнала о доставке записей потребителю
+type streamDelivery struct {
+	Consumer string `json:"consumer"`
+	Count    int    `json:"count"`
+}
+
+func (p *persister) XAdd(key string, id string, fields map[string]interface{}) (StreamID, error) {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return StreamID{}, err
+	}
+	return so.XAdd(key, id, fields)
+}
+
+func (p *persister) XLen(key string) (int, error) {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return so.XLen(key)
+}
+
+func (p *persister) XRange(key string, start string, end string, count int) ([]StreamEntry, error) {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XRange(key, start, end, count)
+}
+
+func (p *persister) XRead(ctx context.Context, key string, after string, count int, block time.Duration) ([]StreamEntry, error) {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XRead(ctx, key, after, count, block)
+}
+
+func (p *persister) XGroupCreate(key string, group string, start string, mkStream bool) error {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return err
+	}
+	return so.XGroupCreate(key, group, start, mkStream)
+}
+
+func (p *persister) XReadGroup(ctx context.Context, key string, group string, consumer string, after string, count int, block time.Duration) ([]StreamEntry, error) {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XReadGroup(ctx, key, group, consumer, after, count, block)
+}
+
+func (p *persister) XAck(key string, group string, ids ...string) (int, error) {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return so.XAck(key, group, ids...)
+}
+
+func (p *persister) XPending(key string, group string) ([]PendingEntry, error) {
+	so, err := asStreamOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XPending(key, group)
+}
+
+func (o *operation) executeStream(target Cache) error {
+	so, err := asStreamOperator(target)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(o.Value)
+	if err != nil {
+		return err
+	}
+	switch o.Type {
+	case "XAdd":
+		entry := StreamEntry{}
+		if err = json.Unmarshal(encoded, &entry); err != nil {
+			return err
+		}
+		_, err = so.XAdd(o.Key, entry.ID.String(), entry.Fields)
+	case "XGroupCreate":
+		start := ""
+		if err = json.Unmarshal(encoded, &start); err != nil {
+			return err
+		}
+		err = so.XGroupCreate(o.Key, o.Field, start, true)
+	case "XReadGroup":
+		delivery := streamDelivery{}
+		if err = json.Unmarshal(encoded, &delivery); err != nil {
+			return err
+		}
+		_, err = so.XReadGroup(context.Background(), o.Key, o.Field, delivery.Consumer, ">", delivery.Count, 0)
+	case "XAck":
+		ids := []string{}
+		if err = json.Unmarshal(encoded, &ids); err != nil {
+			return err
+		}
+		_, err = so.XAck(o.Key, o.Field, ids...)
+	}
+	return err
+}
+
+func (t *ttl) XAdd(key string, id string, fields map[string]interface{}) (StreamID, error) {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return StreamID{}, err
+	}
+	result, err := so.XAdd(key, id, fields)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) XLen(key string) (int, error) {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return so.XLen(key)
+}
+
+func (t *ttl) XRange(key string, start string, end string, count int) ([]StreamEntry, error) {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XRange(key, start, end, count)
+}
+
+func (t *ttl) XRead(ctx context.Context, key string, after string, count int, block time.Duration) ([]StreamEntry, error) {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XRead(ctx, key, after, count, block)
+}
+
+func (t *ttl) XGroupCreate(key string, group string, start string, mkStream bool) error {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return err
+	}
+	err = so.XGroupCreate(key, group, start, mkStream)
+	if err == nil && mkStream {
+		t.expireCreated(key)
+	}
+	return err
+}
+
+func (t *ttl) XReadGroup(ctx context.Context, key string, group string, consumer string, after string, count int, block time.Duration) ([]StreamEntry, error) {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XReadGroup(ctx, key, group, consumer, after, count, block)
+}
+
+func (t *ttl) XAck(key string, group string, ids ...string) (int, error) {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return so.XAck(key, group, ids...)
+}
+
+func (t *ttl) XPending(key string, group string) ([]PendingEntry, error) {
+	so, err := asStreamOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return so.XPending(key, group)
+}
@@ -0,0 +1,279 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func entryIDs(entries []StreamEntry) []string {
+	ids := []string{}
+	for _, e := range entries {
+		ids = append(ids, e.ID.String())
+	}
+	return ids
+}
+
+func TestParseStreamID(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		expected StreamID
+		err      error
+	}{
+		{"1-2", StreamID{1, 2}, nil},
+		{"15", StreamID{15, 0}, nil},
+		{"1-", StreamID{}, ErrStreamID},
+		{"-1", StreamID{}, ErrStreamID},
+		{"a-1", StreamID{}, ErrStreamID},
+	} {
+		if id, err := ParseStreamID(tt.in); id != tt.expected || err != tt.err {
+			t.Errorf("ParseStreamID(%q): expected %v, %v, got %v, %v", tt.in, tt.expected, tt.err, id, err)
+		}
+	}
+}
+
+func TestStreamOperator_XAdd(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	so := c.(StreamOperator)
+	fields := map[string]interface{}{"user": "alice"}
+
+	for _, tt := range []struct {
+		id       string
+		expected string
+		err      error
+	}{
+		{"1-1", "1-1", nil},
+		{"1-*", "1-2", nil},
+		{"1-2", "0-0", ErrStreamIDTooSmall},
+		{"0-5", "0-0", ErrStreamIDTooSmall},
+		{"5", "5-0", nil},
+		{"x-1", "0-0", ErrStreamID},
+	} {
+		if id, err := so.XAdd("events", tt.id, fields); id.String() != tt.expected || err != tt.err {
+			t.Errorf("XAdd %v: expected %v, %v, got %v, %v", tt.id, tt.expected, tt.err, id, err)
+		}
+	}
+	// автоматический идентификатор больше последнего
+	id, _ := so.XAdd("events", "*", fields)
+	if !(StreamID{5, 0}).Less(id) {
+		t.Errorf("XAdd *: expected id after 5-0, got %v", id)
+	}
+	if _, err := so.XAdd("events", "*", nil); err != ErrStreamFields {
+		t.Errorf("XAdd without fields: expected %v, got %v", ErrStreamFields, err)
+	}
+	if n, _ := so.XLen("events"); n != 4 {
+		t.Errorf("XLen: expected 4, got %v", n)
+	}
+	if value, _ := c.Get("events"); value.Type != STREAM {
+		t.Errorf("XAdd: expected type %v, got %v", STREAM, value.Type)
+	}
+	c.Set("list", []interface{}{1}, 0)
+	if _, err := so.XAdd("list", "*", fields); err != ErrWrongType {
+		t.Errorf("XAdd on list: expected %v, got %v", ErrWrongType, err)
+	}
+}
+
+func TestStreamOperator_XRange(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	so := c.(StreamOperator)
+	for _, id := range []string{"1-0", "1-1", "2-0", "3-5"} {
+		so.XAdd("events", id, map[string]interface{}{"id": id})
+	}
+
+	for _, tt := range []struct {
+		start, end string
+		count      int
+		expected   []string
+	}{
+		{"-", "+", 0, []string{"1-0", "1-1", "2-0", "3-5"}},
+		{"1", "1", 0, []string{"1-0", "1-1"}},
+		{"1-1", "3", 2, []string{"1-1", "2-0"}},
+		{"4", "+", 0, []string{}},
+	} {
+		entries, err := so.XRange("events", tt.start, tt.end, tt.count)
+		if !reflect.DeepEqual(entryIDs(entries), tt.expected) || err != nil {
+			t.Errorf("XRange %v %v %v: expected %v, got %v, err %v", tt.start, tt.end, tt.count, tt.expected, entryIDs(entries), err)
+		}
+	}
+	if entries, _ := so.XRange("missing", "-", "+", 0); len(entries) != 0 {
+		t.Errorf("XRange of missing key: expected no entries, got %v", entries)
+	}
+	if entries, _ := so.XRange("events", "2", "2", 0); entries[0].Fields["id"] != "2-0" {
+		t.Errorf("XRange: unexpected fields %v", entries[0].Fields)
+	}
+}
+
+func TestStreamOperator_XRead(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	so := c.(StreamOperator)
+	so.XAdd("events", "1-0", map[string]interface{}{"a": 1})
+	so.XAdd("events", "2-0", map[string]interface{}{"a": 2})
+
+	entries, err := so.XRead(context.Background(), "events", "1-0", 0, 0)
+	if !reflect.DeepEqual(entryIDs(entries), []string{"2-0"}) || err != nil {
+		t.Errorf("XRead after 1-0: got %v, err %v", entryIDs(entries), err)
+	}
+	if entries, _ = so.XRead(context.Background(), "events", "", 1, 0); !reflect.DeepEqual(entryIDs(entries), []string{"1-0"}) {
+		t.Errorf("XRead with count: got %v", entryIDs(entries))
+	}
+
+	start := time.Now()
+	entries, err = so.XRead(context.Background(), "events", "$", 0, 30*time.Millisecond)
+	if len(entries) != 0 || err != nil || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("XRead $ should time out with no entries, got %v, err %v", entries, err)
+	}
+
+	// блокирующее чтение получает запись, добавленную после начала ожидания
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		so.XAdd("events", "3-0", map[string]interface{}{"a": 3})
+	}()
+	entries, err = so.XRead(context.Background(), "events", "$", 0, time.Second)
+	if !reflect.DeepEqual(entryIDs(entries), []string{"3-0"}) || err != nil {
+		t.Errorf("blocking XRead: got %v, err %v", entryIDs(entries), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if _, err = so.XRead(ctx, "events", "$", 0, time.Minute); err != context.Canceled {
+		t.Errorf("XRead with canceled context: expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestStreamOperator_Groups(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	so := c.(StreamOperator)
+	ctx := context.Background()
+
+	if err := so.XGroupCreate("events", "workers", "$", false); err != ErrKeyNotFound {
+		t.Errorf("XGroupCreate without stream: expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if err := so.XGroupCreate("events", "workers", "$", true); err != nil {
+		t.Errorf("XGroupCreate: %v", err)
+	}
+	if err := so.XGroupCreate("events", "workers", "0", false); err != ErrGroupExists {
+		t.Errorf("XGroupCreate twice: expected %v, got %v", ErrGroupExists, err)
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		so.XAdd("events", id, map[string]interface{}{"job": id})
+	}
+
+	// записи распределяются между потребителями группы
+	entries, err := so.XReadGroup(ctx, "events", "workers", "w1", ">", 2, 0)
+	if !reflect.DeepEqual(entryIDs(entries), []string{"1-0", "2-0"}) || err != nil {
+		t.Errorf("XReadGroup w1: got %v, err %v", entryIDs(entries), err)
+	}
+	entries, _ = so.XReadGroup(ctx, "events", "workers", "w2", ">", 0, 0)
+	if !reflect.DeepEqual(entryIDs(entries), []string{"3-0"}) {
+		t.Errorf("XReadGroup w2: got %v", entryIDs(entries))
+	}
+	if entries, _ = so.XReadGroup(ctx, "events", "workers", "w2", ">", 0, 0); len(entries) != 0 {
+		t.Errorf("XReadGroup with nothing new: got %v", entryIDs(entries))
+	}
+	if entries, _ = so.XReadGroup(ctx, "events", "workers", "w1", "0", 0, 0); !reflect.DeepEqual(entryIDs(entries), []string{"1-0", "2-0"}) {
+		t.Errorf("XReadGroup history of w1: got %v", entryIDs(entries))
+	}
+
+	if n, err := so.XAck("events", "workers", "1-0", "3-0", "9-0"); n != 2 || err != nil {
+		t.Errorf("XAck: expected 2, got %v, err %v", n, err)
+	}
+	pending, _ := so.XPending("events", "workers")
+	if len(pending) != 1 || pending[0].ID.String() != "2-0" || pending[0].Consumer != "w1" || pending[0].Count != 1 {
+		t.Errorf("XPending: unexpected %v", pending)
+	}
+
+	// отдельная группа получает записи с начала потока
+	so.XGroupCreate("events", "audit", "0", false)
+	if entries, _ = so.XReadGroup(ctx, "events", "audit", "a1", ">", 0, 0); len(entries) != 3 {
+		t.Errorf("XReadGroup from second group: got %v", entryIDs(entries))
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		so.XAdd("events", "4-0", map[string]interface{}{"job": "4-0"})
+	}()
+	entries, _ = so.XReadGroup(ctx, "events", "workers", "w2", ">", 0, time.Second)
+	if !reflect.DeepEqual(entryIDs(entries), []string{"4-0"}) {
+		t.Errorf("blocking XReadGroup: got %v", entryIDs(entries))
+	}
+
+	if _, err = so.XReadGroup(ctx, "events", "missing", "w1", ">", 0, 0); err != ErrNoGroup {
+		t.Errorf("XReadGroup of unknown group: expected %v, got %v", ErrNoGroup, err)
+	}
+	if _, err = so.XReadGroup(ctx, "missing", "workers", "w1", ">", 0, 0); err != ErrNoGroup {
+		t.Errorf("XReadGroup of missing key: expected %v, got %v", ErrNoGroup, err)
+	}
+	if _, err = so.XPending("events", "missing"); err != ErrNoGroup {
+		t.Errorf("XPending of unknown group: expected %v, got %v", ErrNoGroup, err)
+	}
+}
+
+func TestStreamOperator_Persist(t *testing.T) {
+	sample := `{"Type":"XAdd","k":"events","v":{"id":"1-0","fields":{"a":1}},"e":0}
+{"Type":"XGroupCreate","k":"events","v":"0","e":0,"f":"workers"}
+{"Type":"XAdd","k":"events","v":{"id":"2-0","fields":{"a":2}},"e":0}
+{"Type":"XReadGroup","k":"events","v":{"consumer":"w1","count":2},"e":0,"f":"workers"}
+{"Type":"XAck","k":"events","v":["1-0","5-0"],"e":0,"f":"workers"}
+`
+	restored := checkPersist(t, 2, sample, func(p *persister) {
+		p.XAdd("events", "1-0", map[string]interface{}{"a": 1})
+		p.XGroupCreate("events", "workers", "0", false)
+		p.XAdd("events", "2-0", map[string]interface{}{"a": 2})
+		p.XReadGroup(context.Background(), "events", "workers", "w1", ">", 0, 0)
+		p.XReadGroup(context.Background(), "events", "workers", "w1", ">", 0, 0)
+		p.XAck("events", "workers", "1-0", "5-0")
+	})
+	entries, _ := restored.XRange("events", "-", "+", 0)
+	if !reflect.DeepEqual(entryIDs(entries), []string{"1-0", "2-0"}) {
+		t.Errorf("TestStreamOperator_Persist restored entries: got %v", entryIDs(entries))
+	}
+	pending, _ := restored.XPending("events", "workers")
+	if len(pending) != 1 || pending[0].ID.String() != "2-0" || pending[0].Consumer != "w1" {
+		t.Errorf("TestStreamOperator_Persist restored pending: got %v", pending)
+	}
+}
+
+func TestStreamOperator_PersistCopy(t *testing.T) {
+	s, _ := newSharder(2, nil)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+
+	p.XAdd("events", "1-0", map[string]interface{}{"a": "b"})
+	p.XGroupCreate("events", "workers", "0", false)
+	p.XReadGroup(context.Background(), "events", "workers", "w1", ">", 0, 0)
+	p.Copy("events", "backup", false)
+	flushOplog(p, &rw, "\n\n\n\n")
+
+	restored, _ := newSharder(2, nil)
+	newPersister(restored, &rw, time.Hour)
+	entries, _ := restored.XRange("backup", "-", "+", 0)
+	pending, _ := restored.XPending("backup", "workers")
+	if !reflect.DeepEqual(entryIDs(entries), []string{"1-0"}) || len(pending) != 1 {
+		t.Errorf("TestStreamOperator_PersistCopy: got entries %v, pending %v", entryIDs(entries), pending)
+	}
+}
+
+func TestStreamOperator_PersistBlocking(t *testing.T) {
+	s, _ := newSharder(2, nil)
+	p, _ := newPersister(s, &bytes.Buffer{}, time.Hour)
+	p.XGroupCreate("events", "workers", "$", true)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.XAdd("events", "1-0", map[string]interface{}{"a": 1})
+	}()
+	entries, err := p.XReadGroup(context.Background(), "events", "workers", "w1", ">", 0, time.Second)
+	if !reflect.DeepEqual(entryIDs(entries), []string{"1-0"}) || err != nil {
+		t.Errorf("blocking XReadGroup through persister: got %v, err %v", entryIDs(entries), err)
+	}
+	start := time.Now()
+	entries, err = p.XReadGroup(context.Background(), "events", "workers", "w1", ">", 0, 30*time.Millisecond)
+	if len(entries) != 0 || err != nil || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("XReadGroup through persister should time out, got %v, err %v", entryIDs(entries), err)
+	}
+}
@@ -0,0 +1,282 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/shpaktakur1/TestAvito/db"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// TelnetServer - текстовый tcp сервер для отладки через nc/telnet.
+// Одна команда на строку, ответы в человекочитаемом виде
+type TelnetServer struct {
+	Authorization Authorizer
+	Cache         db.Cache
+	// Databases - базы, доступные через SELECT. Без них доступна только Cache
+	Databases *db.Databases
+}
+
+type telnetSession struct {
+	authorized bool
+	quit       bool
+	// cache - база, выбранная командой SELECT
+	cache db.Cache
+}
+
+type telnetHandler func(s *TelnetServer, session *telnetSession, w io.Writer, line string)
+
+var telnetCommands = map[string]telnetHandler{
+	"AUTH": telnetAuth,
+	"GET":  telnetGet,
+	"SET":  telnetSet,
+	"DEL":  telnetDel,
+	"KEYS": telnetKeys,
+
+	"SELECT":  telnetSelect,
+	"FLUSHDB": telnetFlushDB,
+	"HELP":    telnetHelp,
+	"QUIT":    telnetQuit,
+}
+
+var telnetUsage = `Commands:
+  AUTH login password
+  GET key [index]
+  SET key json_value [ttl]
+  DEL key
+  KEYS
+  SELECT db
+  FLUSHDB
+  HELP
+  QUIT
+ttl is a duration accepted by time.ParseDuration, e.g. 10s or 1h30m`
+
+// maxTelnetLine ограничивает строку команды: сервер для отладки, большие
+// значения передаются через HTTP или RESP
+const maxTelnetLine = 64 * 1024
+
+// Запуск сервера по представленному адресу
+func (s *TelnetServer) Run(addr string) {
+	if s.Cache == nil {
+		log.Fatal("Cannot run telnet server without cache")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(s.Serve(l))
+}
+
+func (s *TelnetServer) Serve(l net.Listener) error {
+	return serveConnections(l, s.serveConn)
+}
+
+func (s *TelnetServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxTelnetLine)
+	session := &telnetSession{authorized: s.Authorization == nil, cache: s.Cache}
+
+	for !session.quit && scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		s.execute(session, conn, line)
+	}
+	if scanner.Err() == bufio.ErrTooLong {
+		telnetError(conn, fmt.Sprintf("line longer than %d bytes", maxTelnetLine))
+	}
+}
+
+func (s *TelnetServer) execute(session *telnetSession, w io.Writer, line string) {
+	name, rest := splitWord(line)
+	name = strings.ToUpper(name)
+	handler, ok := telnetCommands[name]
+	if !ok {
+		telnetError(w, fmt.Sprintf("unknown command '%s', try HELP", name))
+		return
+	}
+	if !session.authorized && name != "AUTH" && name != "QUIT" && name != "HELP" {
+		telnetError(w, "authentication required, use AUTH login password")
+		return
+	}
+	handler(s, session, w, rest)
+}
+
+// splitWord отделяет первое слово строки от остатка
+func splitWord(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	idx := strings.IndexAny(line, " \t")
+	if idx < 0 {
+		return line, ""
+	}
+	return line[:idx], strings.TrimSpace(line[idx+1:])
+}
+
+// splitTTL отделяет ttl в конце строки от json значения. Корректное json
+// значение не может заканчиваться отдельным словом-длительностью, поэтому
+// разбор однозначен
+func splitTTL(in string) (value string, ttl time.Duration) {
+	idx := strings.LastIndexAny(in, " \t")
+	if idx < 0 {
+		return in, 0
+	}
+	ttl, err := processTTL(in[idx+1:])
+	if err != nil {
+		return in, 0
+	}
+	return strings.TrimSpace(in[:idx]), ttl
+}
+
+func telnetReply(w io.Writer, format string, args ...interface{}) {
+	fmt.Fprintf(w, format+"\r\n", args...)
+}
+
+func telnetError(w io.Writer, message string) {
+	telnetReply(w, "(error) %s", message)
+}
+
+func telnetJSON(data interface{}) string {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Sprint(data)
+	}
+	return string(encoded)
+}
+
+func telnetAuth(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	if s.Authorization == nil {
+		telnetReply(w, "OK")
+		return
+	}
+	user, password := splitWord(args)
+	if !s.Authorization.Authorize(user, password) {
+		session.authorized = false
+		telnetError(w, "invalid login or password")
+		return
+	}
+	session.authorized = true
+	telnetReply(w, "OK")
+}
+
+func telnetGet(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	key, index := splitWord(args)
+	if key == "" {
+		telnetError(w, "usage: GET key [index]")
+		return
+	}
+	if index != "" {
+		item, err := session.cache.GetAtIndex(key, index)
+		if err != nil {
+			telnetError(w, err.Error())
+			return
+		}
+		telnetReply(w, "%s", telnetJSON(item))
+		return
+	}
+
+	value, err := session.cache.Get(key)
+	if err == db.ErrKeyNotFound {
+		telnetReply(w, "(nil)")
+		return
+	}
+	if err != nil {
+		telnetError(w, err.Error())
+		return
+	}
+	if value.Expires != 0 {
+		left := time.Duration(value.Expires - time.Now().UnixNano())
+		telnetReply(w, "(%v) %s, expires in %v", value.Type, telnetJSON(value.Data), left.Truncate(time.Millisecond))
+		return
+	}
+	telnetReply(w, "(%v) %s", value.Type, telnetJSON(value.Data))
+}
+
+func telnetSet(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	key, rest := splitWord(args)
+	if key == "" || rest == "" {
+		telnetError(w, "usage: SET key json_value [ttl]")
+		return
+	}
+	raw, ttl := splitTTL(rest)
+	data, err := decodeJSONBody(strings.NewReader(raw))
+	if err != nil {
+		telnetError(w, err.Error())
+		return
+	}
+	if _, err = session.cache.Set(key, data, ttl); err != nil {
+		telnetError(w, err.Error())
+		return
+	}
+	telnetReply(w, "OK")
+}
+
+func telnetDel(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	key, _ := splitWord(args)
+	if key == "" {
+		telnetError(w, "usage: DEL key")
+		return
+	}
+	if err := session.cache.Remove(key); err != nil {
+		telnetError(w, err.Error())
+		return
+	}
+	telnetReply(w, "OK")
+}
+
+func telnetKeys(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	keys, err := session.cache.Keys()
+	if err != nil {
+		telnetError(w, err.Error())
+		return
+	}
+	if len(keys) == 0 {
+		telnetReply(w, "(empty list)")
+		return
+	}
+	for i, key := range keys {
+		telnetReply(w, "%d) %q", i+1, key)
+	}
+}
+
+func telnetSelect(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	name, _ := splitWord(args)
+	if name == "" {
+		telnetError(w, "usage: SELECT db")
+		return
+	}
+	cache, err := selectDatabase(s.Databases, s.Cache, name)
+	if err != nil {
+		telnetError(w, err.Error())
+		return
+	}
+	session.cache = cache
+	telnetReply(w, "OK")
+}
+
+func telnetFlushDB(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	f, ok := session.cache.(db.Flusher)
+	if !ok {
+		telnetError(w, db.ErrUnsupported.Error())
+		return
+	}
+	if err := f.FlushDB(); err != nil {
+		telnetError(w, err.Error())
+		return
+	}
+	telnetReply(w, "OK")
+}
+
+func telnetHelp(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	telnetReply(w, "%s", strings.Replace(telnetUsage, "\n", "\r\n", -1))
+}
+
+func telnetQuit(s *TelnetServer, session *telnetSession, w io.Writer, args string) {
+	session.quit = true
+	telnetReply(w, "Bye")
+}
@@ -0,0 +1,66 @@
+package rest
+
+import (
+	"bufio"
+	"github.com/shpaktakur1/TestAvito/db"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTelnetServer_commands(t *testing.T) {
+	c, _ := db.NewCache(0, nil, nil, 500, 1, nil)
+	c.Set("list", []interface{}{1, "abc"}, 0)
+	s := &TelnetServer{Authorization: &BasicAuthorizer{Username: "Alladin", Password: "Open Sesame"}, Cache: c}
+	server, client := net.Pipe()
+	go s.serveConn(server)
+	defer client.Close()
+	r := bufio.NewReader(client)
+
+	type telnetTest struct {
+		name     string
+		line     string
+		expected string
+	}
+
+	var tests = []telnetTest{
+		{"NoAuth", "GET list", "(error) authentication required, use AUTH login password"},
+		{"WrongAuth", "AUTH admin admin", "(error) invalid login or password"},
+		{"CorrectAuth", "auth Alladin Open Sesame", "OK"},
+		{"Get list", "GET list", `(list) [1,"abc"]`},
+		{"Get list[1]", "GET list 1", `"abc"`},
+		{"Get missing", "GET missing", "(nil)"},
+		{"Set map", `SET map {"a": 1, "b": [1, 2]}`, "OK"},
+		{"Get map", "GET map", `(map) {"a":1,"b":[1,2]}`},
+		{"Set number with ttl", "SET number 42 10s", "OK"},
+		{"Set negative ttl", `SET ill "x" -15s`, "(error) TTL should be positive"},
+		{"Set malformed json", "SET ill {a:1}", "(error) invalid character 'a' looking for beginning of object key string"},
+		{"Del", "DEL map", "OK"},
+		{"Get removed", "GET map", "(nil)"},
+		{"Select default db", "SELECT 0", "OK"},
+		{"Select without databases", "SELECT 1", "(error) database does not exist"},
+		{"Unknown", "FOO bar", "(error) unknown command 'FOO', try HELP"},
+	}
+
+	for _, tt := range tests {
+		client.Write([]byte(tt.line + "\r\n"))
+		got, _ := r.ReadString('\n')
+		got = strings.TrimRight(got, "\r\n")
+		if got != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.name, tt.expected, got)
+		}
+	}
+
+	client.Write([]byte("GET number\r\n"))
+	got, _ := r.ReadString('\n')
+	if !strings.HasPrefix(got, "(string) 42, expires in ") {
+		t.Errorf("Get number with ttl: unexpected reply %v", got)
+	}
+
+	// сервер не дочитывает длинную строку, поэтому пишем ее параллельно
+	go client.Write([]byte("SET big \"" + strings.Repeat("x", maxTelnetLine) + "\"\r\n"))
+	got, _ = r.ReadString('\n')
+	if expected := "(error) line longer than 65536 bytes\r\n"; got != expected {
+		t.Errorf("Long line: expected %q, got %q", expected, got)
+	}
+}
@@ -42,29 +42,46 @@ func (t *ttl) Get(key string) (*Value, error) {
 }
 
 func (t *ttl) Set(key string, value interface{}, expire time.Duration) (*Value, error) {
-
-	var delay time.Duration = expire 
-
-	if t.defaultTTL != 0 && expire == 0 {
-		delay = t.defaultTTL
-	}
-
+	delay := t.delay(expire)
 	result, err := t.Cache.Set(key, value, delay)
 	if err != nil {
 		return nil, err
 	}
+	t.scheduleRemove(key, result, delay)
+	return result, err
+}
 
+// delay возвращает время жизни ключа с учетом TTL по умолчанию
+func (t *ttl) delay(expire time.Duration) time.Duration {
+	if t.defaultTTL != 0 && expire == 0 {
+		return t.defaultTTL
+	}
+	return expire
+}
+
+func (t *ttl) scheduleRemove(key string, result *Value, delay time.Duration) {
 	if delay > 0 {
 		go t.delayRemove(key, result.Expires, delay)
 	}
-	return result, err
 }
 
 func (t *ttl) delayRemove(k string, controlExpire int64, delay time.Duration) {
 	ticker := time.NewTicker(delay)
 	<-ticker.C
 	ticker.Stop()
+	if e, ok := t.Cache.(keyExpirer); ok {
+		// проверка и удаление выполняются под блокировкой шарда, а sharder
+		// публикует удаление событием expired
+		if _, err := e.expireKey(k, controlExpire); err != ErrUnsupported {
+			return
+		}
+	}
 	item, err := t.Cache.Get(k)
+	if err == ErrKeyNotFound {
+		// хранилище уже не отдает истекший ключ, но еще хранит его
+		t.Cache.Remove(k)
+		return
+	}
 	if err != nil {
 		return
 	}
@@ -72,3 +89,24 @@ func (t *ttl) delayRemove(k string, controlExpire int64, delay time.Duration) {
 		t.Cache.Remove(k)
 	}
 }
+
+// defaultExpirer назначает время жизни ключу, у которого его нет. Возвращает
+// измененное значение или nil, если ключа нет или время уже назначено
+type defaultExpirer interface {
+	expireNew(key string, expire time.Duration) (*Value, error)
+}
+
+// expireCreated назначает TTL по умолчанию ключу, который могла создать
+// операция без параметра expire (HSet, LPush и т.п.)
+func (t *ttl) expireCreated(key string) {
+	if t.defaultTTL == 0 {
+		return
+	}
+	e, ok := t.Cache.(defaultExpirer)
+	if !ok {
+		return
+	}
+	if result, err := e.expireNew(key, t.defaultTTL); err == nil && result != nil {
+		t.scheduleRemove(key, result, t.defaultTTL)
+	}
+}
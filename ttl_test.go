@@ -1,6 +1,8 @@
 package db
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -64,3 +66,78 @@ func TestTTL_Default(t *testing.T) {
 		t.Errorf("TestTTL_Default - value with no expiration date was not expired by default")
 	}
 }
+
+func TestTTL_DefaultCreated(t *testing.T) {
+	s, _ := newSharder(1, nil)
+	c, _ := newTtl(s, 20*time.Millisecond)
+	created := map[string]func() error{
+		"hash":    func() error { _, err := c.HSet("hash", "f", "v"); return err },
+		"list":    func() error { _, err := c.RPush("list", "a"); return err },
+		"set":     func() error { _, err := c.SAdd("set", "a"); return err },
+		"zset":    func() error { _, err := c.ZAdd("zset", ZMember{"a", 1}); return err },
+		"counter": func() error { _, err := c.IncrBy("counter", 1); return err },
+		"stream":  func() error { _, err := c.XAdd("stream", "*", map[string]interface{}{"f": "v"}); return err },
+		"hll":     func() error { _, err := c.PFAdd("hll", "a"); return err },
+		"bits":    func() error { _, err := c.SetBit("bits", 7, 1); return err },
+		"geo":     func() error { _, err := c.GeoAdd("geo", GeoMember{"Palermo", 13.361389, 38.115556}); return err },
+		"script": func() error {
+			_, err := c.Eval(`redis.call("HSET", KEYS[1], "f", "v")`, []string{"script"}, nil)
+			return err
+		},
+	}
+	for key, create := range created {
+		if err := create(); err != nil {
+			t.Fatalf("TestTTL_DefaultCreated %v: got error %v", key, err)
+		}
+		value, err := c.Get(key)
+		if err != nil || value.Expires == 0 {
+			t.Errorf("TestTTL_DefaultCreated %v: expected default expiry, got %v, err %v", key, value, err)
+		}
+	}
+
+	// существующий ключ сохраняет свое время истечения
+	before, _ := c.Get("list")
+	c.RPush("list", "b")
+	if after, err := c.Get("list"); err != nil || after.Expires != before.Expires {
+		t.Errorf("TestTTL_DefaultCreated: push changed expiry %v => %v, err %v", before, after, err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	for key := range created {
+		if _, err := s.Get(key); err != ErrKeyNotFound {
+			t.Errorf("TestTTL_DefaultCreated %v: expected expired key, got err %v", key, err)
+		}
+	}
+}
+
+func TestTTL_DefaultCreatedPersist(t *testing.T) {
+	s, _ := newSharder(1, nil)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+	c, _ := newTtl(p, time.Hour)
+	c.HSet("map", "a", 1)
+	value, _ := c.Get("map")
+	sample := fmt.Sprintf(`{"Type":"HSet","k":"map","v":1,"e":0,"f":"a"}
+{"Type":"Expire","k":"map","v":null,"e":%d}
+`, value.Expires)
+	if got := flushOplog(p, &rw, sample); got != sample {
+		t.Errorf("TestTTL_DefaultCreatedPersist expected:\n%v\ngot:\n%v", sample, got)
+	}
+
+	// истекшая запись удаляет ключ, запись для удаленного ключа пропускается
+	rw.WriteString(`{"Type":"HSet","k":"old","v":1,"e":0,"f":"a"}
+{"Type":"Expire","k":"old","v":null,"e":1}
+{"Type":"Expire","k":"missing","v":null,"e":` + fmt.Sprint(value.Expires) + `}
+`)
+	restored, _ := newSharder(1, nil)
+	if _, err := newPersister(restored, &rw, time.Hour); err != nil {
+		t.Fatalf("TestTTL_DefaultCreatedPersist restore: got error %v", err)
+	}
+	// время восстанавливается как оставшееся, с точностью до времени replay
+	if got, err := restored.Get("map"); err != nil || time.Duration(got.Expires-value.Expires).Abs() > time.Second {
+		t.Errorf("TestTTL_DefaultCreatedPersist restore: expected expires %v, got %v, err %v", value.Expires, got, err)
+	}
+	if _, err := restored.Get("old"); err != ErrKeyNotFound {
+		t.Errorf("TestTTL_DefaultCreatedPersist restore: expected removed key, got err %v", err)
+	}
+}
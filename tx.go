@@ -0,0 +1,267 @@
+/*
+    Транзакции MULTI/EXEC с оптимистичным WATCH. Блокировки всех затронутых
+    шардов берутся в порядке возрастания номера шарда, поэтому параллельные
+    транзакции не могут взаимно заблокироваться
+*/
+
+package db
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+var ErrTxAborted = errors.New("transaction aborted: watched key was modified")
+
+// TxOp - операция транзакции: "Set" или "Remove"
+type TxOp struct {
+	Type   string
+	Key    string
+	Value  interface{}
+	Expire time.Duration
+}
+
+// Transactor атомарно применяет операции, если версии ключей из watch не
+// изменились (версия 0 - ключа нет). Возвращает результаты Set в порядке
+// операций, для Remove - nil
+type Transactor interface {
+	Exec(watch map[string]uint64, ops []TxOp) ([]*Value, error)
+}
+
+func asTransactor(c Cache) (Transactor, error) {
+	tr, ok := c.(Transactor)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return tr, nil
+}
+
+// Tx накапливает операции до вызова Exec
+type Tx struct {
+	cache   Cache
+	watched map[string]uint64
+	ops     []TxOp
+}
+
+func Multi(c Cache) *Tx {
+	return &Tx{cache: c, watched: map[string]uint64{}}
+}
+
+// Watch запоминает текущие версии ключей. Если к моменту Exec какой-то
+// из них изменится, транзакция завершится с ErrTxAborted
+func (tx *Tx) Watch(keys ...string) error {
+	for _, key := range keys {
+		value, err := tx.cache.Get(key)
+		switch err {
+		case nil:
+			tx.watched[key] = value.Version
+		case ErrKeyNotFound:
+			tx.watched[key] = 0
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *Tx) Set(key string, value interface{}, expire time.Duration) *Tx {
+	tx.ops = append(tx.ops, TxOp{Type: "Set", Key: key, Value: value, Expire: expire})
+	return tx
+}
+
+func (tx *Tx) Remove(key string) *Tx {
+	tx.ops = append(tx.ops, TxOp{Type: "Remove", Key: key})
+	return tx
+}
+
+func (tx *Tx) Exec() ([]*Value, error) {
+	tr, err := asTransactor(tx.cache)
+	if err != nil {
+		return nil, err
+	}
+	return tr.Exec(tx.watched, tx.ops)
+}
+
+func validateTx(ops []TxOp) error {
+	for _, op := range ops {
+		switch op.Type {
+		case "Set":
+			if op.Expire < 0 {
+				return ErrInvalidTTL
+			}
+			if _, err := typeOf(op.Value); err != nil {
+				return err
+			}
+		case "Remove":
+		default:
+			return ErrUnknownOperationType
+		}
+	}
+	return nil
+}
+
+// lockShards блокирует шарды ключей в порядке возрастания номера и
+// возвращает функцию разблокировки
+func (s *sharder) lockShards(keys []string) func() {
+	if !s.needLock {
+		return func() {}
+	}
+	seen := map[uint32]bool{}
+	indexes := []int{}
+	for _, key := range keys {
+		i := s.getTargetShardIdx(key)
+		if !seen[i] {
+			seen[i] = true
+			indexes = append(indexes, int(i))
+		}
+	}
+	return s.lockIndexes(indexes)
+}
+
+// lockIndexes блокирует шарды с номерами indexes в порядке возрастания и
+// возвращает функцию разблокировки
+func (s *sharder) lockIndexes(indexes []int) func() {
+	if !s.needLock {
+		return func() {}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		s.locks[i].Lock()
+	}
+	return func() {
+		for j := len(indexes) - 1; j >= 0; j-- {
+			s.locks[indexes[j]].Unlock()
+		}
+	}
+}
+
+func (s *sharder) Exec(watch map[string]uint64, ops []TxOp) ([]*Value, error) {
+	if err := validateTx(ops); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(watch)+len(ops))
+	for key := range watch {
+		keys = append(keys, key)
+	}
+	for _, op := range ops {
+		keys = append(keys, op.Key)
+	}
+	return s.exec(keys, watch, ops)
+}
+
+// exec выполняет проверенную транзакцию под блокировкой шардов keys
+func (s *sharder) exec(keys []string, watch map[string]uint64, ops []TxOp) ([]*Value, error) {
+	unlock := s.lockShards(keys)
+	defer unlock()
+
+	for key, version := range watch {
+		var current uint64
+		value, err := s.shards[s.getTargetShardIdx(key)].Get(key)
+		if err == nil {
+			current = value.Version
+		} else if err != ErrKeyNotFound {
+			return nil, err
+		}
+		if current != version {
+			return nil, ErrTxAborted
+		}
+	}
+
+	// операции проверены заранее, поэтому для store запись не прерывается.
+	// Транзакция записывается в журнал одной записью Exec, чтобы при
+	// восстановлении она применилась целиком
+	results := make([]*Value, len(ops))
+	record := operation{Type: "Exec", Ops: make([]operation, len(ops))}
+	for i, op := range ops {
+		shard := s.shards[s.getTargetShardIdx(op.Key)]
+		var err error
+		if op.Type == "Set" {
+			if results[i], err = s.setKey(shard, op.Key, op.Value, op.Expire, journalBatch); err == nil {
+				record.Ops[i] = setOperation(op.Key, results[i])
+			}
+		} else {
+			_, err = s.removeKey(shard, op.Key, EventDel, journalBatch)
+			record.Ops[i] = operation{Type: "Remove", Key: op.Key}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.log(record)
+	return results, nil
+}
+
+func (l *logger) Exec(watch map[string]uint64, ops []TxOp) ([]*Value, error) {
+	defer l.peekIntoPanic("exec", watch, ops)
+	tr, err := asTransactor(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	result, err := tr.Exec(watch, ops)
+	l.infoLog.Println("exec", watch, ops, "=>", result, err)
+	return result, err
+}
+
+func (p *persister) Exec(watch map[string]uint64, ops []TxOp) ([]*Value, error) {
+	tr, err := asTransactor(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return tr.Exec(watch, ops)
+}
+
+// executeTx восстанавливает транзакцию целиком
+func (o *operation) executeTx(target Cache) error {
+	tr, err := asTransactor(target)
+	if err != nil {
+		return err
+	}
+	nowNano := time.Now().UnixNano()
+	ops := make([]TxOp, len(o.Ops))
+	for i, op := range o.Ops {
+		switch {
+		case op.Type == "Remove":
+			ops[i] = TxOp{Type: "Remove", Key: op.Key}
+		case op.Type != "Set":
+			return ErrUnknownOperationType
+		case op.Expire != 0 && op.Expire <= nowNano:
+			ops[i] = TxOp{Type: "Remove", Key: op.Key}
+		default:
+			data, err := convertData(op.DataType, op.Value)
+			if err != nil {
+				return err
+			}
+			ops[i] = TxOp{Type: "Set", Key: op.Key, Value: data}
+			if op.Expire != 0 {
+				ops[i].Expire = time.Duration(op.Expire - nowNano)
+			}
+		}
+	}
+	_, err = tr.Exec(nil, ops)
+	return err
+}
+
+func (t *ttl) Exec(watch map[string]uint64, ops []TxOp) ([]*Value, error) {
+	tr, err := asTransactor(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	withTTL := make([]TxOp, len(ops))
+	for i, op := range ops {
+		withTTL[i] = op
+		if op.Type == "Set" {
+			withTTL[i].Expire = t.delay(op.Expire)
+		}
+	}
+	result, err := tr.Exec(watch, withTTL)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range withTTL {
+		if op.Type == "Set" {
+			t.scheduleRemove(op.Key, result[i], op.Expire)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTx_Exec(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 4, nil)
+	c.Set("from", 100, 0)
+	c.Set("stale", "x", 0)
+
+	tx := Multi(c)
+	if err := tx.Watch("from", "to"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := tx.Set("from", 60, 0).Set("to", 40, 0).Remove("stale").Exec()
+	if err != nil || len(results) != 3 || results[0].Data != 60 || results[1].Data != 40 || results[2] != nil {
+		t.Fatalf("Exec: got %v, err %v", results, err)
+	}
+	if _, err = c.Get("stale"); err != ErrKeyNotFound {
+		t.Errorf("Exec should remove key, got %v", err)
+	}
+
+	tx = Multi(c)
+	tx.Watch("from")
+	c.Set("from", 0, 0)
+	if _, err = tx.Set("to", 0, 0).Exec(); err != ErrTxAborted {
+		t.Errorf("Exec after watched key changed: expected %v, got %v", ErrTxAborted, err)
+	}
+	if value, _ := c.Get("to"); value.Data != 40 {
+		t.Errorf("aborted transaction should not write, got %v", value.Data)
+	}
+
+	tx = Multi(c)
+	tx.Watch("created")
+	c.Set("created", 1, 0)
+	if _, err = tx.Remove("created").Exec(); err != ErrTxAborted {
+		t.Errorf("Exec after watched missing key was created: expected %v, got %v", ErrTxAborted, err)
+	}
+
+	if _, err = Multi(c).Set("a", 1, 0).Set("b", nil, 0).Exec(); err != ErrInvalidValueType {
+		t.Errorf("Exec with invalid value: expected %v, got %v", ErrInvalidValueType, err)
+	}
+	if _, err = c.Get("a"); err != ErrKeyNotFound {
+		t.Errorf("invalid transaction should not write anything, got %v", err)
+	}
+}
+
+func TestTx_NoDeadlock(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 8, nil)
+	keys := []string{}
+	for i := 0; i < 16; i++ {
+		keys = append(keys, "k"+strconv.Itoa(i))
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				tx := Multi(c)
+				// порядок ключей в транзакциях разный
+				for i := range keys {
+					key := keys[(i*(g+1)+n)%len(keys)]
+					tx.Set(key, g, 0)
+				}
+				if _, err := tx.Exec(); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// каждая транзакция записывает одно значение во все ключи
+	first, _ := c.Get(keys[0])
+	for _, key := range keys {
+		if value, _ := c.Get(key); value.Data != first.Data {
+			t.Errorf("transactions interleaved: %v=%v, %v=%v", keys[0], first.Data, key, value.Data)
+		}
+	}
+}
+
+func TestTx_Persist(t *testing.T) {
+	sample := `{"Type":"Exec","k":"","v":null,"e":0,"ops":[{"Type":"Set","k":"a","v":1,"e":0},{"Type":"Set","k":"s","v":["x"],"e":0,"t":3},{"Type":"Remove","k":"b","v":null,"e":0}]}
+`
+	s, _ := newSharder(2, nil)
+	s.Set("b", 2, 0)
+	rw := bytes.Buffer{}
+	p, _ := newPersister(s, &rw, time.Hour)
+
+	Multi(p).Set("a", 1, 0).Set("s", NewSet("x"), 0).Remove("b").Exec()
+	if got := flushOplog(p, &rw, sample); got != sample {
+		t.Errorf("TestTx_Persist expected:\n%v\ngot:\n%v", sample, got)
+	}
+
+	restored, _ := newSharder(2, nil)
+	restored.Set("b", 2, 0)
+	rw.Reset()
+	rw.WriteString(sample)
+	newPersister(restored, &rw, time.Hour)
+	if value, err := restored.Get("s"); err != nil || value.Type != SET {
+		t.Errorf("TestTx_Persist restore: got %v, err %v", value, err)
+	}
+	if _, err := restored.Get("b"); err != ErrKeyNotFound {
+		t.Errorf("TestTx_Persist restore should remove b, got %v", err)
+	}
+}
@@ -4,13 +4,21 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type wrapper func(fn http.HandlerFunc) http.HandlerFunc
 
-var ErrMalformedDuration = errors.New("Malformed duration")
+var (
+	ErrMalformedDuration = errors.New("Malformed duration")
+	ErrMalformedInteger  = errors.New("Malformed integer")
+	ErrMalformedFloat    = errors.New("Malformed float")
+)
 
 func respondWithAppError(w http.ResponseWriter, code int, message string) {
 	respondWithJSON(w, code, map[string]string{"error": message})
@@ -24,7 +32,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
-func decodeJSONBody(body io.ReadCloser) (payload interface{}, err error) {
+func decodeJSONBody(body io.Reader) (payload interface{}, err error) {
 
 	decoder := json.NewDecoder(body)
 	err = decoder.Decode(&payload)
@@ -43,6 +51,44 @@ func processTTL(in string) (ttl time.Duration, err error) {
 	return
 }
 
+func processInt(in string, defaultValue int) (n int, err error) {
+	if in == "" {
+		return defaultValue, nil
+	}
+
+	n, err = strconv.Atoi(in)
+	if err != nil {
+		err = ErrMalformedInteger
+	}
+	return
+}
+
+// processFloat понимает inf и -inf. "+" в query приходит пробелом, поэтому
+// пробелы отбрасываются
+func processFloat(in string, defaultValue float64) (f float64, err error) {
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return defaultValue, nil
+	}
+
+	f, err = strconv.ParseFloat(in, 64)
+	if err != nil {
+		err = ErrMalformedFloat
+	}
+	return
+}
+
+func processBool(in string) bool {
+	b, _ := strconv.ParseBool(in)
+	return b
+}
+
+// disableWriteDeadline снимает WriteTimeout сервера с долгого ответа:
+// иначе соединение закрывается посреди ожидания или потока событий
+func disableWriteDeadline(w http.ResponseWriter) {
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
 func Wrap(fn http.HandlerFunc, wrappers []wrapper) http.HandlerFunc {
 	result := fn
 	for _, wrapper := range wrappers {
@@ -50,3 +96,23 @@ func Wrap(fn http.HandlerFunc, wrappers []wrapper) http.HandlerFunc {
 	}
 	return result
 }
+
+func serveConnections(l net.Listener, handle func(net.Conn)) error {
+	defer l.Close()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			// ошибка в обработке одного соединения не должна останавливать сервер
+			defer func() {
+				if r := recover(); r != nil {
+					log.Println("connection", conn.RemoteAddr(), "panic:", r)
+					conn.Close()
+				}
+			}()
+			handle(conn)
+		}()
+	}
+}
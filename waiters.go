@@ -0,0 +1,153 @@
+/*
+    Ожидание изменений ключа для блокирующих чтений
+*/
+
+package db
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// keyWaiters будит всех, кто ждет изменения ключа. Канал ожидания
+// закрывается при следующем notify и удаляется, когда его никто не ждет
+type keyWaiters struct {
+	mu      sync.Mutex
+	waiting map[string]*keyWait
+}
+
+type keyWait struct {
+	ch chan struct{}
+	n  int
+}
+
+func newKeyWaiters() *keyWaiters {
+	return &keyWaiters{waiting: map[string]*keyWait{}}
+}
+
+// wait возвращает канал, закрываемый при изменении ключа, и функцию отмены
+// ожидания, которую нужно вызвать в любом случае
+func (w *keyWaiters) wait(key string) (<-chan struct{}, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	kw, ok := w.waiting[key]
+	if !ok {
+		kw = &keyWait{ch: make(chan struct{})}
+		w.waiting[key] = kw
+	}
+	kw.n++
+	return kw.ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		kw.n--
+		if kw.n == 0 && w.waiting[key] == kw {
+			delete(w.waiting, key)
+		}
+	}
+}
+
+func (w *keyWaiters) notify(key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if kw, ok := w.waiting[key]; ok {
+		close(kw.ch)
+		delete(w.waiting, key)
+	}
+}
+
+// await повторяет try, пока он не вернет true, не истечет timeout или не
+// будет отменен ctx. Ожидание регистрируется до попытки, поэтому изменение
+// между попыткой и ожиданием не теряется. Нулевой timeout - одна попытка.
+// Истечение timeout не считается ошибкой
+func (s *sharder) await(ctx context.Context, key string, timeout time.Duration, try func() (bool, error)) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		changed, cancel := s.waiters.wait(key)
+		done, err := try()
+		if done || err != nil || timeout <= 0 {
+			cancel()
+			return err
+		}
+		select {
+		case <-changed:
+			cancel()
+		case <-deadline:
+			cancel()
+			return nil
+		case <-ctx.Done():
+			cancel()
+			return ctx.Err()
+		}
+	}
+}
+
+// keyQueues хранит очереди ожидающих ключа в порядке прихода. Сигнал
+// получает только первый в очереди, поэтому элементы достаются ожидающим по
+// очереди, а не тому, кто первым успеет взять блокировку
+type keyQueues struct {
+	mu     sync.Mutex
+	queues map[string]*list.List
+}
+
+// queuedWaiter получает сигнал, когда ему стоит попробовать снова
+type queuedWaiter struct {
+	ready chan struct{}
+}
+
+func newKeyQueues() *keyQueues {
+	return &keyQueues{queues: map[string]*list.List{}}
+}
+
+func (q *keyQueues) join(key string) *list.Element {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	l, ok := q.queues[key]
+	if !ok {
+		l = list.New()
+		q.queues[key] = l
+	}
+	return l.PushBack(&queuedWaiter{ready: make(chan struct{}, 1)})
+}
+
+// leave убирает ожидающего из очереди и будит следующего: ушедший мог
+// получить сигнал, который теперь некому обработать
+func (q *keyQueues) leave(key string, e *list.Element) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	l := q.queues[key]
+	l.Remove(e)
+	if l.Len() == 0 {
+		delete(q.queues, key)
+		return
+	}
+	signal(l.Front().Value.(*queuedWaiter))
+}
+
+func (q *keyQueues) first(key string, e *list.Element) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queues[key].Front() == e
+}
+
+// notify будит первого в очереди ключа
+func (q *keyQueues) notify(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if l, ok := q.queues[key]; ok {
+		signal(l.Front().Value.(*queuedWaiter))
+	}
+}
+
+func signal(w *queuedWaiter) {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,590 @@
+/*
+    Сортированные множества (тип ZSET)
+*/
+
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+var (
+	ErrMemberNotFound = errors.New("member not found")
+	ErrInvalidScore   = errors.New("score should be a finite number")
+)
+
+type ZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// SortedSet упорядочивает элементы по счету. Как и остальные типы, изменяется
+// только в копии: сохраненное значение читается вне блокировки шарда
+type SortedSet struct {
+	scores map[string]float64
+	list   *skipList
+}
+
+func NewSortedSet(members ...ZMember) *SortedSet {
+	z := &SortedSet{scores: make(map[string]float64), list: newSkipList()}
+	for _, m := range members {
+		z.add(m.Member, m.Score)
+	}
+	return z
+}
+
+// copy возвращает независимую копию множества
+func (z *SortedSet) copy() *SortedSet {
+	result := &SortedSet{scores: make(map[string]float64, len(z.scores)), list: newSkipList()}
+	for x := z.list.head.level[0].forward; x != nil; x = x.level[0].forward {
+		result.add(x.member, x.score)
+	}
+	return result
+}
+
+func validScore(score float64) bool {
+	return !math.IsNaN(score) && !math.IsInf(score, 0)
+}
+
+// add возвращает true, если множество изменилось
+func (z *SortedSet) add(member string, score float64) bool {
+	old, ok := z.scores[member]
+	if ok {
+		if old == score {
+			return false
+		}
+		z.list.remove(old, member)
+	}
+	z.scores[member] = score
+	z.list.insert(score, member)
+	return true
+}
+
+func (z *SortedSet) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	delete(z.scores, member)
+	z.list.remove(score, member)
+	return true
+}
+
+func (z *SortedSet) Len() int {
+	return z.list.length
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// Rank возвращает позицию элемента, начиная с 0
+func (z *SortedSet) Rank(member string, reverse bool) (int, bool) {
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.list.rank(score, member) - 1
+	if reverse {
+		rank = z.list.length - 1 - rank
+	}
+	return rank, true
+}
+
+// Range возвращает элементы с позициями от start до stop включительно.
+// Отрицательные позиции отсчитываются от конца
+func (z *SortedSet) Range(start int, stop int, reverse bool) []ZMember {
+	length := z.list.length
+	start, stop = normalizeIndex(start, length), normalizeIndex(stop, length)
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	result := []ZMember{}
+	if start > stop {
+		return result
+	}
+
+	var x *skipNode
+	if reverse {
+		x = z.list.byRank(length - start)
+	} else {
+		x = z.list.byRank(start + 1)
+	}
+	for i := start; i <= stop && x != nil; i++ {
+		result = append(result, ZMember{x.member, x.score})
+		if reverse {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return result
+}
+
+// RangeByScore возвращает элементы со счетом от min до max включительно
+func (z *SortedSet) RangeByScore(min float64, max float64, reverse bool) []ZMember {
+	result := []ZMember{}
+	if reverse {
+		for x := z.list.lastUpTo(max); x != nil && x.score >= min; x = x.backward {
+			result = append(result, ZMember{x.member, x.score})
+		}
+		return result
+	}
+	for x := z.list.firstFrom(min); x != nil && x.score <= max; x = x.level[0].forward {
+		result = append(result, ZMember{x.member, x.score})
+	}
+	return result
+}
+
+func (z *SortedSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(z.Range(0, -1, false))
+}
+
+func newSortedSetFromJSON(data interface{}) (*SortedSet, error) {
+	members, err := zMembersFromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewSortedSet(members...), nil
+}
+
+func zMembersFromJSON(data interface{}) ([]ZMember, error) {
+	items, ok := data.([]interface{})
+	if !ok {
+		return nil, ErrConversionError
+	}
+	members := make([]ZMember, len(items))
+	for i := range items {
+		item, ok := items[i].(map[string]interface{})
+		if !ok {
+			return nil, ErrConversionError
+		}
+		member, ok := item["member"].(string)
+		if !ok {
+			return nil, ErrConversionError
+		}
+		score, ok := item["score"].(float64)
+		if !ok {
+			return nil, ErrConversionError
+		}
+		members[i] = ZMember{member, score}
+	}
+	return members, nil
+}
+
+// SortedSetOperator изменяет сортированные множества атомарно под блокировкой шарда
+type SortedSetOperator interface {
+	ZAdd(key string, members ...ZMember) (int, error)
+	ZIncrBy(key string, member string, delta float64) (float64, error)
+	ZRem(key string, members ...string) (int, error)
+	ZScore(key string, member string) (float64, error)
+	ZRank(key string, member string, reverse bool) (int, error)
+	ZRange(key string, start int, stop int, reverse bool) ([]ZMember, error)
+	ZRangeByScore(key string, min float64, max float64, reverse bool) ([]ZMember, error)
+	ZCard(key string) (int, error)
+}
+
+func asSortedSetOperator(c Cache) (SortedSetOperator, error) {
+	zo, ok := c.(SortedSetOperator)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return zo, nil
+}
+
+// modifySortedSet изменяет копию сортированного множества под блокировкой
+// шарда, создавая его при необходимости. fn сообщает, изменилось ли
+// множество. Пустое множество удаляется. Изменение записывается в журнал
+// записями record
+func (s *sharder) modifySortedSet(key string, create bool, record journalFunc, fn func(z *SortedSet) (bool, error)) error {
+	_, err := s.updateLogged(key, record, func(current *Value) (*Value, error) {
+		result := &Value{Type: ZSET}
+		var z *SortedSet
+		if current != nil {
+			if current.Type != ZSET {
+				return nil, ErrWrongType
+			}
+			z = current.Data.(*SortedSet).copy()
+			result.Expires = current.Expires
+		} else if create {
+			z = NewSortedSet()
+		} else {
+			return nil, nil
+		}
+		changed, err := fn(z)
+		if err != nil {
+			return nil, err
+		}
+		if !changed {
+			return current, nil
+		}
+		if z.list.length == 0 {
+			return nil, nil
+		}
+		result.Data = z
+		return result, nil
+	})
+	return err
+}
+
+func (s *sharder) sortedSet(key string) (z *SortedSet, err error) {
+	err = s.view(key, func(value *Value) error {
+		if value.Type != ZSET {
+			return ErrWrongType
+		}
+		z = value.Data.(*SortedSet)
+		return nil
+	})
+	return
+}
+
+func (s *sharder) ZAdd(key string, members ...ZMember) (added int, err error) {
+	for _, m := range members {
+		if !validScore(m.Score) {
+			return 0, ErrInvalidScore
+		}
+	}
+	record := journalOp(operation{Type: "ZAdd", Key: key, Value: members})
+	err = s.modifySortedSet(key, true, record, func(z *SortedSet) (changed bool, err error) {
+		for _, m := range members {
+			if _, ok := z.scores[m.Member]; !ok {
+				added++
+			}
+			if z.add(m.Member, m.Score) {
+				changed = true
+			}
+		}
+		return changed, nil
+	})
+	return
+}
+
+// ZIncrBy записывается в журнал как ZAdd с итоговым счетом
+func (s *sharder) ZIncrBy(key string, member string, delta float64) (score float64, err error) {
+	record := func(*Value) []operation {
+		return []operation{{Type: "ZAdd", Key: key, Value: []ZMember{{member, score}}}}
+	}
+	err = s.modifySortedSet(key, true, record, func(z *SortedSet) (bool, error) {
+		score = z.scores[member] + delta
+		if !validScore(score) {
+			return false, ErrInvalidScore
+		}
+		return z.add(member, score), nil
+	})
+	return
+}
+
+func (s *sharder) ZRem(key string, members ...string) (removed int, err error) {
+	record := journalOp(operation{Type: "ZRem", Key: key, Value: members})
+	err = s.modifySortedSet(key, false, record, func(z *SortedSet) (bool, error) {
+		for _, m := range members {
+			if z.remove(m) {
+				removed++
+			}
+		}
+		return removed > 0, nil
+	})
+	return
+}
+
+func (s *sharder) ZScore(key string, member string) (float64, error) {
+	z, err := s.sortedSet(key)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := z.Score(member)
+	if !ok {
+		return 0, ErrMemberNotFound
+	}
+	return score, nil
+}
+
+func (s *sharder) ZRank(key string, member string, reverse bool) (int, error) {
+	z, err := s.sortedSet(key)
+	if err != nil {
+		return 0, err
+	}
+	rank, ok := z.Rank(member, reverse)
+	if !ok {
+		return 0, ErrMemberNotFound
+	}
+	return rank, nil
+}
+
+func (s *sharder) ZRange(key string, start int, stop int, reverse bool) ([]ZMember, error) {
+	z, err := s.sortedSet(key)
+	if err != nil {
+		return nil, err
+	}
+	return z.Range(start, stop, reverse), nil
+}
+
+func (s *sharder) ZRangeByScore(key string, min float64, max float64, reverse bool) ([]ZMember, error) {
+	z, err := s.sortedSet(key)
+	if err != nil {
+		return nil, err
+	}
+	return z.RangeByScore(min, max, reverse), nil
+}
+
+func (s *sharder) ZCard(key string) (int, error) {
+	z, err := s.sortedSet(key)
+	if err != nil {
+		return 0, err
+	}
+	return z.Len(), nil
+}
+
+func (l *logger) ZAdd(key string, members ...ZMember) (int, error) {
+	defer l.peekIntoPanic("zadd", key, members)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := zo.ZAdd(key, members...)
+	l.infoLog.Println("zadd", key, members, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) ZIncrBy(key string, member string, delta float64) (float64, error) {
+	defer l.peekIntoPanic("zincrby", key, member, delta)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	score, err := zo.ZIncrBy(key, member, delta)
+	l.infoLog.Println("zincrby", key, member, delta, "=>", score, err)
+	return score, err
+}
+
+func (l *logger) ZRem(key string, members ...string) (int, error) {
+	defer l.peekIntoPanic("zrem", key, members)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := zo.ZRem(key, members...)
+	l.infoLog.Println("zrem", key, members, "=>", n, err)
+	return n, err
+}
+
+func (l *logger) ZScore(key string, member string) (float64, error) {
+	defer l.peekIntoPanic("zscore", key, member)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	score, err := zo.ZScore(key, member)
+	l.infoLog.Println("zscore", key, member, "=>", score, err)
+	return score, err
+}
+
+func (l *logger) ZRank(key string, member string, reverse bool) (int, error) {
+	defer l.peekIntoPanic("zrank", key, member, reverse)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	rank, err := zo.ZRank(key, member, reverse)
+	l.infoLog.Println("zrank", key, member, reverse, "=>", rank, err)
+	return rank, err
+}
+
+func (l *logger) ZRange(key string, start int, stop int, reverse bool) ([]ZMember, error) {
+	defer l.peekIntoPanic("zrange", key, start, stop, reverse)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	members, err := zo.ZRange(key, start, stop, reverse)
+	l.infoLog.Println("zrange", key, start, stop, reverse, "=>", members, err)
+	return members, err
+}
+
+func (l *logger) ZRangeByScore(key string, min float64, max float64, reverse bool) ([]ZMember, error) {
+	defer l.peekIntoPanic("zrangebyscore", key, min, max, reverse)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return nil, err
+	}
+	members, err := zo.ZRangeByScore(key, min, max, reverse)
+	l.infoLog.Println("zrangebyscore", key, min, max, reverse, "=>", members, err)
+	return members, err
+}
+
+func (l *logger) ZCard(key string) (int, error) {
+	defer l.peekIntoPanic("zcard", key)
+	zo, err := asSortedSetOperator(l.Cache)
+	if err != nil {
+		return 0, err
+	}
+	n, err := zo.ZCard(key)
+	l.infoLog.Println("zcard", key, "=>", n, err)
+	return n, err
+}
+
+func (p *persister) ZAdd(key string, members ...ZMember) (int, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZAdd(key, members...)
+}
+
+func (p *persister) ZIncrBy(key string, member string, delta float64) (float64, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZIncrBy(key, member, delta)
+}
+
+func (p *persister) ZRem(key string, members ...string) (int, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZRem(key, members...)
+}
+
+func (p *persister) ZScore(key string, member string) (float64, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZScore(key, member)
+}
+
+func (p *persister) ZRank(key string, member string, reverse bool) (int, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZRank(key, member, reverse)
+}
+
+func (p *persister) ZRange(key string, start int, stop int, reverse bool) ([]ZMember, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return zo.ZRange(key, start, stop, reverse)
+}
+
+func (p *persister) ZRangeByScore(key string, min float64, max float64, reverse bool) ([]ZMember, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return zo.ZRangeByScore(key, min, max, reverse)
+}
+
+func (p *persister) ZCard(key string) (int, error) {
+	zo, err := asSortedSetOperator(p.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZCard(key)
+}
+
+func (o *operation) executeSortedSet(target Cache) error {
+	zo, err := asSortedSetOperator(target)
+	if err != nil {
+		return err
+	}
+	switch o.Type {
+	case "ZAdd":
+		members, err := zMembersFromJSON(o.Value)
+		if err != nil {
+			return err
+		}
+		_, err = zo.ZAdd(o.Key, members...)
+		return err
+	case "ZRem":
+		members, err := newSetFromJSON(o.Value)
+		if err != nil {
+			return err
+		}
+		_, err = zo.ZRem(o.Key, members.Members()...)
+		return err
+	}
+	return nil
+}
+
+func (t *ttl) ZAdd(key string, members ...ZMember) (int, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := zo.ZAdd(key, members...)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) ZIncrBy(key string, member string, delta float64) (float64, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	result, err := zo.ZIncrBy(key, member, delta)
+	if err == nil {
+		t.expireCreated(key)
+	}
+	return result, err
+}
+
+func (t *ttl) ZRem(key string, members ...string) (int, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZRem(key, members...)
+}
+
+func (t *ttl) ZScore(key string, member string) (float64, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZScore(key, member)
+}
+
+func (t *ttl) ZRank(key string, member string, reverse bool) (int, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZRank(key, member, reverse)
+}
+
+func (t *ttl) ZRange(key string, start int, stop int, reverse bool) ([]ZMember, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return zo.ZRange(key, start, stop, reverse)
+}
+
+func (t *ttl) ZRangeByScore(key string, min float64, max float64, reverse bool) ([]ZMember, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return nil, err
+	}
+	return zo.ZRangeByScore(key, min, max, reverse)
+}
+
+func (t *ttl) ZCard(key string) (int, error) {
+	zo, err := asSortedSetOperator(t.Cache)
+	if err != nil {
+		return 0, err
+	}
+	return zo.ZCard(key)
+}
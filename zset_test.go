@@ -0,0 +1,145 @@
+package db
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSortedSet_SkipList(t *testing.T) {
+	z := NewSortedSet()
+	expected := map[string]float64{}
+	for i := 0; i < 2000; i++ {
+		member := strconv.Itoa(rand.Intn(300))
+		if rand.Intn(4) == 0 {
+			z.remove(member)
+			delete(expected, member)
+			continue
+		}
+		score := float64(rand.Intn(50))
+		z.add(member, score)
+		expected[member] = score
+	}
+
+	ordered := []ZMember{}
+	for m, s := range expected {
+		ordered = append(ordered, ZMember{m, s})
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return before(ordered[i].Score, ordered[i].Member, ordered[j].Score, ordered[j].Member)
+	})
+
+	if got := z.Range(0, -1, false); !reflect.DeepEqual(got, ordered) {
+		t.Fatalf("skip list order does not match sorted members")
+	}
+	for i, m := range ordered {
+		rank, ok := z.Rank(m.Member, false)
+		if !ok || rank != i {
+			t.Errorf("Rank(%v): expected %v, got %v", m.Member, i, rank)
+		}
+		rank, _ = z.Rank(m.Member, true)
+		if rank != len(ordered)-1-i {
+			t.Errorf("reverse Rank(%v): expected %v, got %v", m.Member, len(ordered)-1-i, rank)
+		}
+	}
+	if got := z.Range(5, 9, false); !reflect.DeepEqual(got, ordered[5:10]) {
+		t.Errorf("Range(5, 9): got %v, expected %v", got, ordered[5:10])
+	}
+	reversed := z.Range(0, 2, true)
+	if len(reversed) != 3 || reversed[0] != ordered[len(ordered)-1] || reversed[2] != ordered[len(ordered)-3] {
+		t.Errorf("reverse Range(0, 2): got %v", reversed)
+	}
+
+	byScore := []ZMember{}
+	for _, m := range ordered {
+		if m.Score >= 10 && m.Score <= 20 {
+			byScore = append(byScore, m)
+		}
+	}
+	if got := z.RangeByScore(10, 20, false); !reflect.DeepEqual(got, byScore) {
+		t.Errorf("RangeByScore(10, 20): got %v, expected %v", got, byScore)
+	}
+	got := z.RangeByScore(10, 20, true)
+	for i := range got {
+		if got[i] != byScore[len(byScore)-1-i] {
+			t.Errorf("reverse RangeByScore(10, 20): got %v", got)
+			break
+		}
+	}
+}
+
+func TestSortedSet_Operations(t *testing.T) {
+	c, _ := NewCache(0, nil, nil, 0, 2, nil)
+	zo := c.(SortedSetOperator)
+
+	n, err := zo.ZAdd("board", ZMember{"alice", 10}, ZMember{"bob", 20}, ZMember{"carol", 15})
+	if err != nil || n != 3 {
+		t.Errorf("ZAdd: expected 3 added, got %v, err %v", n, err)
+	}
+	n, _ = zo.ZAdd("board", ZMember{"alice", 30})
+	if n != 0 {
+		t.Errorf("ZAdd of existing member should not count as added, got %v", n)
+	}
+	if _, err = zo.ZAdd("board", ZMember{"dave", math.Inf(1)}); err != ErrInvalidScore {
+		t.Errorf("ZAdd with infinite score: expected %v, got %v", ErrInvalidScore, err)
+	}
+
+	score, err := zo.ZIncrBy("board", "bob", 2.5)
+	if err != nil || score != 22.5 {
+		t.Errorf("ZIncrBy: expected 22.5, got %v, err %v", score, err)
+	}
+	score, _ = zo.ZIncrBy("board", "dave", 1)
+	if score != 1 {
+		t.Errorf("ZIncrBy of new member: expected 1, got %v", score)
+	}
+
+	members, _ := zo.ZRange("board", 0, -1, true)
+	expected := []ZMember{{"alice", 30}, {"bob", 22.5}, {"carol", 15}, {"dave", 1}}
+	if !reflect.DeepEqual(members, expected) {
+		t.Errorf("ZRange reversed: got %v", members)
+	}
+	members, _ = zo.ZRangeByScore("board", 10, 25, false)
+	if !reflect.DeepEqual(members, []ZMember{{"carol", 15}, {"bob", 22.5}}) {
+		t.Errorf("ZRangeByScore: got %v", members)
+	}
+
+	rank, err := zo.ZRank("board", "carol", false)
+	if err != nil || rank != 1 {
+		t.Errorf("ZRank: expected 1, got %v, err %v", rank, err)
+	}
+	if _, err = zo.ZRank("board", "eve", false); err != ErrMemberNotFound {
+		t.Errorf("ZRank of missing member: expected %v, got %v", ErrMemberNotFound, err)
+	}
+
+	n, _ = zo.ZRem("board", "dave", "eve")
+	card, _ := zo.ZCard("board")
+	if n != 1 || card != 3 {
+		t.Errorf("ZRem: expected 1 removed and 3 left, got %v and %v", n, card)
+	}
+
+	value, _ := c.Get("board")
+	encoded, _ := json.Marshal(value)
+	if string(encoded) != `{"type":4,"data":[{"member":"carol","score":15},{"member":"bob","score":22.5},{"member":"alice","score":30}]}` {
+		t.Errorf("unexpected JSON for sorted set: %s", encoded)
+	}
+}
+
+func TestSortedSet_Persist(t *testing.T) {
+	sample := `{"Type":"ZAdd","k":"z","v":[{"member":"a","score":1},{"member":"b","score":2}],"e":0}
+{"Type":"ZAdd","k":"z","v":[{"member":"a","score":3.5}],"e":0}
+{"Type":"ZRem","k":"z","v":["b"],"e":0}
+`
+	restored := checkPersist(t, 1, sample, func(p *persister) {
+		p.ZAdd("z", ZMember{"a", 1}, ZMember{"b", 2})
+		p.ZIncrBy("z", "a", 2.5)
+		p.ZRem("z", "b")
+	})
+	members, err := restored.ZRange("z", 0, -1, false)
+	if err != nil || !reflect.DeepEqual(members, []ZMember{{"a", 3.5}}) {
+		t.Errorf("TestSortedSet_Persist restore: got %v, err %v", members, err)
+	}
+}